@@ -0,0 +1,297 @@
+package bobrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/mxbot"
+)
+
+// AccessEffect - result of the access policy
+type AccessEffect string
+
+const (
+	AccessAllow AccessEffect = "allow"
+	AccessDeny  AccessEffect = "deny"
+)
+
+const defaultMembershipTTL = time.Minute
+
+// AccessPolicy - rule of the access control.
+//
+// The scope of the policy is set by Service and Method:
+//   - Service == "" - the policy applies to the whole bot
+//   - Service != "", Method == "" - the policy applies to all methods of the service
+//   - Service != "", Method != "" - the policy applies to the method only
+//
+// The policy matches the request if all non-empty conditions match
+// (a condition with a list matches if any of its values matches).
+// A policy without conditions matches any request
+type AccessPolicy struct {
+	Name   string       `json:"name,omitempty" yaml:"name,omitempty"`
+	Effect AccessEffect `json:"effect" yaml:"effect"` // default: AccessAllow
+
+	Service string `json:"service,omitempty" yaml:"service,omitempty"` // service name or ID
+	Method  string `json:"method,omitempty" yaml:"method,omitempty"`
+
+	Users         []id.UserID `json:"users,omitempty" yaml:"users,omitempty"`
+	Homeservers   []string    `json:"homeservers,omitempty" yaml:"homeservers,omitempty"`
+	Rooms         []id.RoomID `json:"rooms,omitempty" yaml:"rooms,omitempty"`
+	MinPowerLevel *int        `json:"min_power_level,omitempty" yaml:"min_power_level,omitempty"` // power level of the sender in the room of the request
+	MemberOf      []id.RoomID `json:"member_of,omitempty" yaml:"member_of,omitempty"`             // allowlist rooms (groups). The bot must be able to read their state
+}
+
+// AccessConfig - set of access policies. It can be loaded from JSON (see LoadAccessConfig)
+type AccessConfig struct {
+	Policies []AccessPolicy `json:"policies" yaml:"policies"`
+
+	// MembershipTTL - how long the membership in allowlist rooms is cached. Default: 1 minute
+	MembershipTTL time.Duration `json:"-" yaml:"-"`
+}
+
+// AccessRequest - request checked by the AccessController
+type AccessRequest struct {
+	Sender  id.UserID
+	RoomID  id.RoomID
+	Service *BobrixService
+	Method  string
+}
+
+// AccessDecision - result of the access check
+type AccessDecision struct {
+	Allowed bool
+	Policy  string // name of the policy that denied the request (if any)
+	Reason  string
+}
+
+// AccessController checks requests against the access policies.
+//
+// Policies are evaluated by levels: bot, service and method.
+// The request must pass every level that has policies in its scope.
+// On each level a matching deny policy denies the request; if the level has allow policies,
+// at least one of them must match. Levels without policies don't restrict the request
+type AccessController struct {
+	bot      mxbot.Bot
+	policies []AccessPolicy
+
+	membershipTTL time.Duration
+	mu            sync.Mutex
+	membership    map[membershipKey]membershipEntry
+}
+
+type membershipKey struct {
+	room id.RoomID
+	user id.UserID
+}
+
+type membershipEntry struct {
+	joined    bool
+	expiresAt time.Time
+}
+
+// NewAccessController - AccessController constructor
+func NewAccessController(bot mxbot.Bot, cfg AccessConfig) *AccessController {
+	ttl := cfg.MembershipTTL
+	if ttl <= 0 {
+		ttl = defaultMembershipTTL
+	}
+
+	return &AccessController{
+		bot:           bot,
+		policies:      cfg.Policies,
+		membershipTTL: ttl,
+		membership:    make(map[membershipKey]membershipEntry),
+	}
+}
+
+// LoadAccessConfig - reads access policies from JSON
+func LoadAccessConfig(r io.Reader) (AccessConfig, error) {
+	var cfg AccessConfig
+	if err := json.NewDecoder(r).Decode(&cfg); err != nil {
+		return AccessConfig{}, fmt.Errorf("failed to decode access config: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return AccessConfig{}, err
+	}
+
+	return cfg, nil
+}
+
+// LoadAccessConfigFile - reads access policies from the JSON file
+func LoadAccessConfigFile(path string) (AccessConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return AccessConfig{}, fmt.Errorf("failed to open access config: %w", err)
+	}
+	defer f.Close()
+
+	return LoadAccessConfig(f)
+}
+
+// Validate - checks the policies of the config
+func (c AccessConfig) Validate() error {
+	for i, p := range c.Policies {
+		switch p.Effect {
+		case "", AccessAllow, AccessDeny:
+		default:
+			return fmt.Errorf("access policy #%d (%s): unknown effect %q", i, p.Name, p.Effect)
+		}
+
+		if p.Method != "" && p.Service == "" {
+			return fmt.Errorf("access policy #%d (%s): method %q requires a service", i, p.Name, p.Method)
+		}
+	}
+	return nil
+}
+
+// WithAccessControl - enables access control of service calls.
+// Denied requests are answered with contracts.ErrCodeForbidden
+func WithAccessControl(cfg AccessConfig) BobrixOpts {
+	return func(bx *Bobrix) {
+		if err := cfg.Validate(); err != nil {
+			bx.logger.Error("invalid access config, all service calls will be denied", "error", err)
+			cfg = AccessConfig{Policies: []AccessPolicy{{Name: "invalid-config", Effect: AccessDeny}}}
+		}
+
+		bx.access = NewAccessController(bx.bot, cfg)
+	}
+}
+
+// Check - checks the request against the policies
+func (ac *AccessController) Check(ctx context.Context, req AccessRequest) (AccessDecision, error) {
+	levels := [...]func(p *AccessPolicy) bool{
+		func(p *AccessPolicy) bool { return p.Service == "" },
+		func(p *AccessPolicy) bool { return p.Service != "" && p.Method == "" && p.matchesService(req.Service) },
+		func(p *AccessPolicy) bool {
+			return p.Service != "" && p.Method != "" && p.matchesService(req.Service) && p.Method == req.Method
+		},
+	}
+
+	for _, inScope := range levels {
+		var (
+			hasAllow     bool
+			allowMatched bool
+		)
+
+		for i := range ac.policies {
+			p := &ac.policies[i]
+			if !inScope(p) {
+				continue
+			}
+
+			isDeny := p.Effect == AccessDeny
+			if !isDeny {
+				hasAllow = true
+				if allowMatched {
+					continue // an allow already matched on this level, only denies matter
+				}
+			}
+
+			matched, err := ac.matches(ctx, p, req)
+			if err != nil {
+				return AccessDecision{}, fmt.Errorf("access policy %q: %w", p.Name, err)
+			}
+			if !matched {
+				continue
+			}
+
+			if isDeny {
+				return AccessDecision{Policy: p.Name, Reason: "denied by policy"}, nil
+			}
+			allowMatched = true
+		}
+
+		if hasAllow && !allowMatched {
+			return AccessDecision{Reason: "no allow policy matched"}, nil
+		}
+	}
+
+	return AccessDecision{Allowed: true}, nil
+}
+
+func (p *AccessPolicy) matchesService(svc *BobrixService) bool {
+	if svc == nil || svc.Service == nil {
+		return false
+	}
+	return p.Service == svc.Service.ID.String() ||
+		normalizeServiceName(p.Service) == normalizeServiceName(svc.Service.Name)
+}
+
+// matches - checks the conditions of the policy. Cheap conditions are checked first
+func (ac *AccessController) matches(ctx context.Context, p *AccessPolicy, req AccessRequest) (bool, error) {
+	if len(p.Users) > 0 && !slices.Contains(p.Users, req.Sender) {
+		return false, nil
+	}
+
+	if len(p.Homeservers) > 0 && !slices.ContainsFunc(p.Homeservers, func(hs string) bool {
+		return strings.EqualFold(hs, req.Sender.Homeserver())
+	}) {
+		return false, nil
+	}
+
+	if len(p.Rooms) > 0 && !slices.Contains(p.Rooms, req.RoomID) {
+		return false, nil
+	}
+
+	if p.MinPowerLevel != nil {
+		level, err := ac.bot.UserPowerLevel(ctx, req.RoomID, req.Sender)
+		if err != nil {
+			return false, fmt.Errorf("failed to get power level: %w", err)
+		}
+		if level < *p.MinPowerLevel {
+			return false, nil
+		}
+	}
+
+	if len(p.MemberOf) > 0 {
+		member := false
+		for _, roomID := range p.MemberOf {
+			joined, err := ac.isMember(ctx, roomID, req.Sender)
+			if err != nil {
+				return false, fmt.Errorf("failed to check membership in %s: %w", roomID, err)
+			}
+			if joined {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (ac *AccessController) isMember(ctx context.Context, roomID id.RoomID, userID id.UserID) (bool, error) {
+	key := membershipKey{room: roomID, user: userID}
+
+	ac.mu.Lock()
+	entry, ok := ac.membership[key]
+	ac.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.joined, nil
+	}
+
+	joined, err := ac.bot.IsJoinedMember(ctx, roomID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	ac.mu.Lock()
+	ac.membership[key] = membershipEntry{joined: joined, expiresAt: time.Now().Add(ac.membershipTTL)}
+	ac.mu.Unlock()
+
+	return joined, nil
+}
@@ -0,0 +1,99 @@
+package bobrix
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestAccessControllerCheck(t *testing.T) {
+	ada, other := testService("ada"), testService("other")
+	moderator := 50
+
+	bot := &testBot{
+		powerLevels: map[id.UserID]int{"@mod:example.org": 50},
+		members:     map[id.RoomID][]id.UserID{"!staff:example.org": {"@staff:example.org"}},
+	}
+
+	policies := []AccessPolicy{
+		{Name: "no-evil", Effect: AccessDeny, Homeservers: []string{"evil.org"}},
+		{Name: "ada-staff", Service: "ada", MemberOf: []id.RoomID{"!staff:example.org"}},
+		{Name: "ada-mods", Service: "ada", MinPowerLevel: &moderator},
+		{Name: "ada-reset", Effect: AccessDeny, Service: ada.Service.ID.String(), Method: "reset", Users: []id.UserID{"@staff:example.org"}},
+	}
+
+	tests := []struct {
+		name    string
+		sender  id.UserID
+		service *BobrixService
+		method  string
+		policy  string // the policy that denied the request. "" - the request is allowed
+		denied  bool
+	}{
+		{name: "service without policies", sender: "@user:example.org", service: other, method: "chat"},
+		{name: "bot level deny", sender: "@user:EVIL.org", service: other, method: "chat", policy: "no-evil", denied: true},
+		{name: "member of the allowlist room", sender: "@staff:example.org", service: ada, method: "chat"},
+		{name: "power level", sender: "@mod:example.org", service: ada, method: "chat"},
+		{name: "no allow policy matched", sender: "@user:example.org", service: ada, method: "chat", denied: true},
+		{name: "method level deny", sender: "@staff:example.org", service: ada, method: "reset", policy: "ada-reset", denied: true},
+		{name: "method without policies of the others", sender: "@mod:example.org", service: ada, method: "reset"},
+	}
+
+	ac := NewAccessController(bot, AccessConfig{Policies: policies})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := ac.Check(context.Background(), AccessRequest{
+				Sender:  tt.sender,
+				RoomID:  "!room:example.org",
+				Service: tt.service,
+				Method:  tt.method,
+			})
+			if err != nil {
+				t.Fatalf("check: %v", err)
+			}
+			if decision.Allowed == tt.denied || decision.Policy != tt.policy {
+				t.Errorf("decision = %+v, want denied %v by %q", decision, tt.denied, tt.policy)
+			}
+		})
+	}
+}
+
+func TestAccessControllerMembershipCache(t *testing.T) {
+	bot := &testBot{members: map[id.RoomID][]id.UserID{"!staff": {"@staff"}}}
+	ac := NewAccessController(bot, AccessConfig{Policies: []AccessPolicy{{MemberOf: []id.RoomID{"!staff"}}}})
+
+	for range 3 {
+		decision, err := ac.Check(context.Background(), AccessRequest{Sender: "@staff", RoomID: "!room"})
+		if err != nil || !decision.Allowed {
+			t.Fatalf("decision = %+v, err = %v", decision, err)
+		}
+	}
+	if bot.memberChecks != 1 {
+		t.Errorf("membership checked %d times, want 1 (cached)", bot.memberChecks)
+	}
+}
+
+func TestLoadAccessConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		ok   bool
+	}{
+		{"policies", `{"policies": [{"name": "mods", "service": "ada", "min_power_level": 50}]}`, true},
+		{"unknown effect", `{"policies": [{"effect": "maybe"}]}`, false},
+		{"method without a service", `{"policies": [{"method": "chat"}]}`, false},
+		{"invalid json", `{"policies": [`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadAccessConfig(strings.NewReader(tt.json))
+			if (err == nil) != tt.ok {
+				t.Errorf("LoadAccessConfig = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
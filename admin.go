@@ -0,0 +1,488 @@
+package bobrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+	applcommands "github.com/tensved/bobrix/mxbot/application/commands"
+	dombot "github.com/tensved/bobrix/mxbot/domain/bot"
+	domcommands "github.com/tensved/bobrix/mxbot/domain/commands"
+)
+
+const (
+	defaultAdminCommand = "admin"
+	adminPingTimeout    = 5 * time.Second
+
+	defaultAdminAuditPeriod = 24 * time.Hour
+	adminAuditLimit         = 20
+
+	defaultAdminDeadLetters = 10
+)
+
+// AdminOpts - options of the admin command suite (see WithAdmin)
+// Admin commands are accepted from the users of the list or from any member of the admin room
+type AdminOpts struct {
+	Users  []id.UserID // users allowed to run admin commands in any room
+	RoomID id.RoomID   // admin room. Any member of this room can run admin commands in it
+
+	Prefix      string // command prefix. Default: "/"
+	CommandName string // command name. Default: "admin"
+}
+
+// adminAction - single action of the admin command (e.g. "/admin queue")
+type adminAction struct {
+	name        string
+	args        []domcommands.Arg
+	description string
+	do          func(ctx domcommands.CommandCtx, args []string) (string, error)
+}
+
+// adminUsageError - mistake in the admin command (wrong argument, unknown service...).
+// The other errors of the actions are internal
+type adminUsageError string
+
+func (e adminUsageError) Error() string {
+	return string(e)
+}
+
+// WithAdmin - enables the admin command suite (opt-in).
+// It registers the "/admin" command that allows operating the bot from Matrix:
+// list services with health, enable/disable services, inspect the queue,
+// re-run the last failed event, force a backfill, check the deduper and re-request crypto keys
+func WithAdmin(opts AdminOpts) BobrixOpts {
+	return func(bx *Bobrix) {
+		bx.registerAdminCommands(opts)
+	}
+}
+
+func (bx *Bobrix) registerAdminCommands(opts AdminOpts) {
+	if opts.Prefix == "" {
+		opts.Prefix = domcommands.DefaultCommandPrefix
+	}
+	if opts.CommandName == "" {
+		opts.CommandName = defaultAdminCommand
+	}
+	if len(opts.Users) == 0 && opts.RoomID == "" {
+		bx.logger.Warn("admin commands are enabled, but neither admin users nor admin room are set")
+	}
+
+	actions := bx.adminActions()
+	subcommands := make([]*domcommands.Command, 0, len(actions))
+	for _, action := range actions {
+		subcommands = append(subcommands, bx.adminSubcommand(action))
+	}
+
+	// without a subcommand the command shows its help
+	cmd := applcommands.NewCommand(
+		opts.CommandName,
+		nil,
+		domcommands.CommandConfig{
+			Prefix: opts.Prefix,
+			Description: map[string]string{
+				"en": "Operate the bot (admins only)",
+			},
+			Subcommands: subcommands,
+		},
+	)
+
+	applcommands.Register(bx.bot, cmd, filterAdmin(opts))
+}
+
+func (bx *Bobrix) adminSubcommand(action adminAction) *domcommands.Command {
+	return applcommands.NewCommand(
+		action.name,
+		func(ctx domcommands.CommandCtx) error {
+			ctx.TryClaim()
+
+			out, err := action.do(ctx, ctx.Args())
+			if err != nil {
+				var usageErr adminUsageError
+				if errors.As(err, &usageErr) {
+					return ctx.ErrorAnswer(err.Error(), contracts.ErrCodeBadRequest)
+				}
+
+				bx.logger.Error("admin action failed", "action", action.name, "sender", ctx.Event().Sender, "error", err)
+				return ctx.ErrorAnswer(err.Error(), contracts.ErrCodeInternalServiceError)
+			}
+
+			bx.logger.Info("admin action", "action", action.name, "sender", ctx.Event().Sender)
+			return ctx.TextAnswer(out)
+		},
+		domcommands.CommandConfig{
+			Description: map[string]string{"en": action.description},
+			Args:        action.args,
+		},
+	)
+}
+
+// filterAdmin - accepts events from admin users or from the admin room
+func filterAdmin(opts AdminOpts) func(evt *event.Event) bool {
+	return func(evt *event.Event) bool {
+		if opts.RoomID != "" && evt.RoomID == opts.RoomID {
+			return true
+		}
+		return slices.Contains(opts.Users, evt.Sender)
+	}
+}
+
+func (bx *Bobrix) adminActions() []adminAction {
+	serviceArg := []domcommands.Arg{{Name: "service", Required: true, Variadic: true}}
+
+	return []adminAction{
+		{
+			name:        "services",
+			description: "list services with health",
+			do:          bx.adminServices,
+		},
+		{
+			name:        "enable",
+			args:        serviceArg,
+			description: "enable the service",
+			do: func(ctx domcommands.CommandCtx, args []string) (string, error) {
+				return bx.adminSwitchService(args, true)
+			},
+		},
+		{
+			name:        "disable",
+			args:        serviceArg,
+			description: "disable the service",
+			do: func(ctx domcommands.CommandCtx, args []string) (string, error) {
+				return bx.adminSwitchService(args, false)
+			},
+		},
+		{
+			name:        "queue",
+			description: "show the queue and the in-flight work",
+			do:          bx.adminQueue,
+		},
+		{
+			name:        "retry",
+			description: "re-run the last failed event",
+			do:          bx.adminRetry,
+		},
+		{
+			name:        "dead",
+			args:        []domcommands.Arg{{Name: "limit", Type: domcommands.ArgInt, Default: defaultAdminDeadLetters}},
+			description: "list the events that have failed all attempts",
+			do:          bx.adminDeadLetters,
+		},
+		{
+			name:        "replay",
+			args:        []domcommands.Arg{{Name: "id", Required: true}},
+			description: "put the dead letter back to the queue",
+			do:          bx.adminReplay,
+		},
+		{
+			name:        "drop",
+			args:        []domcommands.Arg{{Name: "id", Required: true}},
+			description: "delete the dead letter",
+			do:          bx.adminDropDeadLetter,
+		},
+		{
+			name:        "backfill",
+			args:        []domcommands.Arg{{Name: "room_id"}},
+			description: "force a backfill of the room (default: current room)",
+			do:          bx.adminBackfill,
+		},
+		{
+			name:        "dedup",
+			args:        []domcommands.Arg{{Name: "event_id", Required: true}},
+			description: "show the deduper status of the event",
+			do:          bx.adminDedup,
+		},
+		{
+			name:        "keys",
+			args:        []domcommands.Arg{{Name: "room_id|event_id", Variadic: true}},
+			description: "re-request crypto keys for undecryptable events",
+			do:          bx.adminKeys,
+		},
+		{
+			// the audit sink may be set after the admin commands (see WithAudit), so it is checked on call
+			name: "audit",
+			args: []domcommands.Arg{
+				{Name: "user_id", Required: true},
+				{Name: "period", Type: domcommands.ArgDuration, Default: defaultAdminAuditPeriod},
+			},
+			description: "show the latest contract invocations of the user",
+			do:          bx.adminAudit,
+		},
+	}
+}
+
+func (bx *Bobrix) adminServices(ctx domcommands.CommandCtx, _ []string) (string, error) {
+	services := bx.Services()
+	if len(services) == 0 {
+		return "No services connected", nil
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Service.Name < services[j].Service.Name
+	})
+
+	healths := make([]Health, len(services))
+	wg := &sync.WaitGroup{}
+	for i, svc := range services {
+		wg.Add(1)
+		go func(i int, svc *BobrixService) {
+			defer wg.Done()
+			pingCtx, cancel := context.WithTimeout(ctx.Context(), adminPingTimeout)
+			defer cancel()
+			healths[i] = serviceHealth(pingCtx, svc)
+		}(i, svc)
+	}
+	wg.Wait()
+
+	var sb strings.Builder
+	sb.WriteString("Services:\n\n")
+	for i, svc := range services {
+		state := "online"
+		switch {
+		case svc.disabled.Load():
+			state = "disabled"
+		case !svc.IsOnline:
+			state = "offline"
+		}
+
+		fmt.Fprintf(&sb, "- **%s** (`%s`): %s, %s", svc.Service.Name, svc.Service.ID, state, healths[i].Status)
+		if healths[i].Error != "" {
+			fmt.Fprintf(&sb, " (%s)", healths[i].Error)
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
+
+func (bx *Bobrix) adminSwitchService(args []string, online bool) (string, error) {
+	if len(args) == 0 {
+		return "", adminUsageError("service name or id is required")
+	}
+
+	ref := strings.Join(args, " ")
+	svc, ok := bx.lookupService(ref)
+	if !ok {
+		return "", adminUsageError(fmt.Sprintf("service %q not found", ref))
+	}
+
+	svc.disabled.Store(!online)
+
+	switch {
+	case !online:
+		return fmt.Sprintf("Service **%s** is disabled", svc.Service.Name), nil
+	case !svc.IsOnline:
+		return fmt.Sprintf("Service **%s** is enabled, but it's offline by the healthcheck", svc.Service.Name), nil
+	default:
+		return fmt.Sprintf("Service **%s** is enabled", svc.Service.Name), nil
+	}
+}
+
+func (bx *Bobrix) adminQueue(_ domcommands.CommandCtx, _ []string) (string, error) {
+	stats := bx.bot.QueueStats()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Queue: %d/%d, workers: %d, in flight: %d, rooms: %d\n",
+		stats.Queued, stats.Capacity, stats.Workers, len(stats.Inflight), stats.Rooms)
+	fmt.Fprintf(&sb, "Retrying: %d, dead letters: %d (after %d attempts), dropped: %d\n",
+		stats.Retrying, stats.DeadLetters, stats.MaxAttempts, stats.Dropped)
+
+	if len(stats.Inflight) > 0 {
+		sb.WriteString("\nIn flight:\n\n")
+		for _, e := range stats.Inflight {
+			fmt.Fprintf(&sb, "- `%s` in `%s` (worker %d, %s)\n",
+				e.EventID, e.RoomID, e.Worker, time.Since(e.StartedAt).Round(time.Millisecond))
+		}
+	}
+
+	if f := stats.LastFailed; f != nil {
+		fmt.Fprintf(&sb, "\nLast failed: `%s` in `%s` at %s: %s\n",
+			f.EventID, f.RoomID, f.FailedAt.Format(time.RFC3339), f.Error)
+	}
+
+	if r := stats.DedupRetention; r != nil {
+		fmt.Fprintf(&sb, "\nDedup retention (%s): %d runs, %d records pruned", r.MaxAge, r.Runs, r.Pruned)
+		if !r.LastRunAt.IsZero() {
+			fmt.Fprintf(&sb, ", last run at %s pruned %d", r.LastRunAt.Format(time.RFC3339), r.LastPruned)
+		}
+		if r.LastError != "" {
+			fmt.Fprintf(&sb, ", last error: %s", r.LastError)
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
+
+func (bx *Bobrix) adminRetry(ctx domcommands.CommandCtx, _ []string) (string, error) {
+	eventID, err := bx.bot.RetryLastFailed(ctx.Context())
+	if errors.Is(err, dombot.ErrNoFailedEvent) {
+		return "No failed events to retry", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Event `%s` is queued again", eventID), nil
+}
+
+func (bx *Bobrix) adminDeadLetters(ctx domcommands.CommandCtx, _ []string) (string, error) {
+	letters, err := bx.bot.DeadLetters(ctx.Context(), ctx.Arg("limit").Int())
+	if err != nil {
+		return "", err
+	}
+
+	if len(letters) == 0 {
+		return "No dead letters", nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Dead letters:\n\n")
+	for _, dl := range letters {
+		fmt.Fprintf(&sb, "- `%s` in `%s` at %s after %d attempts: %s\n",
+			dl.ID, dl.RoomID, dl.FailedAt.Format(time.RFC3339), dl.Attempts, dl.Error)
+	}
+	return sb.String(), nil
+}
+
+func (bx *Bobrix) adminReplay(ctx domcommands.CommandCtx, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", adminUsageError("dead letter id is required")
+	}
+
+	if err := bx.bot.ReplayDeadLetter(ctx.Context(), args[0]); err != nil {
+		return "", deadLetterError(args[0], err)
+	}
+	return fmt.Sprintf("Event `%s` is queued again", args[0]), nil
+}
+
+func (bx *Bobrix) adminDropDeadLetter(ctx domcommands.CommandCtx, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", adminUsageError("dead letter id is required")
+	}
+
+	if err := bx.bot.DropDeadLetter(ctx.Context(), args[0]); err != nil {
+		return "", deadLetterError(args[0], err)
+	}
+	return fmt.Sprintf("Dead letter `%s` is deleted", args[0]), nil
+}
+
+// deadLetterError - unknown dead letter is the mistake of the admin
+func deadLetterError(id string, err error) error {
+	if errors.Is(err, dombot.ErrDeadLetterNotFound) {
+		return adminUsageError(fmt.Sprintf("dead letter %q not found", id))
+	}
+	return err
+}
+
+func (bx *Bobrix) adminBackfill(ctx domcommands.CommandCtx, args []string) (string, error) {
+	roomID := ctx.Event().RoomID
+	if len(args) > 0 {
+		roomID = id.RoomID(args[0])
+	}
+
+	// backfill may take a while, so it runs in the background of the bot
+	go func() {
+		if err := bx.bot.BackfillRoom(context.Background(), roomID); err != nil {
+			slog.Error("admin: backfill failed", "room", roomID, "error", err)
+		}
+	}()
+
+	return fmt.Sprintf("Backfill of `%s` is started", roomID), nil
+}
+
+func (bx *Bobrix) adminDedup(ctx domcommands.CommandCtx, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", adminUsageError("event id is required")
+	}
+
+	eventID := id.EventID(args[0])
+	status, err := bx.bot.DedupStatus(ctx.Context(), eventID)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Event `%s`: %s", eventID, status), nil
+}
+
+func (bx *Bobrix) adminKeys(ctx domcommands.CommandCtx, args []string) (string, error) {
+	var (
+		roomID  id.RoomID
+		eventID id.EventID
+	)
+
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "!"):
+			roomID = id.RoomID(arg)
+		case strings.HasPrefix(arg, "$"):
+			eventID = id.EventID(arg)
+		default:
+			return "", adminUsageError(fmt.Sprintf("unexpected argument %q: expected room id (!...) or event id ($...)", arg))
+		}
+	}
+
+	if eventID != "" && roomID == "" {
+		roomID = ctx.Event().RoomID
+	}
+
+	sent, err := bx.bot.RequestRoomKeys(ctx.Context(), roomID, eventID)
+	if err != nil && sent == 0 {
+		return "", err
+	}
+
+	out := fmt.Sprintf("Key requests sent: %d", sent)
+	if err != nil {
+		out += fmt.Sprintf("\n\nErrors: %s", err)
+	}
+	return out, nil
+}
+
+func (bx *Bobrix) adminAudit(ctx domcommands.CommandCtx, args []string) (string, error) {
+	since := ctx.Arg("period").Duration()
+
+	userID := id.UserID(args[0])
+	records, err := bx.QueryAudit(ctx.Context(), AuditQuery{
+		UserID: userID,
+		From:   time.Now().Add(-since),
+		Limit:  adminAuditLimit,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(records) == 0 {
+		return fmt.Sprintf("No invocations of `%s` in the last %s", userID, since), nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Invocations of `%s` in the last %s:\n\n", userID, since)
+	for _, rec := range records {
+		fmt.Fprintf(&sb, "- %s **%s**.%s in `%s` (%s)",
+			rec.Time.Format(time.RFC3339), rec.ServiceName, rec.Method, rec.RoomID, rec.Latency.Round(time.Millisecond))
+		if rec.ErrCode != 0 {
+			fmt.Fprintf(&sb, ": error %d %s", rec.ErrCode, rec.Error)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// lookupService - finds the service by ID or by name
+func (bx *Bobrix) lookupService(ref string) (*BobrixService, bool) {
+	if serviceID, err := uuid.Parse(ref); err == nil {
+		if svc, ok := bx.GetServiceByID(serviceID); ok {
+			return svc, true
+		}
+	}
+	return bx.GetServiceByName(ref)
+}
@@ -0,0 +1,327 @@
+package bobrix
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+	"github.com/tensved/bobrix/mxbot"
+)
+
+const (
+	auditWriteTimeout    = 5 * time.Second
+	maxAuditOutputLen    = 512
+	defaultAuditQueryMax = 100
+)
+
+// ErrAuditNotQueryable - the audit sink doesn't support queries
+var ErrAuditNotQueryable = errors.New("audit sink is not queryable")
+
+// AuditRecord - record of a single contract invocation
+type AuditRecord struct {
+	Time  time.Time `json:"time"`
+	BotID string    `json:"bot_id"`
+
+	UserID  id.UserID  `json:"user_id"`
+	RoomID  id.RoomID  `json:"room_id"`
+	EventID id.EventID `json:"event_id"`
+
+	ServiceID   string         `json:"service_id"`
+	ServiceName string         `json:"service_name"`
+	Method      string         `json:"method"`
+	Inputs      map[string]any `json:"inputs,omitempty"` // redacted according to contracts.Input.Redact
+
+	Output  string        `json:"output,omitempty"` // summary of the outputs
+	ErrCode int           `json:"err_code,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+// AuditQuery - filter of the audit records
+type AuditQuery struct {
+	UserID id.UserID // optional
+	From   time.Time // inclusive (optional)
+	To     time.Time // exclusive (optional)
+	Limit  int       // default: 100
+}
+
+// Match - checks if the record matches the query (without the limit)
+func (q AuditQuery) Match(rec AuditRecord) bool {
+	if q.UserID != "" && rec.UserID != q.UserID {
+		return false
+	}
+	if !q.From.IsZero() && rec.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !rec.Time.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+func (q AuditQuery) limit() int {
+	if q.Limit <= 0 {
+		return defaultAuditQueryMax
+	}
+	return q.Limit
+}
+
+// AuditSink - append-only storage of the audit records
+type AuditSink interface {
+	WriteAudit(ctx context.Context, rec AuditRecord) error
+}
+
+// AuditReader - audit sink that supports queries. Records are returned from the newest to the oldest
+type AuditReader interface {
+	QueryAudit(ctx context.Context, q AuditQuery) ([]AuditRecord, error)
+}
+
+// WithAudit - enables the audit log of the contract invocations.
+// Every resolved ServiceRequest and its outcome is written to the sink
+func WithAudit(sink AuditSink) BobrixOpts {
+	return func(bx *Bobrix) {
+		bx.audit = sink
+	}
+}
+
+// QueryAudit - returns the audit records (see WithAudit). The sink must implement AuditReader
+func (bx *Bobrix) QueryAudit(ctx context.Context, q AuditQuery) ([]AuditRecord, error) {
+	reader, ok := bx.audit.(AuditReader)
+	if !ok {
+		return nil, ErrAuditNotQueryable
+	}
+	return reader.QueryAudit(ctx, q)
+}
+
+// auditCall - audit record of the call in progress
+type auditCall struct {
+	bx      *Bobrix
+	rec     AuditRecord
+	started time.Time
+}
+
+// startAudit - starts the audit record of the resolved request. It returns nil if the audit is disabled
+func (bx *Bobrix) startAudit(ctx mxbot.Ctx, svc *BobrixService, req *ServiceRequest) *auditCall {
+	if bx.audit == nil {
+		return nil
+	}
+
+	evt := ctx.Event()
+	rec := AuditRecord{
+		BotID:       bx.bot.FullName(),
+		UserID:      evt.Sender,
+		RoomID:      evt.RoomID,
+		EventID:     evt.ID,
+		ServiceID:   svc.Service.ID.String(),
+		ServiceName: svc.Service.Name,
+		Method:      req.MethodName,
+	}
+
+	if method, ok := svc.Service.Methods[req.MethodName]; ok {
+		rec.Inputs = method.RedactInputs(req.InputParams)
+	} else {
+		rec.Inputs = (&contracts.Method{}).RedactInputs(req.InputParams)
+	}
+
+	return &auditCall{bx: bx, rec: rec, started: time.Now()}
+}
+
+// finish - writes the record with the outcome of the call. It is safe to call on nil
+func (a *auditCall) finish(resp *contracts.MethodResponse, errCode int, err error) {
+	if a == nil {
+		return
+	}
+
+	a.rec.Time = a.started.UTC()
+	a.rec.Latency = time.Since(a.started)
+	a.rec.ErrCode = errCode
+
+	if resp != nil {
+		a.rec.Output = summarizeOutputs(resp.Outputs)
+		if a.rec.ErrCode == 0 {
+			a.rec.ErrCode = resp.ErrCode
+		}
+		if err == nil {
+			err = resp.Err
+		}
+	}
+
+	if err != nil {
+		a.rec.Error = err.Error()
+		if a.rec.ErrCode == 0 {
+			a.rec.ErrCode = contracts.ErrCodeInternalServiceError
+		}
+	}
+
+	// the audit is written even if the request context is already canceled
+	ctx, cancel := context.WithTimeout(context.Background(), auditWriteTimeout)
+	defer cancel()
+
+	if err := a.bx.audit.WriteAudit(ctx, a.rec); err != nil {
+		a.bx.logger.Error("failed to write audit record",
+			"event", a.rec.EventID, "service", a.rec.ServiceName, "error", err)
+	}
+}
+
+// summarizeOutputs - short text description of the outputs for the audit
+func summarizeOutputs(outputs map[string]contracts.Output) string {
+	if len(outputs) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		out := outputs[name]
+
+		var value string
+		switch out.Type {
+		case contracts.IOTypeAudio, contracts.IOTypeImage, contracts.IOTypeVideo, contracts.IOTypeFile:
+			value = fmt.Sprintf("[%s]", out.Type)
+		default:
+			value = fmt.Sprint(out.Value())
+		}
+
+		parts = append(parts, name+"="+value)
+	}
+
+	summary := strings.Join(parts, "; ")
+	if utf8.RuneCountInString(summary) > maxAuditOutputLen {
+		summary = string([]rune(summary)[:maxAuditOutputLen]) + "…"
+	}
+	return summary
+}
+
+var _ AuditSink = (*SlogAuditSink)(nil)
+
+// SlogAuditSink - writes audit records to the logger. It is not queryable
+type SlogAuditSink struct {
+	logger *slog.Logger
+}
+
+// NewSlogAuditSink - SlogAuditSink constructor. If logger is nil, slog.Default() is used
+func NewSlogAuditSink(logger *slog.Logger) *SlogAuditSink {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogAuditSink{logger: logger}
+}
+
+func (s *SlogAuditSink) WriteAudit(ctx context.Context, rec AuditRecord) error {
+	s.logger.InfoContext(ctx, "audit",
+		"bot_id", rec.BotID,
+		"user_id", rec.UserID,
+		"room_id", rec.RoomID,
+		"event_id", rec.EventID,
+		"service_id", rec.ServiceID,
+		"service_name", rec.ServiceName,
+		"method", rec.Method,
+		"inputs", rec.Inputs,
+		"output", rec.Output,
+		"err_code", rec.ErrCode,
+		"error", rec.Error,
+		"latency", rec.Latency,
+	)
+	return nil
+}
+
+var (
+	_ AuditSink   = (*JSONLAuditSink)(nil)
+	_ AuditReader = (*JSONLAuditSink)(nil)
+)
+
+// JSONLAuditSink - appends audit records to a file (one JSON object per line).
+// Queries scan the whole file, so it fits small deployments
+type JSONLAuditSink struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewJSONLAuditSink - JSONLAuditSink constructor. The file is created if it doesn't exist
+func NewJSONLAuditSink(path string) (*JSONLAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+
+	return &JSONLAuditSink{path: path, file: f}, nil
+}
+
+func (s *JSONLAuditSink) WriteAudit(_ context.Context, rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.file.Write(line)
+	return err
+}
+
+func (s *JSONLAuditSink) QueryAudit(ctx context.Context, q AuditQuery) ([]AuditRecord, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // skip partially written lines
+		}
+		if q.Match(rec) {
+			records = append(records, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// newest first
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.After(records[j].Time)
+	})
+
+	if len(records) > q.limit() {
+		records = records[:q.limit()]
+	}
+	return records, nil
+}
+
+// Close - closes the audit file
+func (s *JSONLAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,117 @@
+package bobrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg"
+)
+
+var (
+	_ AuditSink   = (*PostgresAuditSink)(nil)
+	_ AuditReader = (*PostgresAuditSink)(nil)
+)
+
+// PostgresAuditSink - writes audit records to the table bobrix_audit_log
+// (id bigserial, created_at timestamptz, bot_id, user_id, room_id, event_id, service_id, service_name, method text,
+// inputs jsonb, output text, err_code int, error text, latency_ms bigint).
+// An index on (user_id, created_at) is recommended for queries
+type PostgresAuditSink struct {
+	provider pg.ExecutorProvider
+}
+
+// NewPostgresAuditSink - PostgresAuditSink constructor
+func NewPostgresAuditSink(provider pg.ExecutorProvider) *PostgresAuditSink {
+	return &PostgresAuditSink{provider: provider}
+}
+
+func (s *PostgresAuditSink) WriteAudit(ctx context.Context, rec AuditRecord) error {
+	inputs, err := json.Marshal(rec.Inputs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit inputs: %w", err)
+	}
+
+	exec := s.provider.Get(ctx)
+
+	q := `
+		INSERT INTO bobrix_audit_log(
+			created_at, bot_id, user_id, room_id, event_id,
+			service_id, service_name, method, inputs,
+			output, err_code, error, latency_ms
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		`
+	_, err = exec.Exec(ctx, q,
+		rec.Time, rec.BotID, rec.UserID.String(), rec.RoomID.String(), rec.EventID.String(),
+		rec.ServiceID, rec.ServiceName, rec.Method, inputs,
+		rec.Output, rec.ErrCode, rec.Error, rec.Latency.Milliseconds(),
+	)
+	return err
+}
+
+func (s *PostgresAuditSink) QueryAudit(ctx context.Context, q AuditQuery) ([]AuditRecord, error) {
+	exec := s.provider.Get(ctx)
+
+	var from, to *time.Time
+	if !q.From.IsZero() {
+		from = &q.From
+	}
+	if !q.To.IsZero() {
+		to = &q.To
+	}
+
+	rows, err := exec.Query(ctx, `
+		SELECT created_at, bot_id, user_id, room_id, event_id,
+			service_id, service_name, method, inputs,
+			output, err_code, error, latency_ms
+		FROM bobrix_audit_log
+		WHERE ($1 = '' OR user_id = $1)
+			AND ($2::timestamptz IS NULL OR created_at >= $2)
+			AND ($3::timestamptz IS NULL OR created_at < $3)
+		ORDER BY created_at DESC
+		LIMIT $4
+		`,
+		q.UserID.String(), from, to, q.limit(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("audit query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var records []AuditRecord
+	for rows.Next() {
+		var (
+			rec                     AuditRecord
+			userID, roomID, eventID string
+			inputs                  []byte
+			latencyMs               int64
+		)
+
+		if err := rows.Scan(
+			&rec.Time, &rec.BotID, &userID, &roomID, &eventID,
+			&rec.ServiceID, &rec.ServiceName, &rec.Method, &inputs,
+			&rec.Output, &rec.ErrCode, &rec.Error, &latencyMs,
+		); err != nil {
+			return nil, fmt.Errorf("audit scan failed: %w", err)
+		}
+
+		rec.UserID = id.UserID(userID)
+		rec.RoomID = id.RoomID(roomID)
+		rec.EventID = id.EventID(eventID)
+		rec.Latency = time.Duration(latencyMs) * time.Millisecond
+
+		if len(inputs) > 0 {
+			if err := json.Unmarshal(inputs, &rec.Inputs); err != nil {
+				return nil, fmt.Errorf("audit inputs decode failed: %w", err)
+			}
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, rows.Err()
+}
@@ -0,0 +1,189 @@
+package bobrix
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+)
+
+// memoryAuditSink - records the audit in memory
+type memoryAuditSink struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (s *memoryAuditSink) WriteAudit(_ context.Context, rec AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+	return nil
+}
+
+func TestHandleRequestAudit(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *ServiceRequest
+		offline bool
+		want    AuditRecord // compared fields: Method, Inputs, Output, ErrCode, Error
+	}{
+		{
+			name: "successful call with the redacted input",
+			req:  &ServiceRequest{ServiceName: "ada", MethodName: "echo", InputParams: map[string]any{"text": "secret"}},
+			want: AuditRecord{Method: "echo", Inputs: map[string]any{"text": contracts.RedactedValue}, Output: "text=secret"},
+		},
+		{
+			name: "unknown method",
+			req:  &ServiceRequest{ServiceName: "ada", MethodName: "sing"},
+			want: AuditRecord{Method: "sing", ErrCode: contracts.ErrCodeMethodNotFound, Error: `Method "sing" not found`},
+		},
+		{
+			name:    "offline service",
+			req:     &ServiceRequest{ServiceName: "ada", MethodName: "echo", InputParams: map[string]any{"text": "hi"}},
+			offline: true,
+			want: AuditRecord{
+				Method:  "echo",
+				Inputs:  map[string]any{"text": contracts.RedactedValue},
+				ErrCode: contracts.ErrCodeInternalServiceError,
+				Error:   `Service "ada" is offline`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memoryAuditSink{}
+			bot := &testBot{}
+			bx, svc, _ := newTestBobrix(t, bot, WithAudit(sink))
+			svc.Service.Methods["echo"].Inputs[0].Redact = contracts.RedactFull
+			svc.IsOnline = !tt.offline
+
+			evt := textMessage("$request", "hi")
+			if err := bx.handleRequest(bot.newCtx(t, evt), tt.req, ContractParserOpts{}); err != nil {
+				t.Fatalf("handleRequest: %v", err)
+			}
+
+			if len(sink.records) != 1 {
+				t.Fatalf("records = %+v, want one", sink.records)
+			}
+			got := sink.records[0]
+
+			if got.UserID != testUserID || got.RoomID != testRoomID || got.EventID != evt.ID ||
+				got.BotID != testBotID.String() || got.ServiceID != svc.Service.ID.String() || got.Time.IsZero() {
+				t.Errorf("record = %+v", got)
+			}
+			if got.Method != tt.want.Method || got.Output != tt.want.Output ||
+				got.ErrCode != tt.want.ErrCode || got.Error != tt.want.Error {
+				t.Errorf("outcome = %+v, want %+v", got, tt.want)
+			}
+			if len(got.Inputs) != len(tt.want.Inputs) || got.Inputs["text"] != tt.want.Inputs["text"] {
+				t.Errorf("inputs = %v, want %v", got.Inputs, tt.want.Inputs)
+			}
+		})
+	}
+}
+
+func TestJSONLAuditSinkQuery(t *testing.T) {
+	start := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	sink, err := NewJSONLAuditSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("sink: %v", err)
+	}
+	t.Cleanup(func() { _ = sink.Close() })
+
+	// written out of order: the queries return the newest first
+	for _, rec := range []AuditRecord{
+		{EventID: "$2", UserID: "@alice", Time: start.Add(2 * time.Hour)},
+		{EventID: "$1", UserID: "@alice", Time: start.Add(time.Hour)},
+		{EventID: "$3", UserID: "@bob", Time: start.Add(3 * time.Hour)},
+		{EventID: "$4", UserID: "@alice", Time: start.Add(4 * time.Hour)},
+	} {
+		if err := sink.WriteAudit(context.Background(), rec); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query AuditQuery
+		want  []id.EventID
+	}{
+		{name: "all", want: []id.EventID{"$4", "$3", "$2", "$1"}},
+		{name: "user", query: AuditQuery{UserID: "@alice"}, want: []id.EventID{"$4", "$2", "$1"}},
+		{
+			name:  "period",
+			query: AuditQuery{From: start.Add(2 * time.Hour), To: start.Add(4 * time.Hour)},
+			want:  []id.EventID{"$3", "$2"},
+		},
+		{name: "limit", query: AuditQuery{UserID: "@alice", Limit: 2}, want: []id.EventID{"$4", "$2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := sink.QueryAudit(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+
+			got := make([]id.EventID, len(records))
+			for i, rec := range records {
+				got[i] = rec.EventID
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("records = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeOutputs(t *testing.T) {
+	output := func(name string, typ contracts.IOType, value any) contracts.Output {
+		out := contracts.Output{Name: name, Type: typ}
+		out.SetValue(value)
+		return out
+	}
+
+	tests := []struct {
+		name    string
+		outputs []contracts.Output
+		want    string
+	}{
+		{name: "no outputs"},
+		{
+			name:    "sorted by name",
+			outputs: []contracts.Output{output("text", contracts.IOTypeText, "hi"), output("score", contracts.IOTypeText, 3)},
+			want:    "score=3; text=hi",
+		},
+		{
+			name:    "media is not written",
+			outputs: []contracts.Output{output("voice", contracts.IOTypeAudio, []byte("RIFF"))},
+			want:    "voice=[audio]",
+		},
+		{
+			name:    "long output is truncated",
+			outputs: []contracts.Output{output("text", contracts.IOTypeText, strings.Repeat("a", maxAuditOutputLen))},
+			want:    "text=" + strings.Repeat("a", maxAuditOutputLen-len("text=")) + "…",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs := make(map[string]contracts.Output, len(tt.outputs))
+			for _, out := range tt.outputs {
+				outputs[out.Name] = out
+			}
+
+			if got := summarizeOutputs(outputs); got != tt.want {
+				t.Errorf("summary = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 	"github.com/tensved/bobrix/contracts"
@@ -21,7 +22,14 @@ type BobrixService struct {
 	Service *contracts.Service
 	Handler ServiceHandler
 
-	IsOnline bool
+	IsOnline bool // set by the healthcheck with the auto switch
+
+	disabled atomic.Bool // disabled by the admin command: the service is offline whatever its health
+}
+
+// Online - the service accepts the requests: it's online and isn't disabled by the admin
+func (s *BobrixService) Online() bool {
+	return s.IsOnline && !s.disabled.Load()
 }
 
 // Bobrix - bot structure
@@ -34,6 +42,13 @@ type Bobrix struct {
 	serviceIDsByName map[string]uuid.UUID
 
 	Healthchecker Healthcheck
+	access        *AccessController // optional. See WithAccessControl
+	quotas        *QuotaManager     // optional. See WithQuotas
+	audit         AuditSink         // optional. See WithAudit
+	slots         *slotFiller       // optional. See WithSlotFilling
+	inflight      *inflightRegistry // requests in progress (they can be superseded or canceled)
+	parsers       []contractParser  // contract parsers (see SetContractParser)
+	media         MediaOpts         // limits of the attachments. See WithMedia
 	logger        *slog.Logger
 }
 
@@ -59,6 +74,7 @@ func NewBobrix(mxBot mxbot.Bot, opts ...BobrixOpts) *Bobrix {
 		bot:              mxBot,
 		servicesByID:     make(map[uuid.UUID]*BobrixService),
 		serviceIDsByName: make(map[string]uuid.UUID),
+		inflight:         newInflightRegistry(),
 		logger:           slog.Default().With("name", mxBot.Name()),
 	}
 
@@ -163,6 +179,9 @@ type ServiceRequest struct {
 	ServiceID   string         `json:"service_id"`
 	MethodName  string         `json:"method"`
 	InputParams map[string]any `json:"inputs"`
+
+	// roomDefaults - applies the settings of the room (io.bobrix.config) to the parsed request (see applyRoomDefaults)
+	roomDefaults func(cfg *mxbot.RoomConfig)
 }
 
 type ServiceHandle func(evt *event.Event) *ServiceRequest
@@ -175,6 +194,12 @@ type ContractParserOpts struct {
 	AfterCallHook func(ctx mxbot.Ctx, req *ServiceRequest, resp *contracts.MethodResponse) (string, int, error)
 }
 
+// contractParser - registered contract parser (see SetContractParser)
+type contractParser struct {
+	parse func(evt *event.Event) *ServiceRequest
+	opts  ContractParserOpts
+}
+
 // SetContractParser - set contract parser. It is used for parsing events to service requests
 // You can add hooks for pre-call and after-call with ContractParserOpts
 func (bx *Bobrix) SetContractParser(
@@ -186,152 +211,313 @@ func (bx *Bobrix) SetContractParser(
 		opt = opts[0]
 	}
 
-	bx.Use(
-		mxbot.NewMessageHandler(
-			func(ctx mxbot.Ctx) error {
-				req := parser(ctx.Event())
-
-				if raw := ctx.Event().Content.Raw; raw != nil {
-					if p, ok := raw[BobrixPromptTag]; ok {
-						bx.logger.Info("raw bobrix.prompt", "prompt", p)
-					}
-				}
-
-				// ignore non-contract events
-				if req == nil {
-					return nil
-				}
-
-				// must have at least service_id or service name
-				if req.ServiceID == "" && req.ServiceName == "" {
-					return nil
-				}
-
-				if ctx.IsHandled() {
-					return nil
-				}
-				if !ctx.TryClaim() {
-					return nil
-				}
-
-				// Only this bot claimed the request — start typing and stop when done.
-				stopTyping := bx.bot.EnsureTyping(ctx.Context(), ctx.Event().RoomID, bx.bot.GetTypingTimeout())
-				defer stopTyping()
-
-				// --- Resolve service: prefer ServiceID, fallback to ServiceName ---
-				var (
-					svc *BobrixService
-					ok  bool
-				)
-
-				if req.ServiceID != "" {
-					id, err := uuid.Parse(req.ServiceID)
-					if err != nil {
-						return ctx.ErrorAnswer(
-							fmt.Sprintf("Invalid service_id %q", req.ServiceID),
-							contracts.ErrCodeBadRequest,
-						)
-					}
-
-					svc, ok = bx.GetServiceByID(id)
-
-					if !ok && req.ServiceName != "" {
-						bx.logger.Warn("service_id not found, fallback to name",
-							"service_id", req.ServiceID,
-							"service_name", req.ServiceName,
-						)
-						svc, ok = bx.GetServiceByName(req.ServiceName)
-					}
-				} else {
-					svc, ok = bx.GetServiceByName(req.ServiceName)
-					if !ok {
-						bx.logger.Error("service not found", "service", req.ServiceName)
-						return ctx.ErrorAnswer(
-							fmt.Sprintf("Service %q not found", req.ServiceName),
-							contracts.ErrCodeServiceNotFound,
-						)
-					}
-				}
-
-				if !ok || svc == nil {
-					bx.logger.Error("service not found",
-						"service_id", req.ServiceID,
-						"service_name", req.ServiceName,
-					)
-
-					return ctx.ErrorAnswer(
-						fmt.Sprintf("Service %q not found", req.ServiceName),
-						contracts.ErrCodeServiceNotFound,
-					)
-				}
-
-				// service offline
-				if !svc.IsOnline {
-					svc.Handler(ctx, &contracts.MethodResponse{
-						Err: fmt.Errorf("Service %q is offline", svc.Service.Name),
-					}, nil)
-					return nil
-				}
-
-				callOpts := contracts.CallOpts{}
-				if thread := ctx.Thread(); thread != nil {
-					data := ConvertThreadToMessages(thread, ctx.Bot().FullName())
-					callOpts.Messages = data
-				}
-
-				if opt.PreCallHook != nil {
-					errMsg, errCode, err := opt.PreCallHook(ctx, req)
-					if err != nil {
-						if err := ctx.ErrorAnswer(errMsg, errCode); err != nil {
-							return err
-						}
-						return err
-					}
-				}
-
-				resp, err := svc.Service.CallMethod(
-					ctx.Context(),
-					req.MethodName,
-					req.InputParams,
-					callOpts,
-				)
-				if err != nil {
-					switch {
-					case errors.Is(err, contracts.ErrMethodNotFound):
-						if err := ctx.ErrorAnswer(
-							fmt.Sprintf("Method %q not found", req.MethodName),
-							contracts.ErrCodeMethodNotFound,
-						); err != nil {
-							return err
-						}
-					default:
-						// resp may be nil if CallMethod returned error before response creation
-						errCode := contracts.ErrCodeInternalServiceError
-						if resp != nil && resp.ErrCode != 0 {
-							errCode = resp.ErrCode
-						}
-						if err := ctx.ErrorAnswer(err.Error(), errCode); err != nil {
-							return err
-						}
-					}
-					return nil
-				}
-
-				if opt.AfterCallHook != nil {
-					errMsg, errCode, err := opt.AfterCallHook(ctx, req, resp)
-					if err != nil {
-						if err := ctx.ErrorAnswer(errMsg, errCode); err != nil {
-							return err
-						}
-						return err
-					}
-				}
-
-				svc.Handler(ctx, resp, nil)
-				return nil
-			},
-		),
+	bx.parsers = append(bx.parsers, contractParser{parse: parser, opts: opt})
+
+	handle := func(ctx mxbot.Ctx) error {
+		req := parser(ctx.Event())
+		bx.applyRoomDefaults(ctx.Context(), ctx.Event(), req)
+
+		if raw := ctx.Event().Content.Raw; raw != nil {
+			if p, ok := raw[BobrixPromptTag]; ok {
+				bx.logger.Info("raw bobrix.prompt", "prompt", p)
+			}
+		}
+
+		// ignore non-contract events
+		if req == nil {
+			return nil
+		}
+
+		// must have at least service_id or service name
+		if req.ServiceID == "" && req.ServiceName == "" {
+			contracts.CloseMedia(req.InputParams)
+			return nil
+		}
+
+		if ctx.IsHandled() || !ctx.TryClaim() {
+			// the request is handled by another bot
+			contracts.CloseMedia(req.InputParams)
+			return nil
+		}
+
+		return bx.handleRequest(ctx, req, opt)
+	}
+
+	bx.Use(mxbot.NewMessageHandler(handle))
+	// stickers are separate events (see StickerContractParser)
+	bx.Use(mxbot.NewEventHandler(event.EventSticker, handle))
+}
+
+// applyRoomDefaults - applies the settings of the event room to the request of the built-in parsers
+func (bx *Bobrix) applyRoomDefaults(ctx context.Context, evt *event.Event, req *ServiceRequest) {
+	if req == nil || req.roomDefaults == nil || evt.RoomID == "" {
+		return
+	}
+
+	if cfg, ok := bx.bot.RoomConfig(ctx, evt.RoomID); ok && cfg != nil {
+		req.roomDefaults(cfg)
+	}
+}
+
+// handleRequest - resolves the service of the claimed request, checks the room settings, access and quotas,
+// calls the method and passes the response to the service handler
+func (bx *Bobrix) handleRequest(ctx mxbot.Ctx, req *ServiceRequest, opt ContractParserOpts) error {
+	// Only this bot claimed the request — start typing and stop when done.
+	stopTyping := bx.bot.EnsureTyping(ctx.Context(), ctx.Event().RoomID, bx.bot.GetTypingTimeout())
+	defer stopTyping()
+
+	// media inputs are released when the request is done, unless the slot filling keeps them for the resumed request
+	keepMedia := false
+	defer func() {
+		if !keepMedia {
+			contracts.CloseMedia(req.InputParams)
+		}
+	}()
+
+	// --- Resolve service: prefer ServiceID, fallback to ServiceName ---
+	var (
+		svc *BobrixService
+		ok  bool
 	)
+
+	if req.ServiceID != "" {
+		id, err := uuid.Parse(req.ServiceID)
+		if err != nil {
+			return ctx.ErrorAnswer(
+				fmt.Sprintf("Invalid service_id %q", req.ServiceID),
+				contracts.ErrCodeBadRequest,
+			)
+		}
+
+		svc, ok = bx.GetServiceByID(id)
+
+		if !ok && req.ServiceName != "" {
+			bx.logger.Warn("service_id not found, fallback to name",
+				"service_id", req.ServiceID,
+				"service_name", req.ServiceName,
+			)
+			svc, ok = bx.GetServiceByName(req.ServiceName)
+		}
+	} else {
+		svc, ok = bx.GetServiceByName(req.ServiceName)
+		if !ok {
+			bx.logger.Error("service not found", "service", req.ServiceName)
+			return ctx.ErrorAnswer(
+				fmt.Sprintf("Service %q not found", req.ServiceName),
+				contracts.ErrCodeServiceNotFound,
+			)
+		}
+	}
+
+	if !ok || svc == nil {
+		bx.logger.Error("service not found",
+			"service_id", req.ServiceID,
+			"service_name", req.ServiceName,
+		)
+
+		return ctx.ErrorAnswer(
+			fmt.Sprintf("Service %q not found", req.ServiceName),
+			contracts.ErrCodeServiceNotFound,
+		)
+	}
+
+	audit := bx.startAudit(ctx, svc, req)
+
+	// errorAnswer - answers with the error and records the outcome in the audit
+	errorAnswer := func(msg string, code int) error {
+		audit.finish(nil, code, errors.New(msg))
+		return ctx.ErrorAnswer(msg, code)
+	}
+
+	roomCfg, _ := bx.bot.RoomConfig(ctx.Context(), ctx.Event().RoomID)
+
+	// service disabled by the room settings
+	if !roomCfg.IsServiceEnabled(svc.Service.Name, svc.Service.ID.String()) {
+		return errorAnswer(
+			fmt.Sprintf("Service %q is disabled in this room", svc.Service.Name),
+			contracts.ErrCodeForbidden,
+		)
+	}
+
+	if bx.access != nil {
+		decision, err := bx.access.Check(ctx.Context(), AccessRequest{
+			Sender:  ctx.Event().Sender,
+			RoomID:  ctx.Event().RoomID,
+			Service: svc,
+			Method:  req.MethodName,
+		})
+		if err != nil {
+			bx.logger.Error("access check failed", "service", svc.Service.Name, "error", err)
+			return errorAnswer("Failed to check access", contracts.ErrCodeInternalServiceError)
+		}
+
+		if !decision.Allowed {
+			bx.logger.Warn("access denied",
+				"service", svc.Service.Name,
+				"method", req.MethodName,
+				"sender", ctx.Event().Sender,
+				"room", ctx.Event().RoomID,
+				"policy", decision.Policy,
+				"reason", decision.Reason,
+			)
+			return errorAnswer(
+				fmt.Sprintf("Access to %q is denied", svc.Service.Name),
+				contracts.ErrCodeForbidden,
+			)
+		}
+	}
+
+	// service offline
+	if !svc.Online() {
+		offlineErr := fmt.Errorf("Service %q is offline", svc.Service.Name)
+		audit.finish(nil, contracts.ErrCodeInternalServiceError, offlineErr)
+		svc.Handler(ctx, &contracts.MethodResponse{
+			Err: offlineErr,
+		}, nil)
+		return nil
+	}
+
+	// missing required inputs are asked in a dialogue (see WithSlotFilling)
+	if method, known := svc.Service.Methods[req.MethodName]; known && bx.slots != nil {
+		if missing := method.MissingInputs(req.InputParams); len(missing) > 0 {
+			if err := bx.startSlotFilling(ctx, svc, req, missing); err != nil {
+				bx.logger.Error("failed to start slot filling", "service", svc.Service.Name, "error", err)
+				return errorAnswer("Failed to ask for the missing inputs", contracts.ErrCodeInternalServiceError)
+			}
+			keepMedia = true
+			return nil // the resumed request is audited when the method is called
+		}
+	}
+
+	call, done := bx.inflight.start(ctx, svc, req, stopTyping)
+	defer done()
+
+	callOpts := contracts.CallOpts{
+		Metadata: map[string]any{},
+	}
+	if thread := ctx.Thread(); thread != nil {
+		data := ConvertThreadToMessages(thread, ctx.Bot().FullName())
+		callOpts.Messages = data
+	}
+
+	if roomCfg != nil {
+		if roomCfg.SystemPrompt != "" {
+			callOpts.Messages = append(
+				contracts.Messages{{contracts.SystemRole: roomCfg.SystemPrompt}},
+				callOpts.Messages...,
+			)
+		}
+		if roomCfg.Language != "" {
+			callOpts.Metadata[contracts.MetadataKeyLanguage] = roomCfg.Language
+		}
+	}
+
+	if opt.PreCallHook != nil {
+		errMsg, errCode, err := opt.PreCallHook(ctx, req)
+		if err != nil {
+			if err := errorAnswer(errMsg, errCode); err != nil {
+				return err
+			}
+			return err
+		}
+	}
+
+	// unknown methods are reported by CallMethod and don't consume quota
+	if _, known := svc.Service.Methods[req.MethodName]; known && bx.quotas != nil {
+		err := bx.quotas.Consume(ctx.Context(), QuotaRequest{
+			Sender:  ctx.Event().Sender,
+			RoomID:  ctx.Event().RoomID,
+			Service: svc,
+			Method:  req.MethodName,
+		}, methodCost(svc, req.MethodName))
+		if qe, ok := asQuotaExceeded(err); ok {
+			bx.logger.Warn("quota exceeded",
+				"service", svc.Service.Name,
+				"sender", ctx.Event().Sender,
+				"room", ctx.Event().RoomID,
+				"scope", qe.Limit.Scope,
+				"period", qe.Limit.Period,
+			)
+			return errorAnswer(quotaExceededMessage(qe), contracts.ErrCodeTooManyRequests)
+		}
+		if err != nil {
+			// counters are unavailable: don't block the users because of the storage
+			bx.logger.Error("failed to consume quota", "service", svc.Service.Name, "error", err)
+		}
+	}
+
+	// attachments of the media messages are downloaded only for the allowed requests (after the access, offline and quota checks)
+	if input, err := downloadPendingMedia(ctx.Context(), req.InputParams); err != nil {
+		if errors.Is(err, ErrMediaTooLarge) || errors.Is(err, ErrInappropriateMimeType) {
+			return errorAnswer(mediaErrorMessage(input, err), contracts.ErrCodeBadRequest)
+		}
+		bx.logger.Error("failed to download media", "service", svc.Service.Name, "input", input, "error", err)
+		return errorAnswer(mediaErrorMessage(input, err), contracts.ErrCodeInternalServiceError)
+	}
+
+	// attachments must have the types allowed by the inputs
+	if method, known := svc.Service.Methods[req.MethodName]; known {
+		if problem := mediaTypeProblem(method, req.InputParams); problem != "" {
+			return errorAnswer(problem, contracts.ErrCodeBadRequest)
+		}
+	}
+
+	resp, err := svc.Service.CallMethod(
+		ctx.Context(),
+		req.MethodName,
+		req.InputParams,
+		callOpts,
+	)
+
+	// superseded by an edit or canceled by the user: the answer is not sent
+	if reason := call.cancelReason(); reason != "" {
+		bx.logger.Info("call canceled",
+			"service", svc.Service.Name,
+			"method", req.MethodName,
+			"event", ctx.Event().ID,
+			"reason", reason,
+		)
+		audit.finish(resp, contracts.ErrCodeCanceled, errors.New(reason))
+		return nil
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, contracts.ErrMethodNotFound):
+			if err := errorAnswer(
+				fmt.Sprintf("Method %q not found", req.MethodName),
+				contracts.ErrCodeMethodNotFound,
+			); err != nil {
+				return err
+			}
+		default:
+			// resp may be nil if CallMethod returned error before response creation
+			errCode := contracts.ErrCodeInternalServiceError
+			if resp != nil && resp.ErrCode != 0 {
+				errCode = resp.ErrCode
+			}
+			audit.finish(resp, errCode, err)
+			if err := ctx.ErrorAnswer(err.Error(), errCode); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if opt.AfterCallHook != nil {
+		errMsg, errCode, err := opt.AfterCallHook(ctx, req, resp)
+		if err != nil {
+			if err := errorAnswer(errMsg, errCode); err != nil {
+				return err
+			}
+			return err
+		}
+	}
+
+	audit.finish(resp, 0, nil)
+
+	svc.Handler(ctx, resp, nil)
+	return nil
 }
 
 func ConvertThreadToMessages(thread *mxbot.MessagesThread, botName string) contracts.Messages {
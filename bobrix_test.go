@@ -0,0 +1,266 @@
+package bobrix
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+	"github.com/tensved/bobrix/mxbot"
+	applctx "github.com/tensved/bobrix/mxbot/application/ctx"
+	dombot "github.com/tensved/bobrix/mxbot/domain/bot"
+	domhandlers "github.com/tensved/bobrix/mxbot/domain/handlers"
+	"github.com/tensved/bobrix/mxbot/messages"
+)
+
+const (
+	testBotID  = id.UserID("@bot:example.org")
+	testRoomID = id.RoomID("!room:example.org")
+	testUserID = id.UserID("@alice:example.org")
+)
+
+var _ dombot.BotMessaging = (*testBot)(nil)
+
+// testBot - the bot of the tests. The methods used by the tests are faked, the others panic (nil mxbot.Bot).
+// The messages of the bot are recorded
+type testBot struct {
+	mxbot.Bot
+
+	mu           sync.Mutex
+	handlers     []domhandlers.EventHandler
+	powerLevels  map[id.UserID]int
+	members      map[id.RoomID][]id.UserID
+	memberChecks int
+	roomConfig   *mxbot.RoomConfig
+	events       map[id.EventID]*event.Event
+	files        map[id.ContentURI][]byte
+	downloads    int
+	client       *mautrix.Client // RawClient (e.g. for /messages)
+	sent         []sentMessage
+}
+
+// sentMessage - message sent (or edited) by the bot
+type sentMessage struct {
+	ID      id.EventID
+	Body    string
+	ErrCode int        // error_code of the message (0 - not an error)
+	Edits   id.EventID // the edited message (EditMessage)
+}
+
+func (b *testBot) Name() string                                                  { return "bot" }
+func (b *testBot) FullName() string                                              { return testBotID.String() }
+func (b *testBot) UserID() id.UserID                                             { return testBotID }
+func (b *testBot) IsThreadEnabled() bool                                         { return false }
+func (b *testBot) GetTypingTimeout() time.Duration                               { return time.Second }
+func (b *testBot) EnsureTyping(context.Context, id.RoomID, time.Duration) func() { return func() {} }
+
+func (b *testBot) RawClient() any {
+	if b.client == nil {
+		return nil
+	}
+	return b.client
+}
+
+func (b *testBot) DecryptEvent(_ context.Context, evt *event.Event) (*event.Event, error) {
+	return evt, nil
+}
+
+func (b *testBot) AddEventHandler(handler domhandlers.EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *testBot) RoomConfig(context.Context, id.RoomID) (*mxbot.RoomConfig, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.roomConfig, b.roomConfig != nil
+}
+
+func (b *testBot) UserPowerLevel(_ context.Context, _ id.RoomID, userID id.UserID) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.powerLevels[userID], nil
+}
+
+func (b *testBot) IsJoinedMember(_ context.Context, roomID id.RoomID, userID id.UserID) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.memberChecks++
+	return slices.Contains(b.members[roomID], userID), nil
+}
+
+func (b *testBot) GetEvent(_ context.Context, _ id.RoomID, eventID id.EventID) (*event.Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	evt, ok := b.events[eventID]
+	if !ok {
+		return nil, fmt.Errorf("event %s not found", eventID)
+	}
+	return evt, nil
+}
+
+func (b *testBot) Download(ctx context.Context, uri id.ContentURI) ([]byte, error) {
+	r, err := b.DownloadStream(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func (b *testBot) DownloadStream(_ context.Context, uri id.ContentURI) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.downloads++
+	data, ok := b.files[uri]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", uri)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *testBot) SendMessage(_ context.Context, _ id.RoomID, msg messages.Message) (*messages.Handle, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sent := sentMessage{ID: id.EventID(fmt.Sprintf("$sent%d", len(b.sent))), Body: msg.AsEvent().Body, ErrCode: errCode(msg)}
+	b.sent = append(b.sent, sent)
+	return messages.NewHandle(b, testRoomID, sent.ID), nil
+}
+
+func (b *testBot) EditMessage(_ context.Context, _ id.RoomID, eventID id.EventID, msg messages.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent = append(b.sent, sentMessage{ID: eventID, Body: msg.AsEvent().Body, ErrCode: errCode(msg), Edits: eventID})
+	return nil
+}
+
+func (b *testBot) SendReaction(context.Context, id.RoomID, id.EventID, string) (*messages.Handle, error) {
+	return nil, nil
+}
+
+func (b *testBot) Redact(context.Context, id.RoomID, id.EventID, string) error {
+	return nil
+}
+
+// messages - the messages sent by the bot so far
+func (b *testBot) messages() []sentMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.sent)
+}
+
+// newCtx - ctx of the event as the dispatcher of the bot makes it
+func (b *testBot) newCtx(t *testing.T, evt *event.Event) mxbot.Ctx {
+	t.Helper()
+	ctx, err := applctx.NewDefaultCtx(context.Background(), evt, b, b, nil, nil)
+	if err != nil {
+		t.Fatalf("ctx: %v", err)
+	}
+	return ctx
+}
+
+// dispatch - passes the event to the handlers of its type in the order of registration
+func (b *testBot) dispatch(t *testing.T, evt *event.Event) {
+	t.Helper()
+
+	b.mu.Lock()
+	handlers := slices.Clone(b.handlers)
+	b.mu.Unlock()
+
+	ctx := b.newCtx(t, evt)
+	for _, h := range handlers {
+		if h.EventType() != evt.Type {
+			continue
+		}
+		if err := h.Handle(ctx); err != nil {
+			t.Errorf("handler of %s: %v", evt.ID, err)
+		}
+	}
+}
+
+func errCode(msg messages.Message) int {
+	code, _ := msg.AsJSON()["error_code"].(int)
+	return code
+}
+
+// newTestBobrix - the bot with the service "ada". Its method "echo" answers with the text input,
+// the method "wait" reports the start to started and blocks until its context is canceled
+func newTestBobrix(t *testing.T, bot *testBot, opts ...BobrixOpts) (*Bobrix, *BobrixService, <-chan struct{}) {
+	t.Helper()
+
+	bx := NewBobrix(bot, opts...)
+	started := make(chan struct{}, 1)
+	svc := &contracts.Service{
+		ID:   uuid.New(),
+		Name: "ada",
+		Methods: map[string]*contracts.Method{
+			"echo": {
+				Name:    "echo",
+				Inputs:  []contracts.Input{{Name: "text", Type: contracts.IOTypeText, IsRequired: true}},
+				Outputs: []contracts.Output{{Name: "text", Type: contracts.IOTypeText}},
+				Handler: &contracts.Handler{Do: func(c contracts.HandlerContext) error {
+					text, _ := c.GetString("text")
+					c.SetOutput("text", text)
+					return nil
+				}},
+			},
+			"wait": {
+				Name:    "wait",
+				Outputs: []contracts.Output{{Name: "text", Type: contracts.IOTypeText}},
+				Handler: &contracts.Handler{Do: func(c contracts.HandlerContext) error {
+					started <- struct{}{}
+					<-c.Context().Done()
+					c.SetOutput("text", "finished")
+					return nil
+				}},
+			},
+		},
+	}
+	bx.ConnectService(svc, answerText)
+
+	bs, _ := bx.GetServiceByID(svc.ID)
+	return bx, bs, started
+}
+
+// answerText - service handler of the tests: the text output is the answer
+func answerText(ctx mxbot.Ctx, resp *contracts.MethodResponse, _ any) {
+	if resp.Err != nil {
+		_ = ctx.ErrorAnswer(resp.Err.Error(), contracts.ErrCodeInternalServiceError)
+		return
+	}
+	out := resp.Outputs["text"]
+	_ = ctx.TextAnswer(fmt.Sprint(out.Value()))
+}
+
+// textMessage - message of the user in the test room
+func textMessage(eventID id.EventID, body string) *event.Event {
+	return &event.Event{
+		ID:     eventID,
+		Type:   event.EventMessage,
+		RoomID: testRoomID,
+		Sender: testUserID,
+		Content: event.Content{
+			Raw:    map[string]any{"msgtype": "m.text", "body": body},
+			Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: body},
+		},
+	}
+}
+
+// bodies - bodies of the messages
+func bodies(sent []sentMessage) []string {
+	out := make([]string, len(sent))
+	for i, msg := range sent {
+		out[i] = msg.Body
+	}
+	return out
+}
@@ -0,0 +1,189 @@
+package bobrix
+
+import (
+	"context"
+	"fmt"
+
+	"maunium.net/go/mautrix/event"
+
+	"github.com/tensved/bobrix/contracts"
+	"github.com/tensved/bobrix/mxbot"
+	applcommands "github.com/tensved/bobrix/mxbot/application/commands"
+	dombot "github.com/tensved/bobrix/mxbot/domain/bot"
+	domcommands "github.com/tensved/bobrix/mxbot/domain/commands"
+	"github.com/tensved/bobrix/mxbot/messages"
+)
+
+const (
+	defaultCancelCommand = "cancel"
+	defaultCancelNotice  = "Request cancelled"
+)
+
+var defaultStopReactions = []string{"🛑", "⏹️", "❌"}
+
+// CancelOpts - options of the cancellation of the requests in progress (see WithCancellation)
+type CancelOpts struct {
+	StopReactions []string // reactions on the request message that cancel it. Default: 🛑, ⏹️, ❌
+	Prefix        string   // prefix of the cancel command. Default: "/"
+	// CommandName - name of the cancel command. Default: "cancel".
+	// The command is handled out of the room order (see bot.ControlCommandRegistry)
+	CommandName string
+	Notice      string // message posted when the request is canceled. Default: "Request cancelled"
+}
+
+// WithCancellation - lets the users cancel their requests in progress:
+//   - redaction of the request message
+//   - "stop" reaction of the author on the request message
+//   - /cancel command (cancels every request of the user in the room and the slot filling dialogue)
+//
+// The context passed to Service.CallMethod is canceled, typing is stopped and a short notice is posted.
+// The answer of the canceled call is not sent
+func WithCancellation(opts ...CancelOpts) BobrixOpts {
+	return func(bx *Bobrix) {
+		var opt CancelOpts
+		if len(opts) > 0 {
+			opt = opts[0]
+		}
+		if len(opt.StopReactions) == 0 {
+			opt.StopReactions = defaultStopReactions
+		}
+		if opt.Prefix == "" {
+			opt.Prefix = domcommands.DefaultCommandPrefix
+		}
+		if opt.CommandName == "" {
+			opt.CommandName = defaultCancelCommand
+		}
+		if opt.Notice == "" {
+			opt.Notice = defaultCancelNotice
+		}
+
+		bx.Use(mxbot.NewRedactionHandler(func(ctx mxbot.Ctx) error {
+			redaction, ok := mxbot.AsRedaction(ctx.Event())
+			if !ok {
+				return nil
+			}
+
+			call, ok := bx.inflight.get(ctx.Event().RoomID, redaction.Redacts)
+			if !ok {
+				return nil
+			}
+
+			bx.cancelCall(ctx, call, fmt.Sprintf("request message redacted by %s", redaction.Sender), opt.Notice)
+			return nil
+		}))
+
+		bx.Use(mxbot.NewReactionHandler(func(ctx mxbot.Ctx) error {
+			reaction, ok := mxbot.AsReaction(ctx.Event())
+			if !ok {
+				return nil
+			}
+
+			call, ok := bx.inflight.get(ctx.Event().RoomID, reaction.Target)
+			if !ok || call.sender != reaction.Sender {
+				return nil // only the author can stop the request
+			}
+
+			bx.cancelCall(ctx, call, fmt.Sprintf("stopped with %s reaction", reaction.Key), opt.Notice)
+			return nil
+		}, mxbot.FilterReaction(opt.StopReactions...)))
+
+		bx.registerCancelCommand(opt)
+	}
+}
+
+func (bx *Bobrix) registerCancelCommand(opt CancelOpts) {
+	cmd := applcommands.NewCommand(
+		opt.CommandName,
+		func(ctx domcommands.CommandCtx) error {
+			// the slot filling dialogue may have handled the command already
+			if !ctx.TryClaim() {
+				return nil
+			}
+
+			evt := ctx.Event()
+
+			canceled := 0
+			for _, call := range bx.inflight.userCalls(evt.RoomID, evt.Sender) {
+				bx.cancelCall(ctx, call, fmt.Sprintf("canceled with the %s command", opt.CommandName), opt.Notice)
+				canceled++
+			}
+
+			if bx.slots != nil {
+				session, ok, err := bx.slots.store.GetSlotSession(ctx.Context(), evt.Sender, evt.RoomID)
+				if err != nil {
+					bx.logger.Error("failed to load slot session", "user", evt.Sender, "room", evt.RoomID, "error", err)
+				}
+				if ok {
+					bx.slots.discard(ctx.Context(), bx, session)
+					return ctx.TextAnswer(opt.Notice)
+				}
+			}
+
+			if canceled == 0 {
+				return ctx.ErrorAnswer("You have no requests in progress", contracts.ErrCodeBadRequest)
+			}
+
+			ctx.SetHandled()
+			return nil
+		},
+		domcommands.CommandConfig{
+			Prefix: opt.Prefix,
+			Description: map[string]string{
+				"en": "Cancel your requests in progress",
+			},
+		},
+	)
+
+	applcommands.Register(bx.bot, cmd)
+
+	// the command must not wait in the room order for the call it cancels
+	if registry, ok := bx.bot.(dombot.ControlCommandRegistry); ok {
+		registry.AddControlCommands(opt.Prefix + opt.CommandName)
+	}
+}
+
+// cancelCall - cancels the call and posts the notice as the answer to the request message.
+// The notice is sent with the context of the cancelling event: the context of the call is canceled
+func (bx *Bobrix) cancelCall(ctx mxbot.Ctx, call *inflightCall, reason, notice string) {
+	if !call.cancel(reason) {
+		return // already canceled
+	}
+
+	evt := call.ctx.Event()
+	bx.logger.Info("request canceled by the user",
+		"room", evt.RoomID,
+		"event", evt.ID,
+		"service", call.service,
+		"method", call.method,
+		"reason", reason,
+	)
+
+	sender, ok := bx.messaging()
+	if !ok {
+		return
+	}
+
+	msg := messages.NewText(notice)
+	msg.AddCustomFields(
+		messages.CustomField{Key: mxbot.AnswerToCustomField, Value: evt.ID},
+		messages.CustomField{Key: "error_code", Value: contracts.ErrCodeCanceled},
+	)
+	if thread := call.ctx.Thread(); thread != nil {
+		msg.SetRelatesTo(&event.RelatesTo{
+			Type:    event.RelThread,
+			EventID: thread.ParentID,
+			InReplyTo: &event.InReplyTo{
+				EventID: evt.ID,
+			},
+			IsFallingBack: true,
+		})
+	}
+
+	sendCtx := ctx.Context()
+	if sendCtx.Err() != nil {
+		sendCtx = context.Background()
+	}
+	if _, err := sender.SendMessage(sendCtx, evt.RoomID, msg); err != nil {
+		bx.logger.Error("failed to send cancel notice", "room", evt.RoomID, "error", err)
+	}
+}
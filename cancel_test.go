@@ -0,0 +1,111 @@
+package bobrix
+
+import (
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+)
+
+const requestID = id.EventID("$request")
+
+func reactionEvent(sender id.UserID, key string) *event.Event {
+	return &event.Event{
+		ID:     "$reaction",
+		Type:   event.EventReaction,
+		RoomID: testRoomID,
+		Sender: sender,
+		Content: event.Content{Parsed: &event.ReactionEventContent{
+			RelatesTo: event.RelatesTo{Type: event.RelAnnotation, EventID: requestID, Key: key},
+		}},
+	}
+}
+
+func redactionEvent(sender id.UserID, redacts id.EventID) *event.Event {
+	return &event.Event{
+		ID:      "$redaction",
+		Type:    event.EventRedaction,
+		RoomID:  testRoomID,
+		Sender:  sender,
+		Content: event.Content{Parsed: &event.RedactionEventContent{Redacts: redacts}},
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	tests := []struct {
+		name     string
+		evt      *event.Event // event that may cancel the request in progress
+		canceled bool
+	}{
+		{name: "redaction of the request", evt: redactionEvent(testUserID, requestID), canceled: true},
+		{name: "redaction of another message", evt: redactionEvent(testUserID, "$other")},
+		{name: "stop reaction of the author", evt: reactionEvent(testUserID, "🛑"), canceled: true},
+		{name: "stop reaction of another user", evt: reactionEvent("@bob:example.org", "🛑")},
+		{name: "other reaction of the author", evt: reactionEvent(testUserID, "👍")},
+		{name: "cancel command", evt: textMessage("$command", "/cancel"), canceled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := &testBot{}
+			bx, _, started := newTestBobrix(t, bot, WithCancellation())
+
+			ctx := bot.newCtx(t, textMessage(requestID, "wait"))
+			done := make(chan error, 1)
+			go func() {
+				done <- bx.handleRequest(ctx, &ServiceRequest{ServiceName: "ada", MethodName: "wait"}, ContractParserOpts{})
+			}()
+			<-started
+
+			bot.dispatch(t, tt.evt)
+
+			call, ok := bx.inflight.get(testRoomID, requestID)
+			if !ok {
+				t.Fatal("the request isn't in progress")
+			}
+			if got := call.cancelReason() != ""; got != tt.canceled {
+				t.Errorf("canceled = %v, want %v", got, tt.canceled)
+			}
+			if !tt.canceled {
+				bx.inflight.cancel(testRoomID, requestID, "the end of the test")
+			}
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("handleRequest: %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the canceled call hasn't returned")
+			}
+
+			// the answer of the canceled call isn't sent, the notice is sent by the cancelling event only
+			sent := bot.messages()
+			want := 0
+			if tt.canceled {
+				want = 1
+			}
+			if len(sent) != want {
+				t.Fatalf("messages = %q, want %d", bodies(sent), want)
+			}
+			if tt.canceled && (sent[0].Body != defaultCancelNotice || sent[0].ErrCode != contracts.ErrCodeCanceled) {
+				t.Errorf("notice = %+v", sent[0])
+			}
+		})
+	}
+}
+
+func TestCancelCommandWithoutRequests(t *testing.T) {
+	bot := &testBot{}
+	newTestBobrix(t, bot, WithCancellation())
+
+	bot.dispatch(t, textMessage("$command", "/cancel"))
+
+	sent := bot.messages()
+	if len(sent) != 1 || sent[0].ErrCode != contracts.ErrCodeBadRequest {
+		t.Errorf("messages = %+v, want the error answer", sent)
+	}
+}
@@ -2,6 +2,7 @@ package contracts
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -56,8 +57,47 @@ type HandlerContext interface {
 	SetMessages(messages Messages)
 }
 
-// Ensure DefaultHandlerContext implements HandlerContext.
-var _ HandlerContext = (*DefaultHandlerContext)(nil)
+// MediaContext is implemented by the handler contexts with the media inputs (see GetMedia).
+// It is separate from HandlerContext, so the existing implementations of HandlerContext keep compiling.
+type MediaContext interface {
+	// GetMedia retrieves the media handle of the specified input (audio, image, video, file).
+	GetMedia(inputName string) (*Media, bool)
+}
+
+// MetadataContext is implemented by the handler contexts with the call metadata (see GetMetadata).
+// It is separate from HandlerContext, so the existing implementations of HandlerContext keep compiling.
+type MetadataContext interface {
+	// Metadata returns the call metadata (see CallOpts.Metadata).
+	Metadata() map[string]any
+
+	// SetMetadata sets the call metadata.
+	SetMetadata(metadata map[string]any)
+}
+
+// Ensure DefaultHandlerContext implements HandlerContext, MediaContext and MetadataContext.
+var (
+	_ HandlerContext  = (*DefaultHandlerContext)(nil)
+	_ MediaContext    = (*DefaultHandlerContext)(nil)
+	_ MetadataContext = (*DefaultHandlerContext)(nil)
+)
+
+// GetMedia returns the media handle of the input if the context supports media inputs (see MediaContext).
+func GetMedia(c HandlerContext, inputName string) (*Media, bool) {
+	mc, ok := c.(MediaContext)
+	if !ok {
+		return nil, false
+	}
+	return mc.GetMedia(inputName)
+}
+
+// GetMetadata returns the call metadata if the context supports it (see MetadataContext).
+func GetMetadata(c HandlerContext) map[string]any {
+	mc, ok := c.(MetadataContext)
+	if !ok {
+		return nil
+	}
+	return mc.Metadata()
+}
 
 // DefaultHandlerContext is the default implementation of the HandlerContext interface.
 type DefaultHandlerContext struct {
@@ -66,6 +106,7 @@ type DefaultHandlerContext struct {
 	outputs map[string]Output
 
 	messages Messages
+	metadata map[string]any
 }
 
 type HandlerContextOpts func(handlerContext HandlerContext)
@@ -77,6 +118,15 @@ func WithMessages(messages Messages) HandlerContextOpts {
 	}
 }
 
+// WithMetadata returns a HandlerContextOpts that sets the call metadata in the handler context (see MetadataContext).
+func WithMetadata(metadata map[string]any) HandlerContextOpts {
+	return func(handlerContext HandlerContext) {
+		if mc, ok := handlerContext.(MetadataContext); ok {
+			mc.SetMetadata(metadata)
+		}
+	}
+}
+
 // NewHandlerContext creates a new DefaultHandlerContext with the provided context, inputs, and outputs.
 func NewHandlerContext(ctx context.Context, inputs map[string]Input, outputs map[string]Output, opts ...HandlerContextOpts) HandlerContext {
 	handlerContext := &DefaultHandlerContext{
@@ -84,6 +134,7 @@ func NewHandlerContext(ctx context.Context, inputs map[string]Input, outputs map
 		inputs:   inputs,
 		outputs:  outputs,
 		messages: Messages{},
+		metadata: map[string]any{},
 	}
 
 	for _, opt := range opts {
@@ -157,6 +208,28 @@ func (h *DefaultHandlerContext) GetBool(inputName string) (bool, bool) {
 	return inpBool, true
 }
 
+// GetMedia returns the media handle of the input.
+// Base64 strings (e.g. the default value or the request of a remote caller) are decoded into the in-memory media
+func (h *DefaultHandlerContext) GetMedia(inputName string) (*Media, bool) {
+	inp, ok := h.GetInput(inputName)
+	if !ok {
+		return nil, false
+	}
+
+	switch v := inp.Value().(type) {
+	case *Media:
+		return v, true
+	case string:
+		data, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, false
+		}
+		return NewMemoryMedia(inputName, "", data, ""), true
+	}
+
+	return nil, false
+}
+
 func (h *DefaultHandlerContext) GetInput(name string) (Input, bool) {
 	inp, ok := h.inputs[name]
 	return inp, ok
@@ -238,3 +311,11 @@ func (h *DefaultHandlerContext) Messages() Messages {
 func (h *DefaultHandlerContext) SetMessages(messages Messages) {
 	h.messages = messages
 }
+
+func (h *DefaultHandlerContext) Metadata() map[string]any {
+	return h.metadata
+}
+
+func (h *DefaultHandlerContext) SetMetadata(metadata map[string]any) {
+	h.metadata = metadata
+}
@@ -0,0 +1,179 @@
+package contracts
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// ContextStrategy - describes which messages survive when the history doesn't fit into the budget
+type ContextStrategy string
+
+const (
+	// ContextStrategyKeepSystemLatest keeps all system messages and fills the rest of the budget with the latest messages
+	ContextStrategyKeepSystemLatest ContextStrategy = "keep_system_latest"
+
+	// ContextStrategySlidingWindow keeps only the latest messages that fit into the budget
+	ContextStrategySlidingWindow ContextStrategy = "sliding_window"
+
+	// ContextStrategyPinnedFirst keeps the first message (e.g. the thread root) and the latest messages
+	ContextStrategyPinnedFirst ContextStrategy = "pinned_first"
+)
+
+// MetadataKeyContextWindow - key of the call metadata where ContextTruncation is stored
+const MetadataKeyContextWindow = "context_window"
+
+// Tokenizer estimates the size of a text in budget units (tokens, characters, etc.)
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// TokenizerFunc - adapter to use ordinary functions as Tokenizer
+type TokenizerFunc func(text string) int
+
+func (f TokenizerFunc) CountTokens(text string) int {
+	return f(text)
+}
+
+// CharTokenizer counts characters (runes). Use it for character budgets
+var CharTokenizer Tokenizer = TokenizerFunc(func(text string) int {
+	return utf8.RuneCountInString(text)
+})
+
+// ApproxTokenizer - rough token estimate (~4 characters per token).
+// It is used by default when ContextWindow.Tokenizer is not set
+var ApproxTokenizer Tokenizer = TokenizerFunc(func(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+})
+
+// ContextWindow - describes how the history (CallOpts.Messages) is assembled for a method call.
+// It can be declared on the Method or on the Service (method has priority)
+type ContextWindow struct {
+	MaxTokens   int             `json:"max_tokens" yaml:"max_tokens"`                         // budget in tokenizer units. 0 - unlimited
+	MaxMessages int             `json:"max_messages,omitempty" yaml:"max_messages,omitempty"` // max number of messages. 0 - unlimited
+	Strategy    ContextStrategy `json:"strategy,omitempty" yaml:"strategy,omitempty"`         // default: ContextStrategyKeepSystemLatest
+
+	Tokenizer Tokenizer `json:"-" yaml:"-"` // default: ApproxTokenizer
+}
+
+// ContextTruncation - result of the context assembly. It is recorded in the call metadata
+type ContextTruncation struct {
+	Strategy        ContextStrategy `json:"strategy"`
+	Budget          int             `json:"budget"`
+	TotalMessages   int             `json:"total_messages"`
+	KeptMessages    int             `json:"kept_messages"`
+	DroppedMessages int             `json:"dropped_messages"`
+	TotalTokens     int             `json:"total_tokens"`
+	KeptTokens      int             `json:"kept_tokens"`
+	Truncated       bool            `json:"truncated"`
+}
+
+// Validate - checks the strategy and the limits of the window
+func (w *ContextWindow) Validate() error {
+	switch w.Strategy {
+	case "", ContextStrategyKeepSystemLatest, ContextStrategySlidingWindow, ContextStrategyPinnedFirst:
+	default:
+		return fmt.Errorf("%w: unknown strategy %q", ErrInvalidContextWindow, w.Strategy)
+	}
+
+	if w.MaxTokens < 0 || w.MaxMessages < 0 {
+		return fmt.Errorf("%w: negative limit", ErrInvalidContextWindow)
+	}
+
+	return nil
+}
+
+// Build - assembles the history under the budget of the window.
+// The order of the kept messages is preserved. It returns an error if the window is invalid (see Validate)
+func (w *ContextWindow) Build(messages Messages) (Messages, ContextTruncation, error) {
+	if err := w.Validate(); err != nil {
+		return nil, ContextTruncation{}, err
+	}
+
+	strategy := w.Strategy
+	if strategy == "" {
+		strategy = ContextStrategyKeepSystemLatest
+	}
+
+	tokenizer := w.Tokenizer
+	if tokenizer == nil {
+		tokenizer = ApproxTokenizer
+	}
+
+	sizes := make([]int, len(messages))
+	total := 0
+	for i, msg := range messages {
+		for _, text := range msg {
+			sizes[i] += tokenizer.CountTokens(text)
+		}
+		total += sizes[i]
+	}
+
+	keep := make([]bool, len(messages))
+	used, count := 0, 0
+
+	fits := func(i int) bool {
+		if w.MaxMessages > 0 && count+1 > w.MaxMessages {
+			return false
+		}
+		return w.MaxTokens <= 0 || used+sizes[i] <= w.MaxTokens
+	}
+
+	take := func(i int) {
+		keep[i] = true
+		used += sizes[i]
+		count++
+	}
+
+	// 1) pinned messages go first
+	for i, msg := range messages {
+		if !isPinned(strategy, i, msg) {
+			continue
+		}
+		if fits(i) {
+			take(i)
+		}
+	}
+
+	// 2) fill the rest with the latest messages.
+	// stop on the first message that doesn't fit, so the tail stays contiguous
+	for i := len(messages) - 1; i >= 0; i-- {
+		if keep[i] || isPinned(strategy, i, messages[i]) {
+			continue
+		}
+		if !fits(i) {
+			break
+		}
+		take(i)
+	}
+
+	result := make(Messages, 0, count)
+	for i, msg := range messages {
+		if keep[i] {
+			result = append(result, msg)
+		}
+	}
+
+	return result, ContextTruncation{
+		Strategy:        strategy,
+		Budget:          w.MaxTokens,
+		TotalMessages:   len(messages),
+		KeptMessages:    count,
+		DroppedMessages: len(messages) - count,
+		TotalTokens:     total,
+		KeptTokens:      used,
+		Truncated:       count < len(messages),
+	}, nil
+}
+
+// isPinned - checks if the message is protected from truncation by the strategy
+func isPinned(strategy ContextStrategy, idx int, msg map[ChatRole]string) bool {
+	switch strategy {
+	case ContextStrategyKeepSystemLatest:
+		_, ok := msg[SystemRole]
+		return ok
+	case ContextStrategyPinnedFirst:
+		return idx == 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,85 @@
+package contracts
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// messageTokenizer - one token per message, so the budget is the number of messages
+var messageTokenizer = TokenizerFunc(func(string) int { return 1 })
+
+func TestContextWindowBuild(t *testing.T) {
+	history := Messages{
+		{SystemRole: "root"},
+		{UserRole: "first"},
+		{SystemRole: "rules"},
+		{AssistantRole: "second"},
+		{UserRole: "third"},
+	}
+
+	tests := []struct {
+		name   string
+		window ContextWindow
+		want   []string // texts of the kept messages
+		err    error
+	}{
+		{
+			name:   "unlimited",
+			window: ContextWindow{},
+			want:   []string{"root", "first", "rules", "second", "third"},
+		},
+		{
+			name:   "keep system and latest by default",
+			window: ContextWindow{MaxTokens: 3},
+			want:   []string{"root", "rules", "third"},
+		},
+		{
+			name:   "sliding window",
+			window: ContextWindow{MaxTokens: 3, Strategy: ContextStrategySlidingWindow},
+			want:   []string{"rules", "second", "third"},
+		},
+		{
+			name:   "pinned first",
+			window: ContextWindow{MaxMessages: 2, Strategy: ContextStrategyPinnedFirst},
+			want:   []string{"root", "third"},
+		},
+		{
+			name:   "unknown strategy",
+			window: ContextWindow{MaxTokens: 3, Strategy: "latest_only"},
+			err:    ErrInvalidContextWindow,
+		},
+		{
+			name:   "negative limit",
+			window: ContextWindow{MaxMessages: -1},
+			err:    ErrInvalidContextWindow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.window.Tokenizer = messageTokenizer
+
+			kept, truncation, err := tt.window.Build(history)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			var got []string
+			for _, msg := range kept {
+				for _, text := range msg {
+					got = append(got, text)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+			if truncation.DroppedMessages != len(history)-len(tt.want) || truncation.Truncated != (len(tt.want) < len(history)) {
+				t.Errorf("truncation = %+v", truncation)
+			}
+		})
+	}
+}
@@ -7,11 +7,15 @@ var (
 	ErrHandlerNotFound       = errors.New("handler not found")
 	ErrInputRequired         = errors.New("input is required")
 	ErrNoHealthCheckProvided = errors.New("no healthcheck provided")
+	ErrInvalidContextWindow  = errors.New("invalid context window")
 )
 
 const (
 	ErrCodeBadRequest           = 400 // invalid request / validation error
+	ErrCodeForbidden            = 403 // access to the service/method is denied
 	ErrCodeServiceNotFound      = 404 // service not found
 	ErrCodeMethodNotFound       = 405 // method not found
+	ErrCodeTooManyRequests      = 429 // quota exceeded
+	ErrCodeCanceled             = 499 // the call was canceled or superseded before it finished
 	ErrCodeInternalServiceError = 500 // internal server error
 )
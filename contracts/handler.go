@@ -12,6 +12,9 @@ type MethodResponse struct {
 	// ServiceName is the human-readable name of the service (optional/legacy/UI).
 	ServiceName string `json:"service_name,omitempty"`
 
+	// Metadata contains data about the call (e.g. context window truncation, see MetadataKeyContextWindow).
+	Metadata map[string]any `json:"metadata,omitempty"`
+
 	Err     error // Err contains any error encountered during method execution.
 	ErrCode int   // ErrCode is the error code of the method.
 }
@@ -2,6 +2,7 @@ package contracts
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 )
 
@@ -27,6 +28,8 @@ type Input struct {
 	Description  map[string]string `json:"description,omitempty" yaml:"description,omitempty"` // Optional description of the input.
 	DefaultValue any               `json:"default,omitempty" yaml:"default,omitempty"`         // Optional default value for the input.
 	IsRequired   bool              `json:"is_required" yaml:"is_required"`                     // Indicates if the input is required.
+	Redact       RedactionMode     `json:"redact,omitempty" yaml:"redact,omitempty"`           // How the value is hidden in logs and audit records.
+	MimeTypes    []string          `json:"mime_types,omitempty" yaml:"mime_types,omitempty"`   // Allowed MIME types of the media input (any type if empty).
 	value        any               // Internal value of the input.
 }
 
@@ -65,9 +68,15 @@ func (i *Input) AsPublic() InputPublic {
 		Description:  i.Description,
 		DefaultValue: i.DefaultValue,
 		IsRequired:   i.IsRequired,
+		MimeTypes:    i.MimeTypes,
 	}
 }
 
+// AcceptsMimeType reports whether the media of the given type can be used as the value of the input.
+func (i *Input) AcceptsMimeType(mimeType string) bool {
+	return len(i.MimeTypes) == 0 || slices.Contains(i.MimeTypes, mimeType)
+}
+
 // Output represents the output data of a method.
 type Output struct {
 	Name         string            `json:"name" yaml:"name"`                                   // Name of the output.
@@ -115,6 +124,7 @@ type InputPublic struct {
 	Description  map[string]string `json:"description,omitempty" yaml:"description,omitempty"` // Optional description of the input.
 	DefaultValue any               `json:"default,omitempty" yaml:"default,omitempty"`         // Optional default value for the input.
 	IsRequired   bool              `json:"is_required" yaml:"is_required"`                     // Indicates if the input is required.
+	MimeTypes    []string          `json:"mime_types,omitempty" yaml:"mime_types,omitempty"`   // Allowed MIME types of the media input.
 }
 
 // OutputPublic represents the output data of a method.
@@ -0,0 +1,131 @@
+package contracts
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrMediaClosed is returned when the content of the closed media is read.
+var ErrMediaClosed = errors.New("media is closed")
+
+// Media is the handle of the media input (audio, image, video, file).
+// The content is kept in memory or spooled to a temporary file (large files),
+// the services read it with Open instead of getting the whole file as a base64 string.
+// The handle is closed by the bot after the call: the content must not be used after the handler returns.
+type Media struct {
+	Name             string // file name (or the body of the message)
+	MimeType         string // MIME type detected from the content
+	DeclaredMimeType string // MIME type declared by the sender
+	Size             int64  // size of the (decrypted) content in bytes
+	SHA256           string // hex SHA-256 of the (decrypted) content
+
+	mu     sync.Mutex
+	data   []byte
+	path   string
+	closed bool
+}
+
+// NewMemoryMedia creates the media with the content kept in memory.
+func NewMemoryMedia(name, mimeType string, data []byte, sha256 string) *Media {
+	return &Media{
+		Name:             name,
+		MimeType:         mimeType,
+		DeclaredMimeType: mimeType,
+		Size:             int64(len(data)),
+		SHA256:           sha256,
+		data:             data,
+	}
+}
+
+// NewFileMedia creates the media with the content in the file. The file is removed by Close.
+func NewFileMedia(name, mimeType, path string, size int64, sha256 string) *Media {
+	return &Media{
+		Name:             name,
+		MimeType:         mimeType,
+		DeclaredMimeType: mimeType,
+		Size:             size,
+		SHA256:           sha256,
+		path:             path,
+	}
+}
+
+// Open returns a reader of the content. Every call returns a new reader from the start.
+func (m *Media) Open() (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrMediaClosed
+	}
+
+	if m.path != "" {
+		return os.Open(m.path)
+	}
+
+	return io.NopCloser(bytes.NewReader(m.data)), nil
+}
+
+// Bytes reads the whole content into memory.
+func (m *Media) Bytes() ([]byte, error) {
+	r, err := m.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// Base64 returns the content encoded in base64 (the format of the media inputs in earlier versions).
+func (m *Media) Base64() (string, error) {
+	data, err := m.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Path returns the path of the spooled file (empty if the content is in memory).
+func (m *Media) Path() string {
+	return m.path
+}
+
+// Close releases the content and removes the spooled file.
+func (m *Media) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	m.data = nil
+
+	if m.path != "" {
+		if err := os.Remove(m.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove media file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// String describes the media for logs, the content is not included.
+func (m *Media) String() string {
+	return fmt.Sprintf("[media %s, %d bytes]", m.MimeType, m.Size)
+}
+
+// CloseMedia closes the media values of the input data.
+func CloseMedia(inputData map[string]any) {
+	for _, value := range inputData {
+		if media, ok := value.(*Media); ok {
+			_ = media.Close()
+		}
+	}
+}
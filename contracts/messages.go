@@ -7,6 +7,8 @@ const (
 	UserRole ChatRole = "user"
 	// AssistantRole represents an AI assistant in the chat
 	AssistantRole ChatRole = "assistant"
+	// SystemRole represents system instructions (e.g. system prompt)
+	SystemRole ChatRole = "system"
 )
 
 type Messages []map[ChatRole]string
@@ -18,6 +18,12 @@ type Method struct {
 
 	IsDefault bool `json:"is_default" yaml:"is_default"`
 
+	// ContextWindow - history budget for the method (optional). Overrides the service window
+	ContextWindow *ContextWindow `json:"context_window,omitempty" yaml:"context_window,omitempty"`
+
+	// Cost - quota units consumed by one call of the method. 0 - one unit
+	Cost int64 `json:"cost,omitempty" yaml:"cost,omitempty"`
+
 	Handler *Handler `json:"handler" yaml:"handler"`
 }
 
@@ -58,17 +64,41 @@ func (m *Method) CallWithContext(ctx context.Context, inputData map[string]any,
 		opt = opts[0]
 	}
 
+	metadata := make(map[string]any, len(opt.Metadata)+1)
+	for k, v := range opt.Metadata {
+		metadata[k] = v
+	}
+
 	if opt.Messages != nil {
-		contextOpts = append(contextOpts, WithMessages(opt.Messages))
+		messages := opt.Messages
+
+		window := m.ContextWindow
+		if window == nil {
+			window = opt.ContextWindow
+		}
+
+		if window != nil {
+			var truncation ContextTruncation
+			messages, truncation, err = window.Build(messages)
+			if err != nil {
+				return nil, err
+			}
+			metadata[MetadataKeyContextWindow] = truncation
+		}
+
+		contextOpts = append(contextOpts, WithMessages(messages))
 	}
 
+	contextOpts = append(contextOpts, WithMetadata(metadata))
+
 	c := NewHandlerContext(ctx, inputs, outputs, contextOpts...) // create new handler context with the processed inputs
 
 	err = m.Handler.Do(c)
 
 	response := &MethodResponse{
-		Outputs: c.Outputs(),
-		Err:     err,
+		Outputs:  c.Outputs(),
+		Metadata: GetMetadata(c),
+		Err:      err,
 	}
 
 	return response, nil
@@ -174,6 +204,21 @@ func (m *Method) GetFileInputs() []Input {
 	return inputs
 }
 
+// MissingInputs - returns the required inputs that have neither a value in inputData nor a default value.
+// The inputs are returned in the order of declaration
+func (m *Method) MissingInputs(inputData map[string]any) []Input {
+	var missing []Input
+	for _, input := range m.Inputs {
+		if _, ok := inputData[input.Name]; ok {
+			continue
+		}
+		if input.IsRequired && input.DefaultValue == nil {
+			missing = append(missing, input)
+		}
+	}
+	return missing
+}
+
 // processInputs - checks the inputs of the method and fills values with default values
 // If the input is required and not present, it returns an error
 // If the input is not required and not present, it sets the default value
@@ -0,0 +1,92 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf8"
+)
+
+// RedactionMode - describes how the value of the input is hidden in logs and audit records
+type RedactionMode string
+
+const (
+	RedactNone   RedactionMode = ""       // the value is kept (long values are truncated)
+	RedactFull   RedactionMode = "full"   // the value is replaced with RedactedValue
+	RedactHash   RedactionMode = "hash"   // the value is replaced with its SHA-256 (values can be compared without being revealed)
+	RedactLength RedactionMode = "length" // only the length of the value is kept
+)
+
+// RedactedValue - placeholder of the fully redacted value
+const RedactedValue = "[redacted]"
+
+// maxRedactedLen - max length (in runes) of the value kept by RedactNone
+const maxRedactedLen = 256
+
+// Redacted returns the value prepared for logs and audit records according to the redaction mode of the input.
+// Binary inputs (audio, image, video, file) are never kept as is
+func (i *Input) Redacted(value any) any {
+	if value == nil {
+		return nil
+	}
+
+	switch i.Redact {
+	case RedactFull:
+		return RedactedValue
+	case RedactHash:
+		sum := sha256.Sum256([]byte(fmt.Sprint(value)))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	case RedactLength:
+		return fmt.Sprintf("[%d chars]", utf8.RuneCountInString(fmt.Sprint(value)))
+	}
+
+	switch i.Type {
+	case IOTypeAudio, IOTypeImage, IOTypeVideo, IOTypeFile:
+		return fmt.Sprintf("[%s]", i.Type)
+	case IOTypeNumber, IOTypeBoolean:
+		return value
+	}
+
+	return truncateValue(value)
+}
+
+// RedactInputs returns a copy of the input data prepared for logs and audit records.
+// Values of the undeclared inputs are truncated
+func (m *Method) RedactInputs(inputData map[string]any) map[string]any {
+	if inputData == nil {
+		return nil
+	}
+
+	out := make(map[string]any, len(inputData))
+	for name, value := range inputData {
+		out[name] = truncateValue(value)
+	}
+
+	for i := range m.Inputs {
+		input := &m.Inputs[i]
+		if value, ok := inputData[input.Name]; ok {
+			out[input.Name] = input.Redacted(value)
+		}
+	}
+
+	return out
+}
+
+func truncateValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return truncateString(v)
+	case nil, bool, int, int64, float64:
+		return v
+	default:
+		return truncateString(fmt.Sprint(v))
+	}
+}
+
+func truncateString(s string) string {
+	if utf8.RuneCountInString(s) <= maxRedactedLen {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:maxRedactedLen]) + "…"
+}
@@ -18,10 +18,23 @@ type Service struct {
 	Methods map[string]*Method `json:"methods" yaml:"methods"`
 
 	Pinger *Ping `json:"pinger,omitempty" yaml:"pinger,omitempty"`
+
+	// ContextWindow - default history budget for all methods of the service (optional)
+	ContextWindow *ContextWindow `json:"context_window,omitempty" yaml:"context_window,omitempty"`
 }
 
+// MetadataKeyLanguage - key of the call metadata with the preferred language of the answer (e.g. "en")
+const MetadataKeyLanguage = "language"
+
 type CallOpts struct {
 	Messages Messages
+
+	// ContextWindow - history budget used when the method doesn't declare its own (optional)
+	ContextWindow *ContextWindow
+
+	// Metadata - additional data about the call. It is available in the HandlerContext
+	// and is returned in MethodResponse.Metadata
+	Metadata map[string]any
 }
 
 // CallMethod - calls the method with the given name
@@ -33,6 +46,18 @@ func (s *Service) CallMethod(ctx context.Context, methodName string, inputData m
 		return nil, fmt.Errorf("%w: %s", ErrMethodNotFound, methodName)
 	}
 
+	// the service window is used only if neither the method nor the caller declare one
+	if method.ContextWindow == nil && s.ContextWindow != nil {
+		var opt CallOpts
+		if len(opts) > 0 {
+			opt = opts[0]
+		}
+		if opt.ContextWindow == nil {
+			opt.ContextWindow = s.ContextWindow
+		}
+		opts = []CallOpts{opt}
+	}
+
 	return method.CallWithContext(ctx, inputData, opts...)
 }
 
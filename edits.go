@@ -0,0 +1,326 @@
+package bobrix
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/mxbot"
+	dombot "github.com/tensved/bobrix/mxbot/domain/bot"
+	"github.com/tensved/bobrix/mxbot/messages"
+)
+
+const defaultEditLookback = 50
+
+// EditRerunOpts - options of the edit re-run (see WithEditRerun)
+type EditRerunOpts struct {
+	Lookback int // number of the last room events searched for the previous answer. Default: 50
+}
+
+// WithEditRerun - re-runs the request when the user edits the message the bot has already answered.
+// The new content goes through the contract parsers again and the previous answer of the bot
+// (found by the bobrix.answer_to field) is edited in place instead of posting a new message.
+// If the original request is still in progress, it is canceled and superseded by the edit
+func WithEditRerun(opts ...EditRerunOpts) BobrixOpts {
+	return func(bx *Bobrix) {
+		var opt EditRerunOpts
+		if len(opts) > 0 {
+			opt = opts[0]
+		}
+		if opt.Lookback <= 0 {
+			opt.Lookback = defaultEditLookback
+		}
+
+		// the handler goes before the contract parsers, so the edits are not treated as new requests
+		bx.Use(mxbot.NewEditHandler(func(ctx mxbot.Ctx) error {
+			return bx.handleEdit(ctx, opt)
+		}))
+	}
+}
+
+// handleEdit - re-runs the request of the edited message
+func (bx *Bobrix) handleEdit(ctx mxbot.Ctx, opt EditRerunOpts) error {
+	evt := ctx.Event()
+
+	edit, ok := mxbot.AsEdit(evt)
+	if !ok {
+		return nil
+	}
+
+	if ctx.IsHandled() || !ctx.TryClaim() {
+		return nil
+	}
+
+	superseded := bx.inflight.cancel(evt.RoomID, edit.Target, fmt.Sprintf("superseded by edit %s", evt.ID))
+
+	answer, err := bx.findAnswer(ctx.Context(), evt.RoomID, edit.Target, opt.Lookback)
+	if err != nil {
+		bx.logger.Warn("failed to find previous answer", "room", evt.RoomID, "event", edit.Target, "error", err)
+	}
+
+	// the bot didn't answer the message: it is not a request
+	if answer == "" && !superseded {
+		return nil
+	}
+
+	original, err := bx.loadEvent(ctx.Context(), evt, edit.Target)
+	if err != nil {
+		return fmt.Errorf("failed to load edited message: %w", err)
+	}
+
+	// clients ignore edits of the messages of other users
+	if original.Sender != evt.Sender {
+		return nil
+	}
+
+	rerun := editedEvent(original, evt, edit.NewContent)
+
+	for _, p := range bx.parsers {
+		req := p.parse(rerun)
+		bx.applyRoomDefaults(ctx.Context(), rerun, req)
+		if req == nil || (req.ServiceID == "" && req.ServiceName == "") {
+			continue
+		}
+
+		rerunCtx, err := bx.newEditCtx(ctx, rerun, answer)
+		if err != nil {
+			return err
+		}
+
+		bx.logger.Info("re-running edited request",
+			"room", evt.RoomID,
+			"event", edit.Target,
+			"edit", evt.ID,
+			"answer", answer,
+			"superseded", superseded,
+		)
+
+		return bx.handleRequest(rerunCtx, req, p.opts)
+	}
+
+	return nil
+}
+
+// editedEvent - the original message with the content after the edit.
+// The event keeps the id and the relations of the original message, so the answer refers to it
+func editedEvent(original, edit *event.Event, newContent *event.MessageEventContent) *event.Event {
+	content := *newContent
+	content.NewContent = nil
+	content.RelatesTo = original.Content.AsMessage().RelatesTo
+
+	raw := map[string]any{}
+	if rawNew, ok := edit.Content.Raw["m.new_content"].(map[string]any); ok {
+		raw = maps.Clone(rawNew)
+	}
+	if rel, ok := original.Content.Raw["m.relates_to"]; ok {
+		raw["m.relates_to"] = rel
+	} else {
+		delete(raw, "m.relates_to")
+	}
+
+	return &event.Event{
+		Type:      event.EventMessage,
+		ID:        original.ID,
+		RoomID:    original.RoomID,
+		Sender:    original.Sender,
+		Timestamp: edit.Timestamp,
+		Content: event.Content{
+			Raw:    raw,
+			Parsed: &content,
+		},
+	}
+}
+
+// findAnswer - finds the last answer of the bot to the event among the last room events (edits are skipped).
+// It returns an empty id if there is no answer
+func (bx *Bobrix) findAnswer(ctx context.Context, roomID id.RoomID, eventID id.EventID, lookback int) (id.EventID, error) {
+	client, ok := mxbot.AsMatrixClient(bx.bot.RawClient())
+	if !ok {
+		return "", fmt.Errorf("unsupported client type: %T", bx.bot.RawClient())
+	}
+
+	resp, err := client.Messages(ctx, roomID, "", "", mautrix.DirectionBackward, nil, lookback)
+	if err != nil {
+		return "", fmt.Errorf("failed to load room messages: %w", err)
+	}
+
+	// the events go from the newest to the oldest
+	for _, evt := range resp.Chunk {
+		if evt.Sender != bx.bot.UserID() {
+			continue
+		}
+
+		evt, err := bx.decryptEvent(ctx, evt)
+		if err != nil {
+			continue
+		}
+
+		if evt.Type != event.EventMessage {
+			continue
+		}
+		if answerTo, _ := evt.Content.Raw[mxbot.AnswerToCustomField].(string); answerTo != eventID.String() {
+			continue
+		}
+		if _, isEdit := mxbot.AsEdit(evt); isEdit {
+			continue
+		}
+
+		return evt.ID, nil
+	}
+
+	return "", nil
+}
+
+// messaging - messaging of the bot for the messages that are not answers to the event ctx
+func (bx *Bobrix) messaging() (dombot.BotMessaging, bool) {
+	// the bot facade sends messages only through the event ctx, the default bot can send them directly
+	m, ok := bx.bot.(dombot.BotMessaging)
+	return m, ok
+}
+
+// editCtx - ctx of the re-run request: the event is the edited message,
+// the first answer edits the previous answer of the bot (if any)
+type editCtx struct {
+	mxbot.Ctx // ctx of the edit event
+
+	evt       *event.Event
+	thread    *mxbot.MessagesThread
+	messaging dombot.BotMessaging
+
+	mu      sync.Mutex
+	replace id.EventID // previous answer (it is cleared after the first answer)
+}
+
+func (bx *Bobrix) newEditCtx(ctx mxbot.Ctx, evt *event.Event, answer id.EventID) (*editCtx, error) {
+	sender, ok := bx.messaging()
+	if !ok {
+		return nil, fmt.Errorf("bot %T can't send messages", bx.bot)
+	}
+
+	c := &editCtx{
+		Ctx:       ctx,
+		evt:       evt,
+		messaging: sender,
+		replace:   answer,
+	}
+
+	rel := evt.Content.AsMessage().RelatesTo
+	if rel != nil && rel.Type == event.RelThread && bx.bot.IsThreadEnabled() {
+		thread, err := bx.bot.GetThread(ctx.Context(), evt.RoomID, rel.EventID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load thread: %w", err)
+		}
+		c.thread = editedThread(thread, evt, answer)
+	}
+
+	return c, nil
+}
+
+// editedThread - the thread with the edited message instead of the original one and without the previous answer
+func editedThread(thread *mxbot.MessagesThread, evt *event.Event, answer id.EventID) *mxbot.MessagesThread {
+	if thread == nil {
+		return nil
+	}
+
+	edited := &mxbot.MessagesThread{
+		RoomID:   thread.RoomID,
+		ParentID: thread.ParentID,
+		Messages: make([]*event.Event, 0, len(thread.Messages)),
+	}
+	for _, msg := range thread.Messages {
+		switch msg.ID {
+		case answer:
+			continue
+		case evt.ID:
+			msg = evt
+		}
+		edited.Messages = append(edited.Messages, msg)
+	}
+
+	return edited
+}
+
+func (c *editCtx) Event() *event.Event {
+	return c.evt
+}
+
+func (c *editCtx) Thread() *mxbot.MessagesThread {
+	return c.thread
+}
+
+func (c *editCtx) SetThread(thread *mxbot.MessagesThread) {
+	c.thread = thread
+}
+
+// Send - sends the answer to the edited message. The first answer edits the previous answer of the bot
+func (c *editCtx) Send(msg messages.Message) error {
+	_, err := c.SendWithHandle(msg)
+	return err
+}
+
+// SendWithHandle - like Send. If the previous answer is edited, its handle is returned
+func (c *editCtx) SendWithHandle(msg messages.Message) (*messages.Handle, error) {
+	msg.AddCustomFields(messages.CustomField{Key: mxbot.AnswerToCustomField, Value: c.evt.ID})
+
+	c.mu.Lock()
+	replace := c.replace
+	c.replace = ""
+	c.mu.Unlock()
+
+	if replace != "" {
+		if err := c.messaging.EditMessage(c.Context(), c.evt.RoomID, replace, msg); err != nil {
+			return nil, err
+		}
+		return messages.NewHandle(c.messaging, c.evt.RoomID, replace), nil
+	}
+
+	if c.thread != nil {
+		msg.SetRelatesTo(&event.RelatesTo{
+			Type:    event.RelThread,
+			EventID: c.thread.ParentID,
+			InReplyTo: &event.InReplyTo{
+				EventID: c.evt.ID,
+			},
+			IsFallingBack: true,
+		})
+	}
+
+	return c.messaging.SendMessage(c.Context(), c.evt.RoomID, msg)
+}
+
+func (c *editCtx) TextSend(text string) error {
+	return c.Send(messages.NewText(text))
+}
+
+func (c *editCtx) Answer(msg messages.Message) error {
+	_, err := c.AnswerWithHandle(msg)
+	return err
+}
+
+func (c *editCtx) AnswerWithHandle(msg messages.Message) (*messages.Handle, error) {
+	h, err := c.SendWithHandle(msg)
+	if err == nil {
+		c.SetHandled()
+	}
+	return h, err
+}
+
+func (c *editCtx) TextAnswer(text string) error {
+	return c.Answer(messages.NewText(text))
+}
+
+func (c *editCtx) ErrorAnswer(errorText string, errorType int) error {
+	msg := messages.NewText(errorText)
+	msg.AddCustomFields(messages.CustomField{Key: "error_code", Value: errorType})
+	return c.Answer(msg)
+}
+
+// React - reacts to the edited (original) message
+func (c *editCtx) React(key string) (*messages.Handle, error) {
+	return c.messaging.SendReaction(c.Context(), c.evt.RoomID, c.evt.ID, key)
+}
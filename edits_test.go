@@ -0,0 +1,174 @@
+package bobrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/mxbot"
+)
+
+// withRoomHistory - the homeserver of the bot answers /messages with the events (from the newest to the oldest)
+func withRoomHistory(t *testing.T, bot *testBot, history ...map[string]any) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/messages") {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"start": "s1", "chunk": history})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := mautrix.NewClient(server.URL, testBotID, "token")
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	bot.client = client
+}
+
+// botAnswer - message of the bot in the room history. edits - the answer is an edit of this message
+func botAnswer(eventID id.EventID, answerTo id.EventID, edits id.EventID) map[string]any {
+	content := map[string]any{"msgtype": "m.text", "body": "answer", mxbot.AnswerToCustomField: answerTo}
+	if edits != "" {
+		content["m.relates_to"] = map[string]any{"rel_type": "m.replace", "event_id": edits}
+	}
+	return map[string]any{
+		"type":             "m.room.message",
+		"event_id":         eventID,
+		"sender":           testBotID,
+		"room_id":          testRoomID,
+		"origin_server_ts": 1,
+		"content":          content,
+	}
+}
+
+// editMessage - edit of the message of the user
+func editMessage(sender id.UserID, target id.EventID, body string) *event.Event {
+	return &event.Event{
+		ID:     "$edit",
+		Type:   event.EventMessage,
+		RoomID: testRoomID,
+		Sender: sender,
+		Content: event.Content{
+			Raw: map[string]any{
+				"msgtype":       "m.text",
+				"body":          "* " + body,
+				"m.new_content": map[string]any{"msgtype": "m.text", "body": body},
+				"m.relates_to":  map[string]any{"rel_type": "m.replace", "event_id": target},
+			},
+			Parsed: &event.MessageEventContent{
+				MsgType:    event.MsgText,
+				Body:       "* " + body,
+				NewContent: &event.MessageEventContent{MsgType: event.MsgText, Body: body},
+				RelatesTo:  &event.RelatesTo{Type: event.RelReplace, EventID: target},
+			},
+		},
+	}
+}
+
+// echoParser - "echo <text>" calls the method echo of ada
+func echoParser(evt *event.Event) *ServiceRequest {
+	text, ok := strings.CutPrefix(evt.Content.AsMessage().Body, "echo ")
+	if !ok {
+		return nil
+	}
+	return &ServiceRequest{ServiceName: "ada", MethodName: "echo", InputParams: map[string]any{"text": text}}
+}
+
+func TestEditRerun(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []map[string]any
+		sender  id.UserID // author of the edit
+		want    []sentMessage
+	}{
+		{
+			name:    "the answer is edited in place",
+			history: []map[string]any{botAnswer("$answer", requestID, "")},
+			sender:  testUserID,
+			want:    []sentMessage{{ID: "$answer", Body: "bye", Edits: "$answer"}},
+		},
+		{
+			name: "edits of the answer are skipped",
+			history: []map[string]any{
+				botAnswer("$answer-edit", requestID, "$answer"),
+				botAnswer("$answer", requestID, ""),
+			},
+			sender: testUserID,
+			want:   []sentMessage{{ID: "$answer", Body: "bye", Edits: "$answer"}},
+		},
+		{
+			name:    "the message without an answer is not a request",
+			history: []map[string]any{botAnswer("$answer", "$other", "")},
+			sender:  testUserID,
+		},
+		{
+			name:    "edits of other users are ignored",
+			history: []map[string]any{botAnswer("$answer", requestID, "")},
+			sender:  "@bob:example.org",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := &testBot{events: map[id.EventID]*event.Event{requestID: textMessage(requestID, "echo hi")}}
+			withRoomHistory(t, bot, tt.history...)
+
+			bx, _, _ := newTestBobrix(t, bot, WithEditRerun())
+			bx.SetContractParser(echoParser)
+
+			bot.dispatch(t, editMessage(tt.sender, requestID, "echo bye"))
+
+			sent := bot.messages()
+			if len(sent) != len(tt.want) {
+				t.Fatalf("messages = %+v, want %+v", sent, tt.want)
+			}
+			for i := range sent {
+				if sent[i] != tt.want[i] {
+					t.Errorf("message #%d = %+v, want %+v", i, sent[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestEditRerunSupersedes - the edit of the request in progress cancels it and answers with a new message
+func TestEditRerunSupersedes(t *testing.T) {
+	bot := &testBot{events: map[id.EventID]*event.Event{requestID: textMessage(requestID, "echo hi")}}
+	withRoomHistory(t, bot)
+
+	bx, _, started := newTestBobrix(t, bot, WithEditRerun())
+	bx.SetContractParser(echoParser)
+
+	ctx := bot.newCtx(t, textMessage(requestID, "wait"))
+	done := make(chan error, 1)
+	go func() {
+		done <- bx.handleRequest(ctx, &ServiceRequest{ServiceName: "ada", MethodName: "wait"}, ContractParserOpts{})
+	}()
+	<-started
+
+	bot.dispatch(t, editMessage(testUserID, requestID, "echo bye"))
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("handleRequest: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the superseded call hasn't returned")
+	}
+
+	// the superseded call doesn't answer
+	if sent := bot.messages(); len(sent) != 1 || sent[0].Body != "bye" || sent[0].Edits != "" {
+		t.Errorf("messages = %+v, want the new answer", sent)
+	}
+}
@@ -3,7 +3,11 @@ package bobrix
 import "errors"
 
 var (
-	ErrInappropriateMimeType = errors.New("inappropriate MIME type of audiofile")
-	ErrDownloadFile          = errors.New("failed to download audiofile")
+	ErrInappropriateMimeType = errors.New("inappropriate MIME type of media file")
+	ErrDownloadFile          = errors.New("failed to download media file")
 	ErrParseMXCURI           = errors.New("failed to parse MXC URI")
+	ErrNoMedia               = errors.New("message has no media")
+	ErrMediaTooLarge         = errors.New("media file is too large")
+	ErrMediaHashMismatch     = errors.New("SHA-256 of the encrypted media file doesn't match")
+	ErrInvalidGeoURI         = errors.New("invalid geo URI")
 )
@@ -114,13 +114,12 @@ func NewADAHandler(adaHost string) *contracts.Handler {
 				ResponseType: responseTypeString,
 			}
 
-			audioData := c.Get("audio")
-			if audioData != nil {
+			if audioData, ok := contracts.GetMedia(c, "audio"); ok {
 				message.RequestType = "speech"
 
-				audio, ok := audioData.(string)
-				if !ok {
-					return fmt.Errorf("audio not found")
+				audio, err := audioData.Base64()
+				if err != nil {
+					return fmt.Errorf("failed to read audio: %w", err)
 				}
 				message.Speech = audio
 			} else {
@@ -205,8 +205,9 @@ func (h *DefaultHealthcheck) GetHealth() *BobrixStatus {
 
 			health := h.getServiceHealth(ctx, service)
 
-			// if isAutoSwitch is enabled, update service status based on health
-			if h.isAutoSwitch {
+			// if isAutoSwitch is enabled, update service status based on health.
+			// The service disabled by the admin stays offline (see BobrixService.Online)
+			if h.isAutoSwitch && !service.disabled.Load() {
 				if health.Status == HealthOk {
 					h.bobrix.Services()[i].IsOnline = true
 
@@ -268,6 +269,11 @@ func (h *DefaultHealthcheck) getBotStatus(ctx context.Context) Health {
 }
 
 func (h *DefaultHealthcheck) getServiceHealth(ctx context.Context, service *BobrixService) Health {
+	return serviceHealth(ctx, service)
+}
+
+// serviceHealth - pings the service and returns its health
+func serviceHealth(ctx context.Context, service *BobrixService) Health {
 	status := Health{
 		LastChecked: time.Now(),
 		Status:      HealthOk,
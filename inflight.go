@@ -0,0 +1,144 @@
+package bobrix
+
+import (
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/mxbot"
+)
+
+// inflightKey - the event that started the request
+type inflightKey struct {
+	room  id.RoomID
+	event id.EventID
+}
+
+// inflightUserKey - requests of the user in the room
+type inflightUserKey struct {
+	room id.RoomID
+	user id.UserID
+}
+
+// inflightCall - request that is being processed by handleRequest
+type inflightCall struct {
+	ctx        mxbot.Ctx
+	sender     id.UserID
+	service    string
+	method     string
+	started    time.Time
+	stopTyping func()
+
+	mu       sync.Mutex
+	canceled string // reason of the cancellation (empty if the call is not canceled)
+}
+
+// cancelReason - returns the reason of the cancellation (empty if the call is not canceled)
+func (c *inflightCall) cancelReason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.canceled
+}
+
+// cancel - cancels the context of the call (passed to Service.CallMethod) and stops typing.
+// It returns false if the call is already canceled
+func (c *inflightCall) cancel(reason string) bool {
+	c.mu.Lock()
+	if c.canceled != "" {
+		c.mu.Unlock()
+		return false
+	}
+	c.canceled = reason
+	c.mu.Unlock()
+
+	c.ctx.Cancel()
+	if c.stopTyping != nil {
+		c.stopTyping()
+	}
+	return true
+}
+
+// inflightRegistry - requests in progress by the event that started them and by the user and the room
+type inflightRegistry struct {
+	mu     sync.Mutex
+	calls  map[inflightKey]*inflightCall
+	byUser map[inflightUserKey]map[inflightKey]struct{}
+}
+
+func newInflightRegistry() *inflightRegistry {
+	return &inflightRegistry{
+		calls:  make(map[inflightKey]*inflightCall),
+		byUser: make(map[inflightUserKey]map[inflightKey]struct{}),
+	}
+}
+
+// start - registers the call of the event. The returned func must be called when the call is finished.
+// stopTyping (optional) is called when the call is canceled
+func (r *inflightRegistry) start(ctx mxbot.Ctx, svc *BobrixService, req *ServiceRequest, stopTyping func()) (*inflightCall, func()) {
+	evt := ctx.Event()
+	key := inflightKey{room: evt.RoomID, event: evt.ID}
+	userKey := inflightUserKey{room: evt.RoomID, user: evt.Sender}
+
+	call := &inflightCall{
+		ctx:        ctx,
+		sender:     evt.Sender,
+		service:    svc.Service.Name,
+		method:     req.MethodName,
+		started:    time.Now(),
+		stopTyping: stopTyping,
+	}
+
+	r.mu.Lock()
+	r.calls[key] = call
+	if r.byUser[userKey] == nil {
+		r.byUser[userKey] = make(map[inflightKey]struct{})
+	}
+	r.byUser[userKey][key] = struct{}{}
+	r.mu.Unlock()
+
+	return call, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.calls[key] != call {
+			return
+		}
+		delete(r.calls, key)
+		delete(r.byUser[userKey], key)
+		if len(r.byUser[userKey]) == 0 {
+			delete(r.byUser, userKey)
+		}
+	}
+}
+
+// get - returns the call of the event
+func (r *inflightRegistry) get(roomID id.RoomID, eventID id.EventID) (*inflightCall, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	call, ok := r.calls[inflightKey{room: roomID, event: eventID}]
+	return call, ok
+}
+
+// userCalls - returns the calls of the user in the room
+func (r *inflightRegistry) userCalls(roomID id.RoomID, userID id.UserID) []*inflightCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := r.byUser[inflightUserKey{room: roomID, user: userID}]
+	calls := make([]*inflightCall, 0, len(keys))
+	for key := range keys {
+		calls = append(calls, r.calls[key])
+	}
+	return calls
+}
+
+// cancel - cancels the call of the event. It returns false if there is no such call or it is already canceled
+func (r *inflightRegistry) cancel(roomID id.RoomID, eventID id.EventID, reason string) bool {
+	call, ok := r.get(roomID, eventID)
+	if !ok {
+		return false
+	}
+	return call.cancel(reason)
+}
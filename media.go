@@ -0,0 +1,350 @@
+package bobrix
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+)
+
+const (
+	defaultMediaMaxSize     = 50 << 20 // 50 MiB
+	defaultMediaMemoryLimit = 8 << 20  // 8 MiB
+
+	// sniffLen - number of the first bytes used to detect the MIME type (see http.DetectContentType)
+	sniffLen = 512
+)
+
+// MediaOpts - limits and storage of the incoming attachments (see WithMedia and IngestMedia)
+type MediaOpts struct {
+	MaxSize          int64    // max size of the attachment in bytes. Default: 50 MiB
+	MemoryLimit      int64    // larger attachments are spooled to a temporary file. Default: 8 MiB
+	SpoolDir         string   // directory of the temporary files. Default: os.TempDir()
+	AllowedMimeTypes []string // allowed MIME types (detected from the content). Empty list allows any type
+}
+
+func (o MediaOpts) withDefaults() MediaOpts {
+	if o.MaxSize <= 0 {
+		o.MaxSize = defaultMediaMaxSize
+	}
+	if o.MemoryLimit <= 0 {
+		o.MemoryLimit = defaultMediaMemoryLimit
+	}
+	return o
+}
+
+// WithMedia - sets the limits of the attachments used as the inputs of the methods
+// (command inputs and slot filling answers)
+func WithMedia(opts MediaOpts) BobrixOpts {
+	return func(bx *Bobrix) {
+		bx.media = opts
+	}
+}
+
+// StreamDownloader - downloader that returns the content as a stream (the bot implements it).
+// Downloaders without streaming are read into memory
+type StreamDownloader interface {
+	DownloadStream(ctx context.Context, uri id.ContentURI) (io.ReadCloser, error)
+}
+
+// IngestMedia - downloads the attachment of the message and returns its handle:
+//   - the declared size and the downloaded size are limited by MaxSize
+//   - encrypted attachments are decrypted and their SHA-256 is verified
+//   - the MIME type is detected from the content, the declared type is used only if the format is unknown
+//   - large attachments are spooled to a temporary file
+//
+// The caller must close the handle
+func IngestMedia(ctx context.Context, dl Downloader, evt *event.Event, opts MediaOpts) (*contracts.Media, error) {
+	opts = opts.withDefaults()
+
+	msg, err := mediaContent(evt)
+	if err != nil {
+		return nil, err
+	}
+
+	var declared string
+	if msg.Info != nil {
+		declared = msg.Info.MimeType
+		if int64(msg.Info.Size) > opts.MaxSize {
+			return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrMediaTooLarge, msg.Info.Size, opts.MaxSize)
+		}
+	}
+
+	url := msg.URL
+	if msg.File != nil {
+		url = msg.File.URL
+	}
+	if url == "" {
+		return nil, fmt.Errorf("%w: url not found in message content", ErrDownloadFile)
+	}
+
+	mxcURI, err := url.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrParseMXCURI, err)
+	}
+
+	body, err := openDownload(ctx, dl, mxcURI)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDownloadFile, err)
+	}
+	defer body.Close()
+
+	var (
+		content    io.Reader = body
+		cipherHash hash.Hash
+	)
+	if msg.File != nil {
+		// the hash of the encrypted file is the hash of the ciphertext: it is calculated while the file is read
+		cipherHash = sha256.New()
+		content, err = decryptingReader(&msg.File.EncryptedFile, io.TeeReader(body, cipherHash))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDownloadFile, err)
+		}
+	}
+
+	spooled, err := spoolMedia(content, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if cipherHash != nil {
+		if base64.RawStdEncoding.EncodeToString(cipherHash.Sum(nil)) != strings.TrimRight(msg.File.Hashes.SHA256, "=") {
+			spooled.remove()
+			return nil, ErrMediaHashMismatch
+		}
+	}
+
+	mimeType := detectMimeType(declared, spooled.head)
+	if len(opts.AllowedMimeTypes) > 0 && !slices.Contains(opts.AllowedMimeTypes, mimeType) {
+		spooled.remove()
+		return nil, fmt.Errorf("%w: %s", ErrInappropriateMimeType, mimeType)
+	}
+
+	name := msg.FileName
+	if name == "" {
+		name = msg.Body
+	}
+
+	var media *contracts.Media
+	if spooled.path != "" {
+		media = contracts.NewFileMedia(name, mimeType, spooled.path, spooled.size, spooled.sha256)
+	} else {
+		media = contracts.NewMemoryMedia(name, mimeType, spooled.data, spooled.sha256)
+	}
+	media.DeclaredMimeType = declared
+
+	return media, nil
+}
+
+// decryptingReader - decrypts the attachment (AES-CTR) while it is read
+func decryptingReader(file *attachment.EncryptedFile, r io.Reader) (io.Reader, error) {
+	// checks the version, the algorithm and the lengths of the key, iv and hash
+	if err := file.PrepareForDecryption(); err != nil {
+		return nil, err
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(file.Key.Key, "="))
+	if err != nil {
+		return nil, attachment.InvalidKey
+	}
+	iv, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(file.InitVector, "="))
+	if err != nil {
+		return nil, attachment.InvalidInitVector
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cipher.StreamReader{S: cipher.NewCTR(block, iv), R: r}, nil
+}
+
+// mediaTypeProblem - checks the media inputs against the MIME types allowed by the method.
+// It returns the message for the user if the attachment doesn't fit
+func mediaTypeProblem(method *contracts.Method, inputData map[string]any) string {
+	for i := range method.Inputs {
+		input := &method.Inputs[i]
+
+		media, ok := inputData[input.Name].(*contracts.Media)
+		if !ok || input.AcceptsMimeType(media.MimeType) {
+			continue
+		}
+
+		return fmt.Sprintf("The attachment for %q has an unsupported type %s (allowed: %s)",
+			input.Name, media.MimeType, strings.Join(input.MimeTypes, ", "))
+	}
+	return ""
+}
+
+// mediaContent - returns the content of the media message
+func mediaContent(evt *event.Event) (*event.MessageEventContent, error) {
+	if evt == nil {
+		return nil, ErrNoMedia
+	}
+
+	if evt.Content.Parsed == nil {
+		if err := evt.Content.ParseRaw(evt.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			return nil, fmt.Errorf("%w: %w", ErrNoMedia, err)
+		}
+	}
+
+	msg := evt.Content.AsMessage()
+	if msg == nil || (evt.Type != event.EventSticker && !msg.MsgType.IsMedia()) {
+		return nil, ErrNoMedia
+	}
+
+	return msg, nil
+}
+
+func openDownload(ctx context.Context, dl Downloader, uri id.ContentURI) (io.ReadCloser, error) {
+	if sd, ok := dl.(StreamDownloader); ok {
+		return sd.DownloadStream(ctx, uri)
+	}
+
+	data, err := dl.Download(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// spooledMedia - content read by spoolMedia (in memory or in the temporary file)
+type spooledMedia struct {
+	data   []byte
+	path   string
+	size   int64
+	sha256 string
+	head   []byte // first bytes of the content for the MIME type detection
+}
+
+func (s *spooledMedia) remove() {
+	if s.path != "" {
+		_ = os.Remove(s.path)
+	}
+}
+
+// spoolMedia - reads the content up to MaxSize. It is kept in memory up to MemoryLimit, then it is moved to the file
+func spoolMedia(r io.Reader, opts MediaOpts) (*spooledMedia, error) {
+	var (
+		spooled = &spooledMedia{}
+		sum     = sha256.New()
+		buf     bytes.Buffer
+		file    *os.File
+		chunk   = make([]byte, 32<<10)
+	)
+
+	fail := func(err error) (*spooledMedia, error) {
+		if file != nil {
+			_ = file.Close()
+		}
+		spooled.remove()
+		return nil, err
+	}
+
+	for {
+		n, readErr := r.Read(chunk)
+		if n > 0 {
+			data := chunk[:n]
+
+			spooled.size += int64(n)
+			if spooled.size > opts.MaxSize {
+				return fail(fmt.Errorf("%w: more than %d bytes", ErrMediaTooLarge, opts.MaxSize))
+			}
+
+			sum.Write(data)
+			if missing := sniffLen - len(spooled.head); missing > 0 {
+				spooled.head = append(spooled.head, data[:min(missing, n)]...)
+			}
+
+			if file == nil && int64(buf.Len()+n) > opts.MemoryLimit {
+				f, err := os.CreateTemp(opts.SpoolDir, "bobrix-media-*")
+				if err != nil {
+					return fail(fmt.Errorf("failed to create media spool file: %w", err))
+				}
+				file, spooled.path = f, f.Name()
+
+				if _, err := buf.WriteTo(file); err != nil {
+					return fail(fmt.Errorf("failed to write media spool file: %w", err))
+				}
+			}
+
+			if file != nil {
+				if _, err := file.Write(data); err != nil {
+					return fail(fmt.Errorf("failed to write media spool file: %w", err))
+				}
+			} else {
+				buf.Write(data)
+			}
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return fail(fmt.Errorf("%w: %w", ErrDownloadFile, readErr))
+		}
+	}
+
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return fail(fmt.Errorf("failed to write media spool file: %w", err))
+		}
+	} else {
+		spooled.data = buf.Bytes()
+	}
+	spooled.sha256 = hex.EncodeToString(sum.Sum(nil))
+
+	return spooled, nil
+}
+
+// detectMimeType - MIME type of the content. The sniffed type wins over the declared one, except:
+//   - the format is unknown to the sniffer (application/octet-stream)
+//   - the types differ only in the top-level type (audio/webm is sniffed as video/webm, audio/ogg as application/ogg)
+func detectMimeType(declared string, head []byte) string {
+	sniffed := baseMimeType(http.DetectContentType(head))
+	declared = baseMimeType(declared)
+
+	if declared == "" {
+		return sniffed
+	}
+	if sniffed == "application/octet-stream" || mimeSubtype(sniffed) == mimeSubtype(declared) {
+		return declared
+	}
+	return sniffed
+}
+
+// baseMimeType - MIME type without the parameters (e.g. charset)
+func baseMimeType(t string) string {
+	if t == "" {
+		return ""
+	}
+	base, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(t, ";")[0]))
+	}
+	return base
+}
+
+func mimeSubtype(t string) string {
+	_, sub, _ := strings.Cut(t, "/")
+	return sub
+}
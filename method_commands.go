@@ -0,0 +1,325 @@
+package bobrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+	"github.com/tensved/bobrix/mxbot"
+	applcommands "github.com/tensved/bobrix/mxbot/application/commands"
+	domcommands "github.com/tensved/bobrix/mxbot/domain/commands"
+)
+
+// MethodCommandOpts - options of the method commands (see WithMethodCommands)
+type MethodCommandOpts struct {
+	Prefix  string         // command prefix. Default: "/"
+	Filters []mxbot.Filter // extra filters of the commands (e.g. mxbot.FilterTagMeOrPrivate)
+}
+
+// mediaMessageTypes - message types that can be bound to the media inputs
+var mediaMessageTypes = map[contracts.IOType]event.MessageType{
+	contracts.IOTypeAudio: event.MsgAudio,
+	contracts.IOTypeImage: event.MsgImage,
+	contracts.IOTypeVideo: event.MsgVideo,
+	contracts.IOTypeFile:  event.MsgFile,
+}
+
+// WithMethodCommands - exposes every method of the connected services as a command:
+//
+//	/{service}.{method} [--{input} <value>...] [text...]
+//
+// Flags are derived from the inputs of the method (names, types, defaults and required markers),
+// the first text input can also be passed as plain text after the flags (kept as it is, with its newlines and quotes).
+// Media inputs are bound to the attachment of the message or of the message it replies to:
+// it's downloaded only when the request is allowed (see Bobrix.handleRequest).
+// The calls go through the same pipeline as the contract parsers (room settings, access control, quotas, audit)
+func WithMethodCommands(opts ...MethodCommandOpts) BobrixOpts {
+	return func(bx *Bobrix) {
+		var opt MethodCommandOpts
+		if len(opts) > 0 {
+			opt = opts[0]
+		}
+		if opt.Prefix == "" {
+			opt.Prefix = domcommands.DefaultCommandPrefix
+		}
+
+		bx.registerMethodCommands(opt)
+	}
+}
+
+// registerMethodCommands - the commands are resolved on each message,
+// so services connected after this call are available too
+func (bx *Bobrix) registerMethodCommands(opt MethodCommandOpts) {
+	filters := append([]mxbot.Filter{
+		func(evt *event.Event) bool {
+			_, _, ok := bx.lookupMethodCommand(opt.Prefix, evt)
+			return ok
+		},
+	}, opt.Filters...)
+
+	bx.Use(mxbot.NewMessageHandler(
+		func(ctx mxbot.Ctx) error {
+			svc, method, ok := bx.lookupMethodCommand(opt.Prefix, ctx.Event())
+			if !ok {
+				return nil
+			}
+
+			if ctx.IsHandled() || !ctx.TryClaim() {
+				return nil
+			}
+
+			return bx.methodCommand(opt.Prefix, svc, method).Handler(ctx)
+		},
+		filters...,
+	))
+}
+
+// lookupMethodCommand - finds the service and the method of the command in the message ("/service.method ...")
+func (bx *Bobrix) lookupMethodCommand(prefix string, evt *event.Event) (*BobrixService, *contracts.Method, bool) {
+	if evt.Type != event.EventMessage {
+		return nil, nil, false
+	}
+	msg := evt.Content.AsMessage()
+	if msg == nil {
+		return nil, nil, false
+	}
+
+	words := strings.Fields(msg.Body)
+	if len(words) == 0 || !strings.HasPrefix(words[0], prefix) {
+		return nil, nil, false
+	}
+
+	serviceName, methodName, ok := strings.Cut(strings.TrimPrefix(words[0], prefix), ".")
+	if !ok || serviceName == "" || methodName == "" {
+		return nil, nil, false
+	}
+
+	for _, svc := range bx.Services() {
+		if !strings.EqualFold(commandServiceName(svc.Service), serviceName) {
+			continue
+		}
+
+		if method, ok := svc.Service.Methods[methodName]; ok {
+			return svc, method, true
+		}
+		for name, method := range svc.Service.Methods {
+			if strings.EqualFold(name, methodName) {
+				return svc, method, true
+			}
+		}
+	}
+
+	return nil, nil, false
+}
+
+// commandServiceName - name of the service in the commands (spaces are replaced with "_")
+func commandServiceName(svc *contracts.Service) string {
+	return strings.ToLower(strings.Join(strings.Fields(svc.Name), "_"))
+}
+
+// methodCommand - builds the command of the method from its inputs
+func (bx *Bobrix) methodCommand(prefix string, svc *BobrixService, method *contracts.Method) *domcommands.Command {
+	var (
+		flags     []domcommands.Flag
+		textInput *contracts.Input // input that can be passed as plain text
+	)
+
+	for i := range method.Inputs {
+		input := &method.Inputs[i]
+		if _, isMedia := mediaMessageTypes[input.Type]; isMedia {
+			continue
+		}
+
+		flag := domcommands.Flag{
+			Name:        input.Name,
+			Type:        inputArgType(input.Type),
+			Description: input.Description,
+			Default:     input.DefaultValue,
+			// with the slot filling the missing inputs are asked in a dialogue
+			Required: input.IsRequired && input.DefaultValue == nil && bx.slots == nil,
+		}
+
+		if textInput == nil && input.Type == contracts.IOTypeText {
+			textInput = input
+			flag.Required = false // checked in the handler: it may be passed as plain text
+		}
+
+		flags = append(flags, flag)
+	}
+
+	var args []domcommands.Arg
+	if textInput != nil {
+		args = []domcommands.Arg{{
+			Name:        textInput.Name,
+			Raw:         true,
+			Description: textInput.Description,
+		}}
+	}
+
+	var cmd *domcommands.Command
+	cmd = applcommands.NewCommand(
+		commandServiceName(svc.Service)+"."+method.Name,
+		func(ctx domcommands.CommandCtx) error {
+			inputs := make(map[string]any, len(method.Inputs))
+
+			// usageError - answers with the error and the usage of the command
+			usageError := func(msg string) error {
+				contracts.CloseMedia(inputs)
+				return ctx.ErrorAnswer(
+					applcommands.FormatParseError(&applcommands.ParseError{
+						Path: []*domcommands.Command{cmd},
+						Msg:  msg,
+					}),
+					domcommands.ErrCodeParse,
+				)
+			}
+
+			for _, flag := range flags {
+				if v := ctx.Flag(flag.Name); v.IsSet() {
+					inputs[flag.Name] = v.Any()
+				}
+			}
+
+			// the text is the value of the text input: it can't be passed twice
+			if textInput != nil {
+				if text := ctx.Arg(textInput.Name); text.IsSet() {
+					if _, ok := inputs[textInput.Name]; ok {
+						return usageError(fmt.Sprintf("--%s is set, unexpected text %q", textInput.Name, text.String()))
+					}
+					inputs[textInput.Name] = text.String()
+				}
+			}
+
+			for i := range method.Inputs {
+				input := &method.Inputs[i]
+
+				msgType, isMedia := mediaMessageTypes[input.Type]
+				if isMedia {
+					media, err := bx.attachedMedia(ctx, msgType)
+					if err != nil {
+						contracts.CloseMedia(inputs)
+						bx.logger.Error("failed to load attachment", "input", input.Name, "error", err)
+						return ctx.ErrorAnswer(mediaErrorMessage(input.Name, err), contracts.ErrCodeBadRequest)
+					}
+					if media != nil {
+						inputs[input.Name] = media
+					}
+				}
+
+				if _, ok := inputs[input.Name]; !ok && input.IsRequired && input.DefaultValue == nil && bx.slots == nil {
+					msg := fmt.Sprintf("missing required flag --%s", input.Name)
+					if isMedia {
+						msg = fmt.Sprintf("input %q requires an attachment (%s) in the message or in the replied message", input.Name, msgType)
+					}
+
+					return usageError(msg)
+				}
+			}
+
+			return bx.handleRequest(ctx, &ServiceRequest{
+				ServiceID:   svc.Service.ID.String(),
+				ServiceName: svc.Service.Name,
+				MethodName:  method.Name,
+				InputParams: inputs,
+			}, ContractParserOpts{})
+		},
+		domcommands.CommandConfig{
+			Prefix:      prefix,
+			Description: method.Description,
+			Args:        args,
+			Flags:       flags,
+		},
+	)
+
+	return cmd
+}
+
+// attachedMedia - the attachment of the given type of the message or of the message it replies to.
+// The attachment is downloaded by the allowed request (see pendingMedia). It returns nil if there is no such attachment
+func (bx *Bobrix) attachedMedia(ctx mxbot.Ctx, msgType event.MessageType) (*pendingMedia, error) {
+	evt := ctx.Event()
+
+	if msg := evt.Content.AsMessage(); msg != nil && msg.MsgType == msgType {
+		return bx.declaredMedia(evt, msg)
+	}
+
+	replyTo := evt.Content.AsMessage().GetRelatesTo().GetReplyTo()
+	if replyTo == "" {
+		return nil, nil
+	}
+
+	replied, err := bx.loadEvent(ctx.Context(), evt, replyTo)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg := replied.Content.AsMessage(); msg != nil && msg.MsgType == msgType {
+		return bx.declaredMedia(replied, msg)
+	}
+
+	return nil, nil
+}
+
+// declaredMedia - the attachment of the message with its declared size and type checked
+func (bx *Bobrix) declaredMedia(evt *event.Event, msg *event.MessageEventContent) (*pendingMedia, error) {
+	if err := checkDeclaredMedia(msg, bx.media); err != nil {
+		return nil, err
+	}
+	return &pendingMedia{evt: evt, downloader: bx.bot, opts: bx.media}, nil
+}
+
+// mediaErrorMessage - message for the user about the attachment that can't be used as the input
+func mediaErrorMessage(inputName string, err error) string {
+	switch {
+	case errors.Is(err, ErrMediaTooLarge):
+		return fmt.Sprintf("The attachment for %q is too large", inputName)
+	case errors.Is(err, ErrInappropriateMimeType):
+		return fmt.Sprintf("The attachment for %q has an unsupported type", inputName)
+	}
+	return fmt.Sprintf("Failed to load the attachment for %q", inputName)
+}
+
+// loadEvent - loads the event of the room and decrypts it if needed
+func (bx *Bobrix) loadEvent(ctx context.Context, from *event.Event, eventID id.EventID) (*event.Event, error) {
+	evt, err := bx.bot.GetEvent(ctx, from.RoomID, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load event %s: %w", eventID, err)
+	}
+
+	return bx.decryptEvent(ctx, evt)
+}
+
+// decryptEvent - parses the loaded event (e.g. from /messages) and decrypts it if needed
+func (bx *Bobrix) decryptEvent(ctx context.Context, evt *event.Event) (*event.Event, error) {
+	if err := evt.Content.ParseRaw(evt.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return nil, fmt.Errorf("failed to parse event %s: %w", evt.ID, err)
+	}
+
+	evt, err := bx.bot.DecryptEvent(ctx, evt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt event: %w", err)
+	}
+
+	if err := evt.Content.ParseRaw(evt.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return nil, fmt.Errorf("failed to parse event %s: %w", evt.ID, err)
+	}
+
+	return evt, nil
+}
+
+// inputArgType - type of the command flag for the input
+func inputArgType(t contracts.IOType) domcommands.ArgType {
+	switch t {
+	case contracts.IOTypeNumber:
+		return domcommands.ArgFloat
+	case contracts.IOTypeBoolean:
+		return domcommands.ArgBool
+	default:
+		return domcommands.ArgString
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix/event"
 
+	applcommands "github.com/tensved/bobrix/mxbot/application/commands"
 	appldispatcher "github.com/tensved/bobrix/mxbot/application/dispatcher"
 
 	dombot "github.com/tensved/bobrix/mxbot/domain/bot"
@@ -20,6 +21,7 @@ import (
 
 var _ dombot.FullBot = (*DefaultBot)(nil)
 var _ dombotctx.Bot = (*DefaultBot)(nil)
+var _ applcommands.Registry = (*DefaultBot)(nil)
 
 // Application-level coordinator
 type DefaultBot struct {
@@ -33,6 +35,7 @@ type DefaultBot struct {
 
 	// --- application layer
 	dispatcher *appldispatcher.Dispatcher
+	commands   *applcommands.Dispatcher // registered commands (see applcommands.Register)
 	ctxFactory domctx.CtxFactory
 
 	// --- infrastructure facades
@@ -49,6 +52,9 @@ type DefaultBot struct {
 	health      dombot.BotHealth
 	media       dombot.BotMedia
 	persence    dombot.BotPresenceControl
+	roomConfig  dombot.BotRoomConfig
+	queue       dombot.BotQueueControl
+	control     dombot.ControlCommandRegistry
 
 	// --- runtime state
 	logger *zerolog.Logger
@@ -92,8 +98,12 @@ func NewDefaultBot(
 		health:      facade,
 		media:       facade,
 		persence:    facade,
+		roomConfig:  facade,
+		queue:       facade,
+		control:     facade,
 
 		dispatcher: facade.Dispatcher,
+		commands:   applcommands.NewDispatcher(),
 		ctxFactory: facade.CtxFactory,
 
 		logger: logger,
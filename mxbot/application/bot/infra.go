@@ -2,14 +2,18 @@ package bot
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	applcommands "github.com/tensved/bobrix/mxbot/application/commands"
+	"github.com/tensved/bobrix/mxbot/domain/bot"
 	"github.com/tensved/bobrix/mxbot/domain/filters"
 	"github.com/tensved/bobrix/mxbot/domain/handlers"
+	"github.com/tensved/bobrix/mxbot/domain/roomconfig"
 	"github.com/tensved/bobrix/mxbot/domain/threads"
 	"github.com/tensved/bobrix/mxbot/messages"
 )
@@ -36,10 +40,22 @@ func (b *DefaultBot) Name() string {
 
 // ----- BotMessaging
 
-func (b *DefaultBot) SendMessage(ctx context.Context, roomID id.RoomID, msg messages.Message) error {
+func (b *DefaultBot) SendMessage(ctx context.Context, roomID id.RoomID, msg messages.Message) (*messages.Handle, error) {
 	return b.messaging.SendMessage(ctx, roomID, msg)
 }
 
+func (b *DefaultBot) EditMessage(ctx context.Context, roomID id.RoomID, eventID id.EventID, msg messages.Message) error {
+	return b.messaging.EditMessage(ctx, roomID, eventID, msg)
+}
+
+func (b *DefaultBot) SendReaction(ctx context.Context, roomID id.RoomID, eventID id.EventID, key string) (*messages.Handle, error) {
+	return b.messaging.SendReaction(ctx, roomID, eventID, key)
+}
+
+func (b *DefaultBot) Redact(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string) error {
+	return b.messaging.Redact(ctx, roomID, eventID, reason)
+}
+
 // ----- BotThreads
 
 func (b *DefaultBot) IsThreadEnabled() bool {
@@ -75,6 +91,10 @@ func (b *DefaultBot) JoinedMembersCount(ctx context.Context, roomID id.RoomID) (
 	return b.rooms.JoinedMembersCount(ctx, roomID)
 }
 
+func (b *DefaultBot) IsJoinedMember(ctx context.Context, roomID id.RoomID, userID id.UserID) (bool, error) {
+	return b.rooms.IsJoinedMember(ctx, roomID, userID)
+}
+
 func (b *DefaultBot) GetJoinedRoomsList(ctx context.Context) ([]id.RoomID, error) {
 	return b.rooms.GetJoinedRoomsList(ctx)
 }
@@ -131,6 +151,60 @@ func (b *DefaultBot) Download(ctx context.Context, mxcURL id.ContentURI) ([]byte
 	return b.media.Download(ctx, mxcURL)
 }
 
+func (b *DefaultBot) DownloadStream(ctx context.Context, mxcURL id.ContentURI) (io.ReadCloser, error) {
+	return b.media.DownloadStream(ctx, mxcURL)
+}
+
+// ----- BotRoomConfig
+
+func (b *DefaultBot) RoomConfig(ctx context.Context, roomID id.RoomID) (*roomconfig.Config, bool) {
+	return b.roomConfig.RoomConfig(ctx, roomID)
+}
+
+func (b *DefaultBot) SetRoomConfig(ctx context.Context, roomID id.RoomID, cfg *roomconfig.Config) error {
+	return b.roomConfig.SetRoomConfig(ctx, roomID, cfg)
+}
+
+func (b *DefaultBot) UserPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID) (int, error) {
+	return b.roomConfig.UserPowerLevel(ctx, roomID, userID)
+}
+
+// ----- BotQueueControl
+
+func (b *DefaultBot) QueueStats() bot.QueueStats {
+	return b.queue.QueueStats()
+}
+
+func (b *DefaultBot) RetryLastFailed(ctx context.Context) (id.EventID, error) {
+	return b.queue.RetryLastFailed(ctx)
+}
+
+func (b *DefaultBot) BackfillRoom(ctx context.Context, roomID id.RoomID) error {
+	return b.queue.BackfillRoom(ctx, roomID)
+}
+
+func (b *DefaultBot) DedupStatus(ctx context.Context, eventID id.EventID) (bot.DedupStatus, error) {
+	return b.queue.DedupStatus(ctx, eventID)
+}
+
+func (b *DefaultBot) DeadLetters(ctx context.Context, limit int) ([]bot.DeadLetter, error) {
+	return b.queue.DeadLetters(ctx, limit)
+}
+
+func (b *DefaultBot) ReplayDeadLetter(ctx context.Context, id string) error {
+	return b.queue.ReplayDeadLetter(ctx, id)
+}
+
+func (b *DefaultBot) DropDeadLetter(ctx context.Context, id string) error {
+	return b.queue.DropDeadLetter(ctx, id)
+}
+
+// ----- ControlCommandRegistry
+
+func (b *DefaultBot) AddControlCommands(commands ...string) {
+	b.control.AddControlCommands(commands...)
+}
+
 // ----- BotCrypto
 
 func (b *DefaultBot) DecryptEvent(ctx context.Context, evt *event.Event) (*event.Event, error) {
@@ -162,6 +236,10 @@ func (b *DefaultBot) RequestKey(ctx context.Context, evt *event.Event) error {
 	return b.crypto.RequestKey(ctx, evt)
 }
 
+func (b *DefaultBot) RequestRoomKeys(ctx context.Context, roomID id.RoomID, eventID id.EventID) (int, error) {
+	return b.crypto.RequestRoomKeys(ctx, roomID, eventID)
+}
+
 func (b *DefaultBot) HandleToDevice(ctx context.Context, evt *event.Event) {
 	b.crypto.HandleToDevice(ctx, evt)
 }
@@ -180,6 +258,11 @@ func (b *DefaultBot) AddEventHandler(h handlers.EventHandler) {
 	b.dispatcher.AddEventHandler(h)
 }
 
+// CommandDispatcher - registry of the commands registered in the bot
+func (b *DefaultBot) CommandDispatcher() *applcommands.Dispatcher {
+	return b.commands
+}
+
 func (b *DefaultBot) AddFilter(f filters.Filter) {
 	b.dispatcher.AddFilter(f)
 }
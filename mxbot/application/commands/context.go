@@ -1,10 +1,6 @@
 package commands
 
 import (
-	"strings"
-
-	"maunium.net/go/mautrix/event"
-
 	"github.com/tensved/bobrix/mxbot/domain/commands"
 	"github.com/tensved/bobrix/mxbot/domain/ctx"
 )
@@ -13,33 +9,30 @@ import (
 // provides access to the command and its arguments
 type defaultCommandCtx struct {
 	ctx.Ctx
-	args []string
+	inv *Invocation
 }
 
 func (c *defaultCommandCtx) Args() []string {
-	return c.args
+	return c.inv.Args
 }
 
-// NewCommandCtx - CommandCtx constructor
-// wrap the Ctx
-func NewCommandCtx(c ctx.Ctx) commands.CommandCtx {
-	return &defaultCommandCtx{
-		Ctx:  c,
-		args: parseArgsFromEvent(c.Event()),
-	}
+func (c *defaultCommandCtx) Arg(name string) commands.Value {
+	return c.inv.Values[name]
 }
 
-// parseArgsFromEvent - parse the arguments from the event
-// (ignores the command prefix and command name)
-func parseArgsFromEvent(evt *event.Event) []string {
-	if evt.Type != event.EventMessage {
-		return nil
-	}
+func (c *defaultCommandCtx) Flag(name string) commands.Value {
+	return c.inv.Flags[name]
+}
 
-	words := strings.Split(evt.Content.AsMessage().Body, " ")
-	if len(words) < 2 {
-		return []string{}
-	}
+func (c *defaultCommandCtx) Command() *commands.Command {
+	return c.inv.Command()
+}
 
-	return words[1:]
+// NewCommandCtx - CommandCtx constructor
+// wrap the Ctx with the parsed command
+func NewCommandCtx(c ctx.Ctx, inv *Invocation) commands.CommandCtx {
+	return &defaultCommandCtx{
+		Ctx: c,
+		inv: inv,
+	}
 }
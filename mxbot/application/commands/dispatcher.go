@@ -1,6 +1,10 @@
 package commands
 
 import (
+	"sort"
+	"strconv"
+	"strings"
+
 	applfilters "github.com/tensved/bobrix/mxbot/application/filters"
 
 	dombot "github.com/tensved/bobrix/mxbot/domain/bot"
@@ -9,8 +13,13 @@ import (
 	domhandlers "github.com/tensved/bobrix/mxbot/domain/handlers"
 )
 
+const defaultHelpCommand = "help"
+
+// Dispatcher - registry of the commands.
+// It keeps the handlers of the registered commands and builds the help over all of them
 type Dispatcher struct {
 	handlers []domhandlers.EventHandler
+	commands []*domcommands.Command
 }
 
 func NewDispatcher() *Dispatcher {
@@ -19,19 +28,117 @@ func NewDispatcher() *Dispatcher {
 	}
 }
 
+// Registry - bot that keeps the registry of its commands (e.g. for the help)
+type Registry interface {
+	CommandDispatcher() *Dispatcher
+}
+
+// Register - adds the command handler to the bot.
+// The command is added to the registry of the bot too (see Registry), so its help lists the command
 func Register(
 	dispatcher dombot.EventDispatcher,
 	cmd *domcommands.Command,
 	extraFilters ...domfilters.Filter,
 ) {
-	dispatcher.AddEventHandler(
-		domhandlers.NewMessageHandler(
-			cmd.Handler,
-			append(extraFilters, applfilters.FilterCommand(cmd))...,
-		),
+	if r, ok := dispatcher.(Registry); ok {
+		dispatcher.AddEventHandler(r.CommandDispatcher().Register(cmd, extraFilters...))
+		return
+	}
+	dispatcher.AddEventHandler(newHandler(cmd, extraFilters...))
+}
+
+func newHandler(cmd *domcommands.Command, extraFilters ...domfilters.Filter) domhandlers.EventHandler {
+	return domhandlers.NewMessageHandler(
+		cmd.Handler,
+		append(extraFilters, applfilters.FilterCommand(cmd))...,
 	)
 }
 
+// Register - adds the command to the registry
+func (d *Dispatcher) Register(cmd *domcommands.Command, extraFilters ...domfilters.Filter) domhandlers.EventHandler {
+	h := newHandler(cmd, extraFilters...)
+
+	d.handlers = append(d.handlers, h)
+	d.commands = append(d.commands, cmd)
+
+	return h
+}
+
+// Attach - adds the handlers of all registered commands to the bot
+func (d *Dispatcher) Attach(target dombot.EventDispatcher) {
+	for _, h := range d.handlers {
+		target.AddEventHandler(h)
+	}
+}
+
 func (d *Dispatcher) Handlers() []domhandlers.EventHandler {
 	return d.handlers
 }
+
+func (d *Dispatcher) Commands() []*domcommands.Command {
+	return d.commands
+}
+
+// HelpCommand - creates the "/help [command...]" command for the registered commands.
+// Without arguments it lists the commands, with arguments it shows the help of the (sub)command
+func (d *Dispatcher) HelpCommand(config ...domcommands.CommandConfig) *domcommands.Command {
+	cfg := domcommands.CommandConfig{
+		Prefix: domcommands.DefaultCommandPrefix,
+		Description: map[string]string{
+			"en": "Show the commands and their usage",
+		},
+	}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	cfg.Args = []domcommands.Arg{{
+		Name:        "command",
+		Variadic:    true,
+		Description: map[string]string{"en": "command (and subcommands) to describe"},
+	}}
+
+	return NewCommand(defaultHelpCommand, func(c domcommands.CommandCtx) error {
+		names := c.Args()
+		if len(names) == 0 {
+			return c.TextAnswer(d.list())
+		}
+
+		for _, root := range d.commands {
+			if !root.Matches(strings.TrimPrefix(names[0], root.Prefix)) {
+				continue
+			}
+
+			path := []*domcommands.Command{root}
+			for _, name := range names[1:] {
+				sub, ok := path[len(path)-1].Subcommand(name)
+				if !ok {
+					return c.ErrorAnswer(
+						FormatParseError(&ParseError{Path: path, Msg: "unknown subcommand " + strconv.Quote(name)}),
+						domcommands.ErrCodeParse,
+					)
+				}
+				path = append(path, sub)
+			}
+
+			return c.TextAnswer(Help(path))
+		}
+
+		return c.ErrorAnswer("Error: unknown command "+strconv.Quote(names[0]), domcommands.ErrCodeParse)
+	}, cfg)
+}
+
+func (d *Dispatcher) list() string {
+	cmds := append([]*domcommands.Command(nil), d.commands...)
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+
+	var sb strings.Builder
+	sb.WriteString("Commands:\n\n")
+	for _, cmd := range cmds {
+		sb.WriteString("- `" + Usage([]*domcommands.Command{cmd}) + "`")
+		if desc := describe(cmd.Description, defaultHelpLang); desc != "" {
+			sb.WriteString(" - " + desc)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
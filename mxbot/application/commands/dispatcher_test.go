@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	domfilters "github.com/tensved/bobrix/mxbot/domain/filters"
+	domhandlers "github.com/tensved/bobrix/mxbot/domain/handlers"
+)
+
+// registryBot - event dispatcher with the registry of the commands
+type registryBot struct {
+	handlers []domhandlers.EventHandler
+	commands *Dispatcher
+}
+
+func (b *registryBot) AddEventHandler(h domhandlers.EventHandler) { b.handlers = append(b.handlers, h) }
+func (b *registryBot) AddFilter(domfilters.Filter)                {}
+func (b *registryBot) CommandDispatcher() *Dispatcher             { return b.commands }
+
+func TestRegisterAddsToRegistry(t *testing.T) {
+	bot := &registryBot{commands: NewDispatcher()}
+
+	Register(bot, NewCommand("cancel", nil))
+	Register(bot, NewCommand("admin", nil))
+
+	if len(bot.handlers) != 2 {
+		t.Fatalf("handlers = %d, want 2", len(bot.handlers))
+	}
+
+	help := bot.commands.list()
+	for _, name := range []string{"/cancel", "/admin"} {
+		if !strings.Contains(help, name) {
+			t.Errorf("help doesn't list %s:\n%s", name, help)
+		}
+	}
+}
@@ -1,13 +1,15 @@
 package commands
 
 import (
+	"errors"
+
 	domcommands "github.com/tensved/bobrix/mxbot/domain/commands"
 	domctx "github.com/tensved/bobrix/mxbot/domain/ctx"
 )
 
 // NewCommand - Command constructor
 // name - name of the command
-// commandHandler - handler of the command
+// commandHandler - handler of the command (may be nil for commands with subcommands only)
 // config - config of the command (optional)
 func NewCommand(
 	name string,
@@ -22,13 +24,55 @@ func NewCommand(
 		cfg = config[0]
 	}
 
-	return &domcommands.Command{
+	cmd := &domcommands.Command{
 		Prefix:      cfg.Prefix,
 		Name:        name,
 		Description: cfg.Description,
-		Handler: func(c domctx.Ctx) error {
-			commandCtx := NewCommandCtx(c)
-			return handler(commandCtx)
-		},
+		Run:         handler,
+		Aliases:     cfg.Aliases,
+		Args:        cfg.Args,
+		Flags:       cfg.Flags,
+		Subcommands: cfg.Subcommands,
+	}
+
+	for _, sub := range cmd.Subcommands {
+		inheritPrefix(sub, cmd.Prefix)
+	}
+
+	cmd.Handler = func(c domctx.Ctx) error {
+		return run(cmd, c)
+	}
+
+	return cmd
+}
+
+// run - parses the message and runs the command (or its subcommand)
+func run(root *domcommands.Command, c domctx.Ctx) error {
+	msg := c.Event().Content.AsMessage()
+	if msg == nil {
+		return nil
+	}
+
+	inv, err := Parse(root, msg.Body)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			return c.ErrorAnswer(FormatParseError(parseErr), domcommands.ErrCodeParse)
+		}
+		return err
+	}
+
+	cmd := inv.Command()
+	if inv.Help || cmd.Run == nil {
+		return c.TextAnswer(Help(inv.Path))
+	}
+
+	return cmd.Run(NewCommandCtx(c, inv))
+}
+
+func inheritPrefix(cmd *domcommands.Command, prefix string) {
+	cmd.Prefix = prefix
+	for _, sub := range cmd.Subcommands {
+		inheritPrefix(sub, prefix)
 	}
 }
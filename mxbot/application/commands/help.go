@@ -0,0 +1,159 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	domcommands "github.com/tensved/bobrix/mxbot/domain/commands"
+)
+
+const defaultHelpLang = "en"
+
+// Usage - one-line usage of the command (e.g. "/admin backfill [--limit <int>] [room_id]").
+// path is the root command and the chosen subcommands
+func Usage(path []*domcommands.Command) string {
+	cmd := path[len(path)-1]
+
+	var sb strings.Builder
+	sb.WriteString(path[0].Prefix)
+	for i, c := range path {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(c.Name)
+	}
+
+	if len(cmd.Subcommands) > 0 {
+		if cmd.Run == nil {
+			sb.WriteString(" <subcommand>")
+		} else {
+			sb.WriteString(" [subcommand]")
+		}
+	}
+
+	for _, flag := range cmd.Flags {
+		usage := "--" + flag.Name
+		if flagType(flag.Type) != domcommands.ArgBool {
+			usage += fmt.Sprintf(" <%s>", flagType(flag.Type))
+		}
+		if flag.Required {
+			fmt.Fprintf(&sb, " %s", usage)
+		} else {
+			fmt.Fprintf(&sb, " [%s]", usage)
+		}
+	}
+
+	for _, arg := range cmd.Args {
+		name := arg.Name
+		if arg.Variadic || arg.Raw {
+			name += "..."
+		}
+		if arg.Required {
+			fmt.Fprintf(&sb, " <%s>", name)
+		} else {
+			fmt.Fprintf(&sb, " [%s]", name)
+		}
+	}
+
+	return sb.String()
+}
+
+// Help - help of the command generated from its description, arguments, flags and subcommands
+func Help(path []*domcommands.Command, lang ...string) string {
+	l := defaultHelpLang
+	if len(lang) > 0 && lang[0] != "" {
+		l = lang[0]
+	}
+
+	cmd := path[len(path)-1]
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Usage: `%s`\n", Usage(path))
+
+	if desc := describe(cmd.Description, l); desc != "" {
+		fmt.Fprintf(&sb, "\n%s\n", desc)
+	}
+
+	if len(cmd.Aliases) > 0 {
+		fmt.Fprintf(&sb, "\nAliases: %s\n", strings.Join(cmd.Aliases, ", "))
+	}
+
+	if len(cmd.Args) > 0 {
+		sb.WriteString("\nArguments:\n")
+		for _, arg := range cmd.Args {
+			fmt.Fprintf(&sb, "- `%s` (%s", arg.Name, flagType(arg.Type))
+			if arg.Required {
+				sb.WriteString(", required")
+			} else if arg.Default != nil {
+				fmt.Fprintf(&sb, ", default: %v", arg.Default)
+			}
+			sb.WriteString(")")
+			if desc := describe(arg.Description, l); desc != "" {
+				fmt.Fprintf(&sb, " - %s", desc)
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	if len(cmd.Flags) > 0 {
+		sb.WriteString("\nFlags:\n")
+		for _, flag := range cmd.Flags {
+			fmt.Fprintf(&sb, "- `--%s`", flag.Name)
+			if flag.Short != "" {
+				fmt.Fprintf(&sb, ", `-%s`", flag.Short)
+			}
+			fmt.Fprintf(&sb, " (%s", flagType(flag.Type))
+			if flag.Required {
+				sb.WriteString(", required")
+			} else if flag.Default != nil {
+				fmt.Fprintf(&sb, ", default: %v", flag.Default)
+			}
+			sb.WriteString(")")
+			if desc := describe(flag.Description, l); desc != "" {
+				fmt.Fprintf(&sb, " - %s", desc)
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	if len(cmd.Subcommands) > 0 {
+		sb.WriteString("\nSubcommands:\n")
+		for _, sub := range cmd.Subcommands {
+			fmt.Fprintf(&sb, "- `%s`", sub.Name)
+			if desc := describe(sub.Description, l); desc != "" {
+				fmt.Fprintf(&sb, " - %s", desc)
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// FormatParseError - text of the answer to a command that can't be parsed
+func FormatParseError(err *ParseError) string {
+	usage := Usage(err.Path)
+	return fmt.Sprintf("Error: %s\n\nUsage: `%s`\nSee `%s --help`", err.Msg, usage, commandPath(err.Path))
+}
+
+func commandPath(path []*domcommands.Command) string {
+	names := make([]string, len(path))
+	for i, c := range path {
+		names[i] = c.Name
+	}
+	return path[0].Prefix + strings.Join(names, " ")
+}
+
+// describe - returns the description in the language (or in any language if there is no such translation)
+func describe(description map[string]string, lang string) string {
+	if desc, ok := description[lang]; ok {
+		return desc
+	}
+	if desc, ok := description[defaultHelpLang]; ok {
+		return desc
+	}
+	for _, desc := range description {
+		return desc
+	}
+	return ""
+}
@@ -0,0 +1,327 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	domcommands "github.com/tensved/bobrix/mxbot/domain/commands"
+)
+
+// Invocation - parsed command message
+type Invocation struct {
+	Path []*domcommands.Command // root command and the chosen subcommands
+
+	Args   []string // raw positional arguments
+	Values map[string]domcommands.Value
+	Flags  map[string]domcommands.Value
+
+	Help bool // --help (or -h) was requested
+}
+
+// Command - the executed command (the deepest subcommand)
+func (inv *Invocation) Command() *domcommands.Command {
+	return inv.Path[len(inv.Path)-1]
+}
+
+// ParseError - the command message can't be parsed
+type ParseError struct {
+	Path []*domcommands.Command // commands parsed before the error
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return e.Msg
+}
+
+// Parse - parses the message body of the command: resolves subcommands and aliases,
+// converts positional arguments and flags to their types and applies defaults
+func Parse(root *domcommands.Command, body string) (*Invocation, error) {
+	inv := &Invocation{
+		Path:   []*domcommands.Command{root},
+		Values: map[string]domcommands.Value{},
+		Flags:  map[string]domcommands.Value{},
+	}
+
+	fail := func(format string, args ...any) (*Invocation, error) {
+		return nil, &ParseError{Path: inv.Path, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	// the unterminated quote is an error unless it's in the raw text (see Arg.Raw)
+	spans, errAt, scanErr := scan(body)
+	if scanErr != nil && len(spans) == 0 {
+		return fail("%s", scanErr)
+	}
+	if len(spans) == 0 || !root.Matches(strings.TrimPrefix(spans[0].value, root.Prefix)) {
+		return fail("not a %s%s command", root.Prefix, root.Name)
+	}
+	spans = spans[1:]
+
+	// 1) subcommands
+	for len(spans) > 0 {
+		sub, ok := inv.Command().Subcommand(spans[0].value)
+		if !ok {
+			break
+		}
+		inv.Path = append(inv.Path, sub)
+		spans = spans[1:]
+	}
+
+	cmd := inv.Command()
+	if cmd.Run == nil && len(cmd.Subcommands) > 0 && len(spans) > 0 && !isHelpToken(cmd, spans[0].value) {
+		return fail("unknown subcommand %q", spans[0].value)
+	}
+
+	hasRaw := len(cmd.Args) > 0 && cmd.Args[len(cmd.Args)-1].Raw
+	if scanErr != nil && !hasRaw {
+		return fail("%s", scanErr)
+	}
+
+	// 2) flags
+	var (
+		positional []string
+		rawText    string
+		rawSet     bool
+	)
+	for i := 0; i < len(spans); i++ {
+		tok := spans[i].value
+
+		// the raw text starts with the first positional argument after the other arguments
+		if hasRaw && len(positional) == len(cmd.Args)-1 {
+			if tok == "--" {
+				rawText, rawSet = body[spans[i].end:], true
+				break
+			}
+			if !isHelpToken(cmd, tok) && !isDeclaredFlag(cmd, tok) {
+				rawText, rawSet = body[spans[i].start:], true
+				break
+			}
+		}
+
+		if tok == "--" {
+			for _, span := range spans[i+1:] {
+				positional = append(positional, span.value)
+			}
+			break
+		}
+
+		if isHelpToken(cmd, tok) {
+			inv.Help = true
+			continue
+		}
+
+		name, value, hasValue, isFlag := splitFlag(tok)
+		if !isFlag {
+			positional = append(positional, tok)
+			continue
+		}
+
+		flag, ok := findFlag(cmd, name, strings.HasPrefix(tok, "--"))
+		if !ok {
+			if cmd.Strict() && !isNumber(tok) {
+				return fail("unknown flag %q", tok)
+			}
+			positional = append(positional, tok)
+			continue
+		}
+
+		if !hasValue {
+			if flagType(flag.Type) == domcommands.ArgBool {
+				value = "true"
+			} else {
+				if i+1 >= len(spans) {
+					if scanErr != nil {
+						return fail("%s", scanErr)
+					}
+					return fail("flag --%s requires a value", flag.Name)
+				}
+				i++
+				value = spans[i].value
+			}
+		}
+
+		v, err := convertValue(flag.Type, value)
+		if err != nil {
+			return fail("invalid value of --%s: %s", flag.Name, err)
+		}
+		inv.Flags[flag.Name] = domcommands.NewValue(v, true)
+	}
+
+	// the unterminated quote starts the raw text
+	if scanErr != nil && !rawSet {
+		if len(positional) != len(cmd.Args)-1 {
+			return fail("%s", scanErr)
+		}
+		rawText, rawSet = body[errAt:], true
+	}
+	if rawText = strings.TrimSpace(rawText); rawText == "" {
+		rawSet = false
+	}
+
+	inv.Args = positional
+	if rawSet {
+		inv.Args = append(inv.Args, rawText)
+	}
+	if inv.Help {
+		return inv, nil
+	}
+
+	for _, flag := range cmd.Flags {
+		if _, ok := inv.Flags[flag.Name]; ok {
+			continue
+		}
+		if flag.Required {
+			return fail("missing required flag --%s", flag.Name)
+		}
+		inv.Flags[flag.Name] = domcommands.NewValue(flag.Default, false)
+	}
+
+	// 3) positional arguments
+	for i, arg := range cmd.Args {
+		if arg.Raw {
+			if !rawSet && arg.Required {
+				return fail("missing required argument <%s>", arg.Name)
+			}
+			inv.Values[arg.Name] = domcommands.NewValue(rawText, rawSet)
+			if !rawSet && arg.Default != nil {
+				inv.Values[arg.Name] = domcommands.NewValue(arg.Default, false)
+			}
+			return inv, nil
+		}
+
+		if arg.Variadic {
+			rest := []any{}
+			if i < len(positional) {
+				for _, raw := range positional[i:] {
+					v, err := convertValue(arg.Type, raw)
+					if err != nil {
+						return fail("invalid value of <%s>: %s", arg.Name, err)
+					}
+					rest = append(rest, v)
+				}
+			}
+			if len(rest) == 0 && arg.Required {
+				return fail("missing required argument <%s>", arg.Name)
+			}
+			inv.Values[arg.Name] = domcommands.NewValue(rest, len(rest) > 0)
+			return inv, nil
+		}
+
+		if i >= len(positional) {
+			if arg.Required {
+				return fail("missing required argument <%s>", arg.Name)
+			}
+			inv.Values[arg.Name] = domcommands.NewValue(arg.Default, false)
+			continue
+		}
+
+		v, err := convertValue(arg.Type, positional[i])
+		if err != nil {
+			return fail("invalid value of <%s>: %s", arg.Name, err)
+		}
+		inv.Values[arg.Name] = domcommands.NewValue(v, true)
+	}
+
+	if len(cmd.Args) > 0 && len(positional) > len(cmd.Args) {
+		return fail("unexpected argument %q", positional[len(cmd.Args)])
+	}
+	if len(cmd.Args) == 0 && len(cmd.Flags) > 0 && len(positional) > 0 {
+		return fail("unexpected argument %q", positional[0])
+	}
+
+	return inv, nil
+}
+
+// isDeclaredFlag - the token is a flag of the command
+func isDeclaredFlag(cmd *domcommands.Command, tok string) bool {
+	name, _, _, isFlag := splitFlag(tok)
+	if !isFlag {
+		return false
+	}
+	_, ok := findFlag(cmd, name, strings.HasPrefix(tok, "--"))
+	return ok
+}
+
+// splitFlag - splits "--name=value", "--name" and "-n" tokens
+func splitFlag(tok string) (name, value string, hasValue, ok bool) {
+	switch {
+	case strings.HasPrefix(tok, "--") && len(tok) > 2:
+		name, value, hasValue = strings.Cut(tok[2:], "=")
+		return name, value, hasValue, true
+	case strings.HasPrefix(tok, "-") && len(tok) > 1:
+		name, value, hasValue = strings.Cut(tok[1:], "=")
+		return name, value, hasValue, true
+	default:
+		return "", "", false, false
+	}
+}
+
+func findFlag(cmd *domcommands.Command, name string, long bool) (domcommands.Flag, bool) {
+	for _, flag := range cmd.Flags {
+		if long && strings.EqualFold(flag.Name, name) {
+			return flag, true
+		}
+		if !long && flag.Short != "" && flag.Short == name {
+			return flag, true
+		}
+	}
+	return domcommands.Flag{}, false
+}
+
+// isHelpToken - --help and -h request the help, unless the command declares such flags itself
+func isHelpToken(cmd *domcommands.Command, tok string) bool {
+	switch tok {
+	case "--help":
+		_, declared := findFlag(cmd, "help", true)
+		return !declared
+	case "-h":
+		_, declared := findFlag(cmd, "h", false)
+		return !declared
+	default:
+		return false
+	}
+}
+
+func isNumber(tok string) bool {
+	_, err := strconv.ParseFloat(tok, 64)
+	return err == nil
+}
+
+func flagType(t domcommands.ArgType) domcommands.ArgType {
+	if t == "" {
+		return domcommands.ArgString
+	}
+	return t
+}
+
+func convertValue(t domcommands.ArgType, raw string) (any, error) {
+	switch flagType(t) {
+	case domcommands.ArgInt:
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", raw)
+		}
+		return v, nil
+	case domcommands.ArgFloat:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		return v, nil
+	case domcommands.ArgBool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", raw)
+		}
+		return v, nil
+	case domcommands.ArgDuration:
+		v, err := time.ParseDuration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a duration (e.g. 30s, 5m, 1h)", raw)
+		}
+		return v, nil
+	default:
+		return raw, nil
+	}
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"slices"
+	"testing"
+
+	domcommands "github.com/tensved/bobrix/mxbot/domain/commands"
+)
+
+func TestParseRawArg(t *testing.T) {
+	cmd := NewCommand("ada.generate", nil, domcommands.CommandConfig{
+		Prefix: "/",
+		Args:   []domcommands.Arg{{Name: "prompt", Raw: true}},
+		Flags: []domcommands.Flag{
+			{Name: "temperature", Type: domcommands.ArgFloat},
+			{Name: "verbose", Type: domcommands.ArgBool},
+		},
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		prompt string // "" - the prompt isn't set
+		flags  []string
+		err    bool
+	}{
+		{"plain text", "/ada.generate hello  world", "hello  world", nil, false},
+		{"newlines", "/ada.generate first line\n\n  second line\n", "first line\n\n  second line", nil, false},
+		{"quotes are kept", `/ada.generate say "hi" to 'them'`, `say "hi" to 'them'`, nil, false},
+		{"unmatched quote", `/ada.generate 6" screen, isn't it?`, `6" screen, isn't it?`, nil, false},
+		{"leading single quote", `/ada.generate 'tis the season`, `'tis the season`, nil, false},
+		{"flags before the text", "/ada.generate --temperature 0.5 --verbose tell me --more", "tell me --more", []string{"temperature", "verbose"}, false},
+		{"unknown flag starts the text", "/ada.generate -5 degrees outside", "-5 degrees outside", nil, false},
+		{"separator", "/ada.generate --verbose -- --temperature is text", "--temperature is text", []string{"verbose"}, false},
+		{"flags only", "/ada.generate --verbose", "", []string{"verbose"}, false},
+		{"unterminated flag value", `/ada.generate --temperature "0.5`, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := Parse(cmd, tt.body)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("parsed %+v, want an error", inv)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			prompt := inv.Values["prompt"]
+			if got, _ := prompt.Any().(string); got != tt.prompt || prompt.IsSet() != (tt.prompt != "") {
+				t.Errorf("prompt = %q (set %v), want %q", got, prompt.IsSet(), tt.prompt)
+			}
+
+			var flags []string
+			for name, v := range inv.Flags {
+				if v.IsSet() {
+					flags = append(flags, name)
+				}
+			}
+			slices.Sort(flags)
+			if !slices.Equal(flags, tt.flags) {
+				t.Errorf("flags = %v, want %v", flags, tt.flags)
+			}
+		})
+	}
+}
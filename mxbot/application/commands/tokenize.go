@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var ErrUnterminatedQuote = errors.New("unterminated quote")
+
+// Tokenize - splits the text into arguments like a shell does.
+// Any whitespace (including newlines) separates arguments, quotes ("...", '...', “...”) group them
+// and a backslash escapes the next character (except inside single quotes).
+// A single quote opens a quote only at the start of an argument: the apostrophes ("what's") are kept
+func Tokenize(text string) ([]string, error) {
+	spans, _, err := scan(text)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]string, 0, len(spans))
+	for _, span := range spans {
+		tokens = append(tokens, span.value)
+	}
+	return tokens, nil
+}
+
+// token - argument of the text and the offsets of its raw text (with the quotes)
+type token struct {
+	value      string
+	start, end int
+}
+
+// scan - splits the text into tokens (see Tokenize). On the unterminated quote it returns
+// the tokens before it and the offset of the unterminated token
+func scan(text string) (tokens []token, errAt int, err error) {
+	var (
+		current strings.Builder
+		start   int
+		inToken bool
+		quote   rune // closing quote of the current quoted part (0 - not quoted)
+		escaped bool
+	)
+
+	begin := func(i int) {
+		if !inToken {
+			start = i
+			inToken = true
+		}
+	}
+	flush := func(end int) {
+		if inToken {
+			tokens = append(tokens, token{value: current.String(), start: start, end: end})
+			current.Reset()
+			inToken = false
+		}
+	}
+
+	for i, r := range text {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+
+		case quote != 0:
+			switch {
+			case r == quote:
+				quote = 0
+			case r == '\\' && quote != '\'':
+				escaped = true
+			default:
+				current.WriteRune(r)
+			}
+
+		case r == '\\':
+			begin(i)
+			escaped = true
+
+		case r == '"' || (r == '\'' && !inToken):
+			begin(i)
+			quote = r
+
+		case r == '“':
+			begin(i)
+			quote = '”'
+
+		case unicode.IsSpace(r):
+			flush(i)
+
+		default:
+			begin(i)
+			current.WriteRune(r)
+		}
+	}
+
+	if quote != 0 {
+		return tokens, start, ErrUnterminatedQuote
+	}
+	if escaped {
+		current.WriteRune('\\')
+	}
+	flush(len(text))
+
+	return tokens, 0, nil
+}
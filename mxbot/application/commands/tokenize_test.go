@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+		err  error
+	}{
+		{"words", "/ada.generate hello  world", []string{"/ada.generate", "hello", "world"}, nil},
+		{"newlines", "a\nb\tc", []string{"a", "b", "c"}, nil},
+		{"double quotes", `say "hello world"`, []string{"say", "hello world"}, nil},
+		{"single quotes", `say 'hello world'`, []string{"say", "hello world"}, nil},
+		{"typographic quotes", "say “hello world”", []string{"say", "hello world"}, nil},
+		{"apostrophe", "/ada.generate What's up", []string{"/ada.generate", "What's", "up"}, nil},
+		{"apostrophes", "it's Bob's", []string{"it's", "Bob's"}, nil},
+		{"apostrophe in quotes", `"what's up"`, []string{"what's up"}, nil},
+		{"escape", `a\ b`, []string{"a b"}, nil},
+		{"no escape in single quotes", `'a\b'`, []string{`a\b`}, nil},
+		{"empty quotes", `a ""`, []string{"a", ""}, nil},
+		{"unterminated double quote", `say "hello`, nil, ErrUnterminatedQuote},
+		{"unterminated single quote", `say 'hello`, nil, ErrUnterminatedQuote},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Tokenize(tt.text)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("tokens = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
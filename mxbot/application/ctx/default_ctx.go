@@ -133,11 +133,18 @@ func (c *DefaultCtx) Bot() dombotctx.Bot {
 // it is a wrapper for bot.SendMessage
 // it returns an error if the message could not be sent
 func (c *DefaultCtx) Answer(msg messages.Message) error {
-	err := c.Send(msg)
+	_, err := c.AnswerWithHandle(msg)
+	return err
+}
+
+// AnswerWithHandle - send a message to the room and mark the event handled.
+// it returns the handle of the sent message
+func (c *DefaultCtx) AnswerWithHandle(msg messages.Message) (*messages.Handle, error) {
+	h, err := c.SendWithHandle(msg)
 	if err == nil {
 		c.SetHandled()
 	}
-	return err
+	return h, err
 }
 
 // TextAnswer - send a text message to the room
@@ -154,6 +161,13 @@ func (c *DefaultCtx) ErrorAnswer(errorText string, errorType int) error {
 
 // Send - send a message to the room without marking it "handled."
 func (c *DefaultCtx) Send(msg messages.Message) error {
+	_, err := c.SendWithHandle(msg)
+	return err
+}
+
+// SendWithHandle - send a message to the room without marking it "handled."
+// it returns the handle of the sent message
+func (c *DefaultCtx) SendWithHandle(msg messages.Message) (*messages.Handle, error) {
 	thread := c.thread
 	if thread != nil {
 		msg.SetRelatesTo(&event.RelatesTo{
@@ -170,6 +184,11 @@ func (c *DefaultCtx) Send(msg messages.Message) error {
 	return c.botMessaging.SendMessage(c.Context(), c.event.RoomID, msg)
 }
 
+// React - add the reaction to the event of the context
+func (c *DefaultCtx) React(key string) (*messages.Handle, error) {
+	return c.botMessaging.SendReaction(c.Context(), c.event.RoomID, c.event.ID, key)
+}
+
 func (c *DefaultCtx) TextSend(text string) error {
 	return c.Send(messages.NewText(text))
 }
@@ -17,19 +17,24 @@ type defaultFactory struct {
 	threads dombot.BotThreads
 	events  dombot.EventLoader
 	botCtx  dombotctx.Bot
+	rooms   dombot.BotRoomConfig
 }
 
+// NewFactory - CtxFactory constructor
+// rooms - per-room settings provider (optional). It is used to override the thread mode per room
 func NewFactory(
 	bot dombot.BotMessaging,
 	threads dombot.BotThreads,
 	events dombot.EventLoader,
 	botCtx dombotctx.Bot,
+	rooms dombot.BotRoomConfig,
 ) domctx.CtxFactory {
 	return &defaultFactory{
 		bot:     bot,
 		threads: threads,
 		events:  events,
 		botCtx:  botCtx,
+		rooms:   rooms,
 	}
 }
 
@@ -37,5 +42,23 @@ func (f *defaultFactory) New(
 	ctx context.Context,
 	evt *event.Event,
 ) (domctx.Ctx, error) {
-	return NewDefaultCtx(ctx, evt, f.bot, f.botCtx, f.threads, f.events)
+	threads := f.threads
+
+	if f.rooms != nil && threads != nil && evt != nil && evt.RoomID != "" {
+		if cfg, ok := f.rooms.RoomConfig(ctx, evt.RoomID); ok && cfg.Threads != nil {
+			threads = roomThreads{BotThreads: threads, enabled: *cfg.Threads}
+		}
+	}
+
+	return NewDefaultCtx(ctx, evt, f.bot, f.botCtx, threads, f.events)
+}
+
+// roomThreads - overrides the thread mode of the bot for a single room
+type roomThreads struct {
+	dombot.BotThreads
+	enabled bool
+}
+
+func (t roomThreads) IsThreadEnabled() bool {
+	return t.enabled
 }
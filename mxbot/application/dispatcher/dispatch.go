@@ -26,9 +26,10 @@ func (d *Dispatcher) HandleMatrixEvent(ctx context.Context, evt *event.Event) er
 		}
 	}
 
+	// nothing is handled yet: the event can be retried
 	eventContext, err := d.factory.New(ctx, evt)
 	if err != nil {
-		return err
+		return bot.Retryable(err)
 	}
 
 	for _, h := range d.handlers {
@@ -63,3 +64,15 @@ func (d *Dispatcher) Filters() []filters.Filter {
 func (d *Dispatcher) AddFilter(f filters.Filter) {
 	d.filters = append(d.filters, f)
 }
+
+var _ bot.EventSubscriptions = (*Dispatcher)(nil)
+
+// IsSubscribed - checks if any registered handler handles the event type
+func (d *Dispatcher) IsSubscribed(t event.Type) bool {
+	for _, h := range d.handlers {
+		if h.EventType() == t {
+			return true
+		}
+	}
+	return false
+}
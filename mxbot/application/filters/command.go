@@ -10,21 +10,30 @@ import (
 )
 
 // FilterCommand - filter for command messages
-// (check if message starts with command prefix and name)
+// (check if message starts with command prefix and name or alias)
 func FilterCommand(cmd *commands.Command) filters.Filter {
 	return func(evt *event.Event) bool {
 		if evt.Type != event.EventMessage {
 			return false
 		}
 
-		words := strings.Split(evt.Content.AsMessage().Body, " ")
+		words := strings.Fields(evt.Content.AsMessage().Body)
 		if len(words) == 0 {
 			return false
 		}
 
-		return strings.EqualFold(
-			words[0],
-			cmd.Prefix+cmd.Name,
-		)
+		name, ok := cutPrefixFold(words[0], cmd.Prefix)
+		if !ok {
+			return false
+		}
+
+		return cmd.Matches(name)
+	}
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return "", false
 	}
+	return s[len(prefix):], true
 }
@@ -31,3 +31,11 @@ func FilterAll(fs ...filters.Filter) filters.Filter {
 		return true
 	}
 }
+
+// FilterNot - inverts the filter
+// return true if the filter returns false
+func FilterNot(f filters.Filter) filters.Filter {
+	return func(evt *event.Event) bool {
+		return !f(evt)
+	}
+}
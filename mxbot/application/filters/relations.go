@@ -0,0 +1,57 @@
+package filters
+
+import (
+	"slices"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	domevents "github.com/tensved/bobrix/mxbot/domain/events"
+	df "github.com/tensved/bobrix/mxbot/domain/filters"
+)
+
+// FilterReaction - filter for reactions (m.reaction)
+// if keys are passed, only reactions with one of the keys are accepted
+func FilterReaction(keys ...string) df.Filter {
+	return func(evt *event.Event) bool {
+		reaction, ok := domevents.AsReaction(evt)
+		if !ok {
+			return false
+		}
+		return len(keys) == 0 || slices.Contains(keys, reaction.Key)
+	}
+}
+
+// FilterReactionTo - filter for reactions to the given events
+func FilterReactionTo(targets ...id.EventID) df.Filter {
+	return func(evt *event.Event) bool {
+		reaction, ok := domevents.AsReaction(evt)
+		return ok && slices.Contains(targets, reaction.Target)
+	}
+}
+
+// FilterRedaction - filter for redactions (m.room.redaction)
+// if events are passed, only redactions of these events are accepted
+func FilterRedaction(redacts ...id.EventID) df.Filter {
+	return func(evt *event.Event) bool {
+		redaction, ok := domevents.AsRedaction(evt)
+		if !ok {
+			return false
+		}
+		return len(redacts) == 0 || slices.Contains(redacts, redaction.Redacts)
+	}
+}
+
+// FilterEdit - filter for edits of the messages (m.replace)
+func FilterEdit() df.Filter {
+	return func(evt *event.Event) bool {
+		_, ok := domevents.AsEdit(evt)
+		return ok
+	}
+}
+
+// FilterNotEdit - filter for messages that are not edits
+// (useful for message handlers that shouldn't process edits as new messages)
+func FilterNotEdit() df.Filter {
+	return FilterNot(FilterEdit())
+}
@@ -0,0 +1,48 @@
+package mxbot
+
+import (
+	applcommands "github.com/tensved/bobrix/mxbot/application/commands"
+	domcommands "github.com/tensved/bobrix/mxbot/domain/commands"
+)
+
+type Command = domcommands.Command
+type CommandConfig = domcommands.CommandConfig
+type CommandCtx = domcommands.CommandCtx
+type CommandArg = domcommands.Arg
+type CommandFlag = domcommands.Flag
+type CommandDispatcher = applcommands.Dispatcher
+
+const (
+	ArgString   = domcommands.ArgString
+	ArgInt      = domcommands.ArgInt
+	ArgFloat    = domcommands.ArgFloat
+	ArgBool     = domcommands.ArgBool
+	ArgDuration = domcommands.ArgDuration
+)
+
+// NewCommand - creates a command with typed arguments, flags and subcommands (see CommandConfig)
+func NewCommand(name string, handler func(CommandCtx) error, config ...CommandConfig) *Command {
+	return applcommands.NewCommand(name, handler, config...)
+}
+
+// NewCommandDispatcher - creates a registry of commands (see CommandDispatcher.HelpCommand)
+func NewCommandDispatcher() *CommandDispatcher {
+	return applcommands.NewDispatcher()
+}
+
+// RegisterCommand - adds the command handler to the bot (and to the registry of the bot, see BotCommands)
+func RegisterCommand(bot Bot, cmd *Command, filters ...Filter) {
+	applcommands.Register(bot, cmd, filters...)
+}
+
+// BotCommands - registry of the commands registered in the bot, e.g. for the "/help" command:
+//
+//	mxbot.RegisterCommand(bot, mxbot.BotCommands(bot).HelpCommand())
+//
+// A bot without the registry gets an empty one
+func BotCommands(bot Bot) *CommandDispatcher {
+	if r, ok := bot.(applcommands.Registry); ok {
+		return r.CommandDispatcher()
+	}
+	return applcommands.NewDispatcher()
+}
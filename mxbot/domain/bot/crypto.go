@@ -16,6 +16,10 @@ type BotCrypto interface {
 
 	EnsureOutboundSession(ctx context.Context, roomID id.RoomID) error
 	RequestKey(ctx context.Context, evt *event.Event) error
+	// RequestRoomKeys re-requests keys for events that couldn't be decrypted.
+	// If eventID is set, only this event is used; if roomID is set, only events of the room.
+	// Returns the number of sent requests.
+	RequestRoomKeys(ctx context.Context, roomID id.RoomID, eventID id.EventID) (int, error)
 	HandleToDevice(ctx context.Context, evt *event.Event)
 
 	ObserveEvent(evt *event.Event)
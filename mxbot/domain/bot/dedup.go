@@ -22,4 +22,35 @@ type EventDeduper interface {
 
 	// IsProcessed is needed for the backfill stop condition and simply for diagnostics.
 	IsProcessed(ctx context.Context, eventID string) (bool, error)
+
+	// Status returns the current state of the event (for diagnostics).
+	Status(ctx context.Context, eventID string) (DedupStatus, error)
+}
+
+// DedupPruner - deduper that deletes the old processed records (see the dedup retention of the sync).
+// Without the retention the records are kept forever
+type DedupPruner interface {
+	// PruneProcessed deletes up to limit processed records older than before.
+	// Returns the number of deleted records (less than limit if there are no more old records)
+	PruneProcessed(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+// DedupRetentionStats - counters of the dedup retention
+type DedupRetentionStats struct {
+	MaxAge     time.Duration // processed records older than MaxAge are pruned
+	Runs       int64         // number of the retention runs
+	Pruned     int64         // total number of the pruned records
+	LastRunAt  time.Time
+	LastPruned int64  // records pruned by the last run
+	LastError  string // error of the last run (empty if it succeeded)
 }
+
+// DedupStatus - state of the event in the deduper
+type DedupStatus string
+
+const (
+	DedupStatusUnknown   DedupStatus = "unknown"   // the event has never been seen (or its lease was released)
+	DedupStatusInflight  DedupStatus = "inflight"  // the event is being processed (lease is active)
+	DedupStatusExpired   DedupStatus = "expired"   // the lease has expired, the event can be captured again
+	DedupStatusProcessed DedupStatus = "processed" // the event has been processed
+)
@@ -1,6 +1,8 @@
 package bot
 
 import (
+	"maunium.net/go/mautrix/event"
+
 	dfilters "github.com/tensved/bobrix/mxbot/domain/filters"
 	dhandlers "github.com/tensved/bobrix/mxbot/domain/handlers"
 )
@@ -9,3 +11,9 @@ type EventDispatcher interface {
 	AddEventHandler(handler dhandlers.EventHandler)
 	AddFilter(f dfilters.Filter)
 }
+
+// EventSubscriptions - tells which event types the registered handlers ask for.
+// Sync uses it to forward only the events that can be handled
+type EventSubscriptions interface {
+	IsSubscribed(t event.Type) bool
+}
@@ -8,4 +8,34 @@ var (
 	ErrSendMessage = errors.New("failed to send message")
 	ErrUploadMedia = errors.New("failed to upload media file")
 	ErrJoinToRoom  = errors.New("failed to join room")
+
+	ErrQueueFull          = errors.New("queue is full")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrLeaseLost          = errors.New("lease of the event is lost")
+	ErrUndecryptable      = errors.New("event can't be decrypted")
+	ErrNoFailedEvent      = errors.New("there is no failed event to retry")
 )
+
+// retryableError - transient failure of the event (see Retryable)
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable - marks the failure of the event as transient: the worker retries the event with the backoff.
+// Only the failures before the handlers have changed anything may be retried (e.g. network, decryption),
+// the other errors of the handlers are not retried
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// IsRetryable - the error is marked by Retryable
+func IsRetryable(err error) bool {
+	var r *retryableError
+	return errors.As(err, &r)
+}
@@ -15,4 +15,6 @@ type FullBot interface {
 	BotHealth
 	BotPresenceControl
 	BotMedia
+	BotRoomConfig
+	BotQueueControl
 }
@@ -0,0 +1,22 @@
+package bot
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrLeadershipLost - the replica isn't the leader anymore: it doesn't sync until it's restarted
+var ErrLeadershipLost = errors.New("leadership lost: the sync is stopped")
+
+// LeaderElector - leader election between the replicas of the same bot account.
+// Only the leader logs in and syncs: the replicas would fight over the device and the olm account otherwise
+type LeaderElector interface {
+	// Campaign blocks until the replica becomes the leader (or the context is cancelled)
+	Campaign(ctx context.Context) error
+	// IsLeader reports whether the replica holds the leadership now
+	IsLeader() bool
+	// Lost is closed when the leadership is lost (e.g. the connection to the database is broken)
+	Lost() <-chan struct{}
+	// Resign gives up the leadership, so a standby replica takes over immediately
+	Resign(ctx context.Context) error
+}
@@ -2,10 +2,13 @@ package bot
 
 import (
 	"context"
+	"io"
 
 	"maunium.net/go/mautrix/id"
 )
 
 type BotMedia interface {
 	Download(ctx context.Context, mxcURL id.ContentURI) ([]byte, error)
+	// DownloadStream returns the content of the mxc URL as a stream (the caller must close it)
+	DownloadStream(ctx context.Context, mxcURL id.ContentURI) (io.ReadCloser, error)
 }
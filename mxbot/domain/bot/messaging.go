@@ -8,5 +8,12 @@ import (
 )
 
 type BotMessaging interface {
-	SendMessage(ctx context.Context, roomID id.RoomID, msg messages.Message) error
+	// SendMessage - sends the message and returns its handle (to edit, redact, react or reply later)
+	SendMessage(ctx context.Context, roomID id.RoomID, msg messages.Message) (*messages.Handle, error)
+	// EditMessage - replaces the content of the message sent before (m.replace)
+	EditMessage(ctx context.Context, roomID id.RoomID, eventID id.EventID, msg messages.Message) error
+	// SendReaction - adds the reaction (m.reaction) to the event
+	SendReaction(ctx context.Context, roomID id.RoomID, eventID id.EventID, key string) (*messages.Handle, error)
+	// Redact - redacts the event
+	Redact(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string) error
 }
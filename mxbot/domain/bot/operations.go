@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// BotQueueControl - operational access to the event queue of the bot (used by admin tools)
+type BotQueueControl interface {
+	// QueueStats returns a snapshot of the queue and the in-flight work
+	QueueStats() QueueStats
+	// RetryLastFailed puts the last failed event back to the queue
+	RetryLastFailed(ctx context.Context) (id.EventID, error)
+	// BackfillRoom forces a backfill of the room (even if the backfill on start is disabled)
+	BackfillRoom(ctx context.Context, roomID id.RoomID) error
+	// DedupStatus returns the deduper state of the event
+	DedupStatus(ctx context.Context, eventID id.EventID) (DedupStatus, error)
+
+	// DeadLetters returns up to limit events that have failed all attempts, the latest first
+	DeadLetters(ctx context.Context, limit int) ([]DeadLetter, error)
+	// ReplayDeadLetter puts the dead letter back to the queue with the attempts reset
+	ReplayDeadLetter(ctx context.Context, id string) error
+	// DropDeadLetter deletes the dead letter
+	DropDeadLetter(ctx context.Context, id string) error
+}
+
+// QueueStats - snapshot of the event queue
+type QueueStats struct {
+	Queued   int // events waiting in the queue (including the retries)
+	Capacity int // queue capacity (0 if the queue is unbounded)
+	Workers  int // number of workers
+
+	Retrying    int   // events waiting for the retry after a failure
+	MaxAttempts int   // attempts before the event is moved to the dead letters
+	DeadLetters int   // events that have failed all attempts
+	Dropped     int64 // events not accepted by the queue (e.g. it was full)
+	Rooms       int   // rooms (threads) with queued or in-flight events
+
+	Inflight   []InflightEvent // events that are being processed right now
+	LastFailed *FailedEvent    // last event that failed (nil if there were no failures)
+
+	DedupRetention *DedupRetentionStats // nil if the dedup retention is disabled
+}
+
+// InflightEvent - event that is being processed by a worker
+type InflightEvent struct {
+	EventID   id.EventID
+	RoomID    id.RoomID
+	Worker    int
+	StartedAt time.Time
+}
+
+// FailedEvent - event whose processing has failed
+type FailedEvent struct {
+	EventID  id.EventID
+	RoomID   id.RoomID
+	Error    string
+	FailedAt time.Time
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// WorkQueue - queue of the events waiting for the handlers.
+// A dequeued event is leased by the worker: if it's neither acked nor returned in time
+// (e.g. the process has crashed), a durable queue delivers it again.
+// The queue may order the events by key (e.g. by room): the event is held back while the events
+// with the same key are being handled, and the key is released by Ack, Retry or Bury
+type WorkQueue interface {
+	// Enqueue adds the event. Returns ErrQueueFull if the bounded queue is full
+	Enqueue(ctx context.Context, evt *event.Event) error
+	// Dequeue blocks until an event is ready (its retry time has come) or the context is cancelled
+	Dequeue(ctx context.Context) (*QueuedEvent, error)
+	// Ack removes the handled event
+	Ack(ctx context.Context, item *QueuedEvent) error
+	// Retry returns the failed event to the queue: it's delivered again at retryAt
+	Retry(ctx context.Context, item *QueuedEvent, retryAt time.Time, cause error) error
+	// Bury moves the event that has failed all attempts to the dead letters
+	Bury(ctx context.Context, item *QueuedEvent, cause error) error
+	// Stats returns the counters of the queue
+	Stats(ctx context.Context) (WorkQueueStats, error)
+
+	DeadLetterStore
+}
+
+// ControlCommandRegistry - commands handled at once, out of the queue ordering (e.g. the cancel command):
+// they must not wait for the call they control
+type ControlCommandRegistry interface {
+	// AddControlCommands adds the commands with their prefix (e.g. "/cancel")
+	AddControlCommands(commands ...string)
+}
+
+// LeaseExtender - queue that leases the dequeued events for a limited time (e.g. the durable one).
+// The worker extends the lease while the event is handled, so a long call isn't delivered to another worker
+type LeaseExtender interface {
+	// Lease returns how long the dequeued event is leased for
+	Lease() time.Duration
+	// Extend renews the lease of the event being handled.
+	// Returns ErrLeaseLost if the event has been delivered to another worker
+	Extend(ctx context.Context, item *QueuedEvent) error
+}
+
+// DeadLetterStore - events that have failed all attempts (poison events)
+type DeadLetterStore interface {
+	// DeadLetters returns up to limit dead letters, the latest first
+	DeadLetters(ctx context.Context, limit int) ([]DeadLetter, error)
+	// DeadLetter returns the dead letter by ID. Returns ErrDeadLetterNotFound if there is no such dead letter
+	DeadLetter(ctx context.Context, id string) (*DeadLetter, error)
+	// Replay moves the dead letter back to the queue with the attempts reset.
+	// Returns ErrDeadLetterNotFound if there is no such dead letter
+	Replay(ctx context.Context, id string) error
+	// DropDeadLetter deletes the dead letter. Returns ErrDeadLetterNotFound if there is no such dead letter
+	DropDeadLetter(ctx context.Context, id string) error
+}
+
+// QueuedEvent - event taken from the queue by a worker
+type QueuedEvent struct {
+	ID         string // event ID (generated for the events without it, e.g. to-device)
+	Key        string // ordering key (e.g. room ID). Empty if the event isn't ordered
+	Event      *event.Event
+	Attempts   int    // failed attempts before this one
+	LastError  string // error of the last failed attempt
+	EnqueuedAt time.Time
+}
+
+// DeadLetter - event that has failed all attempts
+type DeadLetter struct {
+	ID         string
+	RoomID     id.RoomID
+	Event      *event.Event
+	Attempts   int
+	Error      string // error of the last attempt
+	EnqueuedAt time.Time
+	FailedAt   time.Time
+}
+
+// WorkQueueStats - counters of the work queue
+type WorkQueueStats struct {
+	Queued      int // events waiting in the queue (including the delayed retries)
+	Retrying    int // events waiting for the retry
+	Capacity    int // 0 if the queue is unbounded
+	DeadLetters int
+	Keys        int // ordering keys (e.g. rooms) with queued or in-flight events
+}
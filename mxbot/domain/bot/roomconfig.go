@@ -0,0 +1,19 @@
+package bot
+
+import (
+	"context"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/mxbot/domain/roomconfig"
+)
+
+type BotRoomConfig interface {
+	// RoomConfig returns cached bobrix settings of the room (false if the room has no settings)
+	RoomConfig(ctx context.Context, roomID id.RoomID) (*roomconfig.Config, bool)
+	// SetRoomConfig stores settings in the room state. The bot must have the required power level
+	SetRoomConfig(ctx context.Context, roomID id.RoomID, cfg *roomconfig.Config) error
+
+	// UserPowerLevel returns the power level of the user in the room
+	UserPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID) (int, error)
+}
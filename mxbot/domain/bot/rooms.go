@@ -12,6 +12,7 @@ import (
 type BotRoomActions interface {
 	JoinRoom(ctx context.Context, roomID id.RoomID) error
 	JoinedMembersCount(ctx context.Context, roomID id.RoomID) (int, error)
+	IsJoinedMember(ctx context.Context, roomID id.RoomID, userID id.UserID) (bool, error)
 	
 	GetJoinedRoomsList(ctx context.Context) ([]id.RoomID, error)
 	GetMessagesFromRoomByNumber(ctx context.Context, roomID id.RoomID, numMessages int, filter *mautrix.FilterPart) ([]*event.Event, error)
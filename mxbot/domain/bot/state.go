@@ -0,0 +1,32 @@
+package bot
+
+import (
+	"context"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// StateStore - persistent state of the bot: sync token, filter ID, join times, prev_batch tokens and device ID.
+// With the state outside of the pod (e.g. in Postgres) the bot is stateless:
+// after a restart it resumes sync from the saved token with the same device
+type StateStore interface {
+	mautrix.SyncStore // next_batch token and filter ID
+	DeviceIDStore
+
+	// JoinTS returns the time of the last join of the bot to the room (unix millis)
+	JoinTS(ctx context.Context, roomID id.RoomID) (int64, bool, error)
+	// SetJoinTSIfLater saves the join time only if it's later than the saved one (or if there is no saved one)
+	SetJoinTSIfLater(ctx context.Context, roomID id.RoomID, tsMillis int64) error
+
+	// PrevBatch returns the last prev_batch token of the room timeline (the start of the backfill)
+	PrevBatch(ctx context.Context, roomID id.RoomID) (string, bool, error)
+	SetPrevBatch(ctx context.Context, roomID id.RoomID, token string) error
+}
+
+// DeviceIDStore - device ID of the bot. The saved device is reused on login
+type DeviceIDStore interface {
+	LoadDeviceID(ctx context.Context) (id.DeviceID, error) // empty if there is no saved device
+	SaveDeviceID(ctx context.Context, deviceID id.DeviceID) error
+	DeleteDeviceID(ctx context.Context) error // the next login creates a new device
+}
@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ArgType - type of the command argument or flag
+type ArgType string
+
+const (
+	ArgString   ArgType = "string"
+	ArgInt      ArgType = "int"
+	ArgFloat    ArgType = "float"
+	ArgBool     ArgType = "bool"
+	ArgDuration ArgType = "duration"
+)
+
+// Arg - positional argument of the command
+type Arg struct {
+	Name        string
+	Type        ArgType // default: ArgString
+	Description map[string]string
+	Required    bool
+	Default     any  // value used when the argument is not provided
+	Variadic    bool // takes all remaining arguments (only the last argument). Value is []any
+	// Raw - takes the rest of the message as it is, with its whitespace, newlines and quotes
+	// (only the last argument, the flags go before it). Value is string
+	Raw bool
+}
+
+// Flag - named argument of the command (--name value, --name=value, -s value).
+// Bool flags don't require a value (--verbose)
+type Flag struct {
+	Name        string
+	Short       string  // one-letter alias (optional)
+	Type        ArgType // default: ArgString
+	Description map[string]string
+	Required    bool
+	Default     any
+}
+
+// Value - parsed value of the argument or flag.
+// Getters return the zero value if the value is not set or has another type
+type Value struct {
+	raw any
+	set bool
+}
+
+// NewValue - Value constructor
+func NewValue(raw any, set bool) Value {
+	return Value{raw: raw, set: set}
+}
+
+// IsSet - checks if the value was provided by the user (defaults are not counted)
+func (v Value) IsSet() bool { return v.set }
+
+// Any - returns the raw value
+func (v Value) Any() any { return v.raw }
+
+func (v Value) String() string {
+	switch raw := v.raw.(type) {
+	case nil:
+		return ""
+	case string:
+		return raw
+	case []any:
+		parts := make([]string, len(raw))
+		for i, p := range raw {
+			parts[i] = fmt.Sprint(p)
+		}
+		return strings.Join(parts, " ")
+	default:
+		return fmt.Sprint(raw)
+	}
+}
+
+func (v Value) Int() int {
+	i, _ := v.raw.(int)
+	return i
+}
+
+func (v Value) Float() float64 {
+	f, _ := v.raw.(float64)
+	return f
+}
+
+func (v Value) Bool() bool {
+	b, _ := v.raw.(bool)
+	return b
+}
+
+func (v Value) Duration() time.Duration {
+	d, _ := v.raw.(time.Duration)
+	return d
+}
+
+// List - returns the values of the variadic argument
+func (v Value) List() []any {
+	l, _ := v.raw.([]any)
+	return l
+}
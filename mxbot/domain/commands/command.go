@@ -1,14 +1,26 @@
 package commands
 
-import "github.com/tensved/bobrix/mxbot/domain/ctx"
+import (
+	"strings"
+
+	"github.com/tensved/bobrix/mxbot/domain/ctx"
+)
 
 const DefaultCommandPrefix = "/"
 
+// ErrCodeParse - error code of the answer when the command can't be parsed (bad arguments, unknown flags, etc.)
+const ErrCodeParse = 400
+
 // CommandConfig - config of the command (should be provided by the user)
-// (prefix, description)
+// (prefix, description, aliases, arguments, flags and subcommands)
 type CommandConfig struct {
 	Prefix      string
 	Description map[string]string
+
+	Aliases     []string   // alternative names of the command
+	Args        []Arg      // positional arguments. If set, extra arguments are reported as errors
+	Flags       []Flag     // --flags. If set, unknown flags are reported as errors
+	Subcommands []*Command // nested commands (e.g. "/admin queue"). Subcommands inherit the prefix
 }
 
 // Command - command of the bot
@@ -17,5 +29,41 @@ type Command struct {
 	Prefix      string
 	Name        string
 	Description map[string]string
-	Handler     func(ctx.Ctx) error
+	Handler     func(ctx.Ctx) error // entry point of the command: parses the message and runs the (sub)command
+
+	Run func(CommandCtx) error // handler of the command itself. Commands without Run show their help
+
+	Aliases     []string
+	Args        []Arg
+	Flags       []Flag
+	Subcommands []*Command
+}
+
+// Matches - checks if the name is the name or an alias of the command (case-insensitive)
+func (c *Command) Matches(name string) bool {
+	if strings.EqualFold(name, c.Name) {
+		return true
+	}
+	for _, alias := range c.Aliases {
+		if strings.EqualFold(name, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subcommand - returns the subcommand by name or alias
+func (c *Command) Subcommand(name string) (*Command, bool) {
+	for _, sub := range c.Subcommands {
+		if sub.Matches(name) {
+			return sub, true
+		}
+	}
+	return nil, false
+}
+
+// Strict - checks if the command declares its arguments or flags.
+// Strict commands report unexpected arguments and unknown flags as errors
+func (c *Command) Strict() bool {
+	return len(c.Args) > 0 || len(c.Flags) > 0
 }
@@ -9,5 +9,9 @@ import (
 // is a wrapper for the Ctx
 type CommandCtx interface {
 	ctx.Ctx         // provides access to the Ctx
-	Args() []string // provides access to the command arguments
+	Args() []string // provides access to the positional arguments (after the command and subcommands)
+
+	Arg(name string) Value  // typed positional argument declared in Command.Args
+	Flag(name string) Value // typed flag declared in Command.Flags
+	Command() *Command      // the executed command (the deepest subcommand)
 }
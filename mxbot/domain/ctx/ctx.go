@@ -32,6 +32,14 @@ type Ctx interface {
 	TextAnswer(text string) error
 	ErrorAnswer(errorText string, errorType int) error
 
+	// SendWithHandle and AnswerWithHandle - like Send and Answer, but return the handle of the sent message
+	// (to edit, redact, react or reply to it later)
+	SendWithHandle(msg messages.Message) (*messages.Handle, error)
+	AnswerWithHandle(msg messages.Message) (*messages.Handle, error)
+
+	// React - adds the reaction to the event (e.g. 👀 when the work starts, ✅ when it is done)
+	React(key string) (*messages.Handle, error)
+
 	IsHandled() bool
 	SetHandled()
 	IsHandledWithUnlocker() (bool, func())
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"errors"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Reaction - reaction (m.reaction) to the event
+type Reaction struct {
+	EventID id.EventID // id of the reaction event (it is redacted when the reaction is removed)
+	Sender  id.UserID
+	Key     string     // reaction key (usually an emoji)
+	Target  id.EventID // reacted event
+}
+
+// Redaction - redaction (m.room.redaction) of the event
+type Redaction struct {
+	EventID id.EventID
+	Sender  id.UserID
+	Redacts id.EventID // redacted event
+	Reason  string
+}
+
+// Edit - edit (m.replace) of the message
+type Edit struct {
+	EventID    id.EventID
+	Sender     id.UserID
+	Target     id.EventID                 // edited (original) message
+	NewContent *event.MessageEventContent // content of the message after the edit
+}
+
+// AsReaction - returns the reaction if the event is m.reaction
+func AsReaction(evt *event.Event) (*Reaction, bool) {
+	if evt == nil || evt.Type != event.EventReaction || !parse(evt) {
+		return nil, false
+	}
+
+	rel := evt.Content.AsReaction().GetRelatesTo()
+	if rel.Type != event.RelAnnotation || rel.EventID == "" {
+		return nil, false
+	}
+
+	return &Reaction{
+		EventID: evt.ID,
+		Sender:  evt.Sender,
+		Key:     rel.Key,
+		Target:  rel.EventID,
+	}, true
+}
+
+// AsRedaction - returns the redaction if the event is m.room.redaction.
+// Both formats are supported: "redacts" in the content (room v11+) and in the event itself
+func AsRedaction(evt *event.Event) (*Redaction, bool) {
+	if evt == nil || evt.Type != event.EventRedaction || !parse(evt) {
+		return nil, false
+	}
+
+	content := evt.Content.AsRedaction()
+
+	redacts := content.Redacts
+	if redacts == "" {
+		redacts = evt.Redacts
+	}
+	if redacts == "" {
+		return nil, false
+	}
+
+	return &Redaction{
+		EventID: evt.ID,
+		Sender:  evt.Sender,
+		Redacts: redacts,
+		Reason:  content.Reason,
+	}, true
+}
+
+// AsEdit - returns the edit if the event is a message with m.replace relation.
+// If the edit has no m.new_content, the content of the event itself is used
+func AsEdit(evt *event.Event) (*Edit, bool) {
+	if evt == nil || evt.Type != event.EventMessage || !parse(evt) {
+		return nil, false
+	}
+
+	msg := evt.Content.AsMessage()
+
+	target := msg.RelatesTo.GetReplaceID()
+	if target == "" {
+		return nil, false
+	}
+
+	newContent := msg.NewContent
+	if newContent == nil {
+		newContent = msg
+	}
+
+	return &Edit{
+		EventID:    evt.ID,
+		Sender:     evt.Sender,
+		Target:     target,
+		NewContent: newContent,
+	}, true
+}
+
+// parse - parses the content of the event if it is not parsed yet (e.g. events loaded with /messages)
+func parse(evt *event.Event) bool {
+	if evt.Content.Parsed != nil {
+		return true
+	}
+
+	err := evt.Content.ParseRaw(evt.Type)
+	return err == nil || errors.Is(err, event.ErrContentAlreadyParsed)
+}
@@ -4,6 +4,7 @@ import (
 	"maunium.net/go/mautrix/event"
 
 	"github.com/tensved/bobrix/mxbot/domain/ctx"
+	"github.com/tensved/bobrix/mxbot/domain/events"
 	"github.com/tensved/bobrix/mxbot/domain/filters"
 )
 
@@ -50,6 +51,38 @@ func NewStateMemberHandler(
 	return NewEventHandler(event.StateMember, handler, filters...)
 }
 
+// NewReactionHandler - EventHandler constructor for reactions (m.reaction)
+// It is a wrapper for NewEventHandler. Use events.AsReaction to get the key and the target event
+func NewReactionHandler(
+	handler func(ctx.Ctx) error,
+	filters ...filters.Filter,
+) EventHandler {
+	return NewEventHandler(event.EventReaction, handler, filters...)
+}
+
+// NewRedactionHandler - EventHandler constructor for redactions (m.room.redaction)
+// It is a wrapper for NewEventHandler. Use events.AsRedaction to get the redacted event
+func NewRedactionHandler(
+	handler func(ctx.Ctx) error,
+	filters ...filters.Filter,
+) EventHandler {
+	return NewEventHandler(event.EventRedaction, handler, filters...)
+}
+
+// NewEditHandler - EventHandler constructor for edits of the messages (m.replace)
+// It is a wrapper for NewEventHandler. Use events.AsEdit to get the edited message and the new content
+func NewEditHandler(
+	handler func(ctx.Ctx) error,
+	fs ...filters.Filter,
+) EventHandler {
+	return NewEventHandler(event.EventMessage, handler, append([]filters.Filter{isEdit}, fs...)...)
+}
+
+func isEdit(evt *event.Event) bool {
+	_, ok := events.AsEdit(evt)
+	return ok
+}
+
 func (h *DefaultEventHandler) EventType() event.Type {
 	return h.eventType
 }
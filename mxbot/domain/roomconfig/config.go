@@ -0,0 +1,47 @@
+package roomconfig
+
+import (
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// StateEventType - custom room state event that carries bobrix settings of the room
+var StateEventType = event.Type{Type: "io.bobrix.config", Class: event.StateEventType}
+
+// Config - bobrix settings of the room.
+// It is stored in the room state (see StateEventType), so only users with
+// the required power level can change it
+type Config struct {
+	DefaultService string `json:"default_service,omitempty"` // service name or ID used by auto parsers
+	DefaultMethod  string `json:"default_method,omitempty"`  // method used by auto parsers
+	DefaultInput   string `json:"default_input,omitempty"`   // input that receives the message text
+
+	Threads *bool `json:"threads,omitempty"` // thread mode. nil - use the bot default
+
+	Language     string `json:"language,omitempty"`      // language of answers and descriptions (e.g. "en")
+	SystemPrompt string `json:"system_prompt,omitempty"` // system prompt added to the history
+
+	EnabledServices []string `json:"enabled_services,omitempty"` // services allowed in the room. empty - all services
+}
+
+// IsServiceEnabled - checks if the service (by name or ID) is allowed in the room
+func (c *Config) IsServiceEnabled(names ...string) bool {
+	if c == nil || len(c.EnabledServices) == 0 {
+		return true
+	}
+
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if slices.ContainsFunc(c.EnabledServices, func(s string) bool {
+			return strings.EqualFold(strings.TrimSpace(s), strings.TrimSpace(name))
+		}) {
+			return true
+		}
+	}
+
+	return false
+}
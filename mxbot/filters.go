@@ -3,6 +3,7 @@ package mxbot
 import (
 	applfilters "github.com/tensved/bobrix/mxbot/application/filters"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 
 	dombot "github.com/tensved/bobrix/mxbot/domain/bot"
 	domfilters "github.com/tensved/bobrix/mxbot/domain/filters"
@@ -22,6 +23,28 @@ func FilterEventMessage() domfilters.Filter {
 	return applfilters.FilterEventMessage()
 }
 
+// ---- relations ----
+
+func FilterReaction(keys ...string) domfilters.Filter {
+	return applfilters.FilterReaction(keys...)
+}
+
+func FilterReactionTo(targets ...id.EventID) domfilters.Filter {
+	return applfilters.FilterReactionTo(targets...)
+}
+
+func FilterRedaction(redacts ...id.EventID) domfilters.Filter {
+	return applfilters.FilterRedaction(redacts...)
+}
+
+func FilterEdit() domfilters.Filter {
+	return applfilters.FilterEdit()
+}
+
+func FilterNotEdit() domfilters.Filter {
+	return applfilters.FilterNotEdit()
+}
+
 // ---- bot related ----
 
 func FilterTagMe(bot dombot.BotInfo) domfilters.Filter {
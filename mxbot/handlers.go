@@ -34,6 +34,27 @@ func NewMessageHandler(
 	return handlers.NewMessageHandler(handler, filters...)
 }
 
+func NewReactionHandler(
+	handler func(Ctx) error,
+	filters ...filters.Filter,
+) EventHandler {
+	return handlers.NewReactionHandler(handler, filters...)
+}
+
+func NewRedactionHandler(
+	handler func(Ctx) error,
+	filters ...filters.Filter,
+) EventHandler {
+	return handlers.NewRedactionHandler(handler, filters...)
+}
+
+func NewEditHandler(
+	handler func(Ctx) error,
+	filters ...filters.Filter,
+) EventHandler {
+	return handlers.NewEditHandler(handler, filters...)
+}
+
 func AutoJoinRoomHandler(bot Bot, params ...applhandlers.JoinRoomParams) EventHandler {
 	return applhandlers.AutoJoinRoomHandler(bot, bot, params...)
 }
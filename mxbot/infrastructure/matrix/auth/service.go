@@ -4,17 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 
 	"maunium.net/go/mautrix"
-	"maunium.net/go/mautrix/id"
 
 	dombot "github.com/tensved/bobrix/mxbot/domain/bot"
 	infracfg "github.com/tensved/bobrix/mxbot/infrastructure/matrix/config"
-	utils "github.com/tensved/bobrix/mxbot/infrastructure/utils"
 )
 
 var _ dombot.BotAuth = (*Service)(nil)
@@ -23,16 +20,22 @@ type Service struct {
 	client *mautrix.Client
 	creds  *infracfg.BotCredentials
 	name   string
+
+	devices dombot.DeviceIDStore // saved device ID is reused on login
 }
 
-func New(client *mautrix.Client, creds *infracfg.BotCredentials, name string) (*Service, error) {
+func New(client *mautrix.Client, creds *infracfg.BotCredentials, name string, devices dombot.DeviceIDStore) (*Service, error) {
 	if name == "" {
 		return nil, errors.New("bot name shouldnt be an empty string")
 	}
+	if devices == nil {
+		return nil, errors.New("device ID store is required")
+	}
 	return &Service{
-		client: client,
-		creds:  creds,
-		name:   name,
+		client:  client,
+		creds:   creds,
+		name:    name,
+		devices: devices,
 	}, nil
 }
 
@@ -51,26 +54,10 @@ func (a *Service) Authorize(ctx context.Context) error {
 
 // authBot - Authenticates the bot with the homeserver
 func (a *Service) authBot(ctx context.Context) error {
-	// Get the current directory
-	currentDir, err := os.Getwd()
+	// If the device ID was saved, the login continues with the same device
+	deviceID, err := a.devices.LoadDeviceID(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current directory: %w", err)
-	}
-
-	safeUser := utils.SafeFilePart(a.creds.Username)
-
-	// Check if a file with a saved device ID exists
-	deviceIDFile := filepath.Join(currentDir, ".bin", "crypto", fmt.Sprintf("device-id-%s.txt", safeUser))
-	var deviceID id.DeviceID
-
-	if _, err := os.Stat(deviceIDFile); err == nil {
-		// If the file exists, read the device ID
-		data, err := os.ReadFile(deviceIDFile)
-		if err != nil {
-			return fmt.Errorf("failed to read device ID file: %w", err)
-		}
-
-		deviceID = id.DeviceID(string(data))
+		return err
 	}
 
 	loginReq := &mautrix.ReqLogin{
@@ -96,14 +83,8 @@ func (a *Service) authBot(ctx context.Context) error {
 	a.client.AccessToken = resp.AccessToken
 	a.client.DeviceID = resp.DeviceID
 
-	// Save device ID to file
-	cryptoDir := filepath.Join(currentDir, ".bin", "crypto")
-	if err := os.MkdirAll(cryptoDir, 0755); err != nil {
-		return fmt.Errorf("failed to create crypto directory: %w", err)
-	}
-
-	if err := os.WriteFile(deviceIDFile, []byte(resp.DeviceID), 0644); err != nil {
-		return fmt.Errorf("failed to save device ID: %w", err)
+	if err := a.devices.SaveDeviceID(ctx, resp.DeviceID); err != nil {
+		return err
 	}
 
 	// We check that the client is actually authorized
@@ -8,8 +8,11 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
 
 	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
 	dctx "github.com/tensved/bobrix/mxbot/domain/ctx"
@@ -25,8 +28,11 @@ import (
 	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/events"
 	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/health"
 	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/info"
+	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/leader"
 	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/media"
 	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/messaging"
+	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/queue"
+	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/roomconfig"
 	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/rooms"
 	infrastore "github.com/tensved/bobrix/mxbot/infrastructure/matrix/store"
 	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/sync"
@@ -35,6 +41,7 @@ import (
 	utils "github.com/tensved/bobrix/mxbot/infrastructure/utils"
 
 	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg"
+	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg/migrations"
 
 	applctx "github.com/tensved/bobrix/mxbot/application/ctx"
 	appldisp "github.com/tensved/bobrix/mxbot/application/dispatcher"
@@ -45,6 +52,48 @@ var (
 	defaultTypingTimeout = 5 * time.Second
 )
 
+// DeduperBackend - storage of the processed events (see bot.EventDeduper)
+type DeduperBackend string
+
+const (
+	DeduperPostgres DeduperBackend = "postgres" // table matrix_event_dedup in MatrixDB
+	DeduperSQLite   DeduperBackend = "sqlite"   // SQLite file (single-node deployments without a database)
+	DeduperMemory   DeduperBackend = "memory"   // in memory: the processed events are forgotten on restart
+)
+
+// QueueBackend - queue of the events waiting for the workers (see bot.WorkQueue)
+type QueueBackend string
+
+const (
+	QueueMemory   QueueBackend = "memory"   // bounded by WorkChCap, lost on restart (default)
+	QueuePostgres QueueBackend = "postgres" // tables bobrix_work_queue and bobrix_dead_letters in MatrixDB
+)
+
+// QueueOrdering - which events are handled in order (see queue.Ordering)
+type QueueOrdering string
+
+const (
+	OrderByRoom   QueueOrdering = "room"   // the events of the room are handled in order (default)
+	OrderByThread QueueOrdering = "thread" // the events of the thread are handled in order, threads of the room in parallel
+	OrderNone     QueueOrdering = "none"   // any event is handled by any free worker
+)
+
+// StateStorage - storage of the bot state: sync token, join times, prev_batch tokens and device ID
+type StateStorage string
+
+const (
+	StateStorageFile     StateStorage = "file"     // files in .bin/syncstore/<user> and .bin/crypto (default)
+	StateStoragePostgres StateStorage = "postgres" // tables in MatrixDB (see store.PostgresStateStore) with CryptoStoragePostgres
+)
+
+// CryptoStorage - storage of the olm account and the encryption sessions
+type CryptoStorage string
+
+const (
+	CryptoStorageFile     CryptoStorage = "file"     // SQLite file in .bin/crypto
+	CryptoStoragePostgres CryptoStorage = "postgres" // mautrix crypto tables in MatrixDB, shared by the replicas
+)
+
 type Config struct {
 	Credentials   *config.BotCredentials
 	Logger        *zerolog.Logger
@@ -52,18 +101,80 @@ type Config struct {
 	SyncTimeout   time.Duration
 	PatchStart    time.Time
 
+	// Deduper - storage of the processed events.
+	// Default: DeduperPostgres if MatrixDB is set, otherwise DeduperSQLite
+	Deduper                  DeduperBackend
+	DeduperSQLitePath        string // default: .bin/dedup/<user>.db
 	DeduperProcessedCacheTTL time.Duration
 	DeduperProcessedCacheMax int
 
+	// DeduperRetention - processed events older than this are pruned from the deduper (0: kept forever).
+	// It must be longer than the backfill window and the possible downtime of the bot:
+	// older events are skipped because they can't be deduplicated anymore
+	DeduperRetention     time.Duration
+	DeduperPruneInterval time.Duration // default: 1 hour
+	DeduperPruneBatch    int           // records deleted per query. Default: 5000
+
 	BackfillLimitPerRequest int
 	WithBackfill            bool
 
+	// EventTypes - event types that are always received from sync (e.g. event.EventReaction).
+	// Event types of the registered handlers are received without this setting
+	EventTypes []event.Type
+
 	AuthRetry   time.Duration
-	InflightTTL time.Duration
+	InflightTTL time.Duration // also the lease of the event in QueuePostgres (extended while the event is handled)
 	NumWorkers  int
 	WorkChCap   int
 
+	// Queue - queue of the events waiting for the workers. Default: QueueMemory.
+	// With QueuePostgres the queued events and the dead letters survive restarts
+	Queue             QueueBackend
+	QueuePollInterval time.Duration // how often the idle workers check QueuePostgres. Default: 1s
+	// QueueMaxAttempts - attempts of the event with a transient failure (see mxbot.Retryable)
+	// before it's moved to the dead letters. Default: 5
+	QueueMaxAttempts int
+	QueueRetryBase   time.Duration // backoff after the first failure, doubled after each next one. Default: 1s
+	QueueRetryMax    time.Duration // default: 5m
+	// QueueOrdering - events handled in order. The rooms (threads) are handled in parallel,
+	// and a busy room doesn't take more than RoomConcurrency workers. Default: OrderByRoom
+	QueueOrdering   QueueOrdering
+	RoomConcurrency int // events of one room (thread) handled at the same time. Default: 1
+	// ControlCommands - commands handled at once, out of the ordering, like the reactions, redactions and edits:
+	// they must not wait for the call they cancel (see queue.ControlEvents).
+	// The commands registered later are added with bot.ControlCommandRegistry (e.g. by bobrix.WithCancellation)
+	ControlCommands []string
+
 	MatrixDB *pgxpool.Pool
+	// Migrate - apply the embedded schema migrations to MatrixDB on start (see migrations.Apply).
+	// Leave it off if the schema is managed by other tools (see migrations.SQL)
+	Migrate bool
+
+	// StateStorage - where the state of the bot is kept. Default: StateStorageFile.
+	// Use StateStoragePostgres for stateless pods: a rescheduled pod resumes sync with the same device
+	StateStorage StateStorage
+	// StateStore - custom state store (StateStorage is ignored)
+	StateStore dbot.StateStore
+	// CryptoStorage - where the olm account is kept. It must be kept with the device ID:
+	// StateStoragePostgres requires CryptoStoragePostgres.
+	// Default: CryptoStorageFile, CryptoStoragePostgres with StateStoragePostgres or LeaderElection
+	CryptoStorage CryptoStorage
+
+	// LeaderElection - several replicas of the bot for failover: only the leader logs in and syncs,
+	// the others stand by in NewMatrixBot until the leader is gone (see leader.PostgresElector).
+	// Requires MatrixDB and the shared state (StateStoragePostgres or a custom StateStore)
+	LeaderElection      bool
+	LeaderRetryInterval time.Duration // default: 2s. A standby replica takes over in a few intervals
+	// OnLeadershipLost - called after the sync is stopped because the leadership was lost
+	// (MatrixBot.Leader.Lost() is closed then). The replica should be restarted as a standby one
+	OnLeadershipLost func()
+	// ExitOnLeadershipLost - the process exits when the leadership is lost (e.g. to be restarted by the orchestrator).
+	// Otherwise the process stays up idle and Ping fails with dbot.ErrLeadershipLost (the health check is unhealthy)
+	ExitOnLeadershipLost bool
+
+	// Context - context of the start: the migrations and the wait for the leadership stop when it's cancelled
+	// (e.g. on SIGTERM). Default: context.Background()
+	Context context.Context
 }
 
 type MatrixBot struct {
@@ -80,9 +191,13 @@ type MatrixBot struct {
 	dbot.BotHealth
 	dbot.BotPresenceControl
 	dbot.BotMedia
+	dbot.BotRoomConfig
+	dbot.BotQueueControl
+	dbot.ControlCommandRegistry
 
 	dctx.CtxFactory
 	Dispatcher *appldisp.Dispatcher
+	Leader     dbot.LeaderElector // nil without LeaderElection
 }
 
 func NewMatrixBot(cfg Config) (*MatrixBot, error) {
@@ -90,36 +205,58 @@ func NewMatrixBot(cfg Config) (*MatrixBot, error) {
 		l := zerolog.New(os.Stdout).With().Timestamp().Logger()
 		cfg.Logger = &l
 	}
-
-	if cfg.MatrixDB == nil {
-		return nil, fmt.Errorf("constructor: cfg.MatrixDB is required for PostgresDeduper")
+	if cfg.Context == nil {
+		cfg.Context = context.Background()
 	}
 
-	safeUser := utils.SafeFilePart(cfg.Credentials.Username)
+	if cfg.Migrate {
+		if cfg.MatrixDB == nil {
+			return nil, fmt.Errorf("constructor: cfg.MatrixDB is required for migrations")
+		}
 
-	store, err := infrastore.NewFileSyncStore(
-		filepath.Join(".bin", "syncstore", safeUser, "sync.json"),
-	)
+		applied, err := migrations.Apply(cfg.Context, cfg.MatrixDB)
+		if err != nil {
+			return nil, fmt.Errorf("constructor: %w", err)
+		}
+		for _, m := range applied {
+			cfg.Logger.Info().Int("version", m.Version).Str("name", m.Name).Msg("applied schema migration")
+		}
+	}
+
+	stateStore, err := newStateStore(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	joinStore, err := infrastore.NewJoinStore(
-		filepath.Join(".bin", "syncstore", safeUser, "join.json"),
-	)
+	// --- leadership: the standby replica waits here, before it touches the device and the olm account
+	var elector dbot.LeaderElector
+	if cfg.LeaderElection {
+		elector, err = newElector(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Logger.Info().Str("user_id", cfg.Credentials.Username).Msg("waiting for leadership")
+		if err := elector.Campaign(cfg.Context); err != nil {
+			return nil, fmt.Errorf("constructor: %w", err)
+		}
+		cfg.Logger.Info().Str("user_id", cfg.Credentials.Username).Msg("became the leader")
+	}
+
+	cryptoDB, err := newCryptoDB(cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	// --- raw Matrix client (no auth yet)
-	clientProvider, err := client.New(cfg.Credentials.HomeServerURL, store)
+	clientProvider, err := client.New(cfg.Credentials.HomeServerURL, stateStore)
 	if err != nil {
 		return nil, err
 	}
 	rawClient := clientProvider.RawClient().(*mautrix.Client)
 
 	// --- authorize
-	authSvc, err := auth.New(rawClient, cfg.Credentials, cfg.Credentials.Username)
+	authSvc, err := auth.New(rawClient, cfg.Credentials, cfg.Credentials.Username, stateStore)
 	if err != nil {
 		return nil, err
 	}
@@ -129,23 +266,39 @@ func NewMatrixBot(cfg Config) (*MatrixBot, error) {
 	}
 
 	// --- crypto
-	cryptoSvc, err := crypto.New(rawClient, cfg.Credentials.PickleKey, cfg.Credentials.Username)
+	newCrypto := func() (*crypto.Service, error) {
+		if cryptoDB != nil {
+			return crypto.NewShared(rawClient, cfg.Credentials.PickleKey, cfg.Credentials.Username, cryptoDB)
+		}
+		return crypto.New(rawClient, cfg.Credentials.PickleKey, cfg.Credentials.Username)
+	}
+	resetCrypto := func() error {
+		if cryptoDB != nil {
+			return crypto.ResetSharedState(context.Background(), cryptoDB, cfg.Credentials.Username)
+		}
+		return crypto.ResetLocalState(cfg.Credentials.Username)
+	}
+
+	cryptoSvc, err := newCrypto()
 	if crypto.IsAccountKeyMismatch(err) {
 		cfg.Logger.Warn().Err(err).Msg(
 			"local olm account is out of sync with the homeserver (likely a stale crypto store " +
 				"left over from an unclean restart); resetting local crypto state and re-authorizing with a new device",
 		)
 
-		if resetErr := crypto.ResetLocalState(cfg.Credentials.Username); resetErr != nil {
+		if resetErr := resetCrypto(); resetErr != nil {
 			return nil, fmt.Errorf("failed to reset local crypto state after olm account mismatch: %w", resetErr)
 		}
+		if resetErr := stateStore.DeleteDeviceID(context.Background()); resetErr != nil {
+			return nil, fmt.Errorf("failed to reset device id after olm account mismatch: %w", resetErr)
+		}
 
-		// device id file was just removed, so this login will register a brand-new device
+		// device id was just removed, so this login will register a brand-new device
 		if err := authSvc.Authorize(context.Background()); err != nil {
 			return nil, fmt.Errorf("re-authorize after crypto reset: %w", err)
 		}
 
-		cryptoSvc, err = crypto.New(rawClient, cfg.Credentials.PickleKey, cfg.Credentials.Username)
+		cryptoSvc, err = newCrypto()
 	}
 	if err != nil {
 		return nil, err
@@ -165,12 +318,16 @@ func NewMatrixBot(cfg Config) (*MatrixBot, error) {
 		return nil, err
 	}
 
+	// --- per-room settings (io.bobrix.config state events)
+	roomConfigSvc := roomconfig.New(clientProvider)
+
 	// --- application ctx factory
 	ctxFactory := applctx.NewFactory(
 		messagingSvc,
 		threadsSvc,
 		nil,
 		ctx.NewBotCtx(clientProvider, infoSvc),
+		roomConfigSvc,
 	)
 
 	// --- dispatcher (application)
@@ -189,15 +346,14 @@ func NewMatrixBot(cfg Config) (*MatrixBot, error) {
 
 	// --- sync (Matrix → events)
 
-	// other version of deduper
-	// deduper := dedup.NewLeaseDeduper(30 * time.Minute)
+	deduper, err := newDeduper(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	provider := pg.StaticProvider{DB: cfg.MatrixDB}
-	deduper, err := dedup.NewPostgresDeduper(provider, dedup.PostgresDeduperOptions{
-		ProcessedCacheTTL: cfg.DeduperProcessedCacheTTL,
-		UserID:            cfg.Credentials.Username,
-		ProcessedCacheMax: cfg.DeduperProcessedCacheMax,
-	})
+	// the encrypted control commands are recognized before their turn in the room
+	controlCommands := queue.NewControlCommands(cfg.ControlCommands...)
+	workQueue, err := newQueue(cfg, queue.ControlEvents(controlCommands, cryptoSvc))
 	if err != nil {
 		return nil, err
 	}
@@ -211,34 +367,54 @@ func NewMatrixBot(cfg Config) (*MatrixBot, error) {
 		cfg.WorkChCap,
 		sync.WithAuth(authSvc),
 		sync.WithPatchStart(cfg.PatchStart),
-		sync.WithJoinStore(joinStore),
+		sync.WithStateStore(stateStore),
 		sync.WithBackfill(cfg.WithBackfill, cfg.BackfillLimitPerRequest),
 		sync.WithDeduper(deduper),
+		sync.WithQueue(workQueue),
+		sync.WithRetryPolicy(cfg.QueueMaxAttempts, cfg.QueueRetryBase, cfg.QueueRetryMax),
+		sync.WithDedupRetention(cfg.DeduperRetention, cfg.DeduperPruneInterval, cfg.DeduperPruneBatch),
+		sync.WithStateObserver(roomConfigSvc),
+		sync.WithEventSubscriptions(dispatcherSvc),
+		sync.WithEventTypes(cfg.EventTypes...),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	healthSvc := health.New(clientProvider)
+	var healthOpts []health.Option
+	if elector != nil {
+		healthOpts = append(healthOpts, health.WithLeader(elector))
+	}
+	healthSvc := health.New(clientProvider, healthOpts...)
 
 	mediaSvc := media.New(clientProvider)
 
 	// --- final bot facade
 	matrixBot := &MatrixBot{
-		BotAuth:        authSvc,
-		BotInfo:        infoSvc,
-		BotMessaging:   messagingSvc,
-		BotThreads:     threadsSvc,
-		BotCrypto:      cryptoSvc,
-		BotClient:      clientProvider,
-		BotRoomActions: roomsSvc,
-		BotTyping:      typingSvc,
-		BotSync:        syncSvc,
-		BotHealth:      healthSvc,
-		BotMedia:       mediaSvc,
+		BotAuth:         authSvc,
+		BotInfo:         infoSvc,
+		BotMessaging:    messagingSvc,
+		BotThreads:      threadsSvc,
+		BotCrypto:       cryptoSvc,
+		BotClient:       clientProvider,
+		EventLoader:     roomsSvc,
+		BotRoomActions:  roomsSvc,
+		BotTyping:       typingSvc,
+		BotSync:         syncSvc,
+		BotHealth:       healthSvc,
+		BotMedia:        mediaSvc,
+		BotRoomConfig:   roomConfigSvc,
+		BotQueueControl: syncSvc,
+
+		ControlCommandRegistry: controlCommands,
 
 		CtxFactory: ctxFactory,
 		Dispatcher: dispatcherSvc,
+		Leader:     elector,
+	}
+
+	if elector != nil {
+		go watchLeadership(cfg, elector, syncSvc)
 	}
 
 	// inject FullBot into dispatcher
@@ -247,6 +423,169 @@ func NewMatrixBot(cfg Config) (*MatrixBot, error) {
 	return matrixBot, nil
 }
 
+// newStateStore - state store selected by the config
+func newStateStore(cfg Config) (dbot.StateStore, error) {
+	if cfg.StateStore != nil {
+		return cfg.StateStore, nil
+	}
+
+	switch cfg.StateStorage {
+	case StateStorageFile, "":
+		safeUser := utils.SafeFilePart(cfg.Credentials.Username)
+
+		return infrastore.NewFileStateStore(infrastore.FileStateStoreOptions{
+			Dir:          filepath.Join(".bin", "syncstore", safeUser),
+			DeviceIDPath: filepath.Join(".bin", "crypto", fmt.Sprintf("device-id-%s.txt", safeUser)),
+		})
+	case StateStoragePostgres:
+		if cfg.MatrixDB == nil {
+			return nil, fmt.Errorf("constructor: cfg.MatrixDB is required for the postgres state storage")
+		}
+		return infrastore.NewPostgresStateStore(pg.StaticProvider{DB: cfg.MatrixDB}, cfg.Credentials.Username)
+	default:
+		return nil, fmt.Errorf("constructor: unknown state storage %q", cfg.StateStorage)
+	}
+}
+
+// newQueue - work queue selected by the config
+func newQueue(cfg Config, control queue.ControlFunc) (dbot.WorkQueue, error) {
+	ordering := queue.Ordering{PerKey: cfg.RoomConcurrency, Control: control}
+	switch cfg.QueueOrdering {
+	case OrderByRoom, "":
+		ordering.Key = queue.ByRoom
+	case OrderByThread:
+		ordering.Key = queue.ByThread
+	case OrderNone:
+	default:
+		return nil, fmt.Errorf("constructor: unknown queue ordering %q", cfg.QueueOrdering)
+	}
+
+	switch cfg.Queue {
+	case QueueMemory, "":
+		return queue.NewMemoryQueue(cfg.WorkChCap, 0, ordering), nil
+	case QueuePostgres:
+		if cfg.MatrixDB == nil {
+			return nil, fmt.Errorf("constructor: cfg.MatrixDB is required for the postgres queue")
+		}
+		return queue.NewPostgresQueue(pg.StaticProvider{DB: cfg.MatrixDB}, queue.PostgresQueueOptions{
+			UserID:       cfg.Credentials.Username,
+			Lease:        cfg.InflightTTL,
+			PollInterval: cfg.QueuePollInterval,
+			Ordering:     ordering,
+		})
+	default:
+		return nil, fmt.Errorf("constructor: unknown queue %q", cfg.Queue)
+	}
+}
+
+// newElector - leader election of the bot account. The device and the olm account must be shared by the replicas
+func newElector(cfg Config) (dbot.LeaderElector, error) {
+	if cfg.MatrixDB == nil {
+		return nil, fmt.Errorf("constructor: cfg.MatrixDB is required for the leader election")
+	}
+	if cfg.StateStore == nil && cfg.StateStorage != StateStoragePostgres {
+		return nil, fmt.Errorf("constructor: the leader election requires the shared state (StateStoragePostgres)")
+	}
+	if cfg.CryptoStorage == CryptoStorageFile {
+		return nil, fmt.Errorf("constructor: the leader election requires the shared crypto storage (CryptoStoragePostgres)")
+	}
+
+	return leader.NewPostgresElector(pg.StaticProvider{DB: cfg.MatrixDB}, leader.PostgresElectorOptions{
+		UserID:        cfg.Credentials.Username,
+		RetryInterval: cfg.LeaderRetryInterval,
+	})
+}
+
+// watchLeadership - stops the sync when the leadership is lost: a standby replica has taken over or will soon
+func watchLeadership(cfg Config, elector dbot.LeaderElector, syncSvc dbot.BotSync) {
+	<-elector.Lost()
+
+	_ = syncSvc.StopListening(context.Background())
+	cfg.Logger.Error().Str("user_id", cfg.Credentials.Username).Msg("leadership lost: the sync is stopped")
+
+	if cfg.OnLeadershipLost != nil {
+		cfg.OnLeadershipLost()
+	}
+
+	if cfg.ExitOnLeadershipLost {
+		cfg.Logger.Error().Str("user_id", cfg.Credentials.Username).Msg("exiting to restart as a standby replica")
+		os.Exit(1)
+	}
+
+	// the process stays up, but it doesn't handle events anymore: Ping reports it (see health.WithLeader)
+	cfg.Logger.Error().Str("user_id", cfg.Credentials.Username).
+		Msg("the replica is idle: restart it to stand by for the leadership (or set ExitOnLeadershipLost)")
+}
+
+// newCryptoDB - shared database of the crypto store (nil for the file storage)
+func newCryptoDB(cfg Config) (*dbutil.Database, error) {
+	storage := cfg.CryptoStorage
+	if storage == "" {
+		storage = CryptoStorageFile
+		if cfg.LeaderElection || cfg.StateStorage == StateStoragePostgres {
+			storage = CryptoStoragePostgres
+		}
+	}
+
+	switch storage {
+	case CryptoStorageFile:
+		// the device ID from the database doesn't match the olm account of the pod: a new device would be created
+		if cfg.StateStore == nil && cfg.StateStorage == StateStoragePostgres {
+			return nil, fmt.Errorf("constructor: StateStoragePostgres requires CryptoStoragePostgres")
+		}
+		return nil, nil
+	case CryptoStoragePostgres:
+		if cfg.MatrixDB == nil {
+			return nil, fmt.Errorf("constructor: cfg.MatrixDB is required for the postgres crypto storage")
+		}
+		db, err := dbutil.NewWithDB(stdlib.OpenDBFromPool(cfg.MatrixDB), "postgres")
+		if err != nil {
+			return nil, fmt.Errorf("constructor: crypto storage: %w", err)
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("constructor: unknown crypto storage %q", storage)
+	}
+}
+
+// newDeduper - deduper selected by the config
+func newDeduper(cfg Config) (dbot.EventDeduper, error) {
+	backend := cfg.Deduper
+	if backend == "" {
+		backend = DeduperSQLite
+		if cfg.MatrixDB != nil {
+			backend = DeduperPostgres
+		}
+	}
+
+	switch backend {
+	case DeduperPostgres:
+		if cfg.MatrixDB == nil {
+			return nil, fmt.Errorf("constructor: cfg.MatrixDB is required for PostgresDeduper")
+		}
+		return dedup.NewPostgresDeduper(pg.StaticProvider{DB: cfg.MatrixDB}, dedup.PostgresDeduperOptions{
+			ProcessedCacheTTL: cfg.DeduperProcessedCacheTTL,
+			UserID:            cfg.Credentials.Username,
+			ProcessedCacheMax: cfg.DeduperProcessedCacheMax,
+		})
+	case DeduperSQLite:
+		path := cfg.DeduperSQLitePath
+		if path == "" {
+			path = filepath.Join(".bin", "dedup", utils.SafeFilePart(cfg.Credentials.Username)+".db")
+		}
+		return dedup.NewSQLiteDeduper(dedup.SQLiteDeduperOptions{
+			Path:              path,
+			ProcessedCacheTTL: cfg.DeduperProcessedCacheTTL,
+			UserID:            cfg.Credentials.Username,
+			ProcessedCacheMax: cfg.DeduperProcessedCacheMax,
+		})
+	case DeduperMemory:
+		return dedup.NewLeaseDeduper(30 * time.Second), nil
+	default:
+		return nil, fmt.Errorf("constructor: unknown deduper %q", backend)
+	}
+}
+
 func (b *MatrixBot) AddEventHandler(h dhandlers.EventHandler) {
 	b.Dispatcher.AddEventHandler(h)
 }
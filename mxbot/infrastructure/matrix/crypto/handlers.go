@@ -2,8 +2,11 @@ package crypto
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 func (s *Service) HandleToDevice(ctx context.Context, evt *event.Event) {
@@ -21,3 +24,81 @@ func (s *Service) RequestKey(ctx context.Context, evt *event.Event) error {
 		nil,
 	)
 }
+
+// RequestRoomKeys re-requests keys for events that couldn't be decrypted.
+// eventID (optional) - request the key for a single event (it is loaded from the server if it isn't remembered)
+// roomID (optional) - limit the requests to a single room
+func (s *Service) RequestRoomKeys(ctx context.Context, roomID id.RoomID, eventID id.EventID) (int, error) {
+	var events []*event.Event
+
+	if eventID != "" {
+		s.pendingMu.Lock()
+		evt, ok := s.pendingKeys[eventID]
+		s.pendingMu.Unlock()
+
+		if !ok {
+			if roomID == "" {
+				return 0, fmt.Errorf("event %s is unknown: room id is required to load it", eventID)
+			}
+
+			var err error
+			evt, err = s.client.GetEvent(ctx, roomID, eventID)
+			if err != nil {
+				return 0, fmt.Errorf("load event %s: %w", eventID, err)
+			}
+			evt.RoomID = roomID
+		}
+
+		if evt.Type != event.EventEncrypted {
+			return 0, fmt.Errorf("event %s is not encrypted", eventID)
+		}
+
+		if err := evt.Content.ParseRaw(evt.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			return 0, fmt.Errorf("parse event %s: %w", eventID, err)
+		}
+
+		events = append(events, evt)
+	} else {
+		s.pendingMu.Lock()
+		for _, evt := range s.pendingKeys {
+			if roomID == "" || evt.RoomID == roomID {
+				events = append(events, evt)
+			}
+		}
+		s.pendingMu.Unlock()
+	}
+
+	sent := 0
+	var errs error
+	for _, evt := range events {
+		if err := s.RequestKey(ctx, evt); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", evt.ID, err))
+			continue
+		}
+		sent++
+	}
+
+	return sent, errs
+}
+
+func (s *Service) rememberPending(evt *event.Event) {
+	if evt == nil || evt.ID == "" {
+		return
+	}
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	if len(s.pendingKeys) >= maxPendingKeys {
+		return
+	}
+	s.pendingKeys[evt.ID] = evt
+}
+
+func (s *Service) forgetPending(eventID id.EventID) {
+	if eventID == "" {
+		return
+	}
+	s.pendingMu.Lock()
+	delete(s.pendingKeys, eventID)
+	s.pendingMu.Unlock()
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 
+	"go.mau.fi/util/dbutil"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/cryptohelper"
@@ -53,8 +54,9 @@ func IsAccountKeyMismatch(err error) bool {
 	return false
 }
 
-// ResetLocalState removes the persisted crypto store and saved device id for
-// the given bot username, forcing a brand-new device and olm account to be
+// ResetLocalState removes the persisted crypto store for the given bot
+// username. Together with the removal of the saved device id (see
+// bot.DeviceIDStore) it forces a brand-new device and olm account to be
 // created on the next login. Use this to recover from IsAccountKeyMismatch.
 func ResetLocalState(name string) error {
 	safeUser := utils.SafeFilePart(name)
@@ -70,11 +72,6 @@ func ResetLocalState(name string) error {
 		}
 	}
 
-	deviceIDFile := filepath.Join(dir, fmt.Sprintf("device-id-%s.txt", safeUser))
-	if err := os.Remove(deviceIDFile); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("remove %s: %w", deviceIDFile, err)
-	}
-
 	return nil
 }
 
@@ -85,8 +82,15 @@ type Service struct {
 
 	encMu   sync.RWMutex
 	encRoom map[id.RoomID]bool
+
+	// events that couldn't be decrypted because of a missing session (see RequestRoomKeys)
+	pendingMu   sync.Mutex
+	pendingKeys map[id.EventID]*event.Event
 }
 
+// maxPendingKeys limits the number of remembered undecryptable events
+const maxPendingKeys = 1000
+
 var _ dbot.BotCrypto = (*Service)(nil)
 
 func New(client *mautrix.Client, pickleKey []byte, name string) (*Service, error) {
@@ -102,6 +106,34 @@ func New(client *mautrix.Client, pickleKey []byte, name string) (*Service, error
 		return nil, err
 	}
 
+	return newService(client, helper)
+}
+
+// NewShared - crypto service with the store in the shared database (see dbutil.NewWithDB).
+// The olm account is kept under the bot username, so the replicas of the bot
+// (see bot.LeaderElector) continue with the same account and sessions after a failover
+func NewShared(client *mautrix.Client, pickleKey []byte, name string, db *dbutil.Database) (*Service, error) {
+	helper, err := cryptohelper.NewCryptoHelper(client, pickleKey, db)
+	if err != nil {
+		return nil, err
+	}
+	helper.DBAccountID = name
+
+	return newService(client, helper)
+}
+
+// ResetSharedState - the same as ResetLocalState for the store created by NewShared:
+// removes the olm account of the bot and its sessions. Inbound group sessions are kept
+func ResetSharedState(ctx context.Context, db *dbutil.Database, name string) error {
+	for _, table := range []string{"crypto_olm_session", "crypto_megolm_outbound_session", "crypto_account"} {
+		if _, err := db.Exec(ctx, "DELETE FROM "+table+" WHERE account_id=$1", name); err != nil {
+			return fmt.Errorf("reset %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func newService(client *mautrix.Client, helper *cryptohelper.CryptoHelper) (*Service, error) {
 	if err := helper.Init(context.Background()); err != nil {
 		return nil, err
 	}
@@ -116,6 +148,8 @@ func New(client *mautrix.Client, pickleKey []byte, name string) (*Service, error
 		machine: m,
 		helper:  helper,
 		encRoom: make(map[id.RoomID]bool),
+
+		pendingKeys: make(map[id.EventID]*event.Event),
 	}, nil
 }
 
@@ -141,10 +175,12 @@ func (s *Service) DecryptEvent(ctx context.Context, evt *event.Event) (*event.Ev
 
 	decrypted, err := s.machine.DecryptMegolmEvent(ctx, evt)
 	if err == nil {
+		s.forgetPending(evt.ID)
 		return decrypted, nil
 	}
 
 	if err.Error() == "no session with given ID found" {
+		s.rememberPending(evt)
 		_ = s.RequestKey(ctx, evt)
 		return nil, err
 	}
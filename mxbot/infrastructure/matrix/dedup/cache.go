@@ -0,0 +1,88 @@
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ---- processed cache ----
+// Cache only "processed" (final state).
+// LRU with TTL: when the cache is full, the least recently used event is evicted
+type processedCache struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	max int
+
+	order *list.List               // front: most recently used
+	m     map[string]*list.Element // cacheKey -> element of order
+}
+
+type cacheEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+func newProcessedCache(ttl time.Duration, max int) *processedCache {
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	if max <= 0 {
+		max = 200_000
+	}
+	return &processedCache{
+		ttl:   ttl,
+		max:   max,
+		order: list.New(),
+		m:     make(map[string]*list.Element),
+	}
+}
+
+func (c *processedCache) Has(eventID, userID string) bool {
+	if eventID == "" || userID == "" {
+		return false
+	}
+	now := time.Now()
+	cacheKey := userID + "\x00" + eventID
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.m[cacheKey]
+	if !ok {
+		return false
+	}
+	if now.After(el.Value.(*cacheEntry).expiresAt) {
+		c.removeLocked(el)
+		return false
+	}
+	c.order.MoveToFront(el)
+	return true
+}
+
+func (c *processedCache) Put(eventID, userID string) {
+	if eventID == "" || userID == "" {
+		return
+	}
+	now := time.Now()
+	cacheKey := userID + "\x00" + eventID
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.m[cacheKey]; ok {
+		el.Value.(*cacheEntry).expiresAt = now.Add(c.ttl)
+		c.order.MoveToFront(el)
+		return
+	}
+
+	for c.order.Len() >= c.max {
+		c.removeLocked(c.order.Back())
+	}
+	c.m[cacheKey] = c.order.PushFront(&cacheEntry{key: cacheKey, expiresAt: now.Add(c.ttl)})
+}
+
+func (c *processedCache) removeLocked(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.m, el.Value.(*cacheEntry).key)
+}
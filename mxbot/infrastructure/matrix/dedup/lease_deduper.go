@@ -8,12 +8,15 @@ import (
 	"github.com/tensved/bobrix/mxbot/domain/bot"
 )
 
-var _ bot.EventDeduper = (*LeaseDeduper)(nil)
+var (
+	_ bot.EventDeduper = (*LeaseDeduper)(nil)
+	_ bot.DedupPruner  = (*LeaseDeduper)(nil)
+)
 
 type LeaseDeduper struct {
 	mu sync.Mutex
 
-	processed map[string]struct{}
+	processed map[string]time.Time // eventID -> processedAt
 	inflight  map[string]time.Time // eventID -> expiresAt
 
 	// optional GC
@@ -26,7 +29,7 @@ func NewLeaseDeduper(gcEvery time.Duration) *LeaseDeduper {
 		gcEvery = 30 * time.Second
 	}
 	d := &LeaseDeduper{
-		processed: make(map[string]struct{}),
+		processed: make(map[string]time.Time),
 		inflight:  make(map[string]time.Time),
 		gcEvery:   gcEvery,
 		stopGC:    make(chan struct{}),
@@ -96,7 +99,9 @@ func (d *LeaseDeduper) MarkProcessed(_ context.Context, eventID string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	d.processed[eventID] = struct{}{}
+	if _, ok := d.processed[eventID]; !ok {
+		d.processed[eventID] = time.Now()
+	}
 	delete(d.inflight, eventID)
 	return nil
 }
@@ -122,3 +127,42 @@ func (d *LeaseDeduper) IsProcessed(_ context.Context, eventID string) (bool, err
 	_, ok := d.processed[eventID]
 	return ok, nil
 }
+
+func (d *LeaseDeduper) Status(_ context.Context, eventID string) (bot.DedupStatus, error) {
+	if eventID == "" {
+		return bot.DedupStatusUnknown, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.processed[eventID]; ok {
+		return bot.DedupStatusProcessed, nil
+	}
+
+	if exp, ok := d.inflight[eventID]; ok {
+		if exp.After(time.Now()) {
+			return bot.DedupStatusInflight, nil
+		}
+		return bot.DedupStatusExpired, nil
+	}
+
+	return bot.DedupStatusUnknown, nil
+}
+
+// PruneProcessed forgets the processed events older than before
+func (d *LeaseDeduper) PruneProcessed(_ context.Context, before time.Time, limit int) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var pruned int64
+	for id, processedAt := range d.processed {
+		if limit > 0 && pruned >= int64(limit) {
+			break
+		}
+		if processedAt.Before(before) {
+			delete(d.processed, id)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -13,76 +12,19 @@ import (
 	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg"
 )
 
-var _ bot.EventDeduper = (*PostgresDeduper)(nil)
+var (
+	_ bot.EventDeduper = (*PostgresDeduper)(nil)
+	_ bot.DedupPruner  = (*PostgresDeduper)(nil)
+)
 
 const (
 	statusInflight  int16 = 1
 	statusProcessed int16 = 2
 )
 
-// ---- processed cache ----
-// Cache only "processed" (final state).
-type processedCache struct {
-	mu  sync.Mutex
-	ttl time.Duration
-	max int
-	m   map[string]time.Time // eventID -> expiresAt
-}
-
-func newProcessedCache(ttl time.Duration, max int) *processedCache {
-	if ttl <= 0 {
-		ttl = 10 * time.Minute
-	}
-	if max <= 0 {
-		max = 200_000
-	}
-	return &processedCache{
-		ttl: ttl,
-		max: max,
-		m:   make(map[string]time.Time, max),
-	}
-}
-
-func (c *processedCache) Has(eventID, userID string) bool {
-	if eventID == "" || userID == "" {
-		return false
-	}
-	now := time.Now()
-	cacheKey := userID + "\x00" + eventID
-
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	exp, ok := c.m[cacheKey]
-	if !ok {
-		return false
-	}
-	if now.After(exp) {
-		delete(c.m, cacheKey)
-		return false
-	}
-	return true
-}
-
-func (c *processedCache) Put(eventID, userID string) {
-	if eventID == "" || userID == "" {
-		return
-	}
-	now := time.Now()
-	cacheKey := userID + "\x00" + eventID
-
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// simple growth preventer
-	if len(c.m) >= c.max {
-		c.m = make(map[string]time.Time, c.max)
-	}
-	c.m[cacheKey] = now.Add(c.ttl)
-}
-
 // ---- Deduper ----
 
+// PostgresDeduper - deduper in the table matrix_event_dedup (see the migrations package for the schema)
 type PostgresDeduper struct {
 	provider pg.ExecutorProvider
 	userID   string
@@ -226,3 +168,67 @@ func (d *PostgresDeduper) IsProcessed(ctx context.Context, eventID string) (bool
 	}
 	return ok, nil
 }
+
+func (d *PostgresDeduper) Status(ctx context.Context, eventID string) (bot.DedupStatus, error) {
+	if eventID == "" {
+		return bot.DedupStatusUnknown, nil
+	}
+
+	if d.cache != nil && d.cache.Has(eventID, d.userID) {
+		return bot.DedupStatusProcessed, nil
+	}
+
+	exec := d.provider.Get(ctx)
+
+	var (
+		status      int16
+		noLease     bool
+		leaseActive bool
+	)
+	err := exec.QueryRow(ctx,
+		`SELECT status, lease_until IS NULL, COALESCE(lease_until > now(), false)
+		FROM matrix_event_dedup WHERE user_id=$1 AND event_id=$2`,
+		d.userID, eventID,
+	).Scan(&status, &noLease, &leaseActive)
+
+	if err == pgx.ErrNoRows {
+		return bot.DedupStatusUnknown, nil
+	}
+	if err != nil {
+		return bot.DedupStatusUnknown, fmt.Errorf("dedup Status query failed: %w", err)
+	}
+
+	switch {
+	case status == statusProcessed:
+		return bot.DedupStatusProcessed, nil
+	case leaseActive:
+		return bot.DedupStatusInflight, nil
+	case noLease:
+		return bot.DedupStatusUnknown, nil
+	default:
+		return bot.DedupStatusExpired, nil
+	}
+}
+
+// PruneProcessed deletes the processed events of the bot older than before (by processed_at)
+func (d *PostgresDeduper) PruneProcessed(ctx context.Context, before time.Time, limit int) (int64, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	exec := d.provider.Get(ctx)
+
+	q := `
+		DELETE FROM matrix_event_dedup
+		WHERE ctid IN (
+			SELECT ctid FROM matrix_event_dedup
+			WHERE status = $1 AND processed_at < $2 AND user_id = $3
+			LIMIT $4
+		)
+		`
+	tag, err := exec.Exec(ctx, q, statusProcessed, before, d.userID, limit)
+	if err != nil {
+		return 0, fmt.Errorf("dedup prune failed: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,253 @@
+package dedup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // sqlite3 driver
+
+	bot "github.com/tensved/bobrix/mxbot/domain/bot"
+)
+
+var (
+	_ bot.EventDeduper = (*SQLiteDeduper)(nil)
+	_ bot.DedupPruner  = (*SQLiteDeduper)(nil)
+)
+
+// sqliteSchema - the same table as matrix_event_dedup in Postgres, the times are unix millis
+const sqliteSchema = `
+	CREATE TABLE IF NOT EXISTS matrix_event_dedup (
+		user_id      TEXT    NOT NULL,
+		event_id     TEXT    NOT NULL,
+		status       INTEGER NOT NULL,
+		lease_until  INTEGER NULL,
+		processed_at INTEGER NULL,
+		updated_at   INTEGER NOT NULL,
+		PRIMARY KEY (user_id, event_id)
+	);
+	CREATE INDEX IF NOT EXISTS matrix_event_dedup_processed_at_idx
+		ON matrix_event_dedup (processed_at) WHERE status = 2;
+	`
+
+// SQLiteDeduper - deduper in the SQLite file for the single-node deployments (no external database).
+// The lease semantics are the same as in PostgresDeduper
+type SQLiteDeduper struct {
+	db     *sql.DB
+	userID string
+	cache  *processedCache
+}
+
+type SQLiteDeduperOptions struct {
+	Path              string // file of the database. The directory is created if it doesn't exist
+	ProcessedCacheTTL time.Duration
+	UserID            string
+	ProcessedCacheMax int
+}
+
+func NewSQLiteDeduper(opts SQLiteDeduperOptions) (*SQLiteDeduper, error) {
+	if opts.UserID == "" {
+		return nil, errors.New("SQLiteDeduper: UserID is required")
+	}
+	if opts.Path == "" {
+		return nil, errors.New("SQLiteDeduper: Path is required")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o700); err != nil {
+		return nil, fmt.Errorf("SQLiteDeduper: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate", opts.Path))
+	if err != nil {
+		return nil, fmt.Errorf("SQLiteDeduper: %w", err)
+	}
+	// one writer at a time: SQLite locks the whole database anyway
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("SQLiteDeduper: failed to create schema: %w", err)
+	}
+
+	return &SQLiteDeduper{
+		db:     db,
+		userID: opts.UserID,
+		cache:  newProcessedCache(opts.ProcessedCacheTTL, opts.ProcessedCacheMax),
+	}, nil
+}
+
+func (d *SQLiteDeduper) Close() error {
+	return d.db.Close()
+}
+
+func (d *SQLiteDeduper) TryStartProcessing(ctx context.Context, eventID string, ttl time.Duration) (bool, error) {
+	if eventID == "" {
+		return true, nil
+	}
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+
+	// fast failure: recently processed
+	if d.cache != nil && d.cache.Has(eventID, d.userID) {
+		return false, nil
+	}
+
+	now := time.Now().UnixMilli()
+
+	q := `
+		INSERT INTO matrix_event_dedup(user_id, event_id, status, lease_until, processed_at, updated_at)
+		VALUES (?1, ?2, ?3, ?4, NULL, ?5)
+		ON CONFLICT (user_id, event_id) DO UPDATE
+		SET status = excluded.status,
+			lease_until = excluded.lease_until,
+			updated_at = excluded.updated_at
+		WHERE
+		matrix_event_dedup.status <> ?6
+		AND (matrix_event_dedup.lease_until IS NULL OR matrix_event_dedup.lease_until < ?5)
+		RETURNING event_id
+		`
+
+	var returned string
+	err := d.db.QueryRowContext(ctx, q,
+		d.userID, eventID,
+		statusInflight,
+		now+ttl.Milliseconds(),
+		now,
+		statusProcessed,
+	).Scan(&returned)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (d *SQLiteDeduper) MarkProcessed(ctx context.Context, eventID string) error {
+	if eventID == "" {
+		return nil
+	}
+
+	q := `
+		INSERT INTO matrix_event_dedup(user_id, event_id, status, lease_until, processed_at, updated_at)
+		VALUES (?1, ?2, ?3, NULL, ?4, ?4)
+		ON CONFLICT (user_id, event_id) DO UPDATE
+		SET status = excluded.status,
+			lease_until = NULL,
+			processed_at = COALESCE(matrix_event_dedup.processed_at, excluded.processed_at),
+			updated_at = excluded.updated_at
+		WHERE matrix_event_dedup.status <> excluded.status
+		`
+	_, err := d.db.ExecContext(ctx, q, d.userID, eventID, statusProcessed, time.Now().UnixMilli())
+	if err == nil && d.cache != nil {
+		d.cache.Put(eventID, d.userID)
+	}
+	return err
+}
+
+func (d *SQLiteDeduper) UnmarkInflight(ctx context.Context, eventID string) error {
+	if eventID == "" {
+		return nil
+	}
+
+	q := `
+		UPDATE matrix_event_dedup
+		SET lease_until=NULL, updated_at=?4
+		WHERE user_id=?1 AND event_id=?2 AND status <> ?3
+		`
+	_, err := d.db.ExecContext(ctx, q, d.userID, eventID, statusProcessed, time.Now().UnixMilli())
+	return err
+}
+
+func (d *SQLiteDeduper) IsProcessed(ctx context.Context, eventID string) (bool, error) {
+	if eventID == "" {
+		return false, nil
+	}
+
+	if d.cache != nil && d.cache.Has(eventID, d.userID) {
+		return true, nil
+	}
+
+	var status int16
+	err := d.db.QueryRowContext(ctx,
+		`SELECT status FROM matrix_event_dedup WHERE user_id=?1 AND event_id=?2`,
+		d.userID, eventID,
+	).Scan(&status)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("dedup IsProcessed query failed: %w", err)
+	}
+
+	ok := status == statusProcessed
+	if ok && d.cache != nil {
+		d.cache.Put(eventID, d.userID)
+	}
+	return ok, nil
+}
+
+func (d *SQLiteDeduper) Status(ctx context.Context, eventID string) (bot.DedupStatus, error) {
+	if eventID == "" {
+		return bot.DedupStatusUnknown, nil
+	}
+
+	if d.cache != nil && d.cache.Has(eventID, d.userID) {
+		return bot.DedupStatusProcessed, nil
+	}
+
+	var (
+		status     int16
+		leaseUntil sql.NullInt64
+	)
+	err := d.db.QueryRowContext(ctx,
+		`SELECT status, lease_until FROM matrix_event_dedup WHERE user_id=?1 AND event_id=?2`,
+		d.userID, eventID,
+	).Scan(&status, &leaseUntil)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return bot.DedupStatusUnknown, nil
+	}
+	if err != nil {
+		return bot.DedupStatusUnknown, fmt.Errorf("dedup Status query failed: %w", err)
+	}
+
+	switch {
+	case status == statusProcessed:
+		return bot.DedupStatusProcessed, nil
+	case !leaseUntil.Valid:
+		return bot.DedupStatusUnknown, nil
+	case leaseUntil.Int64 > time.Now().UnixMilli():
+		return bot.DedupStatusInflight, nil
+	default:
+		return bot.DedupStatusExpired, nil
+	}
+}
+
+// PruneProcessed deletes the processed events of the bot older than before (by processed_at)
+func (d *SQLiteDeduper) PruneProcessed(ctx context.Context, before time.Time, limit int) (int64, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	q := `
+		DELETE FROM matrix_event_dedup
+		WHERE rowid IN (
+			SELECT rowid FROM matrix_event_dedup
+			WHERE status = ?1 AND processed_at < ?2 AND user_id = ?3
+			LIMIT ?4
+		)
+		`
+	res, err := d.db.ExecContext(ctx, q, statusProcessed, before.UnixMilli(), d.userID, limit)
+	if err != nil {
+		return 0, fmt.Errorf("dedup prune failed: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -2,6 +2,7 @@ package events
 
 import (
 	"context"
+	"fmt"
 
 	"maunium.net/go/mautrix/event"
 
@@ -39,10 +40,11 @@ func (s *Service) HandleMatrixEvent(ctx context.Context, evt *event.Event) error
 		return nil
 	}
 
-	// 2) encrypted → decrypt
+	// 2) encrypted → decrypt. The keys may arrive later: the event is retried,
+	// then left for the backfill (see bot.ErrUndecryptable)
 	decrypted, err := s.crypto.DecryptEvent(ctx, evt)
 	if err != nil {
-		return err
+		return bot.Retryable(fmt.Errorf("%w: %w", bot.ErrUndecryptable, err))
 	}
 
 	return s.sink.HandleMatrixEvent(ctx, decrypted)
@@ -12,17 +12,35 @@ var _ dbot.BotHealth = (*Service)(nil)
 
 type Service struct {
 	client *mautrix.Client
+	leader dbot.LeaderElector // optional. See WithLeader
 }
 
-func New(c dbot.BotClient) *Service {
-	return &Service{
+type Option func(*Service)
+
+// WithLeader - the bot is unhealthy when the replica has lost the leadership (its sync is stopped)
+func WithLeader(l dbot.LeaderElector) Option {
+	return func(s *Service) {
+		s.leader = l
+	}
+}
+
+func New(c dbot.BotClient, opts ...Option) *Service {
+	s := &Service{
 		client: c.RawClient().(*mautrix.Client),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Ping - Checks if the bot is online
-// It will return error if the bot is offline
+// It will return error if the bot is offline or has lost the leadership
 func (s *Service) Ping(ctx context.Context) error {
+	if s.leader != nil && !s.leader.IsLeader() {
+		return dbot.ErrLeadershipLost
+	}
+
 	_, err := s.client.GetOwnDisplayName(ctx)
 	return err
 }
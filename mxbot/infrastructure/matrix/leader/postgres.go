@@ -0,0 +1,241 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg"
+)
+
+var _ dbot.LeaderElector = (*PostgresElector)(nil)
+
+const (
+	defaultRetryInterval = 2 * time.Second
+	defaultCheckTimeout  = 2 * time.Second
+	lockPrefix           = "bobrix:leader:"
+)
+
+// keepaliveSettings - the server notices the dead leader (killed pod, lost network) in ~10s and releases its lock.
+// Without them the lock is held until the TCP timeout of the server (minutes)
+var keepaliveSettings = []string{
+	`SET tcp_keepalives_idle = 4`,
+	`SET tcp_keepalives_interval = 2`,
+	`SET tcp_keepalives_count = 3`,
+}
+
+// acquirer - executor that gives out dedicated connections (*pgxpool.Pool).
+// The advisory lock belongs to the session, so it must be taken and checked on the same connection
+type acquirer interface {
+	Acquire(ctx context.Context) (*pgxpool.Conn, error)
+}
+
+// PostgresElector - leader election with the session advisory lock of the bot account.
+// The leader holds the lock on a dedicated connection, the standby replicas retry every RetryInterval.
+// If the leader dies, its session is closed by the server and a standby replica takes over
+type PostgresElector struct {
+	provider pg.ExecutorProvider
+	userID   string
+	retry    time.Duration
+
+	mu      sync.Mutex
+	conn    *pgxpool.Conn // nil if the executor isn't a pool
+	exec    pg.Executor
+	lost    chan struct{}
+	stop    chan struct{}
+	stopped sync.Once
+	watched chan struct{} // closed when the watcher returns
+
+	leader atomic.Bool
+}
+
+type PostgresElectorOptions struct {
+	UserID        string        // bot account: replicas of the same account compete for the leadership
+	RetryInterval time.Duration // how often the standby replicas try to take the lock and the leader checks it. Default: 2s
+}
+
+func NewPostgresElector(provider pg.ExecutorProvider, opts PostgresElectorOptions) (*PostgresElector, error) {
+	if provider == nil {
+		return nil, errors.New("PostgresElector: provider is required")
+	}
+	if opts.UserID == "" {
+		return nil, errors.New("PostgresElector: UserID is required")
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaultRetryInterval
+	}
+
+	return &PostgresElector{
+		provider: provider,
+		userID:   opts.UserID,
+		retry:    opts.RetryInterval,
+	}, nil
+}
+
+func (e *PostgresElector) Campaign(ctx context.Context) error {
+	if e.leader.Load() {
+		return nil
+	}
+
+	for {
+		ok, err := e.tryLock(ctx)
+		if ok {
+			slog.Info("leader: acquired leadership", "user_id", e.userID)
+			return nil
+		}
+		if err != nil {
+			slog.Warn("leader: failed to take the lock", "user_id", e.userID, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.retry):
+		}
+	}
+}
+
+func (e *PostgresElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+func (e *PostgresElector) Lost() <-chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lost
+}
+
+func (e *PostgresElector) Resign(ctx context.Context) error {
+	// the watcher checks the session on the same connection: it's stopped before the unlock.
+	// The wait is bounded by its check timeout
+	e.mu.Lock()
+	if !e.leader.Load() {
+		e.mu.Unlock()
+		return nil
+	}
+	e.stopped.Do(func() { close(e.stop) })
+	watched := e.watched
+	e.mu.Unlock()
+
+	<-watched
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.leader.Load() { // resigned meanwhile
+		return nil
+	}
+
+	_, err := e.exec.Exec(ctx, `SELECT pg_advisory_unlock(hashtextextended($1, 0))`, lockPrefix+e.userID)
+	e.release(err != nil)
+	if err != nil {
+		return fmt.Errorf("leader: failed to release the lock: %w", err)
+	}
+
+	slog.Info("leader: resigned", "user_id", e.userID)
+	return nil
+}
+
+// tryLock - one attempt to take the lock. The connection is kept only by the leader
+func (e *PostgresElector) tryLock(ctx context.Context) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	exec := e.provider.Get(ctx)
+	var conn *pgxpool.Conn
+	if a, ok := exec.(acquirer); ok {
+		c, err := a.Acquire(ctx)
+		if err != nil {
+			return false, err
+		}
+		conn, exec = c, c
+	}
+
+	var locked bool
+	err := exec.QueryRow(ctx,
+		`SELECT pg_try_advisory_lock(hashtextextended($1, 0))`,
+		lockPrefix+e.userID,
+	).Scan(&locked)
+	if err != nil || !locked {
+		if conn != nil {
+			conn.Release()
+		}
+		return false, err
+	}
+
+	for _, q := range keepaliveSettings {
+		if _, err := exec.Exec(ctx, q); err != nil {
+			slog.Warn("leader: failed to set keepalive", "query", q, "error", err)
+		}
+	}
+
+	e.conn, e.exec = conn, exec
+	e.lost = make(chan struct{})
+	e.stop = make(chan struct{})
+	e.stopped = sync.Once{}
+	e.watched = make(chan struct{})
+	e.leader.Store(true)
+
+	go e.watch(e.exec, e.lost, e.stop, e.watched)
+
+	return true, nil
+}
+
+// watch - checks the session of the lock. The leadership is lost if the session is broken
+func (e *PostgresElector) watch(exec pg.Executor, lost, stop, watched chan struct{}) {
+	defer close(watched)
+
+	t := time.NewTicker(e.retry)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), defaultCheckTimeout)
+		_, err := exec.Exec(ctx, `SELECT 1`)
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		e.mu.Lock()
+		select {
+		case <-stop: // resigned meanwhile
+		default:
+			slog.Error("leader: lost leadership", "user_id", e.userID, "error", err)
+			// the session may still be alive: close it, so the lock is released for sure
+			e.release(true)
+			close(lost)
+		}
+		e.mu.Unlock()
+		return
+	}
+}
+
+// release - stops the watcher and returns (or closes) the connection of the lock. Called under mu
+func (e *PostgresElector) release(closeConn bool) {
+	e.leader.Store(false)
+	e.stopped.Do(func() { close(e.stop) })
+
+	if e.conn != nil {
+		if closeConn {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultCheckTimeout)
+			_ = e.conn.Conn().Close(ctx)
+			cancel()
+		}
+		// the pool drops the closed connection
+		e.conn.Release()
+	}
+	e.conn, e.exec = nil, nil
+}
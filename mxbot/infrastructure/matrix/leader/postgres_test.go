@@ -0,0 +1,140 @@
+package leader
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg"
+)
+
+const testRetry = 20 * time.Millisecond
+
+// newTestElectors - replicas of a fresh bot in the database of BOBRIX_TEST_POSTGRES_DSN (skipped without it)
+func newTestElectors(t *testing.T, n int) (*pgxpool.Pool, []*PostgresElector) {
+	t.Helper()
+
+	dsn := os.Getenv("BOBRIX_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("BOBRIX_TEST_POSTGRES_DSN is not set")
+	}
+
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	userID := "@test-" + uuid.NewString() + ":example.org"
+
+	electors := make([]*PostgresElector, n)
+	for i := range electors {
+		e, err := NewPostgresElector(pg.StaticProvider{DB: pool}, PostgresElectorOptions{UserID: userID, RetryInterval: testRetry})
+		if err != nil {
+			t.Fatalf("elector: %v", err)
+		}
+		t.Cleanup(func() { _ = e.Resign(context.Background()) })
+		electors[i] = e
+	}
+	return pool, electors
+}
+
+// campaignFor - the result of the campaign within the timeout
+func campaignFor(e *PostgresElector, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return e.Campaign(ctx)
+}
+
+func TestPostgresElectorFailover(t *testing.T) {
+	tests := []struct {
+		name string
+		// leave - the leader gives up the leadership. lost - the leader notices it
+		leave func(t *testing.T, pool *pgxpool.Pool, leader *PostgresElector)
+		lost  bool
+	}{
+		{
+			name: "resign",
+			leave: func(t *testing.T, _ *pgxpool.Pool, leader *PostgresElector) {
+				if err := leader.Resign(context.Background()); err != nil {
+					t.Fatalf("resign: %v", err)
+				}
+			},
+		},
+		{
+			name: "the session of the lock is terminated",
+			leave: func(t *testing.T, pool *pgxpool.Pool, leader *PostgresElector) {
+				_, err := pool.Exec(context.Background(),
+					`SELECT pg_terminate_backend(pid) FROM pg_locks
+					WHERE locktype = 'advisory' AND granted
+					AND ((classid::bigint << 32) | objid::bigint) = hashtextextended($1, 0)`,
+					lockPrefix+leader.userID,
+				)
+				if err != nil {
+					t.Fatalf("terminate: %v", err)
+				}
+			},
+			lost: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, electors := newTestElectors(t, 2)
+			leader, standby := electors[0], electors[1]
+
+			if err := campaignFor(leader, time.Second); err != nil {
+				t.Fatalf("leader campaign: %v", err)
+			}
+			if err := campaignFor(standby, 5*testRetry); err == nil {
+				t.Fatal("the standby replica took the held lock")
+			}
+
+			lost := leader.Lost()
+			tt.leave(t, pool, leader)
+
+			if tt.lost {
+				select {
+				case <-lost:
+				case <-time.After(2 * time.Second):
+					t.Fatal("Lost isn't closed")
+				}
+			}
+
+			if err := campaignFor(standby, 2*time.Second); err != nil {
+				t.Fatalf("standby campaign: %v", err)
+			}
+			if leader.IsLeader() || !standby.IsLeader() {
+				t.Errorf("leader = %v, standby = %v after the failover", leader.IsLeader(), standby.IsLeader())
+			}
+
+			if !tt.lost {
+				select {
+				case <-lost:
+					t.Error("Lost is closed after the resign")
+				default:
+				}
+			}
+		})
+	}
+}
+
+// TestPostgresElectorResignWhileWatching - Resign and the watcher use the same connection (run with -race)
+func TestPostgresElectorResignWhileWatching(t *testing.T) {
+	_, electors := newTestElectors(t, 1)
+	e := electors[0]
+
+	for range 20 {
+		if err := campaignFor(e, time.Second); err != nil {
+			t.Fatalf("campaign: %v", err)
+		}
+		time.Sleep(testRetry)
+		if err := e.Resign(context.Background()); err != nil {
+			t.Fatalf("resign: %v", err)
+		}
+	}
+}
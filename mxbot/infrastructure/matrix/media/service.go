@@ -2,6 +2,7 @@ package media
 
 import (
 	"context"
+	"io"
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/id"
@@ -25,3 +26,12 @@ func New(c dbot.BotClient) *Service {
 func (s *Service) Download(ctx context.Context, mxcURL id.ContentURI) ([]byte, error) {
 	return s.client.DownloadBytes(ctx, mxcURL)
 }
+
+// DownloadStream - returns the body of the download response of the mxc URL
+func (s *Service) DownloadStream(ctx context.Context, mxcURL id.ContentURI) (io.ReadCloser, error) {
+	resp, err := s.client.Download(ctx, mxcURL)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
@@ -2,9 +2,12 @@ package messaging
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/attachment"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
@@ -13,6 +16,7 @@ import (
 )
 
 var _ dbot.BotMessaging = (*Service)(nil)
+var _ messages.Messenger = (*Service)(nil)
 
 type Service struct {
 	client *mautrix.Client
@@ -26,38 +30,190 @@ func New(c dbot.BotClient, crypto dbot.BotCrypto) *Service {
 	}
 }
 
-func (s *Service) SendMessage(ctx context.Context, roomID id.RoomID, msg messages.Message) error {
+func (s *Service) SendMessage(ctx context.Context, roomID id.RoomID, msg messages.Message) (*messages.Handle, error) {
 	if msg == nil {
-		return dbot.ErrNilMessage
+		return nil, dbot.ErrNilMessage
+	}
+
+	encrypted, err := s.crypto.IsEncryptedRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
 	}
 
 	if msg.Type().IsMedia() {
-		resp, err := s.client.UploadMedia(ctx, msg.AsReqUpload())
+		if err := s.upload(ctx, msg, encrypted); err != nil {
+			return nil, fmt.Errorf("%w: %w", dbot.ErrUploadMedia, err)
+		}
+	}
+
+	return s.sendContent(ctx, roomID, event.EventMessage, msg.AsJSON(), encrypted)
+}
+
+// upload - uploads the media of the message and its thumbnail. In encrypted rooms the files are encrypted (AES-CTR)
+// before the upload and the message gets the keys, ivs and hashes of the files instead of the plain urls
+func (s *Service) upload(ctx context.Context, msg messages.Message, encrypted bool) error {
+	var media messages.EncryptedMedia
+	if encrypted {
+		var ok bool
+		if media, ok = unwrapMedia[messages.EncryptedMedia](msg); !ok {
+			// the plain upload would leave the file unencrypted on the media repo
+			return fmt.Errorf("message %T doesn't support encrypted attachments", msg)
+		}
+	}
+
+	if thumbnail, ok := unwrapMedia[messages.ThumbnailMedia](msg); ok {
+		if req, ok := thumbnail.ThumbnailUpload(); ok {
+			uri, file, err := s.uploadFile(ctx, req, encrypted)
+			if err != nil {
+				return fmt.Errorf("failed to upload thumbnail: %w", err)
+			}
+			if file != nil {
+				thumbnail.SetEncryptedThumbnail(file)
+			} else {
+				thumbnail.SetThumbnailURI(uri)
+			}
+		}
+	}
+
+	uri, file, err := s.uploadFile(ctx, msg.AsReqUpload(), encrypted)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		media.SetEncryptedFile(file)
+	} else {
+		msg.SetContentURI(uri)
+	}
+	return nil
+}
+
+// uploadFile - uploads the file. The encrypted file is returned with the key, iv, hashes and URI
+func (s *Service) uploadFile(
+	ctx context.Context,
+	req mautrix.ReqUploadMedia,
+	encrypted bool,
+) (id.ContentURI, *event.EncryptedFileInfo, error) {
+	if !encrypted {
+		resp, err := s.client.UploadMedia(ctx, req)
 		if err != nil {
-			return fmt.Errorf("%w: %w", dbot.ErrUploadMedia, err)
+			return id.ContentURI{}, nil, err
+		}
+		return resp.ContentURI, nil, nil
+	}
+
+	file := attachment.NewEncryptedFile()
+
+	var stream io.ReadSeekCloser
+	if req.ContentBytes != nil {
+		req.ContentBytes = file.Encrypt(req.ContentBytes)
+	} else if req.Content != nil {
+		stream = file.EncryptStream(req.Content)
+		req.Content = stream
+	}
+	// the type and the name of the file are in the (encrypted) event only
+	req.ContentType = "application/octet-stream"
+	req.FileName = ""
+
+	resp, err := s.client.UploadMedia(ctx, req)
+	if stream != nil {
+		// the hash of the stream is calculated while it is read and is set on close
+		if closeErr := stream.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return id.ContentURI{}, nil, err
+	}
+
+	return resp.ContentURI, &event.EncryptedFileInfo{
+		EncryptedFile: *file,
+		URL:           resp.ContentURI.CUString(),
+	}, nil
+}
+
+// unwrapMedia - the media message (the new content for edits) that implements T
+func unwrapMedia[T any](msg messages.Message) (T, bool) {
+	for {
+		if media, ok := msg.(T); ok {
+			return media, true
 		}
-		msg.SetContentURI(resp.ContentURI)
+		wrapper, ok := msg.(interface{ Unwrap() messages.Message })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		msg = wrapper.Unwrap()
+	}
+}
+
+func (s *Service) EditMessage(ctx context.Context, roomID id.RoomID, eventID id.EventID, msg messages.Message) error {
+	if msg == nil {
+		return dbot.ErrNilMessage
 	}
 
+	_, err := s.SendMessage(ctx, roomID, messages.NewReplace(msg, eventID))
+	return err
+}
+
+func (s *Service) SendReaction(ctx context.Context, roomID id.RoomID, eventID id.EventID, key string) (*messages.Handle, error) {
+	return s.send(ctx, roomID, event.EventReaction, map[string]any{
+		"m.relates_to": map[string]any{
+			"rel_type": event.RelAnnotation,
+			"event_id": eventID,
+			"key":      key,
+		},
+	})
+}
+
+// Redact - redactions are never encrypted
+func (s *Service) Redact(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string) error {
+	_, err := s.client.RedactEvent(ctx, roomID, eventID, mautrix.ReqRedact{Reason: reason})
+	return err
+}
+
+// send - sends the event (encrypted if the room is encrypted)
+func (s *Service) send(ctx context.Context, roomID id.RoomID, evType event.Type, content map[string]any) (*messages.Handle, error) {
 	encrypted, err := s.crypto.IsEncryptedRoom(ctx, roomID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	return s.sendContent(ctx, roomID, evType, content, encrypted)
+}
+
+func (s *Service) sendContent(
+	ctx context.Context,
+	roomID id.RoomID,
+	evType event.Type,
+	content map[string]any,
+	encrypted bool,
+) (*messages.Handle, error) {
 	if !encrypted {
-		_, err := s.client.SendMessageEvent(ctx, roomID, event.EventMessage, msg.AsJSON())
-		return err
+		resp, err := s.client.SendMessageEvent(ctx, roomID, evType, content)
+		if err != nil {
+			return nil, err
+		}
+		return messages.NewHandle(s, roomID, resp.EventID), nil
 	}
 
 	if err := s.crypto.EnsureOutboundSession(ctx, roomID); err != nil {
-		return err
+		return nil, err
 	}
 
-	evType, content, err := s.crypto.Encrypt(ctx, roomID, event.EventMessage, msg.AsJSON())
+	// raw JSON lets the crypto machine keep m.relates_to unencrypted (threads, edits and reactions)
+	raw, err := json.Marshal(content)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = s.client.SendMessageEvent(ctx, roomID, evType, content)
-	return err
+	encType, encContent, err := s.crypto.Encrypt(ctx, roomID, evType, json.RawMessage(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.SendMessageEvent(ctx, roomID, encType, encContent)
+	if err != nil {
+		return nil, err
+	}
+	return messages.NewHandle(s, roomID, resp.EventID), nil
 }
@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"maunium.net/go/mautrix/event"
+)
+
+// itemID - queue key of the event. Events without ID (e.g. to-device) get a generated one
+func itemID(evt *event.Event) string {
+	if evt.ID != "" {
+		return evt.ID.String()
+	}
+	return "local:" + uuid.NewString()
+}
+
+// encodeEvent - event as it came from sync (encrypted events are decrypted by the worker)
+func encodeEvent(evt *event.Event) ([]byte, error) {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event %s: %w", evt.ID, err)
+	}
+	return data, nil
+}
+
+// decodeEvent - restores the event with the parsed content, as the syncer delivers it
+func decodeEvent(data []byte) (*event.Event, error) {
+	var evt event.Event
+	if err := json.Unmarshal(data, &evt); err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+
+	switch {
+	case evt.StateKey != nil:
+		evt.Type.Class = event.StateEventType
+	case evt.Type.Class == event.UnknownEventType && evt.RoomID != "":
+		evt.Type.Class = event.MessageEventType
+	}
+
+	err := evt.Content.ParseRaw(evt.Type)
+	if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) && !errors.Is(err, event.ErrUnsupportedContentType) {
+		return nil, fmt.Errorf("failed to parse content of event %s: %w", evt.ID, err)
+	}
+
+	return &evt, nil
+}
@@ -0,0 +1,385 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+)
+
+var _ dbot.WorkQueue = (*MemoryQueue)(nil)
+
+const defaultMaxDeadLetters = 1000
+
+// MemoryQueue - bounded queue in memory (default of the sync).
+// The queued events and the dead letters are lost on restart: the backfill picks up the unprocessed events,
+// but not the dead letters (they are marked as processed).
+// The events are kept in lanes by the ordering key, the workers take the lanes in turn,
+// so a busy room doesn't starve the others
+type MemoryQueue struct {
+	mu       sync.Mutex
+	lanes    map[string]*lane
+	turn     []*lane // lanes with the queued events in the order of their turn
+	size     int
+	seq      uint64
+	capacity int
+	ordering Ordering
+	wake     chan struct{}
+
+	dead    []dbot.DeadLetter // the oldest first
+	maxDead int
+}
+
+// lane - queued and in-flight events of one ordering key
+type lane struct {
+	key    string
+	items  itemHeap
+	active int  // events being handled
+	queued bool // the lane is in the turn
+}
+
+// NewMemoryQueue - queue of up to capacity events (0: unbounded) delivered by the ordering.
+// Up to maxDeadLetters dead letters are kept (default: 1000), the oldest are dropped
+func NewMemoryQueue(capacity, maxDeadLetters int, ordering Ordering) *MemoryQueue {
+	if maxDeadLetters <= 0 {
+		maxDeadLetters = defaultMaxDeadLetters
+	}
+
+	return &MemoryQueue{
+		lanes:    map[string]*lane{},
+		capacity: capacity,
+		ordering: ordering,
+		wake:     make(chan struct{}, 1),
+		maxDead:  maxDeadLetters,
+	}
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, evt *event.Event) error {
+	// the key may need the decryption of the event: it's taken out of the lock
+	key := q.ordering.key(ctx, evt)
+
+	q.mu.Lock()
+	if q.capacity > 0 && q.size >= q.capacity {
+		q.mu.Unlock()
+		return dbot.ErrQueueFull
+	}
+	q.push(&dbot.QueuedEvent{ID: itemID(evt), Key: key, Event: evt, EnqueuedAt: time.Now()}, time.Now())
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+func (q *MemoryQueue) Dequeue(ctx context.Context) (*dbot.QueuedEvent, error) {
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		item, next := q.take(now)
+		if item != nil {
+			more := q.hasReady(now)
+			q.mu.Unlock()
+
+			// the other workers may be waiting
+			if more {
+				q.signal()
+			}
+			return item, nil
+		}
+		q.mu.Unlock()
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(now))
+			fire = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-fire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *MemoryQueue) Ack(_ context.Context, item *dbot.QueuedEvent) error {
+	q.mu.Lock()
+	q.release(item.Key)
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+func (q *MemoryQueue) Retry(_ context.Context, item *dbot.QueuedEvent, retryAt time.Time, cause error) error {
+	retried := *item
+	retried.Attempts++
+	if cause != nil {
+		retried.LastError = cause.Error()
+	}
+
+	// the retries don't count against the capacity: they are already accepted
+	q.mu.Lock()
+	q.release(item.Key)
+	q.push(&retried, retryAt)
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+func (q *MemoryQueue) Bury(_ context.Context, item *dbot.QueuedEvent, cause error) error {
+	dl := dbot.DeadLetter{
+		ID:         item.ID,
+		RoomID:     item.Event.RoomID,
+		Event:      item.Event,
+		Attempts:   item.Attempts + 1,
+		EnqueuedAt: item.EnqueuedAt,
+		FailedAt:   time.Now(),
+	}
+	if cause != nil {
+		dl.Error = cause.Error()
+	}
+
+	q.mu.Lock()
+	q.release(item.Key)
+	q.removeDead(item.ID)
+	q.dead = append(q.dead, dl)
+	if len(q.dead) > q.maxDead {
+		q.dead = q.dead[len(q.dead)-q.maxDead:]
+	}
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+func (q *MemoryQueue) Stats(_ context.Context) (dbot.WorkQueueStats, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := dbot.WorkQueueStats{
+		Queued:      q.size,
+		Capacity:    q.capacity,
+		DeadLetters: len(q.dead),
+	}
+	for key, l := range q.lanes {
+		if key != "" {
+			stats.Keys++
+		}
+		for _, it := range l.items {
+			if it.item.Attempts > 0 {
+				stats.Retrying++
+			}
+		}
+	}
+	return stats, nil
+}
+
+func (q *MemoryQueue) DeadLetters(_ context.Context, limit int) ([]dbot.DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if limit <= 0 || limit > len(q.dead) {
+		limit = len(q.dead)
+	}
+
+	out := make([]dbot.DeadLetter, 0, limit)
+	for i := len(q.dead) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, q.dead[i])
+	}
+	return out, nil
+}
+
+func (q *MemoryQueue) DeadLetter(_ context.Context, id string) (*dbot.DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.dead {
+		if q.dead[i].ID == id {
+			dl := q.dead[i]
+			return &dl, nil
+		}
+	}
+	return nil, dbot.ErrDeadLetterNotFound
+}
+
+func (q *MemoryQueue) Replay(ctx context.Context, id string) error {
+	q.mu.Lock()
+	dl, ok := q.removeDead(id)
+	q.mu.Unlock()
+	if !ok {
+		return dbot.ErrDeadLetterNotFound
+	}
+
+	key := q.ordering.key(ctx, dl.Event)
+
+	q.mu.Lock()
+	q.push(&dbot.QueuedEvent{
+		ID:         dl.ID,
+		Key:        key,
+		Event:      dl.Event,
+		EnqueuedAt: dl.EnqueuedAt,
+	}, time.Now())
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+func (q *MemoryQueue) DropDeadLetter(_ context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.removeDead(id); !ok {
+		return dbot.ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// push - adds the item ready at readyAt to the lane of its key. Called under mu
+func (q *MemoryQueue) push(item *dbot.QueuedEvent, readyAt time.Time) {
+	l, ok := q.lanes[item.Key]
+	if !ok {
+		l = &lane{key: item.Key}
+		q.lanes[item.Key] = l
+	}
+
+	q.seq++
+	heap.Push(&l.items, &memItem{item: item, readyAt: readyAt, seq: q.seq})
+	q.size++
+
+	if !l.queued {
+		l.queued = true
+		q.turn = append(q.turn, l)
+	}
+}
+
+// take - takes the ready item from the first lane in turn that isn't at the limit and moves the lane
+// to the end of the turn. Without the ready items returns the time the next one is ready at (zero if unknown).
+// Called under mu
+func (q *MemoryQueue) take(now time.Time) (*dbot.QueuedEvent, time.Time) {
+	var next time.Time
+
+	for i, l := range q.turn {
+		if !q.open(l) {
+			continue // released by Ack/Retry/Bury with a signal
+		}
+
+		head := l.items[0]
+		if head.readyAt.After(now) {
+			if next.IsZero() || head.readyAt.Before(next) {
+				next = head.readyAt
+			}
+			continue
+		}
+
+		heap.Pop(&l.items)
+		q.size--
+		l.active++
+
+		q.turn = slices.Delete(q.turn, i, i+1)
+		if len(l.items) > 0 {
+			q.turn = append(q.turn, l)
+		} else {
+			l.queued = false
+		}
+		return head.item, time.Time{}
+	}
+
+	return nil, next
+}
+
+// hasReady - there is a ready item for another worker. Called under mu
+func (q *MemoryQueue) hasReady(now time.Time) bool {
+	for _, l := range q.turn {
+		if q.open(l) && !l.items[0].readyAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// open - the lane may deliver one more event. Called under mu
+func (q *MemoryQueue) open(l *lane) bool {
+	limit := q.ordering.limit(l.key)
+	return limit == 0 || l.active < limit
+}
+
+// release - the event of the key isn't handled anymore. Called under mu
+func (q *MemoryQueue) release(key string) {
+	l, ok := q.lanes[key]
+	if !ok {
+		return
+	}
+
+	if l.active > 0 {
+		l.active--
+	}
+	if l.active == 0 && len(l.items) == 0 {
+		delete(q.lanes, key)
+	}
+}
+
+// removeDead - removes the dead letter by ID. Called under mu
+func (q *MemoryQueue) removeDead(id string) (dbot.DeadLetter, bool) {
+	for i := range q.dead {
+		if q.dead[i].ID == id {
+			dl := q.dead[i]
+			q.dead = append(q.dead[:i], q.dead[i+1:]...)
+			return dl, true
+		}
+	}
+	return dbot.DeadLetter{}, false
+}
+
+// signal - wakes up one waiting worker
+func (q *MemoryQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// memItem - queued event with the time it's ready at (later for the retries)
+type memItem struct {
+	item    *dbot.QueuedEvent
+	readyAt time.Time
+	seq     uint64 // FIFO order of the items ready at the same time
+}
+
+// itemHeap - min-heap of the items by readyAt
+type itemHeap []*memItem
+
+func (h itemHeap) Len() int { return len(h) }
+
+func (h itemHeap) Less(i, j int) bool {
+	if h[i].readyAt.Equal(h[j].readyAt) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].readyAt.Before(h[j].readyAt)
+}
+
+func (h itemHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *itemHeap) Push(x any) { *h = append(*h, x.(*memItem)) }
+
+func (h *itemHeap) Pop() any {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return it
+}
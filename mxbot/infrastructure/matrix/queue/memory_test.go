@@ -0,0 +1,263 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+)
+
+func roomMessage(roomID id.RoomID, eventID id.EventID) *event.Event {
+	evt := message(eventID, "hello")
+	evt.RoomID = roomID
+	return evt
+}
+
+// dequeueNow - dequeues the ready event, nil if there is none
+func dequeueNow(t *testing.T, q dbot.WorkQueue) *dbot.QueuedEvent {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	item, err := q.Dequeue(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("dequeue: %v", err)
+	}
+	return item
+}
+
+func TestMemoryQueueOrdering(t *testing.T) {
+	tests := []struct {
+		name     string
+		ordering Ordering
+		events   []*event.Event
+		want     []id.EventID // delivered without an ack
+	}{
+		{
+			name:     "no ordering",
+			ordering: Ordering{},
+			events:   []*event.Event{roomMessage("!a", "$a1"), roomMessage("!a", "$a2"), roomMessage("!b", "$b1")},
+			want:     []id.EventID{"$a1", "$a2", "$b1"},
+		},
+		{
+			name:     "by room",
+			ordering: RoomOrdering,
+			events:   []*event.Event{roomMessage("!a", "$a1"), roomMessage("!a", "$a2"), roomMessage("!b", "$b1")},
+			want:     []id.EventID{"$a1", "$b1"},
+		},
+		{
+			name:     "two per room",
+			ordering: Ordering{Key: ByRoom, PerKey: 2},
+			events:   []*event.Event{roomMessage("!a", "$a1"), roomMessage("!a", "$a2"), roomMessage("!a", "$a3")},
+			want:     []id.EventID{"$a1", "$a2"},
+		},
+		{
+			name:     "rooms in turn",
+			ordering: RoomOrdering,
+			events: []*event.Event{
+				roomMessage("!a", "$a1"), roomMessage("!a", "$a2"), roomMessage("!a", "$a3"),
+				roomMessage("!b", "$b1"), roomMessage("!c", "$c1"),
+			},
+			want: []id.EventID{"$a1", "$b1", "$c1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			q := NewMemoryQueue(0, 0, tt.ordering)
+
+			for _, evt := range tt.events {
+				if err := q.Enqueue(ctx, evt); err != nil {
+					t.Fatalf("enqueue: %v", err)
+				}
+			}
+
+			var got []id.EventID
+			for item := dequeueNow(t, q); item != nil; item = dequeueNow(t, q) {
+				got = append(got, item.Event.ID)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("delivered %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("delivered %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryQueueAckReleasesRoom(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(0, 0, RoomOrdering)
+
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a1"))
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a2"))
+
+	first := dequeueNow(t, q)
+	if item := dequeueNow(t, q); item != nil {
+		t.Fatalf("dequeue = %s while the room is busy", item.ID)
+	}
+
+	if err := q.Ack(ctx, first); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if item := dequeueNow(t, q); item == nil || item.ID != "$a2" {
+		t.Fatalf("dequeue = %v after the ack, want $a2", item)
+	}
+}
+
+func TestMemoryQueueCapacity(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(1, 0, RoomOrdering)
+
+	if err := q.Enqueue(ctx, roomMessage("!a", "$a1")); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := q.Enqueue(ctx, roomMessage("!a", "$a2")); !errors.Is(err, dbot.ErrQueueFull) {
+		t.Fatalf("enqueue = %v, want ErrQueueFull", err)
+	}
+}
+
+func TestMemoryQueueRetry(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(0, 0, RoomOrdering)
+
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a1"))
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a2"))
+
+	failed := dequeueNow(t, q)
+	if err := q.Retry(ctx, failed, time.Now().Add(200*time.Millisecond), errors.New("boom")); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+
+	// the retry waits for its time without blocking the room
+	next := dequeueNow(t, q)
+	if next == nil || next.ID != "$a2" {
+		t.Fatalf("dequeue = %v, want $a2", next)
+	}
+
+	stats, _ := q.Stats(ctx)
+	if stats.Queued != 1 || stats.Retrying != 1 {
+		t.Fatalf("stats = %+v, want 1 queued retry", stats)
+	}
+
+	// the retry is ready, but the room is busy
+	time.Sleep(250 * time.Millisecond)
+	if item := dequeueNow(t, q); item != nil {
+		t.Fatalf("dequeue = %s while the room is busy", item.ID)
+	}
+
+	_ = q.Ack(ctx, next)
+	if item := dequeueNow(t, q); item == nil || item.ID != "$a1" {
+		t.Fatalf("dequeue = %v after the ack, want the retry of $a1", item)
+	}
+}
+
+func TestMemoryQueueRetryDelay(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(0, 0, RoomOrdering)
+
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a1"))
+	failed := dequeueNow(t, q)
+
+	retryAt := time.Now().Add(100 * time.Millisecond)
+	_ = q.Retry(ctx, failed, retryAt, errors.New("boom"))
+
+	item, err := q.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("dequeue: %v", err)
+	}
+	if time.Now().Before(retryAt) {
+		t.Fatal("the retry is delivered before its time")
+	}
+	if item.Attempts != 1 || item.LastError != "boom" {
+		t.Fatalf("retried item = %+v, want 1 attempt with the error", item)
+	}
+}
+
+func TestMemoryQueueBuryAndReplay(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(0, 2, RoomOrdering)
+
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a1"))
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a2"))
+
+	poison := dequeueNow(t, q)
+	poison.Attempts = 4
+	if err := q.Bury(ctx, poison, errors.New("boom")); err != nil {
+		t.Fatalf("bury: %v", err)
+	}
+
+	// the room is released by the bury
+	next := dequeueNow(t, q)
+	if next == nil || next.ID != "$a2" {
+		t.Fatalf("dequeue = %v, want $a2", next)
+	}
+	_ = q.Ack(ctx, next)
+
+	dl, err := q.DeadLetter(ctx, "$a1")
+	if err != nil {
+		t.Fatalf("dead letter: %v", err)
+	}
+	if dl.Attempts != 5 || dl.Error != "boom" || dl.RoomID != "!a" {
+		t.Fatalf("dead letter = %+v", dl)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		want error
+	}{
+		{"replay", "$a1", nil},
+		{"replayed already", "$a1", dbot.ErrDeadLetterNotFound},
+		{"unknown", "$unknown", dbot.ErrDeadLetterNotFound},
+	}
+	for _, tt := range tests {
+		if err := q.Replay(ctx, tt.id); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: replay = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	replayed := dequeueNow(t, q)
+	if replayed == nil || replayed.ID != "$a1" || replayed.Attempts != 0 {
+		t.Fatalf("replayed = %+v, want $a1 with the attempts reset", replayed)
+	}
+	if stats, _ := q.Stats(ctx); stats.DeadLetters != 0 {
+		t.Fatalf("dead letters = %d after the replay", stats.DeadLetters)
+	}
+}
+
+func TestMemoryQueueDeadLettersLimit(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(0, 2, Ordering{})
+
+	for _, eventID := range []id.EventID{"$1", "$2", "$3"} {
+		_ = q.Enqueue(ctx, roomMessage("!a", eventID))
+		_ = q.Bury(ctx, dequeueNow(t, q), errors.New("boom"))
+	}
+
+	dead, _ := q.DeadLetters(ctx, 0)
+	if len(dead) != 2 || dead[0].ID != "$3" || dead[1].ID != "$2" {
+		t.Fatalf("dead letters = %+v, want $3, $2", dead)
+	}
+
+	if err := q.DropDeadLetter(ctx, "$1"); !errors.Is(err, dbot.ErrDeadLetterNotFound) {
+		t.Fatalf("drop of the evicted dead letter = %v", err)
+	}
+	if err := q.DropDeadLetter(ctx, "$2"); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+}
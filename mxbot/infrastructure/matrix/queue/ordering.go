@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// KeyFunc - ordering key of the event. The events with the same key are delivered in the enqueue order.
+// The empty key means no ordering (e.g. to-device events)
+type KeyFunc func(evt *event.Event) string
+
+// ControlFunc - reports the control events (cancellation, edit of the request...).
+// They are delivered at once, out of the ordering: otherwise they'd wait for the event they control
+type ControlFunc func(ctx context.Context, evt *event.Event) bool
+
+// Ordering - order of the delivery of the events with the same key (e.g. of the room).
+// An event is delivered only if less than PerKey events with its key are being handled,
+// so a busy room takes at most PerKey workers and the other rooms are handled in parallel.
+// A failed event waits for its retry without blocking the next events of the key
+type Ordering struct {
+	Key     KeyFunc     // nil: no ordering, the events are delivered in the enqueue order to any worker
+	PerKey  int         // events of the key handled at the same time. Default: 1
+	Control ControlFunc // the events out of the ordering. nil: none
+}
+
+// RoomOrdering - the events of the room are handled one by one, except for the reactions, redactions and edits
+var RoomOrdering = Ordering{Key: ByRoom, PerKey: 1, Control: ControlEvents(nil, nil)}
+
+// ByRoom - the events of the room are ordered
+func ByRoom(evt *event.Event) string {
+	return evt.RoomID.String()
+}
+
+// ByThread - the events of the thread are ordered, the thread is handled in parallel with the rest of the room.
+// The events outside of the threads are ordered by room
+func ByThread(evt *event.Event) string {
+	if evt.RoomID == "" {
+		return ""
+	}
+	if root := threadRoot(evt); root != "" {
+		return evt.RoomID.String() + "/" + root
+	}
+	return evt.RoomID.String()
+}
+
+// Decrypter - decrypts the event (see bot.BotCrypto)
+type Decrypter interface {
+	DecryptEvent(ctx context.Context, evt *event.Event) (*event.Event, error)
+}
+
+// ControlCommands - commands handled out of the ordering. The commands are added when they are registered
+// (e.g. the cancel command of bobrix.WithCancellation), so they are safe to add while the events are queued
+type ControlCommands struct {
+	mu       sync.RWMutex
+	commands []string
+}
+
+// NewControlCommands - ControlCommands constructor
+func NewControlCommands(commands ...string) *ControlCommands {
+	c := &ControlCommands{}
+	c.AddControlCommands(commands...)
+	return c
+}
+
+// AddControlCommands - adds the commands (with the prefix, e.g. "/cancel")
+func (c *ControlCommands) AddControlCommands(commands ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, cmd := range commands {
+		if cmd = strings.TrimSpace(cmd); cmd != "" && !slices.Contains(c.commands, cmd) {
+			c.commands = append(c.commands, cmd)
+		}
+	}
+}
+
+// empty - there are no commands
+func (c *ControlCommands) empty() bool {
+	if c == nil {
+		return true
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.commands) == 0
+}
+
+// matches - the body is one of the commands (with or without the arguments), case-insensitive like the commands
+func (c *ControlCommands) matches(body string) bool {
+	words := strings.Fields(body)
+	if len(words) == 0 {
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return slices.ContainsFunc(c.commands, func(cmd string) bool {
+		return strings.EqualFold(words[0], cmd)
+	})
+}
+
+// ControlEvents - reactions, redactions, edits (m.replace) and the messages starting with one of the commands.
+// The reactions and the edits are recognized by their relation (it isn't encrypted).
+// The body of the encrypted message is read with the decrypter: without it (or the keys)
+// the encrypted commands are ordered as usual
+func ControlEvents(commands *ControlCommands, decrypter Decrypter) ControlFunc {
+	return func(ctx context.Context, evt *event.Event) bool {
+		switch evt.Type {
+		case event.EventReaction, event.EventRedaction:
+			return true
+		}
+
+		if rel := relation(evt); rel != nil && (rel.Type == event.RelAnnotation || rel.Type == event.RelReplace) {
+			return true
+		}
+
+		if commands.empty() {
+			return false
+		}
+
+		if evt.Type == event.EventEncrypted {
+			if decrypter == nil {
+				return false
+			}
+			decrypted, err := decrypter.DecryptEvent(ctx, evt)
+			if err != nil {
+				return false
+			}
+			evt = decrypted
+		}
+
+		msg := evt.Content.AsMessage()
+		return msg != nil && commands.matches(msg.Body)
+	}
+}
+
+// key - ordering key of the event ("" without the ordering)
+func (o Ordering) key(ctx context.Context, evt *event.Event) string {
+	if o.Key == nil || (o.Control != nil && o.Control(ctx, evt)) {
+		return ""
+	}
+	return o.Key(evt)
+}
+
+// limit - events of the key handled at the same time (0: unlimited)
+func (o Ordering) limit(key string) int {
+	if key == "" {
+		return 0
+	}
+	return o.perKey()
+}
+
+// perKey - events of any key handled at the same time (0 without the ordering)
+func (o Ordering) perKey() int {
+	switch {
+	case o.Key == nil:
+		return 0
+	case o.PerKey <= 0:
+		return 1
+	default:
+		return o.PerKey
+	}
+}
+
+// threadRoot - root of the thread the event belongs to
+func threadRoot(evt *event.Event) string {
+	return relation(evt).GetThreadParent().String()
+}
+
+// relation - relation of the event (nil if there is none). The relation of the encrypted event is not encrypted
+func relation(evt *event.Event) *event.RelatesTo {
+	switch content := evt.Content.Parsed.(type) {
+	case *event.EncryptedEventContent:
+		return content.RelatesTo
+	case event.Relatable:
+		return content.OptionalGetRelatesTo()
+	}
+	return nil
+}
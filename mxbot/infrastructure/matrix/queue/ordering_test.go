@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const testRoom = id.RoomID("!room:example.org")
+
+func message(eventID id.EventID, body string) *event.Event {
+	return &event.Event{
+		ID:     eventID,
+		RoomID: testRoom,
+		Type:   event.EventMessage,
+		Content: event.Content{Parsed: &event.MessageEventContent{
+			MsgType: event.MsgText,
+			Body:    body,
+		}},
+	}
+}
+
+// decrypterFunc - decrypter of the tests
+type decrypterFunc func(evt *event.Event) (*event.Event, error)
+
+func (f decrypterFunc) DecryptEvent(_ context.Context, evt *event.Event) (*event.Event, error) {
+	return f(evt)
+}
+
+// encrypted - the encrypted message with the body known to testDecrypter
+func encrypted(eventID id.EventID, body string) *event.Event {
+	return &event.Event{ID: eventID, RoomID: testRoom, Type: event.EventEncrypted,
+		Content: event.Content{Parsed: &event.EncryptedEventContent{MegolmCiphertext: []byte(body)}}}
+}
+
+var testDecrypter = decrypterFunc(func(evt *event.Event) (*event.Event, error) {
+	content := evt.Content.Parsed.(*event.EncryptedEventContent)
+	if string(content.MegolmCiphertext) == "" {
+		return nil, errors.New("no session with given ID found")
+	}
+	return message(evt.ID, string(content.MegolmCiphertext)), nil
+})
+
+func TestControlEvents(t *testing.T) {
+	commands := NewControlCommands("/cancel")
+	control := ControlEvents(commands, testDecrypter)
+	commands.AddControlCommands("/stop") // registered later
+
+	edit := message("$edit", "* new text")
+	edit.Content.AsMessage().RelatesTo = (&event.RelatesTo{}).SetReplace("$request")
+
+	threaded := message("$threaded", "hello")
+	threaded.Content.AsMessage().RelatesTo = (&event.RelatesTo{}).SetThread("$root", "$root")
+
+	tests := []struct {
+		name string
+		evt  *event.Event
+		want bool
+	}{
+		{"message", message("$msg", "hello"), false},
+		{"other command", message("$cmd", "/cancelled"), false},
+		{"cancel command", message("$cancel", "/cancel"), true},
+		{"cancel command with args", message("$cancel", " /cancel all"), true},
+		{"cancel command in other case", message("$cancel", "/CANCEL"), true},
+		{"command registered later", message("$stop", "/stop"), true},
+		{"encrypted cancel command", encrypted("$enc-cancel", "/cancel"), true},
+		{"encrypted message", encrypted("$enc-msg", "hello"), false},
+		{"encrypted without the keys", encrypted("$enc-nokeys", ""), false},
+		{"edit", edit, true},
+		{"thread message", threaded, false},
+		{"redaction", &event.Event{ID: "$r", RoomID: testRoom, Type: event.EventRedaction,
+			Content: event.Content{Parsed: &event.RedactionEventContent{Redacts: "$request"}}}, true},
+		{"reaction", &event.Event{ID: "$re", RoomID: testRoom, Type: event.EventReaction,
+			Content: event.Content{Parsed: &event.ReactionEventContent{
+				RelatesTo: *(&event.RelatesTo{}).SetAnnotation("$request", "🛑"),
+			}}}, true},
+		{"encrypted edit", &event.Event{ID: "$enc", RoomID: testRoom, Type: event.EventEncrypted,
+			Content: event.Content{Parsed: &event.EncryptedEventContent{
+				RelatesTo: (&event.RelatesTo{}).SetReplace("$request"),
+			}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := control(context.Background(), tt.evt); got != tt.want {
+				t.Errorf("control = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestControlEventsBypassBusyRoom(t *testing.T) {
+	ctx := context.Background()
+
+	control := ControlEvents(NewControlCommands("/cancel"), testDecrypter)
+
+	for _, ordering := range []Ordering{
+		{Key: ByRoom, PerKey: 1, Control: control},
+		{Key: ByThread, PerKey: 1, Control: control},
+	} {
+		q := NewMemoryQueue(0, 0, ordering)
+
+		for _, evt := range []*event.Event{
+			message("$call", "/generate a long answer"),
+			message("$next", "another request"),
+			encrypted("$cancel", "/cancel"),
+		} {
+			if err := q.Enqueue(ctx, evt); err != nil {
+				t.Fatalf("enqueue: %v", err)
+			}
+		}
+
+		// the call is being handled: the room is busy
+		busy, err := q.Dequeue(ctx)
+		if err != nil || busy.ID != "$call" {
+			t.Fatalf("dequeue = %v, %v, want $call", busy, err)
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+		item, err := q.Dequeue(waitCtx)
+		cancel()
+		if err != nil {
+			t.Fatalf("/cancel is held back by the busy room: %v", err)
+		}
+		if item.ID != "$cancel" {
+			t.Fatalf("dequeue = %s, want $cancel", item.ID)
+		}
+
+		// the next request still waits for the call
+		waitCtx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+		if item, err := q.Dequeue(waitCtx); err == nil {
+			t.Fatalf("dequeue = %s while the room is busy", item.ID)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,419 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg"
+)
+
+var (
+	_ dbot.WorkQueue     = (*PostgresQueue)(nil)
+	_ dbot.LeaseExtender = (*PostgresQueue)(nil)
+)
+
+const (
+	defaultLease        = 5 * time.Minute
+	defaultPollInterval = time.Second
+)
+
+// PostgresQueue - durable queue in the tables bobrix_work_queue and bobrix_dead_letters
+// (see the migrations package for the schema). The queued events survive restarts,
+// and several replicas of the bot may consume the same queue: the events are claimed with SKIP LOCKED.
+// With the ordering the claims of the bot are serialized by the advisory lock,
+// so the limit of the key holds across the replicas
+type PostgresQueue struct {
+	provider pg.ExecutorProvider
+	userID   string
+	lease    time.Duration
+	poll     time.Duration
+	ordering Ordering
+	wake     chan struct{}
+}
+
+type PostgresQueueOptions struct {
+	UserID string
+	// Lease - the event is delivered again if the worker neither finishes nor extends it in time
+	// (the sync extends the lease while the event is handled). Default: 5m
+	Lease        time.Duration
+	PollInterval time.Duration // how often the idle workers check the table. Default: 1s
+	Ordering     Ordering      // default: no ordering
+}
+
+// txBeginner - executor that starts transactions (*pgxpool.Pool, pgx.Tx)
+type txBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+func NewPostgresQueue(provider pg.ExecutorProvider, opts PostgresQueueOptions) (*PostgresQueue, error) {
+	if opts.UserID == "" {
+		return nil, errors.New("PostgresQueue: UserID is required")
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = defaultLease
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+
+	return &PostgresQueue{
+		provider: provider,
+		userID:   opts.UserID,
+		lease:    opts.Lease,
+		poll:     opts.PollInterval,
+		ordering: opts.Ordering,
+		wake:     make(chan struct{}, 1),
+	}, nil
+}
+
+func (q *PostgresQueue) Enqueue(ctx context.Context, evt *event.Event) error {
+	data, err := encodeEvent(evt)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO bobrix_work_queue(user_id, id, room_id, order_key, event)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, id) DO NOTHING
+		`
+	_, err = q.provider.Get(ctx).Exec(ctx, query, q.userID, itemID(evt), evt.RoomID.String(), q.ordering.key(ctx, evt), data)
+	if err != nil {
+		return fmt.Errorf("queue: enqueue failed: %w", err)
+	}
+
+	q.signal()
+	return nil
+}
+
+func (q *PostgresQueue) Dequeue(ctx context.Context) (*dbot.QueuedEvent, error) {
+	for {
+		item, err := q.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		if item != nil {
+			// the other workers may be waiting
+			q.signal()
+			return item, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.wake:
+		case <-time.After(q.poll):
+		}
+	}
+}
+
+// claim - leases the next ready event (nil if there is none)
+func (q *PostgresQueue) claim(ctx context.Context) (*dbot.QueuedEvent, error) {
+	exec := q.provider.Get(ctx)
+
+	b, ok := exec.(txBeginner)
+	if q.ordering.Key == nil || !ok {
+		return q.claimWith(ctx, exec)
+	}
+
+	tx, err := b.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("queue: dequeue failed: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// the concurrent claim could take the next event of the same key: it doesn't see the lease of this one yet
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, "bobrix:queue:"+q.userID); err != nil {
+		return nil, fmt.Errorf("queue: dequeue failed: %w", err)
+	}
+
+	item, err := q.claimWith(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("queue: dequeue failed: %w", err)
+	}
+	return item, nil
+}
+
+// claimWith - leases the oldest ready event whose key isn't at the limit.
+// The expired lease is a failed attempt: the worker has died on the event (e.g. the poison event crashes the process)
+func (q *PostgresQueue) claimWith(ctx context.Context, exec pg.Executor) (*dbot.QueuedEvent, error) {
+	query := `
+		UPDATE bobrix_work_queue
+		SET lease_until = now() + make_interval(secs => $2),
+			attempts = attempts + CASE WHEN lease_until IS NOT NULL THEN 1 ELSE 0 END,
+			last_error = CASE WHEN lease_until IS NOT NULL THEN 'lease expired' ELSE last_error END
+		WHERE user_id = $1 AND id = (
+			SELECT q.id FROM bobrix_work_queue q
+			WHERE q.user_id = $1
+			AND q.available_at <= now()
+			AND (q.lease_until IS NULL OR q.lease_until < now())
+			AND ($3 = 0 OR q.order_key = '' OR (
+				SELECT count(*) FROM bobrix_work_queue b
+				WHERE b.user_id = $1 AND b.order_key = q.order_key
+				AND b.lease_until IS NOT NULL AND b.lease_until >= now()
+			) < $3)
+			ORDER BY q.available_at, q.enqueued_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, order_key, event, attempts, last_error, enqueued_at
+		`
+
+	var (
+		item dbot.QueuedEvent
+		data []byte
+	)
+	err := exec.QueryRow(ctx, query, q.userID, q.lease.Seconds(), q.ordering.perKey()).
+		Scan(&item.ID, &item.Key, &data, &item.Attempts, &item.LastError, &item.EnqueuedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("queue: dequeue failed: %w", err)
+	}
+
+	item.Event, err = decodeEvent(data)
+	if err != nil {
+		// the event can't be handled ever: park it right away
+		_ = q.bury(ctx, exec, &item, err)
+		return nil, nil
+	}
+	return &item, nil
+}
+
+func (q *PostgresQueue) Lease() time.Duration {
+	return q.lease
+}
+
+// Extend - renews the lease of the event. The lease is lost if the event has been reclaimed
+// (the reclaim counts an attempt, see claimWith)
+func (q *PostgresQueue) Extend(ctx context.Context, item *dbot.QueuedEvent) error {
+	query := `
+		UPDATE bobrix_work_queue
+		SET lease_until = now() + make_interval(secs => $4)
+		WHERE user_id=$1 AND id=$2 AND attempts=$3 AND lease_until IS NOT NULL
+		`
+	tag, err := q.provider.Get(ctx).Exec(ctx, query, q.userID, item.ID, item.Attempts, q.lease.Seconds())
+	if err != nil {
+		return fmt.Errorf("queue: extend failed: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return dbot.ErrLeaseLost
+	}
+	return nil
+}
+
+func (q *PostgresQueue) Ack(ctx context.Context, item *dbot.QueuedEvent) error {
+	_, err := q.provider.Get(ctx).Exec(ctx,
+		`DELETE FROM bobrix_work_queue WHERE user_id=$1 AND id=$2`,
+		q.userID, item.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("queue: ack failed: %w", err)
+	}
+
+	// the key of the event is released
+	q.signal()
+	return nil
+}
+
+func (q *PostgresQueue) Retry(ctx context.Context, item *dbot.QueuedEvent, retryAt time.Time, cause error) error {
+	query := `
+		UPDATE bobrix_work_queue
+		SET attempts = attempts + 1,
+			last_error = $3,
+			available_at = $4,
+			lease_until = NULL
+		WHERE user_id=$1 AND id=$2
+		`
+	if _, err := q.provider.Get(ctx).Exec(ctx, query, q.userID, item.ID, errorText(cause), retryAt); err != nil {
+		return fmt.Errorf("queue: retry failed: %w", err)
+	}
+
+	q.signal()
+	return nil
+}
+
+func (q *PostgresQueue) Bury(ctx context.Context, item *dbot.QueuedEvent, cause error) error {
+	if err := q.bury(ctx, q.provider.Get(ctx), item, cause); err != nil {
+		return err
+	}
+
+	// the key of the event is released
+	q.signal()
+	return nil
+}
+
+func (q *PostgresQueue) bury(ctx context.Context, exec pg.Executor, item *dbot.QueuedEvent, cause error) error {
+	// one statement: the event is either in the queue or in the dead letters
+	query := `
+		WITH moved AS (
+			DELETE FROM bobrix_work_queue
+			WHERE user_id=$1 AND id=$2
+			RETURNING id, room_id, order_key, event, attempts, enqueued_at
+		)
+		INSERT INTO bobrix_dead_letters(user_id, id, room_id, order_key, event, attempts, error, enqueued_at)
+		SELECT $1, id, room_id, order_key, event, attempts + 1, $3, enqueued_at FROM moved
+		ON CONFLICT (user_id, id) DO UPDATE
+		SET order_key = EXCLUDED.order_key,
+			event = EXCLUDED.event,
+			attempts = EXCLUDED.attempts,
+			error = EXCLUDED.error,
+			failed_at = now()
+		`
+	if _, err := exec.Exec(ctx, query, q.userID, item.ID, errorText(cause)); err != nil {
+		return fmt.Errorf("queue: bury failed: %w", err)
+	}
+	return nil
+}
+
+func (q *PostgresQueue) Stats(ctx context.Context) (dbot.WorkQueueStats, error) {
+	query := `
+		SELECT
+			count(*),
+			count(*) FILTER (WHERE attempts > 0),
+			count(DISTINCT order_key) FILTER (WHERE order_key <> ''),
+			(SELECT count(*) FROM bobrix_dead_letters WHERE user_id=$1)
+		FROM bobrix_work_queue
+		WHERE user_id=$1
+		`
+
+	var stats dbot.WorkQueueStats
+	err := q.provider.Get(ctx).QueryRow(ctx, query, q.userID).
+		Scan(&stats.Queued, &stats.Retrying, &stats.Keys, &stats.DeadLetters)
+	if err != nil {
+		return stats, fmt.Errorf("queue: stats failed: %w", err)
+	}
+	return stats, nil
+}
+
+func (q *PostgresQueue) DeadLetters(ctx context.Context, limit int) ([]dbot.DeadLetter, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := q.provider.Get(ctx).Query(ctx, `
+		SELECT id, room_id, event, attempts, error, enqueued_at, failed_at
+		FROM bobrix_dead_letters
+		WHERE user_id=$1
+		ORDER BY failed_at DESC
+		LIMIT $2
+		`, q.userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("queue: dead letters query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var out []dbot.DeadLetter
+	for rows.Next() {
+		dl, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("queue: dead letters query failed: %w", err)
+		}
+		out = append(out, *dl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("queue: dead letters query failed: %w", err)
+	}
+	return out, nil
+}
+
+func (q *PostgresQueue) DeadLetter(ctx context.Context, key string) (*dbot.DeadLetter, error) {
+	row := q.provider.Get(ctx).QueryRow(ctx, `
+		SELECT id, room_id, event, attempts, error, enqueued_at, failed_at
+		FROM bobrix_dead_letters
+		WHERE user_id=$1 AND id=$2
+		`, q.userID, key)
+
+	dl, err := scanDeadLetter(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, dbot.ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("queue: dead letter query failed: %w", err)
+	}
+	return dl, nil
+}
+
+func (q *PostgresQueue) Replay(ctx context.Context, key string) error {
+	query := `
+		WITH moved AS (
+			DELETE FROM bobrix_dead_letters
+			WHERE user_id=$1 AND id=$2
+			RETURNING id, room_id, order_key, event, enqueued_at
+		), queued AS (
+			INSERT INTO bobrix_work_queue(user_id, id, room_id, order_key, event, enqueued_at)
+			SELECT $1, id, room_id, order_key, event, enqueued_at FROM moved
+			ON CONFLICT (user_id, id) DO NOTHING
+		)
+		SELECT count(*) FROM moved
+		`
+
+	var n int
+	if err := q.provider.Get(ctx).QueryRow(ctx, query, q.userID, key).Scan(&n); err != nil {
+		return fmt.Errorf("queue: replay failed: %w", err)
+	}
+	if n == 0 {
+		return dbot.ErrDeadLetterNotFound
+	}
+
+	q.signal()
+	return nil
+}
+
+func (q *PostgresQueue) DropDeadLetter(ctx context.Context, key string) error {
+	tag, err := q.provider.Get(ctx).Exec(ctx,
+		`DELETE FROM bobrix_dead_letters WHERE user_id=$1 AND id=$2`,
+		q.userID, key,
+	)
+	if err != nil {
+		return fmt.Errorf("queue: drop dead letter failed: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return dbot.ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// signal - wakes up one waiting worker of this replica
+func (q *PostgresQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func scanDeadLetter(row pgx.Row) (*dbot.DeadLetter, error) {
+	var (
+		dl     dbot.DeadLetter
+		roomID string
+		data   []byte
+	)
+	if err := row.Scan(&dl.ID, &roomID, &data, &dl.Attempts, &dl.Error, &dl.EnqueuedAt, &dl.FailedAt); err != nil {
+		return nil, err
+	}
+	dl.RoomID = id.RoomID(roomID)
+
+	// the undecodable event is listed too (it may be the reason it's here)
+	if evt, err := decodeEvent(data); err == nil {
+		dl.Event = evt
+	}
+	return &dl, nil
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
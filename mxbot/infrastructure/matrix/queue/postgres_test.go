@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg"
+	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg/migrations"
+)
+
+// newTestPostgresQueue - queue of a fresh bot in the database of BOBRIX_TEST_POSTGRES_DSN (skipped without it)
+func newTestPostgresQueue(t *testing.T, opts PostgresQueueOptions) *PostgresQueue {
+	t.Helper()
+
+	dsn := os.Getenv("BOBRIX_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("BOBRIX_TEST_POSTGRES_DSN is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if _, err := migrations.Apply(ctx, pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	opts.UserID = "@test-" + uuid.NewString() + ":example.org"
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, `DELETE FROM bobrix_work_queue WHERE user_id=$1`, opts.UserID)
+		_, _ = pool.Exec(ctx, `DELETE FROM bobrix_dead_letters WHERE user_id=$1`, opts.UserID)
+		pool.Close()
+	})
+
+	q, err := NewPostgresQueue(pg.StaticProvider{DB: pool}, opts)
+	if err != nil {
+		t.Fatalf("queue: %v", err)
+	}
+	return q
+}
+
+func TestPostgresQueueOrdering(t *testing.T) {
+	ctx := context.Background()
+	q := newTestPostgresQueue(t, PostgresQueueOptions{Ordering: RoomOrdering, PollInterval: 10 * time.Millisecond})
+
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a1"))
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a2"))
+	_ = q.Enqueue(ctx, roomMessage("!b", "$b1"))
+
+	first := dequeueNow(t, q)
+	second := dequeueNow(t, q)
+	if first == nil || first.ID != "$a1" || second == nil || second.ID != "$b1" {
+		t.Fatalf("dequeue = %v, %v, want $a1, $b1", first, second)
+	}
+	if item := dequeueNow(t, q); item != nil {
+		t.Fatalf("dequeue = %s while the rooms are busy", item.ID)
+	}
+
+	_ = q.Ack(ctx, first)
+	if item := dequeueNow(t, q); item == nil || item.ID != "$a2" {
+		t.Fatalf("dequeue = %v after the ack, want $a2", item)
+	}
+}
+
+func TestPostgresQueueRetryBuryReplay(t *testing.T) {
+	ctx := context.Background()
+	q := newTestPostgresQueue(t, PostgresQueueOptions{PollInterval: 10 * time.Millisecond})
+
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a1"))
+
+	item := dequeueNow(t, q)
+	if err := q.Retry(ctx, item, time.Now().Add(time.Hour), errors.New("boom")); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if next := dequeueNow(t, q); next != nil {
+		t.Fatalf("dequeue = %s before the retry time", next.ID)
+	}
+	if stats, _ := q.Stats(ctx); stats.Retrying != 1 {
+		t.Fatalf("stats = %+v, want 1 retrying", stats)
+	}
+
+	// the attempts are counted by the table: the retry and the bury
+	if err := q.Bury(ctx, item, errors.New("boom")); err != nil {
+		t.Fatalf("bury: %v", err)
+	}
+	dl, err := q.DeadLetter(ctx, "$a1")
+	if err != nil || dl.Attempts != 2 || dl.Error != "boom" {
+		t.Fatalf("dead letter = %+v, %v", dl, err)
+	}
+
+	if err := q.Replay(ctx, "$a1"); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if err := q.Replay(ctx, "$a1"); !errors.Is(err, dbot.ErrDeadLetterNotFound) {
+		t.Fatalf("second replay = %v, want ErrDeadLetterNotFound", err)
+	}
+	if replayed := dequeueNow(t, q); replayed == nil || replayed.Attempts != 0 {
+		t.Fatalf("replayed = %+v, want the attempts reset", replayed)
+	}
+}
+
+func TestPostgresQueueLease(t *testing.T) {
+	ctx := context.Background()
+	q := newTestPostgresQueue(t, PostgresQueueOptions{Lease: time.Second, PollInterval: 10 * time.Millisecond})
+
+	_ = q.Enqueue(ctx, roomMessage("!a", "$a1"))
+	item := dequeueNow(t, q)
+
+	// the extended lease holds the event
+	time.Sleep(600 * time.Millisecond)
+	if err := q.Extend(ctx, item); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	time.Sleep(600 * time.Millisecond)
+	if next := dequeueNow(t, q); next != nil {
+		t.Fatalf("dequeue = %s under the extended lease", next.ID)
+	}
+
+	// the expired lease is reclaimed as a failed attempt
+	time.Sleep(time.Second)
+	reclaimed := dequeueNow(t, q)
+	if reclaimed == nil || reclaimed.Attempts != 1 {
+		t.Fatalf("reclaimed = %+v, want 1 attempt", reclaimed)
+	}
+	if err := q.Extend(ctx, item); !errors.Is(err, dbot.ErrLeaseLost) {
+		t.Fatalf("extend of the reclaimed event = %v, want ErrLeaseLost", err)
+	}
+}
@@ -0,0 +1,229 @@
+package roomconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+	droomcfg "github.com/tensved/bobrix/mxbot/domain/roomconfig"
+)
+
+var _ dbot.BotRoomConfig = (*Service)(nil)
+
+// Service caches room settings (io.bobrix.config) and power levels of the rooms.
+// The cache is filled from /sync (see ObserveRoomState) and lazily from the room state.
+// Both paths accept the settings only if the sender has the power level required for io.bobrix.config (see accept)
+type Service struct {
+	client *mautrix.Client
+
+	mu          sync.RWMutex
+	configs     map[id.RoomID]*droomcfg.Config // nil value - the room has no settings
+	powerLevels map[id.RoomID]*event.PowerLevelsEventContent
+}
+
+func New(c dbot.BotClient) *Service {
+	return &Service{
+		client:      c.RawClient().(*mautrix.Client),
+		configs:     make(map[id.RoomID]*droomcfg.Config),
+		powerLevels: make(map[id.RoomID]*event.PowerLevelsEventContent),
+	}
+}
+
+// ObserveRoomState - updates the cache with a state event from /sync.
+// Rejected settings evict the cached ones: they are no longer the settings of the room.
+// The cache of the room is dropped when the bot leaves it
+func (s *Service) ObserveRoomState(ctx context.Context, roomID id.RoomID, evt *event.Event) {
+	if evt == nil || evt.StateKey == nil {
+		return
+	}
+
+	switch evt.Type.Type {
+	case event.StateMember.Type:
+		if *evt.StateKey != s.client.UserID.String() {
+			return
+		}
+		// the content isn't parsed yet (the observers run before the syncer dispatches the events)
+		var member event.MemberEventContent
+		if err := json.Unmarshal(evt.Content.VeryRaw, &member); err != nil {
+			slog.Error("roomconfig: failed to parse membership", "room", roomID, "err", err)
+			return
+		}
+		if member.Membership == event.MembershipLeave || member.Membership == event.MembershipBan {
+			s.forget(roomID)
+		}
+
+	case event.StatePowerLevels.Type:
+		if *evt.StateKey != "" {
+			return
+		}
+
+		var pl event.PowerLevelsEventContent
+		if err := json.Unmarshal(evt.Content.VeryRaw, &pl); err != nil {
+			slog.Error("roomconfig: failed to parse power levels", "room", roomID, "err", err)
+			return
+		}
+
+		s.mu.Lock()
+		s.powerLevels[roomID] = &pl
+		s.mu.Unlock()
+
+	case droomcfg.StateEventType.Type:
+		if *evt.StateKey != "" {
+			return
+		}
+
+		cfg, err := s.accept(ctx, roomID, evt)
+		if err != nil {
+			// the settings are loaded again on the next request
+			slog.Error("roomconfig: failed to accept settings", "room", roomID, "err", err)
+			s.mu.Lock()
+			delete(s.configs, roomID)
+			s.mu.Unlock()
+			return
+		}
+
+		s.mu.Lock()
+		s.configs[roomID] = cfg
+		s.mu.Unlock()
+
+		if cfg != nil {
+			slog.Info("roomconfig: settings updated", "room", roomID, "sender", evt.Sender)
+		}
+	}
+}
+
+// accept - parses the settings of the state event.
+// It returns nil if the sender doesn't have the power level required for io.bobrix.config
+func (s *Service) accept(ctx context.Context, roomID id.RoomID, evt *event.Event) (*droomcfg.Config, error) {
+	level, err := s.UserPowerLevel(ctx, roomID, evt.Sender)
+	if err != nil {
+		return nil, fmt.Errorf("check sender power level: %w", err)
+	}
+
+	required, err := s.requiredLevel(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("get required power level: %w", err)
+	}
+
+	if level < required {
+		slog.Warn("roomconfig: ignoring settings from unauthorized user",
+			"room", roomID, "sender", evt.Sender, "level", level, "required", required)
+		return nil, nil
+	}
+
+	var cfg droomcfg.Config
+	if len(evt.Content.VeryRaw) > 0 {
+		if err := json.Unmarshal(evt.Content.VeryRaw, &cfg); err != nil {
+			return nil, fmt.Errorf("parse settings: %w", err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// forget - drops the cache of the room
+func (s *Service) forget(roomID id.RoomID) {
+	s.mu.Lock()
+	delete(s.configs, roomID)
+	delete(s.powerLevels, roomID)
+	s.mu.Unlock()
+}
+
+func (s *Service) RoomConfig(ctx context.Context, roomID id.RoomID) (*droomcfg.Config, bool) {
+	s.mu.RLock()
+	cfg, ok := s.configs[roomID]
+	s.mu.RUnlock()
+
+	if !ok {
+		cfg = s.fetchConfig(ctx, roomID)
+	}
+
+	return cfg, cfg != nil
+}
+
+func (s *Service) SetRoomConfig(ctx context.Context, roomID id.RoomID, cfg *droomcfg.Config) error {
+	if cfg == nil {
+		cfg = &droomcfg.Config{}
+	}
+
+	if _, err := s.client.SendStateEvent(ctx, roomID, droomcfg.StateEventType, "", cfg); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.configs[roomID] = cfg
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *Service) UserPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID) (int, error) {
+	pl, err := s.getPowerLevels(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+	return pl.GetUserLevel(userID), nil
+}
+
+func (s *Service) requiredLevel(ctx context.Context, roomID id.RoomID) (int, error) {
+	pl, err := s.getPowerLevels(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+	return pl.GetEventLevel(droomcfg.StateEventType), nil
+}
+
+func (s *Service) getPowerLevels(ctx context.Context, roomID id.RoomID) (*event.PowerLevelsEventContent, error) {
+	s.mu.RLock()
+	pl, ok := s.powerLevels[roomID]
+	s.mu.RUnlock()
+
+	if ok {
+		return pl, nil
+	}
+
+	pl = &event.PowerLevelsEventContent{}
+	if err := s.client.StateEvent(ctx, roomID, event.StatePowerLevels, "", pl); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.powerLevels[roomID] = pl
+	s.mu.Unlock()
+
+	return pl, nil
+}
+
+// fetchConfig - loads settings from the room state. The result (including "no settings") is cached
+func (s *Service) fetchConfig(ctx context.Context, roomID id.RoomID) *droomcfg.Config {
+	var result *droomcfg.Config
+
+	evt, err := s.client.FullStateEvent(ctx, roomID, droomcfg.StateEventType, "")
+	switch {
+	case errors.Is(err, mautrix.MNotFound):
+	case err != nil:
+		// don't cache transient errors
+		slog.Error("roomconfig: failed to load settings", "room", roomID, "err", err)
+		return nil
+	default:
+		result, err = s.accept(ctx, roomID, evt)
+		if err != nil {
+			slog.Error("roomconfig: failed to accept settings", "room", roomID, "err", err)
+			return nil
+		}
+	}
+
+	s.mu.Lock()
+	s.configs[roomID] = result
+	s.mu.Unlock()
+
+	return result
+}
@@ -0,0 +1,160 @@
+package roomconfig
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	droomcfg "github.com/tensved/bobrix/mxbot/domain/roomconfig"
+)
+
+const (
+	testRoom = id.RoomID("!room:example.org")
+	botUser  = id.UserID("@bot:example.org")
+	admin    = id.UserID("@admin:example.org")
+	member   = id.UserID("@member:example.org")
+)
+
+// newTestService - the service with the power levels of the test room (io.bobrix.config requires 50).
+// The room state of the homeserver is the given io.bobrix.config event (nil - the room has no settings)
+func newTestService(t *testing.T, state *event.Event) *Service {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state == nil {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"Event not found"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(state)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := mautrix.NewClient(server.URL, botUser, "token")
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	return &Service{
+		client:  client,
+		configs: make(map[id.RoomID]*droomcfg.Config),
+		powerLevels: map[id.RoomID]*event.PowerLevelsEventContent{testRoom: {
+			Users:  map[id.UserID]int{admin: 100},
+			Events: map[string]int{droomcfg.StateEventType.Type: 50},
+		}},
+	}
+}
+
+func stateEvent(evtType event.Type, stateKey string, sender id.UserID, content any) *event.Event {
+	raw, _ := json.Marshal(content)
+	return &event.Event{
+		Type:     evtType,
+		StateKey: &stateKey,
+		Sender:   sender,
+		RoomID:   testRoom,
+		Content:  event.Content{VeryRaw: raw},
+	}
+}
+
+func settings(sender id.UserID, language string) *event.Event {
+	return stateEvent(droomcfg.StateEventType, "", sender, droomcfg.Config{Language: language})
+}
+
+func TestObserveRoomState(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []*event.Event
+		want   string // language of the cached settings. "" - no settings
+		cached bool   // the room is in the cache
+	}{
+		{
+			name:   "settings from an admin",
+			events: []*event.Event{settings(admin, "en")},
+			want:   "en",
+			cached: true,
+		},
+		{
+			name:   "settings from a member are ignored",
+			events: []*event.Event{settings(member, "en")},
+			cached: true,
+		},
+		{
+			name:   "rejected settings evict the previous ones",
+			events: []*event.Event{settings(admin, "en"), settings(member, "de")},
+			cached: true,
+		},
+		{
+			name: "the bot leaves the room",
+			events: []*event.Event{
+				settings(admin, "en"),
+				stateEvent(event.StateMember, botUser.String(), admin, event.MemberEventContent{Membership: event.MembershipLeave}),
+			},
+		},
+		{
+			name: "another member leaves the room",
+			events: []*event.Event{
+				settings(admin, "en"),
+				stateEvent(event.StateMember, member.String(), member, event.MemberEventContent{Membership: event.MembershipLeave}),
+			},
+			want:   "en",
+			cached: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, nil)
+
+			for _, evt := range tt.events {
+				s.ObserveRoomState(context.Background(), testRoom, evt)
+			}
+
+			s.mu.RLock()
+			cfg, cached := s.configs[testRoom]
+			s.mu.RUnlock()
+
+			if cached != tt.cached {
+				t.Fatalf("cached = %v, want %v", cached, tt.cached)
+			}
+			if got := language(cfg); got != tt.want {
+				t.Errorf("language = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoomConfigFromState(t *testing.T) {
+	tests := []struct {
+		name  string
+		state *event.Event
+		want  string // "" - no settings
+	}{
+		{name: "no settings"},
+		{name: "settings from an admin", state: settings(admin, "en"), want: "en"},
+		{name: "settings from a member are ignored", state: settings(member, "en")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, tt.state)
+
+			cfg, ok := s.RoomConfig(context.Background(), testRoom)
+			if ok != (tt.want != "") || language(cfg) != tt.want {
+				t.Errorf("RoomConfig = %q (%v), want %q", language(cfg), ok, tt.want)
+			}
+		})
+	}
+}
+
+func language(cfg *droomcfg.Config) string {
+	if cfg == nil {
+		return ""
+	}
+	return cfg.Language
+}
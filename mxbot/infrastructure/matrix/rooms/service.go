@@ -2,6 +2,7 @@ package rooms
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"slices"
 	"time"
@@ -14,6 +15,7 @@ import (
 )
 
 var _ dbot.BotRoomActions = (*Service)(nil)
+var _ dbot.EventLoader = (*Service)(nil)
 
 type Service struct {
 	client *mautrix.Client
@@ -33,6 +35,19 @@ func (s *Service) JoinedMembersCount(ctx context.Context, roomID id.RoomID) (int
 	return len(resp.Joined), nil
 }
 
+// IsJoinedMember - checks if the user is joined to the room. The bot must be able to read the room state
+func (s *Service) IsJoinedMember(ctx context.Context, roomID id.RoomID, userID id.UserID) (bool, error) {
+	var member event.MemberEventContent
+	err := s.client.StateEvent(ctx, roomID, event.StateMember, userID.String(), &member)
+	if err != nil {
+		if errors.Is(err, mautrix.MNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return member.Membership == event.MembershipJoin, nil
+}
+
 func (s *Service) JoinRoom(ctx context.Context, roomID id.RoomID) error {
 	_, err := s.client.JoinRoomByID(ctx, roomID)
 	if err != nil {
@@ -98,3 +113,7 @@ func (s *Service) GetMessagesFromRoomByDuration(ctx context.Context, roomID id.R
 
 	return allMessages, nil
 }
+
+func (s *Service) GetEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.Event, error) {
+	return s.client.GetEvent(ctx, roomID, eventID)
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"maunium.net/go/mautrix/id"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+)
+
+var _ dbot.StateStore = (*FileStateStore)(nil)
+
+// FileStateStore - state of the bot in the files:
+//   - <Dir>/sync.json - sync token and filter ID (see FileSyncStore)
+//   - <Dir>/join.json - join times (see JoinStore)
+//   - <Dir>/prev_batch.json - prev_batch tokens of the rooms
+//   - <DeviceIDPath> - device ID
+type FileStateStore struct {
+	*FileSyncStore
+
+	join *JoinStore
+
+	prevBatchPath string
+	prevBatchMu   sync.Mutex
+	prevBatch     map[id.RoomID]string
+
+	deviceIDPath string
+}
+
+type FileStateStoreOptions struct {
+	Dir          string // directory of the sync, join and prev_batch files
+	DeviceIDPath string // file of the device ID. Default: <Dir>/device-id.txt
+}
+
+func NewFileStateStore(opts FileStateStoreOptions) (*FileStateStore, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("FileStateStore: Dir is required")
+	}
+	if opts.DeviceIDPath == "" {
+		opts.DeviceIDPath = filepath.Join(opts.Dir, "device-id.txt")
+	}
+
+	syncStore, err := NewFileSyncStore(filepath.Join(opts.Dir, "sync.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	joinStore, err := NewJoinStore(filepath.Join(opts.Dir, "join.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStateStore{
+		FileSyncStore: syncStore,
+		join:          joinStore,
+		prevBatch:     map[id.RoomID]string{},
+	}
+
+	if s.prevBatchPath, err = filepath.Abs(filepath.Join(opts.Dir, "prev_batch.json")); err != nil {
+		return nil, err
+	}
+	if s.deviceIDPath, err = filepath.Abs(opts.DeviceIDPath); err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(s.prevBatchPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &s.prevBatch); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", s.prevBatchPath, err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *FileStateStore) JoinTS(_ context.Context, roomID id.RoomID) (int64, bool, error) {
+	v, ok := s.join.Get(roomID)
+	return v, ok, nil
+}
+
+func (s *FileStateStore) SetJoinTSIfLater(_ context.Context, roomID id.RoomID, tsMillis int64) error {
+	return s.join.SetIfLater(roomID, tsMillis)
+}
+
+func (s *FileStateStore) PrevBatch(_ context.Context, roomID id.RoomID) (string, bool, error) {
+	s.prevBatchMu.Lock()
+	defer s.prevBatchMu.Unlock()
+	v, ok := s.prevBatch[roomID]
+	return v, ok, nil
+}
+
+func (s *FileStateStore) SetPrevBatch(_ context.Context, roomID id.RoomID, token string) error {
+	if token == "" {
+		return nil
+	}
+
+	s.prevBatchMu.Lock()
+	defer s.prevBatchMu.Unlock()
+
+	if s.prevBatch[roomID] == token {
+		return nil // the file isn't rewritten on every sync without changes
+	}
+	s.prevBatch[roomID] = token
+
+	b, err := json.MarshalIndent(s.prevBatch, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.prevBatchPath, b)
+}
+
+func (s *FileStateStore) LoadDeviceID(_ context.Context) (id.DeviceID, error) {
+	data, err := os.ReadFile(s.deviceIDPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read device ID file: %w", err)
+	}
+	return id.DeviceID(strings.TrimSpace(string(data))), nil
+}
+
+func (s *FileStateStore) SaveDeviceID(_ context.Context, deviceID id.DeviceID) error {
+	if err := writeFileAtomic(s.deviceIDPath, []byte(deviceID)); err != nil {
+		return fmt.Errorf("failed to save device ID: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStateStore) DeleteDeviceID(_ context.Context) error {
+	if err := os.Remove(s.deviceIDPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove %s: %w", s.deviceIDPath, err)
+	}
+	return nil
+}
+
+// writeFileAtomic - writes the file through the temporary file (the file is never left half-written)
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"sync"
+
+	"maunium.net/go/mautrix/id"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+)
+
+var _ dbot.StateStore = (*MemoryStateStore)(nil)
+
+// MemoryStateStore - state of the bot in memory. It is lost on restart:
+// the bot does the initial sync and logs in with a new device
+type MemoryStateStore struct {
+	mu        sync.Mutex
+	nextBatch string
+	filterID  string
+	deviceID  id.DeviceID
+	joinTS    map[id.RoomID]int64
+	prevBatch *PrevBatchStore
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{
+		joinTS:    map[id.RoomID]int64{},
+		prevBatch: NewPrevBatchStore(),
+	}
+}
+
+func (s *MemoryStateStore) SaveNextBatch(_ context.Context, _ id.UserID, nextBatch string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextBatch = nextBatch
+	return nil
+}
+
+func (s *MemoryStateStore) LoadNextBatch(_ context.Context, _ id.UserID) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextBatch, nil
+}
+
+func (s *MemoryStateStore) SaveFilterID(_ context.Context, _ id.UserID, filterID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filterID = filterID
+	return nil
+}
+
+func (s *MemoryStateStore) LoadFilterID(_ context.Context, _ id.UserID) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filterID, nil
+}
+
+func (s *MemoryStateStore) LoadDeviceID(_ context.Context) (id.DeviceID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deviceID, nil
+}
+
+func (s *MemoryStateStore) SaveDeviceID(_ context.Context, deviceID id.DeviceID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deviceID = deviceID
+	return nil
+}
+
+func (s *MemoryStateStore) DeleteDeviceID(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deviceID = ""
+	return nil
+}
+
+func (s *MemoryStateStore) JoinTS(_ context.Context, roomID id.RoomID) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.joinTS[roomID]
+	return v, ok, nil
+}
+
+func (s *MemoryStateStore) SetJoinTSIfLater(_ context.Context, roomID id.RoomID, tsMillis int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.joinTS[roomID]; ok && old >= tsMillis {
+		return nil
+	}
+	s.joinTS[roomID] = tsMillis
+	return nil
+}
+
+func (s *MemoryStateStore) PrevBatch(_ context.Context, roomID id.RoomID) (string, bool, error) {
+	v, ok := s.prevBatch.Get(roomID)
+	return v, ok, nil
+}
+
+func (s *MemoryStateStore) SetPrevBatch(_ context.Context, roomID id.RoomID, token string) error {
+	s.prevBatch.Set(roomID, token)
+	return nil
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"maunium.net/go/mautrix/id"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg"
+)
+
+var _ dbot.StateStore = (*PostgresStateStore)(nil)
+
+// PostgresStateStore - state of the bot in Postgres. Pods of the bot don't need a persistent volume:
+// a rescheduled pod resumes sync from the saved token with the same device.
+// It uses the tables:
+//   - matrix_bot_state(user_id text primary key, next_batch text, filter_id text, device_id text, updated_at timestamptz)
+//   - matrix_room_state(user_id text, room_id text, join_ts bigint, prev_batch text, updated_at timestamptz)
+//     with the primary key (user_id, room_id)
+type PostgresStateStore struct {
+	provider pg.ExecutorProvider
+	userID   string
+
+	// last saved prev_batch tokens: the token is written only when it changes
+	prevBatchMu sync.Mutex
+	prevBatch   map[id.RoomID]string
+}
+
+func NewPostgresStateStore(provider pg.ExecutorProvider, userID string) (*PostgresStateStore, error) {
+	if userID == "" {
+		return nil, errors.New("PostgresStateStore: userID is required")
+	}
+
+	return &PostgresStateStore{
+		provider:  provider,
+		userID:    userID,
+		prevBatch: map[id.RoomID]string{},
+	}, nil
+}
+
+// saveBotField - upserts one column of matrix_bot_state (the column name is a constant of the caller)
+func (s *PostgresStateStore) saveBotField(ctx context.Context, column, value string) error {
+	q := fmt.Sprintf(`
+		INSERT INTO matrix_bot_state(user_id, %[1]s, updated_at)
+		VALUES ($1, $2, now())
+		ON CONFLICT (user_id) DO UPDATE
+		SET %[1]s = EXCLUDED.%[1]s,
+			updated_at = now()
+		`, column)
+
+	if _, err := s.provider.Get(ctx).Exec(ctx, q, s.userID, value); err != nil {
+		return fmt.Errorf("failed to save %s: %w", column, err)
+	}
+	return nil
+}
+
+func (s *PostgresStateStore) loadBotField(ctx context.Context, column string) (string, error) {
+	var value *string
+	err := s.provider.Get(ctx).QueryRow(ctx,
+		fmt.Sprintf(`SELECT %s FROM matrix_bot_state WHERE user_id=$1`, column),
+		s.userID,
+	).Scan(&value)
+
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && value == nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load %s: %w", column, err)
+	}
+	return *value, nil
+}
+
+func (s *PostgresStateStore) SaveNextBatch(ctx context.Context, _ id.UserID, nextBatch string) error {
+	return s.saveBotField(ctx, "next_batch", nextBatch)
+}
+
+func (s *PostgresStateStore) LoadNextBatch(ctx context.Context, _ id.UserID) (string, error) {
+	return s.loadBotField(ctx, "next_batch")
+}
+
+func (s *PostgresStateStore) SaveFilterID(ctx context.Context, _ id.UserID, filterID string) error {
+	return s.saveBotField(ctx, "filter_id", filterID)
+}
+
+func (s *PostgresStateStore) LoadFilterID(ctx context.Context, _ id.UserID) (string, error) {
+	return s.loadBotField(ctx, "filter_id")
+}
+
+func (s *PostgresStateStore) LoadDeviceID(ctx context.Context) (id.DeviceID, error) {
+	deviceID, err := s.loadBotField(ctx, "device_id")
+	return id.DeviceID(deviceID), err
+}
+
+func (s *PostgresStateStore) SaveDeviceID(ctx context.Context, deviceID id.DeviceID) error {
+	return s.saveBotField(ctx, "device_id", deviceID.String())
+}
+
+func (s *PostgresStateStore) DeleteDeviceID(ctx context.Context) error {
+	_, err := s.provider.Get(ctx).Exec(ctx,
+		`UPDATE matrix_bot_state SET device_id = NULL, updated_at = now() WHERE user_id=$1`,
+		s.userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete device_id: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStateStore) JoinTS(ctx context.Context, roomID id.RoomID) (int64, bool, error) {
+	var ts *int64
+	err := s.provider.Get(ctx).QueryRow(ctx,
+		`SELECT join_ts FROM matrix_room_state WHERE user_id=$1 AND room_id=$2`,
+		s.userID, roomID.String(),
+	).Scan(&ts)
+
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && ts == nil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to load join_ts: %w", err)
+	}
+	return *ts, true, nil
+}
+
+func (s *PostgresStateStore) SetJoinTSIfLater(ctx context.Context, roomID id.RoomID, tsMillis int64) error {
+	q := `
+		INSERT INTO matrix_room_state(user_id, room_id, join_ts, updated_at)
+		VALUES ($1, $2, $3, now())
+		ON CONFLICT (user_id, room_id) DO UPDATE
+		SET join_ts = EXCLUDED.join_ts,
+			updated_at = now()
+		WHERE matrix_room_state.join_ts IS NULL OR matrix_room_state.join_ts < EXCLUDED.join_ts
+		`
+
+	if _, err := s.provider.Get(ctx).Exec(ctx, q, s.userID, roomID.String(), tsMillis); err != nil {
+		return fmt.Errorf("failed to save join_ts: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStateStore) PrevBatch(ctx context.Context, roomID id.RoomID) (string, bool, error) {
+	var token *string
+	err := s.provider.Get(ctx).QueryRow(ctx,
+		`SELECT prev_batch FROM matrix_room_state WHERE user_id=$1 AND room_id=$2`,
+		s.userID, roomID.String(),
+	).Scan(&token)
+
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && (token == nil || *token == "")) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to load prev_batch: %w", err)
+	}
+	return *token, true, nil
+}
+
+func (s *PostgresStateStore) SetPrevBatch(ctx context.Context, roomID id.RoomID, token string) error {
+	if token == "" {
+		return nil
+	}
+
+	s.prevBatchMu.Lock()
+	defer s.prevBatchMu.Unlock()
+
+	if s.prevBatch[roomID] == token {
+		return nil
+	}
+
+	q := `
+		INSERT INTO matrix_room_state(user_id, room_id, prev_batch, updated_at)
+		VALUES ($1, $2, $3, now())
+		ON CONFLICT (user_id, room_id) DO UPDATE
+		SET prev_batch = EXCLUDED.prev_batch,
+			updated_at = now()
+		`
+
+	if _, err := s.provider.Get(ctx).Exec(ctx, q, s.userID, roomID.String(), token); err != nil {
+		return fmt.Errorf("failed to save prev_batch: %w", err)
+	}
+
+	s.prevBatch[roomID] = token
+	return nil
+}
@@ -8,6 +8,8 @@ import (
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
 )
 
 func (s *Service) backfillRoom(ctx context.Context, roomID id.RoomID) error {
@@ -17,8 +19,16 @@ func (s *Service) backfillRoom(ctx context.Context, roomID id.RoomID) error {
 		return nil
 	}
 
+	return s.doBackfillRoom(ctx, roomID)
+}
+
+// doBackfillRoom - backfill without the "enabled" check (used by BackfillRoom to force it)
+func (s *Service) doBackfillRoom(ctx context.Context, roomID id.RoomID) error {
 	// 1) pagination start token
-	from, ok := s.prevBatch.Get(roomID)
+	from, ok, err := s.state.PrevBatch(ctx, roomID)
+	if err != nil {
+		return err
+	}
 	if !ok || from == "" {
 		// prev_batch hasn't synced yet — backfill isn't possible yet
 		return nil
@@ -28,10 +38,12 @@ func (s *Service) backfillRoom(ctx context.Context, roomID id.RoomID) error {
 	start := s.patchStart
 
 	var joinTime time.Time
-	if s.joinStore != nil {
-		if joinTS, ok := s.joinStore.Get(roomID); ok && joinTS > 0 {
-			joinTime = time.UnixMilli(joinTS)
-		}
+	joinTS, ok, err := s.state.JoinTS(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if ok && joinTS > 0 {
+		joinTime = time.UnixMilli(joinTS)
 	}
 
 	if start.IsZero() {
@@ -61,13 +73,17 @@ func (s *Service) backfillRoom(ctx context.Context, roomID id.RoomID) error {
 		for i := len(resp.Chunk) - 1; i >= 0; i-- {
 			evt := resp.Chunk[i]
 
-			if evt.Type != event.EventMessage && evt.Type != event.EventEncrypted {
+			if evt.Type != event.EventMessage && evt.Type != event.EventEncrypted && !s.isSubscribed(evt.Type) {
 				continue
 			}
 
 			if evt.Timestamp > 0 && time.UnixMilli(evt.Timestamp).Before(start) {
 				return nil
 			}
+			// older events may be processed already (their dedup records are pruned)
+			if s.beyondRetention(evt) {
+				return nil
+			}
 
 			if s.deduper != nil && evt.ID != "" {
 				processed, err := s.deduper.IsProcessed(ctx, evt.ID.String())
@@ -80,10 +96,13 @@ func (s *Service) backfillRoom(ctx context.Context, roomID id.RoomID) error {
 			}
 
 			if err := s.eventRouter.HandleMatrixEvent(ctx, evt); err != nil {
-				// don't mark processed so we can repeat
 				slog.Error("backfill: HandleMatrixEvent failed",
 					"err", err, "type", evt.Type.String(), "room", evt.RoomID, "id", evt.ID)
-				continue
+				s.recordFailure(evt, err)
+				// the transient failure (e.g. no keys yet) isn't marked processed so we can repeat
+				if dbot.IsRetryable(err) {
+					continue
+				}
 			}
 
 			if s.deduper != nil && evt.ID != "" {
@@ -0,0 +1,178 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+)
+
+var _ dbot.BotQueueControl = (*Service)(nil)
+
+const queueStatsTimeout = 2 * time.Second
+
+var (
+	ErrNoFailedEvent = dbot.ErrNoFailedEvent
+	ErrQueueFull     = dbot.ErrQueueFull
+	ErrNoDeduper     = errors.New("deduper is not configured")
+)
+
+// failedEvent - last failed event with the original payload (for RetryLastFailed)
+type failedEvent struct {
+	evt  *event.Event
+	info dbot.FailedEvent
+}
+
+func (s *Service) QueueStats() dbot.QueueStats {
+	ctx, cancel := context.WithTimeout(context.Background(), queueStatsTimeout)
+	qs, err := s.queue.Stats(ctx)
+	cancel()
+	if err != nil {
+		slog.Warn("sync: failed to get queue stats", "err", err)
+	}
+
+	s.opsMu.Lock()
+	defer s.opsMu.Unlock()
+
+	stats := dbot.QueueStats{
+		Queued:   qs.Queued,
+		Capacity: qs.Capacity,
+		Workers:  s.numWorkers,
+		Inflight: make([]dbot.InflightEvent, 0, len(s.inflight)),
+
+		Retrying:    qs.Retrying,
+		MaxAttempts: s.maxAttempts,
+		DeadLetters: qs.DeadLetters,
+		Dropped:     s.dropped,
+		Rooms:       qs.Keys,
+	}
+
+	for _, e := range s.inflight {
+		stats.Inflight = append(stats.Inflight, e)
+	}
+	sort.Slice(stats.Inflight, func(i, j int) bool {
+		return stats.Inflight[i].StartedAt.Before(stats.Inflight[j].StartedAt)
+	})
+
+	if s.lastFailed != nil {
+		info := s.lastFailed.info
+		stats.LastFailed = &info
+	}
+
+	if s.retention != nil {
+		retention := s.retentionStats
+		retention.MaxAge = s.retention.maxAge
+		stats.DedupRetention = &retention
+	}
+
+	return stats
+}
+
+func (s *Service) RetryLastFailed(ctx context.Context) (id.EventID, error) {
+	s.opsMu.Lock()
+	failed := s.lastFailed
+	s.opsMu.Unlock()
+
+	if failed == nil {
+		return "", ErrNoFailedEvent
+	}
+
+	evt := failed.evt
+
+	if s.deduper != nil && evt.ID != "" {
+		ok, err := s.deduper.TryStartProcessing(ctx, evt.ID.String(), s.inflightTTL)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("event %s is already processed or in flight", evt.ID)
+		}
+	}
+
+	if err := s.queue.Enqueue(ctx, evt); err != nil {
+		if s.deduper != nil && evt.ID != "" {
+			_ = s.deduper.UnmarkInflight(ctx, evt.ID.String())
+		}
+		return "", err
+	}
+
+	s.opsMu.Lock()
+	if s.lastFailed == failed {
+		s.lastFailed = nil
+	}
+	s.opsMu.Unlock()
+
+	return evt.ID, nil
+}
+
+func (s *Service) BackfillRoom(ctx context.Context, roomID id.RoomID) error {
+	if s.deduper == nil {
+		return ErrNoDeduper
+	}
+	return s.doBackfillRoom(ctx, roomID)
+}
+
+func (s *Service) DedupStatus(ctx context.Context, eventID id.EventID) (dbot.DedupStatus, error) {
+	if s.deduper == nil {
+		return dbot.DedupStatusUnknown, ErrNoDeduper
+	}
+	return s.deduper.Status(ctx, eventID.String())
+}
+
+func (s *Service) DeadLetters(ctx context.Context, limit int) ([]dbot.DeadLetter, error) {
+	return s.queue.DeadLetters(ctx, limit)
+}
+
+// ReplayDeadLetter - returns the dead letter to the queue.
+// The dead letter is marked as processed (see retryLater), so neither the sync nor a backfill handles it meanwhile
+func (s *Service) ReplayDeadLetter(ctx context.Context, id string) error {
+	return s.queue.Replay(ctx, id)
+}
+
+func (s *Service) DropDeadLetter(ctx context.Context, id string) error {
+	return s.queue.DropDeadLetter(ctx, id)
+}
+
+func (s *Service) trackStart(worker int, evt *event.Event) {
+	s.opsMu.Lock()
+	s.inflight[evt.ID] = dbot.InflightEvent{
+		EventID:   evt.ID,
+		RoomID:    evt.RoomID,
+		Worker:    worker,
+		StartedAt: time.Now(),
+	}
+	s.opsMu.Unlock()
+}
+
+func (s *Service) trackDone(evt *event.Event) {
+	s.opsMu.Lock()
+	delete(s.inflight, evt.ID)
+	s.opsMu.Unlock()
+}
+
+func (s *Service) recordDrop() {
+	s.opsMu.Lock()
+	s.dropped++
+	s.opsMu.Unlock()
+}
+
+func (s *Service) recordFailure(evt *event.Event, err error) {
+	s.opsMu.Lock()
+	s.lastFailed = &failedEvent{
+		evt: evt,
+		info: dbot.FailedEvent{
+			EventID:  evt.ID,
+			RoomID:   evt.RoomID,
+			Error:    err.Error(),
+			FailedAt: time.Now(),
+		},
+	}
+	s.opsMu.Unlock()
+}
@@ -1,14 +1,23 @@
 package sync
 
 import (
+	"context"
 	"time"
 
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
 	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
-	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/store"
 )
 
 type Option func(*Service)
 
+// StateObserver receives room state events from /sync (e.g. room settings, power levels).
+// The state of the left rooms is passed too, with the leave of the bot
+type StateObserver interface {
+	ObserveRoomState(ctx context.Context, roomID id.RoomID, evt *event.Event)
+}
+
 func WithAuth(a dbot.BotAuth) Option {
 	return func(s *Service) {
 		s.auth = a
@@ -37,9 +46,12 @@ func WithBackfill(enabled bool, backfillLimitPerReq int) Option {
 	}
 }
 
-func WithJoinStore(js *store.JoinStore) Option {
+// WithStateStore - store of the join times and prev_batch tokens. Default: in memory
+func WithStateStore(st dbot.StateStore) Option {
 	return func(s *Service) {
-		s.joinStore = js
+		if st != nil {
+			s.state = st
+		}
 	}
 }
 
@@ -56,3 +68,26 @@ func WithWorkers(w int) Option {
 		s.numWorkers = w
 	}
 }
+
+func WithStateObserver(o StateObserver) Option {
+	return func(s *Service) {
+		if o != nil {
+			s.stateObservers = append(s.stateObservers, o)
+		}
+	}
+}
+
+// WithEventSubscriptions - forwards the event types the registered handlers ask for
+// (in addition to messages and encrypted events that are always forwarded)
+func WithEventSubscriptions(subs dbot.EventSubscriptions) Option {
+	return func(s *Service) {
+		s.subscriptions = subs
+	}
+}
+
+// WithEventTypes - always forwards the given event types (e.g. m.reaction, m.room.redaction)
+func WithEventTypes(types ...event.Type) Option {
+	return func(s *Service) {
+		s.eventTypes = append(s.eventTypes, types...)
+	}
+}
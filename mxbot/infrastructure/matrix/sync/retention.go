@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+)
+
+const (
+	defaultRetentionInterval = time.Hour
+	defaultRetentionBatch    = 5000
+)
+
+// dedupRetention - pruning of the old processed events (see WithDedupRetention)
+type dedupRetention struct {
+	maxAge    time.Duration
+	interval  time.Duration
+	batchSize int
+}
+
+// WithDedupRetention - the processed events older than maxAge are deleted from the deduper
+// every interval in batches of batchSize (the deduper must implement bot.DedupPruner).
+// Events older than maxAge can't be deduplicated anymore, so sync and backfill skip them:
+// maxAge must be longer than the backfill window and the downtime of the bot
+func WithDedupRetention(maxAge, interval time.Duration, batchSize int) Option {
+	return func(s *Service) {
+		if maxAge <= 0 {
+			return
+		}
+		if interval <= 0 {
+			interval = defaultRetentionInterval
+		}
+		if batchSize <= 0 {
+			batchSize = defaultRetentionBatch
+		}
+		s.retention = &dedupRetention{maxAge: maxAge, interval: interval, batchSize: batchSize}
+	}
+}
+
+// beyondRetention - the event is older than the retention horizon: its dedup record may be pruned already
+func (s *Service) beyondRetention(evt *event.Event) bool {
+	if s.retention == nil || evt.Timestamp <= 0 {
+		return false
+	}
+	return time.Since(time.UnixMilli(evt.Timestamp)) > s.retention.maxAge
+}
+
+// runDedupRetention - prunes the old processed events until the context is cancelled
+func (s *Service) runDedupRetention(ctx context.Context) {
+	pruner, ok := s.deduper.(dbot.DedupPruner)
+	if !ok {
+		slog.Warn("dedup retention: the deduper can't prune records", "deduper", s.deduper)
+		return
+	}
+
+	t := time.NewTicker(s.retention.interval)
+	defer t.Stop()
+
+	for {
+		s.pruneDedup(ctx, pruner)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// pruneDedup - one retention run: deletes the batches until the old records are over
+func (s *Service) pruneDedup(ctx context.Context, pruner dbot.DedupPruner) {
+	before := time.Now().Add(-s.retention.maxAge)
+
+	var (
+		total int64
+		err   error
+	)
+	for ctx.Err() == nil {
+		var n int64
+		n, err = pruner.PruneProcessed(ctx, before, s.retention.batchSize)
+		total += n
+		if err != nil || n < int64(s.retention.batchSize) {
+			break
+		}
+	}
+
+	s.opsMu.Lock()
+	st := &s.retentionStats
+	st.Runs++
+	st.Pruned += total
+	st.LastRunAt = time.Now()
+	st.LastPruned = total
+	st.LastError = ""
+	if err != nil {
+		st.LastError = err.Error()
+	}
+	s.opsMu.Unlock()
+
+	if err != nil {
+		slog.Error("dedup retention: prune failed", "pruned", total, "error", err)
+		return
+	}
+	if total > 0 {
+		slog.Info("dedup retention: pruned processed events", "pruned", total, "before", before)
+	}
+}
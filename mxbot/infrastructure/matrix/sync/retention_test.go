@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// fakePruner - deduper with old processed records. failAt - the call that fails (0 - none)
+type fakePruner struct {
+	old    int
+	failAt int
+	calls  int
+	before time.Time
+}
+
+func (p *fakePruner) PruneProcessed(_ context.Context, before time.Time, limit int) (int64, error) {
+	p.calls++
+	p.before = before
+	if p.calls == p.failAt {
+		return 0, errors.New("database is unavailable")
+	}
+	n := min(p.old, limit)
+	p.old -= n
+	return int64(n), nil
+}
+
+func TestPruneDedup(t *testing.T) {
+	tests := []struct {
+		name      string
+		pruner    *fakePruner
+		wantCalls int
+		wantTotal int64
+		wantErr   bool
+	}{
+		{name: "nothing to prune", pruner: &fakePruner{}, wantCalls: 1},
+		{name: "batches until the old records are over", pruner: &fakePruner{old: 5}, wantCalls: 3, wantTotal: 5},
+		{name: "the last batch is empty", pruner: &fakePruner{old: 4}, wantCalls: 3, wantTotal: 4},
+		{name: "failed batch", pruner: &fakePruner{old: 5, failAt: 2}, wantCalls: 2, wantTotal: 2, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			WithDedupRetention(time.Hour, time.Minute, 2)(s)
+
+			s.pruneDedup(context.Background(), tt.pruner)
+
+			if tt.pruner.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", tt.pruner.calls, tt.wantCalls)
+			}
+			if age := time.Since(tt.pruner.before); age < time.Hour || age > time.Hour+time.Minute {
+				t.Errorf("pruned the records older than %s, want 1h", age)
+			}
+
+			st := s.retentionStats
+			if st.Runs != 1 || st.Pruned != tt.wantTotal || st.LastPruned != tt.wantTotal || (st.LastError != "") != tt.wantErr {
+				t.Errorf("stats = %+v, want %d pruned (error %v)", st, tt.wantTotal, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBeyondRetention(t *testing.T) {
+	at := func(age time.Duration) *event.Event {
+		return &event.Event{ID: "$evt", Timestamp: time.Now().Add(-age).UnixMilli()}
+	}
+
+	tests := []struct {
+		name   string
+		maxAge time.Duration // 0 - the retention is disabled
+		evt    *event.Event
+		want   bool
+	}{
+		{name: "retention disabled", evt: at(48 * time.Hour)},
+		{name: "recent event", maxAge: 24 * time.Hour, evt: at(time.Hour)},
+		{name: "event older than the retention", maxAge: 24 * time.Hour, evt: at(48 * time.Hour), want: true},
+		{name: "event without a timestamp", maxAge: 24 * time.Hour, evt: &event.Event{ID: "$evt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			WithDedupRetention(tt.maxAge, 0, 0)(s)
+
+			if got := s.beyondRetention(tt.evt); got != tt.want {
+				t.Errorf("beyondRetention = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithDedupRetentionDefaults(t *testing.T) {
+	s := &Service{}
+	WithDedupRetention(24*time.Hour, 0, 0)(s)
+
+	if s.retention == nil || s.retention.interval != defaultRetentionInterval || s.retention.batchSize != defaultRetentionBatch {
+		t.Errorf("retention = %+v, want the defaults", s.retention)
+	}
+}
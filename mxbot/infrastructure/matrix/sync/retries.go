@@ -0,0 +1,119 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultRetryBase   = time.Second
+	defaultRetryMax    = 5 * time.Minute
+)
+
+// WithQueue - queue of the events waiting for the workers.
+// The queue defines the order of the events (see queue.Ordering).
+// Default: in memory, bounded by workChCap, the events of the room are handled one by one
+// (see queue.PostgresQueue for the durable one)
+func WithQueue(q dbot.WorkQueue) Option {
+	return func(s *Service) {
+		if q != nil {
+			s.queue = q
+		}
+	}
+}
+
+// WithRetryPolicy - the event with a transient failure (see bot.Retryable) is retried up to maxAttempts times in total
+// with the exponential backoff (base, 2*base, 4*base... up to maxDelay), then it's moved to the dead letters.
+// Defaults: 5 attempts, 1s, 5m
+func WithRetryPolicy(maxAttempts int, base, maxDelay time.Duration) Option {
+	return func(s *Service) {
+		if maxAttempts > 0 {
+			s.maxAttempts = maxAttempts
+		}
+		if base > 0 {
+			s.retryBase = base
+		}
+		if maxDelay > 0 {
+			s.retryMax = maxDelay
+		}
+	}
+}
+
+// retryLater - returns the event with a transient failure (see bot.Retryable) to the queue with the backoff,
+// or moves it to the dead letters after the last attempt.
+// The dead letter is marked as processed: only ReplayDeadLetter brings it back, not a backfill.
+// The undecryptable event isn't buried: it's left for the backfill, when the keys may have arrived
+func (s *Service) retryLater(ctx context.Context, item *dbot.QueuedEvent, cause error) {
+	evt := item.Event
+	attempt := item.Attempts + 1
+
+	if attempt >= s.maxAttempts && errors.Is(cause, dbot.ErrUndecryptable) {
+		slog.Warn("worker: event is still undecryptable, leaving it for the backfill",
+			"attempts", attempt, "err", cause, "id", evt.ID, "room", evt.RoomID)
+		s.unmarkInflight(ctx, evt)
+		if err := s.queue.Ack(ctx, item); err != nil {
+			slog.Error("worker: ack failed", "err", err, "id", evt.ID)
+		}
+		return
+	}
+
+	if attempt >= s.maxAttempts {
+		slog.Error("worker: event failed all attempts, moving to dead letters",
+			"attempts", attempt, "err", cause, "id", evt.ID, "room", evt.RoomID)
+		if err := s.queue.Bury(ctx, item, cause); err != nil {
+			slog.Error("worker: failed to bury the event", "err", err, "id", evt.ID, "room", evt.RoomID)
+			s.unmarkInflight(ctx, evt)
+			return
+		}
+		if s.deduper != nil && evt.ID != "" {
+			if err := s.deduper.MarkProcessed(ctx, evt.ID.String()); err != nil {
+				slog.Error("dedup: MarkProcessed failed", "err", err, "id", evt.ID)
+			}
+		}
+		return
+	}
+
+	delay := s.backoff(attempt)
+	if err := s.queue.Retry(ctx, item, time.Now().Add(delay), cause); err != nil {
+		slog.Error("worker: failed to schedule the retry", "err", err, "id", evt.ID, "room", evt.RoomID)
+		s.unmarkInflight(ctx, evt)
+	}
+}
+
+// giveUp - the event has failed in the handlers: it isn't retried (the handlers may have answered
+// or called the service already) and it's marked as processed, so a backfill doesn't run it again
+func (s *Service) giveUp(ctx context.Context, item *dbot.QueuedEvent, cause error) {
+	evt := item.Event
+
+	if s.deduper != nil && evt.ID != "" {
+		if err := s.deduper.MarkProcessed(ctx, evt.ID.String()); err != nil {
+			slog.Error("dedup: MarkProcessed failed", "err", err, "id", evt.ID)
+		}
+	}
+	if err := s.queue.Ack(ctx, item); err != nil {
+		slog.Error("worker: ack failed", "err", err, "id", evt.ID, "cause", cause)
+	}
+}
+
+// unmarkInflight - the event isn't retried by the queue: a backfill may pick it up
+func (s *Service) unmarkInflight(ctx context.Context, evt *event.Event) {
+	if s.deduper != nil && evt.ID != "" {
+		_ = s.deduper.UnmarkInflight(ctx, evt.ID.String())
+	}
+}
+
+// backoff - delay before the next attempt (attempt is the number of the failed attempts)
+func (s *Service) backoff(attempt int) time.Duration {
+	d := s.retryBase
+	for i := 1; i < attempt && d < s.retryMax; i++ {
+		d *= 2
+	}
+	return min(d, s.retryMax)
+}
@@ -0,0 +1,182 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/queue"
+)
+
+func TestBackoff(t *testing.T) {
+	s := &Service{retryBase: time.Second, retryMax: 10 * time.Second}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := s.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+// routerFunc - event router of the tests
+type routerFunc func(ctx context.Context, evt *event.Event) error
+
+func (f routerFunc) HandleMatrixEvent(ctx context.Context, evt *event.Event) error {
+	return f(ctx, evt)
+}
+
+// dedupRecorder - deduper of the tests that records the final state of the events
+type dedupRecorder struct {
+	mu    sync.Mutex
+	state map[string]string // processed or unmarked
+}
+
+func (d *dedupRecorder) set(eventID, state string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state[eventID] = state
+	return nil
+}
+
+func (d *dedupRecorder) get(eventID string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state[eventID]
+}
+
+func (d *dedupRecorder) TryStartProcessing(context.Context, string, time.Duration) (bool, error) {
+	return true, nil
+}
+func (d *dedupRecorder) MarkProcessed(_ context.Context, eventID string) error {
+	return d.set(eventID, "processed")
+}
+func (d *dedupRecorder) UnmarkInflight(_ context.Context, eventID string) error {
+	return d.set(eventID, "unmarked")
+}
+func (d *dedupRecorder) IsProcessed(_ context.Context, eventID string) (bool, error) {
+	return d.get(eventID) == "processed", nil
+}
+func (d *dedupRecorder) Status(context.Context, string) (dbot.DedupStatus, error) {
+	return dbot.DedupStatusUnknown, nil
+}
+
+func TestWorkerFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		fail     func() error
+		attempts int    // handler calls of the failing event
+		buried   bool   // moved to the dead letters
+		dedup    string // final dedup state of the failing event
+	}{
+		{
+			name:     "handler error isn't retried",
+			fail:     func() error { return errors.New("boom") },
+			attempts: 1,
+			dedup:    "processed",
+		},
+		{
+			name:     "panic isn't retried",
+			fail:     func() error { panic("boom") },
+			attempts: 1,
+			dedup:    "processed",
+		},
+		{
+			name:     "transient failure is retried, then buried",
+			fail:     func() error { return dbot.Retryable(errors.New("network")) },
+			attempts: 3,
+			buried:   true,
+			dedup:    "processed",
+		},
+		{
+			name: "undecryptable event is left for the backfill",
+			fail: func() error {
+				return dbot.Retryable(fmt.Errorf("%w: no session", dbot.ErrUndecryptable))
+			},
+			attempts: 3,
+			dedup:    "unmarked",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var calls atomic.Int32
+			handled := make(chan id.EventID, 10)
+			q := queue.NewMemoryQueue(0, 0, queue.RoomOrdering)
+			dedup := &dedupRecorder{state: map[string]string{}}
+
+			s := &Service{
+				eventRouter: routerFunc(func(_ context.Context, evt *event.Event) error {
+					if evt.ID == "$failing" {
+						calls.Add(1)
+						return tt.fail()
+					}
+					handled <- evt.ID
+					return nil
+				}),
+				queue:       q,
+				deduper:     dedup,
+				maxAttempts: 3,
+				retryBase:   time.Millisecond,
+				retryMax:    time.Millisecond,
+				inflight:    map[id.EventID]dbot.InflightEvent{},
+			}
+			go s.worker(ctx, 0)
+
+			for _, eventID := range []id.EventID{"$failing", "$next"} {
+				evt := &event.Event{ID: eventID, RoomID: "!room", Type: event.EventMessage}
+				if err := q.Enqueue(ctx, evt); err != nil {
+					t.Fatalf("enqueue: %v", err)
+				}
+			}
+
+			// the worker survives, and the room isn't blocked by the failing event
+			select {
+			case <-handled:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the next event of the room isn't handled")
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for dedup.get("$failing") == "" && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			if got := int(calls.Load()); got != tt.attempts {
+				t.Errorf("attempts = %d, want %d", got, tt.attempts)
+			}
+			if got := dedup.get("$failing"); got != tt.dedup {
+				t.Errorf("dedup state = %q, want %q", got, tt.dedup)
+			}
+			_, err := q.DeadLetter(ctx, "$failing")
+			if buried := err == nil; buried != tt.buried {
+				t.Errorf("buried = %v, want %v", buried, tt.buried)
+			}
+			if stats, _ := q.Stats(ctx); stats.Queued != 0 {
+				t.Errorf("queued = %d, want the failing event acked", stats.Queued)
+			}
+		})
+	}
+}
@@ -6,13 +6,16 @@ import (
 	"fmt"
 	"log/slog"
 	"runtime/debug"
+	"slices"
 	"sync"
 	"time"
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 
 	dbot "github.com/tensved/bobrix/mxbot/domain/bot"
+	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/queue"
 	"github.com/tensved/bobrix/mxbot/infrastructure/matrix/store"
 )
 
@@ -26,10 +29,17 @@ type Service struct {
 	deduper     dbot.EventDeduper
 	retry       time.Duration
 
-	joinStore  *store.JoinStore
-	prevBatch  *store.PrevBatchStore
+	retention      *dedupRetention // optional. Pruning of the old processed events
+	retentionStats dbot.DedupRetentionStats
+
+	state      dbot.StateStore // join times and prev_batch tokens (see WithStateStore)
 	patchStart time.Time
 
+	stateObservers []StateObserver
+
+	subscriptions dbot.EventSubscriptions // optional. Event types of the registered handlers
+	eventTypes    []event.Type            // event types that are always forwarded (see WithEventTypes)
+
 	enableBackfill      bool
 	backfillLimitPerReq int
 	backfillDone        chan struct{}
@@ -38,10 +48,21 @@ type Service struct {
 	startOnce sync.Once
 	runOnce   sync.Once
 
-	workCh      chan *event.Event
+	queue       dbot.WorkQueue // events waiting for the workers (see WithQueue)
 	numWorkers  int
 	inflightTTL time.Duration
 
+	// retries of the failed events (see WithRetryPolicy)
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+
+	// operational state (see operations.go)
+	opsMu      sync.Mutex
+	inflight   map[id.EventID]dbot.InflightEvent
+	lastFailed *failedEvent
+	dropped    int64 // events not accepted by the queue
+
 	cancel context.CancelFunc
 }
 
@@ -63,11 +84,17 @@ func New(c dbot.BotClient, eventRouter dbot.EventRouter, authRetry, inflightTTL
 
 		backfillDone: make(chan struct{}),
 
-		prevBatch: store.NewPrevBatchStore(),
+		state: store.NewMemoryStateStore(),
 
-		workCh:      make(chan *event.Event, workChCap),
+		queue:       queue.NewMemoryQueue(workChCap, 0, queue.RoomOrdering),
 		numWorkers:  numWorkers,
 		inflightTTL: inflightTTL,
+
+		maxAttempts: defaultMaxAttempts,
+		retryBase:   defaultRetryBase,
+		retryMax:    defaultRetryMax,
+
+		inflight: make(map[id.EventID]dbot.InflightEvent),
 	}
 
 	for _, o := range opts {
@@ -77,6 +104,38 @@ func New(c dbot.BotClient, eventRouter dbot.EventRouter, authRetry, inflightTTL
 	return s, nil
 }
 
+// observeRoomState - passes the state events of the joined and left rooms to the observers.
+// With state_after the timeline goes first: state_after is the state at the end of the timeline
+func (s *Service) observeRoomState(ctx context.Context, resp *mautrix.RespSync) {
+	observe := func(roomID id.RoomID, evts []*event.Event) {
+		for _, evt := range evts {
+			if evt.StateKey == nil {
+				continue
+			}
+			for _, o := range s.stateObservers {
+				o.ObserveRoomState(ctx, roomID, evt)
+			}
+		}
+	}
+
+	for roomID, roomData := range resp.Rooms.Join {
+		if roomData.StateAfter != nil {
+			observe(roomID, roomData.Timeline.Events)
+			observe(roomID, roomData.StateAfter.Events)
+			continue
+		}
+
+		observe(roomID, roomData.State.Events)
+		observe(roomID, roomData.Timeline.Events)
+	}
+
+	// the membership of the bot in the left rooms (the observers drop the state of the room)
+	for roomID, roomData := range resp.Rooms.Leave {
+		observe(roomID, roomData.State.Events)
+		observe(roomID, roomData.Timeline.Events)
+	}
+}
+
 func (s *Service) StartListening(ctx context.Context) error {
 	var startErr error
 	s.startOnce.Do(func() {
@@ -110,6 +169,16 @@ func (s *Service) startListening(ctx context.Context) error {
 		},
 	}
 
+	// Room state goes to the observers (room settings, power levels) before anything else:
+	// the syncer runs the OnSync hooks before it dispatches the events of the response,
+	// so the events are enqueued with the state of this response already applied
+	if len(s.stateObservers) > 0 {
+		ds.OnSync(func(ctxSync context.Context, resp *mautrix.RespSync, since string) bool {
+			s.observeRoomState(ctxSync, resp)
+			return true
+		})
+	}
+
 	svcCtx := ctx
 	// We need prev_batch for backfill. It is only available in /sync responses,
 	// so we capture it via OnSync.
@@ -137,29 +206,31 @@ func (s *Service) startListening(ctx context.Context) error {
 
 		// 1) Save prev_batch tokens per room for /messages backfill
 		for roomID, roomData := range resp.Rooms.Join {
-			if roomData.Timeline.PrevBatch != "" && s.prevBatch != nil {
-				s.prevBatch.Set(roomID, roomData.Timeline.PrevBatch)
+			if roomData.Timeline.PrevBatch != "" {
+				if err := s.state.SetPrevBatch(ctxSync, roomID, roomData.Timeline.PrevBatch); err != nil {
+					slog.Warn("sync: failed to save prev_batch", "room_id", roomID, "error", err)
+				}
 			}
 		}
 
 		// 2) Save join timestamp for the bot user (room membership join)
 		// We store it ourselves because StateEvent() in mautrix v0.24.0 returns only error (content only),
 		// and doesn't give us origin_server_ts.
-		if s.joinStore != nil {
-			for roomID, roomData := range resp.Rooms.Join {
-				scan := func(evts []*event.Event) {
-					for _, evt := range evts {
-						if evt.Type == event.StateMember && evt.GetStateKey() == s.client.UserID.String() {
-							if membership, _ := evt.Content.Raw["membership"].(string); membership == "join" {
-								_ = s.joinStore.SetIfLater(roomID, evt.Timestamp)
+		for roomID, roomData := range resp.Rooms.Join {
+			scan := func(evts []*event.Event) {
+				for _, evt := range evts {
+					if evt.Type == event.StateMember && evt.GetStateKey() == s.client.UserID.String() {
+						if membership, _ := evt.Content.Raw["membership"].(string); membership == "join" {
+							if err := s.state.SetJoinTSIfLater(ctxSync, roomID, evt.Timestamp); err != nil {
+								slog.Warn("sync: failed to save join time", "room_id", roomID, "error", err)
 							}
 						}
 					}
 				}
-
-				scan(roomData.State.Events)
-				scan(roomData.Timeline.Events)
 			}
+
+			scan(roomData.State.Events)
+			scan(roomData.Timeline.Events)
 		}
 
 		// 3) Start backfill once (after we have at least some prev_batch tokens)
@@ -176,10 +247,12 @@ func (s *Service) startListening(ctx context.Context) error {
 		go s.worker(ctx, i)
 	}
 
+	if s.retention != nil && s.deduper != nil {
+		go s.runDedupRetention(ctx)
+	}
+
 	ds.OnEvent(func(ctxEvt context.Context, evt *event.Event) {
-		if evt.Type != event.EventMessage &&
-			evt.Type != event.EventEncrypted &&
-			evt.Type != event.StateEncryption {
+		if !s.accepts(evt) {
 			return
 		}
 
@@ -189,6 +262,11 @@ func (s *Service) startListening(ctx context.Context) error {
 			return
 		}
 
+		// the dedup record of the event may be pruned: it may have been processed already
+		if s.beyondRetention(evt) {
+			return
+		}
+
 		// dedup
 		if s.deduper != nil && evt.ID != "" {
 			ok, err := s.deduper.TryStartProcessing(ctx, evt.ID.String(), s.inflightTTL)
@@ -202,16 +280,16 @@ func (s *Service) startListening(ctx context.Context) error {
 		}
 
 		// enqueue (dont block sync)
-		select {
-		case s.workCh <- evt:
-			slog.Debug("sync: got msg", "room", evt.RoomID, "id", evt.ID, "ts", evt.Timestamp)
-		default:
-			// queue is full: better remove inflight so we can try again
-			slog.Error("sync: queue full, dropping", "room", evt.RoomID, "id", evt.ID)
+		if err := s.queue.Enqueue(ctx, evt); err != nil {
+			// better remove inflight so we can try again
+			slog.Error("sync: failed to enqueue, dropping", "room", evt.RoomID, "id", evt.ID, "err", err)
+			s.recordDrop()
 			if s.deduper != nil && evt.ID != "" {
 				_ = s.deduper.UnmarkInflight(ctx, evt.ID.String())
 			}
+			return
 		}
+		slog.Debug("sync: got msg", "room", evt.RoomID, "id", evt.ID, "ts", evt.Timestamp)
 	})
 
 	// Start sync loop
@@ -222,6 +300,30 @@ func (s *Service) startListening(ctx context.Context) error {
 	return nil
 }
 
+// accepts - checks if the event should be forwarded to the handlers.
+// Messages and encrypted events are always forwarded (the type of encrypted events is known only after decryption),
+// other room events are forwarded from the timeline (and invites) if they are subscribed
+func (s *Service) accepts(evt *event.Event) bool {
+	switch evt.Type {
+	case event.EventMessage, event.EventEncrypted, event.StateEncryption:
+		return true
+	}
+
+	if evt.RoomID == "" || evt.Mautrix.EventSource&(event.SourceTimeline|event.SourceInvite) == 0 {
+		return false
+	}
+
+	return s.isSubscribed(evt.Type)
+}
+
+// isSubscribed - checks if the event type is configured or asked for by a handler
+func (s *Service) isSubscribed(t event.Type) bool {
+	if slices.Contains(s.eventTypes, t) {
+		return true
+	}
+	return s.subscriptions != nil && s.subscriptions.IsSubscribed(t)
+}
+
 func (s *Service) StopListening(ctx context.Context) error {
 	if s.cancel != nil {
 		s.cancel()
@@ -255,6 +357,64 @@ func (s *Service) run(ctx context.Context) {
 }
 
 func (s *Service) worker(ctx context.Context, idx int) {
+	for {
+		item, err := s.queue.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("worker: dequeue failed", "w", idx, "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.retry):
+			}
+			continue
+		}
+
+		evt := item.Event
+
+		// the lease has expired while the event was handled (e.g. the process has crashed on it)
+		if item.Attempts >= s.maxAttempts {
+			s.retryLater(ctx, item, errors.New(item.LastError))
+			continue
+		}
+
+		start := time.Now()
+		s.trackStart(idx, evt)
+
+		stopLease := s.extendLease(ctx, item)
+		err = s.handle(ctx, evt)
+		stopLease()
+
+		s.trackDone(evt)
+		dur := time.Since(start)
+		if err != nil {
+			slog.Error("worker: HandleMatrixEvent failed",
+				"w", idx, "dur_ms", dur.Milliseconds(), "attempt", item.Attempts+1,
+				"err", err, "id", evt.ID, "room", evt.RoomID)
+			s.recordFailure(evt, err)
+			if dbot.IsRetryable(err) {
+				s.retryLater(ctx, item, err)
+			} else {
+				s.giveUp(ctx, item, err)
+			}
+			continue
+		}
+
+		if s.deduper != nil && evt.ID != "" {
+			if err := s.deduper.MarkProcessed(ctx, evt.ID.String()); err != nil {
+				slog.Error("dedup: MarkProcessed failed", "w", idx, "err", err, "id", evt.ID)
+			}
+		}
+		if err := s.queue.Ack(ctx, item); err != nil {
+			slog.Error("worker: ack failed", "w", idx, "err", err, "id", evt.ID)
+		}
+	}
+}
+
+// handle - passes the event to the handlers. The panic of a handler fails the attempt
+func (s *Service) handle(ctx context.Context, evt *event.Event) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			slog.Error(
@@ -264,34 +424,51 @@ func (s *Service) worker(ctx context.Context, idx int) {
 				"stack",
 				string(debug.Stack()),
 			)
+			err = fmt.Errorf("handler panic: %v", r)
 		}
 	}()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case evt := <-s.workCh:
-			start := time.Now()
+	return s.eventRouter.HandleMatrixEvent(ctx, evt)
+}
 
-			err := s.eventRouter.HandleMatrixEvent(ctx, evt)
+// extendLease - extends the lease of the event while it's handled (see bot.LeaseExtender).
+// Returns the func that stops the extension
+func (s *Service) extendLease(ctx context.Context, item *dbot.QueuedEvent) (stop func()) {
+	ext, ok := s.queue.(dbot.LeaseExtender)
+	if !ok || ext.Lease() <= 0 {
+		return func() {}
+	}
 
-			dur := time.Since(start)
-			if err != nil {
-				slog.Error("worker: HandleMatrixEvent failed",
-					"w", idx, "dur_ms", dur.Milliseconds(),
-					"err", err, "id", evt.ID, "room", evt.RoomID)
-				if s.deduper != nil && evt.ID != "" {
-					_ = s.deduper.UnmarkInflight(ctx, evt.ID.String())
-				}
-				continue
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(ext.Lease() / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
 			}
 
-			if s.deduper != nil && evt.ID != "" {
-				if err := s.deduper.MarkProcessed(ctx, evt.ID.String()); err != nil {
-					slog.Error("dedup: MarkProcessed failed", "w", idx, "err", err, "id", evt.ID)
-				}
+			err := ext.Extend(ctx, item)
+			switch {
+			case err == nil, ctx.Err() != nil:
+			case errors.Is(err, dbot.ErrLeaseLost):
+				slog.Error("worker: lease of the event is lost", "id", item.Event.ID, "room", item.Event.RoomID)
+				return
+			default:
+				slog.Error("worker: failed to extend the lease", "err", err, "id", item.Event.ID)
 			}
 		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
 	}
 }
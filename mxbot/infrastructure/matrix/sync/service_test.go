@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"context"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// observerFunc - state observer of the tests
+type observerFunc func(roomID id.RoomID, evt *event.Event)
+
+func (f observerFunc) ObserveRoomState(_ context.Context, roomID id.RoomID, evt *event.Event) {
+	f(roomID, evt)
+}
+
+func stateEvent(eventID id.EventID, value string) *event.Event {
+	stateKey := ""
+	return &event.Event{
+		ID:       eventID,
+		Type:     event.Type{Type: "io.bobrix.config", Class: event.StateEventType},
+		StateKey: &stateKey,
+		Content:  event.Content{Raw: map[string]any{"value": value}},
+	}
+}
+
+func TestObserveRoomState(t *testing.T) {
+	message := &event.Event{ID: "$msg", Type: event.EventMessage}
+
+	tests := []struct {
+		name string
+		room mautrix.SyncJoinedRoom
+		want string // the last observed value
+	}{
+		{
+			name: "state and timeline",
+			room: mautrix.SyncJoinedRoom{
+				State:    mautrix.SyncEventsList{Events: []*event.Event{stateEvent("$old", "old")}},
+				Timeline: mautrix.SyncTimeline{SyncEventsList: mautrix.SyncEventsList{Events: []*event.Event{message, stateEvent("$new", "new")}}},
+			},
+			want: "new",
+		},
+		{
+			name: "state_after is the state at the end of the timeline",
+			room: mautrix.SyncJoinedRoom{
+				Timeline:   mautrix.SyncTimeline{SyncEventsList: mautrix.SyncEventsList{Events: []*event.Event{stateEvent("$rejected", "rejected"), message}}},
+				StateAfter: &mautrix.SyncEventsList{Events: []*event.Event{stateEvent("$resolved", "resolved")}},
+			},
+			want: "resolved",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var last string
+			var observed int
+			s := &Service{stateObservers: []StateObserver{observerFunc(func(roomID id.RoomID, evt *event.Event) {
+				if roomID != "!room" {
+					t.Errorf("room = %s", roomID)
+				}
+				last, _ = evt.Content.Raw["value"].(string)
+				observed++
+			})}}
+
+			s.observeRoomState(context.Background(), &mautrix.RespSync{Rooms: mautrix.RespSyncRooms{
+				Join: map[id.RoomID]*mautrix.SyncJoinedRoom{"!room": &tt.room},
+			}})
+
+			if observed != 2 || last != tt.want {
+				t.Errorf("observed %d events, last %q, want 2 and %q", observed, last, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockKey - key of the advisory lock held while the migrations are applied ("bobrix" in hex)
+const lockKey int64 = 0x626f62726978
+
+const createVersionsTable = `
+CREATE TABLE IF NOT EXISTS bobrix_schema_migrations (
+    version    integer     PRIMARY KEY,
+    name       text        NOT NULL,
+    applied_at timestamptz NOT NULL DEFAULT now()
+);
+`
+
+// Migration - one step of the schema. The file name is <version>_<name>.sql
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// TxBeginner - database that starts transactions (*pgxpool.Pool, *pgx.Conn)
+type TxBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// All - embedded migrations sorted by version
+func All() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, e := range entries {
+		version, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %q", e.Name())
+		}
+
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", e.Name(), err)
+		}
+
+		data, err := files.ReadFile(path.Join("sql", e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", e.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{Version: v, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Apply - applies the pending migrations in one transaction and returns them.
+// Concurrent migrators (e.g. replicas started at the same time) wait for each other on the advisory lock,
+// so every migration is applied once
+func Apply(ctx context.Context, db TxBeginner) ([]Migration, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin migration: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// the lock is released on commit or rollback
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, lockKey); err != nil {
+		return nil, fmt.Errorf("failed to take migration lock: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, createVersionsTable); err != nil {
+		return nil, fmt.Errorf("failed to create bobrix_schema_migrations: %w", err)
+	}
+
+	applied := map[int]bool{}
+	rows, err := tx.Query(ctx, `SELECT version FROM bobrix_schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			return nil, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO bobrix_schema_migrations(version, name) VALUES ($1, $2)`,
+			m.Version, m.Name,
+		); err != nil {
+			return nil, fmt.Errorf("failed to record migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit migrations: %w", err)
+	}
+
+	return done, nil
+}
+
+// SQL - all migrations as one script for the external migration tools.
+// The script is idempotent and records the versions, so Apply doesn't repeat them later
+func SQL() (string, error) {
+	migrations, err := All()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("BEGIN;\n\n")
+	fmt.Fprintf(&sb, "SELECT pg_advisory_xact_lock(%d);\n", lockKey)
+	sb.WriteString(createVersionsTable)
+
+	for _, m := range migrations {
+		fmt.Fprintf(&sb, "\n-- %04d_%s\n", m.Version, m.Name)
+		sb.WriteString(strings.TrimSpace(m.SQL))
+		fmt.Fprintf(&sb,
+			"\n\nINSERT INTO bobrix_schema_migrations(version, name) VALUES (%d, '%s') ON CONFLICT (version) DO NOTHING;\n",
+			m.Version, strings.ReplaceAll(m.Name, "'", "''"),
+		)
+	}
+
+	sb.WriteString("\nCOMMIT;\n")
+	return sb.String(), nil
+}
@@ -0,0 +1,20 @@
+-- events captured and processed by the bot (see dedup.PostgresDeduper)
+CREATE TABLE IF NOT EXISTS matrix_event_dedup (
+    user_id      text        NOT NULL,
+    event_id     text        NOT NULL,
+    status       smallint    NOT NULL, -- 1: inflight, 2: processed
+    lease_until  timestamptz NULL,
+    processed_at timestamptz NULL,
+    updated_at   timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, event_id)
+);
+
+-- cleanup of the old processed events
+CREATE INDEX IF NOT EXISTS matrix_event_dedup_processed_at_idx
+    ON matrix_event_dedup (processed_at)
+    WHERE status = 2;
+
+-- expired leases of the inflight events
+CREATE INDEX IF NOT EXISTS matrix_event_dedup_lease_until_idx
+    ON matrix_event_dedup (lease_until)
+    WHERE status = 1;
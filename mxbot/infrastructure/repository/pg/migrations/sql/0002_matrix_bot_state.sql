@@ -0,0 +1,18 @@
+-- state of the bot: sync token, filter and device (see store.PostgresStateStore)
+CREATE TABLE IF NOT EXISTS matrix_bot_state (
+    user_id    text        PRIMARY KEY,
+    next_batch text        NULL,
+    filter_id  text        NULL,
+    device_id  text        NULL,
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- state of the rooms: last join time and prev_batch token for the backfill
+CREATE TABLE IF NOT EXISTS matrix_room_state (
+    user_id    text        NOT NULL,
+    room_id    text        NOT NULL,
+    join_ts    bigint      NULL,
+    prev_batch text        NULL,
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, room_id)
+);
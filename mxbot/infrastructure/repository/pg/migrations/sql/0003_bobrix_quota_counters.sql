@@ -0,0 +1,11 @@
+-- usage counters of the quotas (see bobrix.PostgresQuotaStore)
+CREATE TABLE IF NOT EXISTS bobrix_quota_counters (
+    key          text        NOT NULL,
+    window_start timestamptz NOT NULL,
+    used         bigint      NOT NULL DEFAULT 0,
+    expires_at   timestamptz NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS bobrix_quota_counters_expires_at_idx
+    ON bobrix_quota_counters (expires_at);
@@ -0,0 +1,23 @@
+-- audit of the service calls (see bobrix.PostgresAuditSink)
+CREATE TABLE IF NOT EXISTS bobrix_audit_log (
+    id           bigserial   PRIMARY KEY,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    bot_id       text        NOT NULL DEFAULT '',
+    user_id      text        NOT NULL DEFAULT '',
+    room_id      text        NOT NULL DEFAULT '',
+    event_id     text        NOT NULL DEFAULT '',
+    service_id   text        NOT NULL DEFAULT '',
+    service_name text        NOT NULL DEFAULT '',
+    method       text        NOT NULL DEFAULT '',
+    inputs       jsonb       NULL,
+    output       text        NOT NULL DEFAULT '',
+    err_code     integer     NOT NULL DEFAULT 0,
+    error        text        NOT NULL DEFAULT '',
+    latency_ms   bigint      NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS bobrix_audit_log_user_id_created_at_idx
+    ON bobrix_audit_log (user_id, created_at);
+
+CREATE INDEX IF NOT EXISTS bobrix_audit_log_created_at_idx
+    ON bobrix_audit_log (created_at);
@@ -0,0 +1,32 @@
+-- events waiting for the handlers (see queue.PostgresQueue)
+CREATE TABLE IF NOT EXISTS bobrix_work_queue (
+    user_id      text        NOT NULL,
+    id           text        NOT NULL, -- event ID (generated for the events without it)
+    room_id      text        NOT NULL DEFAULT '',
+    event        jsonb       NOT NULL,
+    attempts     integer     NOT NULL DEFAULT 0,
+    last_error   text        NOT NULL DEFAULT '',
+    available_at timestamptz NOT NULL DEFAULT now(), -- the event isn't delivered before (retry backoff)
+    lease_until  timestamptz NULL,                   -- the event is being handled by a worker
+    enqueued_at  timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS bobrix_work_queue_available_at_idx
+    ON bobrix_work_queue (user_id, available_at);
+
+-- events that have failed all attempts
+CREATE TABLE IF NOT EXISTS bobrix_dead_letters (
+    user_id     text        NOT NULL,
+    id          text        NOT NULL,
+    room_id     text        NOT NULL DEFAULT '',
+    event       jsonb       NOT NULL,
+    attempts    integer     NOT NULL,
+    error       text        NOT NULL DEFAULT '',
+    enqueued_at timestamptz NOT NULL,
+    failed_at   timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS bobrix_dead_letters_failed_at_idx
+    ON bobrix_dead_letters (user_id, failed_at);
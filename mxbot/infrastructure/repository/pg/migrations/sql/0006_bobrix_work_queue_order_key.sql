@@ -0,0 +1,8 @@
+-- ordering key of the queued events (see queue.Ordering): the events with the same key are handled in order
+ALTER TABLE bobrix_work_queue ADD COLUMN IF NOT EXISTS order_key text NOT NULL DEFAULT '';
+ALTER TABLE bobrix_dead_letters ADD COLUMN IF NOT EXISTS order_key text NOT NULL DEFAULT '';
+
+-- leased events of the key
+CREATE INDEX IF NOT EXISTS bobrix_work_queue_order_key_idx
+    ON bobrix_work_queue (user_id, order_key)
+    WHERE lease_until IS NOT NULL;
@@ -1,33 +1,56 @@
 package messages
 
 import (
-	"fmt"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
-	"time"
 )
 
-// NewAudio - creates a new audio message
+// NewAudio - creates a new audio message. The MIME type and the duration are detected from the content
 // Audio - audio bytes
-// Text - message text (optional: take first argument if set). Default: audio_YYYY-MM-DD_HH-MM-SS.mp3
+// Text - file name (optional: take first argument if set). Default: audio_YYYY-MM-DD_HH-MM-SS.<ext>
 func NewAudio(audio []byte, text ...string) Message {
+	file := newAudioFile(audio, text...)
 
-	t := fmt.Sprintf("audio_%s.mp3", time.Now().Format(time.RFC3339))
-
-	if len(text) > 0 {
-		t = text[0]
+	return &BaseMessage{
+		msgType:         event.MsgAudio,
+		text:            file.fileName,
+		file:            file,
+		markDownSupport: MarkDownSupportDefault,
 	}
+}
+
+// NewVoice - creates a new voice message (MSC3245): clients show it as the voice note with the waveform.
+// Use audio/ogg (Opus) for the best support
+// Audio - audio bytes
+// Text - file name (optional: take first argument if set). Default: audio_YYYY-MM-DD_HH-MM-SS.<ext>
+func NewVoice(audio []byte, text ...string) Message {
+	file := newAudioFile(audio, text...)
+	file.voice = true
+	file.waveform = voiceWaveform(audio, file.mimeType)
 
 	return &BaseMessage{
-		msgType: event.MsgAudio,
-		text:    "Voice message",
-		file: &FileInfo{
-			fileName:     t,
-			mimeType:     "audio/mpeg",
-			contentBytes: audio,
-
-			contentURI: id.ContentURI{},
-		},
+		msgType:         event.MsgAudio,
+		text:            "Voice message",
+		file:            file,
 		markDownSupport: MarkDownSupportDefault,
 	}
 }
+
+func newAudioFile(audio []byte, text ...string) *FileInfo {
+	mimeType := detectMediaType(audio, kindAudio, "audio/mpeg")
+
+	name := defaultFileName(kindAudio, mimeType)
+	if len(text) > 0 {
+		name = text[0]
+	}
+
+	file := &FileInfo{
+		fileName:     name,
+		mimeType:     mimeType,
+		contentBytes: audio,
+		contentURI:   id.ContentURI{},
+	}
+	analyzeMedia(file, kindAudio)
+
+	return file
+}
@@ -0,0 +1,345 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// waveformBars - number of the values in the waveform of the voice message
+const waveformBars = 100
+
+// waveformMax - max value of the waveform (MSC1767)
+const waveformMax = 1024
+
+// audioDuration - duration of the audio (0 if the format is unknown)
+func audioDuration(data []byte, mimeType string) time.Duration {
+	switch mimeType {
+	case "audio/ogg":
+		d, _ := oggInfo(data)
+		return d
+	case "audio/mpeg":
+		return mp3Duration(data)
+	case "audio/wav":
+		d, _ := wavInfo(data)
+		return d
+	case "audio/mp4", "audio/webm":
+		_, _, d := videoInfo(data, "video/"+mimeType[len("audio/"):])
+		return d
+	}
+	return 0
+}
+
+// voiceWaveform - waveform of the voice message (waveformBars values 0-1024).
+// WAV is measured by the loudness of the samples. Ogg (Opus) is not decoded:
+// the sizes of the packets are used instead (louder audio takes more bytes in VBR).
+// Other formats get the flat waveform
+func voiceWaveform(data []byte, mimeType string) []int {
+	var levels []float64
+	switch mimeType {
+	case "audio/wav":
+		_, levels = wavInfo(data)
+	case "audio/ogg":
+		_, levels = oggInfo(data)
+	}
+
+	return normalizeWaveform(levels)
+}
+
+// normalizeWaveform - resamples the levels to waveformBars values and scales them to 0-1024
+func normalizeWaveform(levels []float64) []int {
+	waveform := make([]int, waveformBars)
+	if len(levels) == 0 {
+		for i := range waveform {
+			waveform[i] = waveformMax / 4
+		}
+		return waveform
+	}
+
+	bars := make([]float64, waveformBars)
+	peak := 0.0
+	for i := range bars {
+		from := i * len(levels) / waveformBars
+		to := max((i+1)*len(levels)/waveformBars, from+1)
+		if from >= len(levels) {
+			from, to = len(levels)-1, len(levels)
+		}
+
+		sum := 0.0
+		for _, l := range levels[from:to] {
+			sum += l
+		}
+		bars[i] = sum / float64(to-from)
+		peak = math.Max(peak, bars[i])
+	}
+
+	for i, b := range bars {
+		if peak > 0 {
+			waveform[i] = int(b / peak * waveformMax)
+		}
+	}
+	return waveform
+}
+
+// oggInfo - duration of the Ogg (Opus or Vorbis) stream and the sizes of its audio packets
+func oggInfo(data []byte) (time.Duration, []float64) {
+	const headerLen = 27
+
+	var (
+		sampleRate  float64
+		preSkip     int64
+		lastGranule int64 = -1
+		packets     []float64
+		packet      int
+		packetNum   int
+	)
+
+	for pos := 0; pos+headerLen <= len(data); {
+		if !bytes.Equal(data[pos:pos+4], []byte("OggS")) {
+			next := bytes.Index(data[pos+1:], []byte("OggS"))
+			if next < 0 {
+				break
+			}
+			pos += next + 1
+			continue
+		}
+
+		granule := int64(binary.LittleEndian.Uint64(data[pos+6 : pos+14]))
+		segments := int(data[pos+26])
+		if pos+headerLen+segments > len(data) {
+			break
+		}
+		lacing := data[pos+headerLen : pos+headerLen+segments]
+
+		body := pos + headerLen + segments
+		for _, l := range lacing {
+			if packetNum == 0 && body+19 <= len(data) {
+				// the first packet is the header of the codec
+				switch {
+				case bytes.HasPrefix(data[body:], []byte("OpusHead")):
+					sampleRate = 48000 // the granule of Opus is always in 48 kHz
+					preSkip = int64(binary.LittleEndian.Uint16(data[body+10 : body+12]))
+				case bytes.HasPrefix(data[body:], []byte("\x01vorbis")) && body+16 <= len(data):
+					sampleRate = float64(binary.LittleEndian.Uint32(data[body+12 : body+16]))
+				}
+			}
+
+			packet += int(l)
+			if l < 255 {
+				// header packets (id and comments) are not the audio
+				if packetNum >= 2 {
+					packets = append(packets, float64(packet))
+				}
+				packet = 0
+				packetNum++
+			}
+		}
+
+		if granule >= 0 {
+			lastGranule = granule
+		}
+
+		pageLen := 0
+		for _, l := range lacing {
+			pageLen += int(l)
+		}
+		pos = body + pageLen
+	}
+
+	if sampleRate == 0 || lastGranule <= preSkip {
+		return 0, packets
+	}
+
+	return time.Duration(float64(lastGranule-preSkip) / sampleRate * float64(time.Second)), packets
+}
+
+// wavInfo - duration of the WAV file and the loudness (RMS) of its PCM samples per 10 ms
+func wavInfo(data []byte) (time.Duration, []float64) {
+	if len(data) < 12 || !bytes.Equal(data[0:4], []byte("RIFF")) || !bytes.Equal(data[8:12], []byte("WAVE")) {
+		return 0, nil
+	}
+
+	var (
+		format, channels, bits uint16
+		sampleRate, byteRate   uint32
+	)
+
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int64(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := pos + 8
+		end := len(data)
+		if size <= int64(len(data)-body) {
+			end = body + int(size)
+		}
+
+		switch id {
+		case "fmt ":
+			if end-body >= 16 {
+				format = binary.LittleEndian.Uint16(data[body:])
+				channels = binary.LittleEndian.Uint16(data[body+2:])
+				sampleRate = binary.LittleEndian.Uint32(data[body+4:])
+				byteRate = binary.LittleEndian.Uint32(data[body+8:])
+				bits = binary.LittleEndian.Uint16(data[body+14:])
+			}
+		case "data":
+			if byteRate == 0 {
+				return 0, nil
+			}
+			duration := time.Duration(float64(size) / float64(byteRate) * float64(time.Second))
+
+			// only integer PCM is measured
+			if format != 1 || (bits != 8 && bits != 16) || channels == 0 || sampleRate == 0 {
+				return duration, nil
+			}
+			return duration, pcmLevels(data[body:end], int(bits/8)*int(channels), int(sampleRate)/100, bits)
+		}
+
+		if end == len(data) {
+			break
+		}
+		pos = end + int(size%2) // chunks are aligned to 2 bytes
+	}
+
+	return 0, nil
+}
+
+// pcmLevels - RMS of the first channel in the windows of the given number of frames
+func pcmLevels(pcm []byte, frameSize, window int, bits uint16) []float64 {
+	if frameSize == 0 || window == 0 {
+		return nil
+	}
+
+	var levels []float64
+	var sum float64
+	n := 0
+	for pos := 0; pos+frameSize <= len(pcm); pos += frameSize {
+		var sample float64
+		if bits == 8 {
+			sample = (float64(pcm[pos]) - 128) / 128
+		} else {
+			sample = float64(int16(binary.LittleEndian.Uint16(pcm[pos:]))) / 32768
+		}
+
+		sum += sample * sample
+		n++
+		if n == window {
+			levels = append(levels, math.Sqrt(sum/float64(n)))
+			sum, n = 0, 0
+		}
+	}
+	if n > 0 {
+		levels = append(levels, math.Sqrt(sum/float64(n)))
+	}
+
+	return levels
+}
+
+var (
+	// mp3Bitrates - bitrates (kbps) by [MPEG-1][layer 1-3] and [MPEG-2/2.5][layer 1-3]
+	mp3Bitrates = [2][3][16]int{
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+
+	// mp3SampleRates - sample rates by [MPEG-1, MPEG-2, MPEG-2.5]
+	mp3SampleRates = [3][3]int{
+		{44100, 48000, 32000},
+		{22050, 24000, 16000},
+		{11025, 12000, 8000},
+	}
+)
+
+// mp3Frame - parses the header of the MP3 frame. It returns the length of the frame and its number of samples
+func mp3Frame(h []byte) (length, samples, sampleRate int, ok bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return 0, 0, 0, false
+	}
+
+	versionBits := (h[1] >> 3) & 0x03 // 0: MPEG-2.5, 2: MPEG-2, 3: MPEG-1
+	layerBits := (h[1] >> 1) & 0x03   // 1: layer 3, 2: layer 2, 3: layer 1
+	bitrateIdx := h[2] >> 4
+	rateIdx := (h[2] >> 2) & 0x03
+	padding := int((h[2] >> 1) & 0x01)
+
+	if versionBits == 1 || layerBits == 0 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+		return 0, 0, 0, false
+	}
+
+	layer := 4 - int(layerBits) // 1-3
+	version := 0                // MPEG-1
+	rateRow := 0
+	switch versionBits {
+	case 2:
+		version, rateRow = 1, 1
+	case 0:
+		version, rateRow = 1, 2
+	}
+
+	bitrate := mp3Bitrates[version][layer-1][bitrateIdx] * 1000
+	sampleRate = mp3SampleRates[rateRow][rateIdx]
+
+	switch {
+	case layer == 1:
+		return (12*bitrate/sampleRate + padding) * 4, 384, sampleRate, true
+	case layer == 3 && version == 1:
+		return 72*bitrate/sampleRate + padding, 576, sampleRate, true
+	default:
+		return 144*bitrate/sampleRate + padding, 1152, sampleRate, true
+	}
+}
+
+// skipID3 - position after the ID3v2 tag (0 if there is no tag)
+func skipID3(data []byte) int {
+	if len(data) < 10 || !bytes.Equal(data[0:3], []byte("ID3")) {
+		return 0
+	}
+
+	size := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+	pos := 10 + size
+	if data[5]&0x10 != 0 {
+		pos += 10 // footer
+	}
+	return pos
+}
+
+// isMP3 - checks that the data starts with the ID3 tag or with two consecutive MP3 frames
+func isMP3(data []byte) bool {
+	if skipID3(data) > 0 {
+		return true
+	}
+
+	length, _, _, ok := mp3Frame(data)
+	if !ok || length <= 0 {
+		return false
+	}
+	_, _, _, ok = mp3Frame(data[min(length, len(data)):])
+	return ok
+}
+
+// mp3Duration - duration of the MP3 stream (sum of the durations of its frames)
+func mp3Duration(data []byte) time.Duration {
+	var seconds float64
+
+	for pos := skipID3(data); pos+4 <= len(data); {
+		length, samples, sampleRate, ok := mp3Frame(data[pos:])
+		if !ok || length <= 0 {
+			pos++ // lost sync: look for the next frame
+			continue
+		}
+
+		seconds += float64(samples) / float64(sampleRate)
+		pos += length
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -5,23 +5,27 @@ import (
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 	"mime"
+	"strings"
 )
 
 // NewFile - creates a new file message
 // Bytes - file bytes
 // Name - file fileName
-// FileType - file type
+// FileType - file type (extension). The type is detected from the content if the extension is unknown
 // Text - message text (optional: take first argument if set). Default: file_YYYY-MM-DD_HH-MM-SS.png
 func NewFile(bytes []byte, name string, fileType string, text ...string) Message {
-	t := fmt.Sprintf("file_%s.%s", name, fileType)
+	t := fmt.Sprintf("file_%s.%s", name, strings.TrimPrefix(fileType, "."))
 
 	if len(text) > 0 {
 		t = text[0]
 	}
 
-	mimeType := mime.TypeByExtension(fileType)
+	if !strings.HasPrefix(fileType, ".") {
+		fileType = "." + fileType
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(fileType), ";")
 	if mimeType == "" {
-		mimeType = "application/octet-stream"
+		mimeType = detectMediaType(bytes, kindFile, "application/octet-stream")
 	}
 
 	return &BaseMessage{
@@ -0,0 +1,56 @@
+package messages
+
+import (
+	"context"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Messenger - sends messages and relations to them (it is implemented by the bot messaging)
+type Messenger interface {
+	SendMessage(ctx context.Context, roomID id.RoomID, msg Message) (*Handle, error)
+	EditMessage(ctx context.Context, roomID id.RoomID, eventID id.EventID, msg Message) error
+	SendReaction(ctx context.Context, roomID id.RoomID, eventID id.EventID, key string) (*Handle, error)
+	Redact(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string) error
+}
+
+// Handle - sent event (message or reaction). It allows to edit, redact, react and reply to the event later
+type Handle struct {
+	RoomID  id.RoomID
+	EventID id.EventID
+
+	messenger Messenger
+}
+
+// NewHandle - Handle constructor
+func NewHandle(messenger Messenger, roomID id.RoomID, eventID id.EventID) *Handle {
+	return &Handle{
+		RoomID:    roomID,
+		EventID:   eventID,
+		messenger: messenger,
+	}
+}
+
+// Edit - replaces the content of the message (m.replace)
+func (h *Handle) Edit(ctx context.Context, msg Message) error {
+	return h.messenger.EditMessage(ctx, h.RoomID, h.EventID, msg)
+}
+
+// Redact - removes the event (e.g. the reaction of the bot)
+func (h *Handle) Redact(ctx context.Context, reason string) error {
+	return h.messenger.Redact(ctx, h.RoomID, h.EventID, reason)
+}
+
+// React - adds the reaction to the message
+func (h *Handle) React(ctx context.Context, key string) (*Handle, error) {
+	return h.messenger.SendReaction(ctx, h.RoomID, h.EventID, key)
+}
+
+// Reply - sends the message as a reply to this message
+func (h *Handle) Reply(ctx context.Context, msg Message) (*Handle, error) {
+	msg.SetRelatesTo(&event.RelatesTo{
+		InReplyTo: &event.InReplyTo{EventID: h.EventID},
+	})
+	return h.messenger.SendMessage(ctx, h.RoomID, msg)
+}
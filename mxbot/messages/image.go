@@ -1,30 +1,35 @@
 package messages
 
 import (
-	"fmt"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
-	"time"
 )
 
-// NewImage - creates a new image message
-// Image - image bytes (base64 encoded)
-// Text - message text (optional: take first argument if set). Default: image_YYYY-MM-DD_HH-MM-SS.png
+// NewImage - creates a new image message. The MIME type is detected from the content,
+// dimensions, thumbnail and blurhash are generated for the supported formats (png, jpeg, gif)
+// Image - image bytes
+// Text - message text (optional: take first argument if set). Default: image_YYYY-MM-DD_HH-MM-SS.<ext>
 func NewImage(image []byte, text ...string) Message {
-	t := fmt.Sprintf("image_%s.png", time.Now().Format(time.RFC3339))
+	mimeType := detectMediaType(image, kindImage, "image/png")
+	name := defaultFileName(kindImage, mimeType)
 
+	t := name
 	if len(text) > 0 {
 		t = text[0]
 	}
 
+	file := &FileInfo{
+		fileName:     name,
+		contentBytes: image,
+		mimeType:     mimeType,
+		contentURI:   id.ContentURI{},
+	}
+	analyzeMedia(file, kindImage)
+
 	return &BaseMessage{
-		text:    t,
-		msgType: event.MsgImage,
-		file: &FileInfo{
-			contentBytes: image,
-			mimeType:     "image/png",
-			contentURI:   id.ContentURI{},
-		},
+		text:            t,
+		msgType:         event.MsgImage,
+		file:            file,
 		markDownSupport: MarkDownSupportDefault,
 	}
 }
@@ -0,0 +1,203 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // decoder of the gif images
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// thumbnails are generated for the images larger than the bounds
+	thumbnailMaxWidth  = 800
+	thumbnailMaxHeight = 600
+
+	// blurhashSize - the blurhash is calculated on the image downscaled to this size (it is only a placeholder)
+	blurhashSize        = 32
+	blurhashXComponents = 4
+	blurhashYComponents = 3
+
+	thumbnailJPEGQuality = 80
+)
+
+// mediaKind - top-level MIME type of the message (image, audio, video)
+type mediaKind string
+
+const (
+	kindImage mediaKind = "image"
+	kindAudio mediaKind = "audio"
+	kindVideo mediaKind = "video"
+	kindFile  mediaKind = ""
+)
+
+// mediaExtensions - extensions of the default file names
+var mediaExtensions = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+	"audio/ogg":  "ogg",
+	"audio/mpeg": "mp3",
+	"audio/webm": "webm",
+	"audio/wav":  "wav",
+	"audio/mp4":  "m4a",
+	"video/mp4":  "mp4",
+	"video/webm": "webm",
+	"video/ogg":  "ogv",
+}
+
+// detectMediaType - detects the MIME type of the content. The fallback is used if the format is unknown
+func detectMediaType(data []byte, kind mediaKind, fallback string) string {
+	sniffed, _, _ := strings.Cut(http.DetectContentType(data), ";")
+
+	switch {
+	case sniffed == "audio/wave":
+		sniffed = "audio/wav"
+	case sniffed == "application/octet-stream" && isMP3(data):
+		sniffed = "audio/mpeg"
+	case sniffed == "application/octet-stream", strings.HasPrefix(sniffed, "text/"):
+		return fallback
+	}
+
+	// the containers are the same for audio and video: the kind of the message decides
+	_, subtype, _ := strings.Cut(sniffed, "/")
+	switch subtype {
+	case "ogg", "webm", "mp4":
+		if kind == kindAudio || kind == kindVideo {
+			return string(kind) + "/" + subtype
+		}
+	}
+
+	return sniffed
+}
+
+// defaultFileName - name of the file of the given kind: <kind>_<time>.<ext>
+func defaultFileName(kind mediaKind, mimeType string) string {
+	name := string(kind)
+	if name == "" {
+		name = "file"
+	}
+
+	ext, ok := mediaExtensions[mimeType]
+	if !ok {
+		_, ext, _ = strings.Cut(mimeType, "/")
+	}
+
+	return fmt.Sprintf("%s_%s.%s", name, time.Now().Format(time.RFC3339), ext)
+}
+
+// analyzeMedia - fills the metadata of the file from its content:
+// dimensions, thumbnail and blurhash of the images, duration of the audio, dimensions and duration of the videos.
+// Unknown formats are left without the metadata
+func analyzeMedia(f *FileInfo, kind mediaKind) {
+	switch kind {
+	case kindImage:
+		analyzeImage(f)
+	case kindAudio:
+		f.duration = audioDuration(f.contentBytes, f.mimeType)
+	case kindVideo:
+		f.width, f.height, f.duration = videoInfo(f.contentBytes, f.mimeType)
+	}
+}
+
+func analyzeImage(f *FileInfo) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(f.contentBytes))
+	if err != nil {
+		return // the format has no decoder (e.g. webp)
+	}
+	f.width, f.height = cfg.Width, cfg.Height
+
+	img, _, err := image.Decode(bytes.NewReader(f.contentBytes))
+	if err != nil {
+		slog.Debug("failed to decode image", "mimetype", f.mimeType, "error", err)
+		return
+	}
+
+	bw, bh := fitSize(cfg.Width, cfg.Height, blurhashSize, blurhashSize)
+	f.blurhash = encodeBlurhash(resizeImage(img, bw, bh), blurhashXComponents, blurhashYComponents)
+
+	if cfg.Width <= thumbnailMaxWidth && cfg.Height <= thumbnailMaxHeight {
+		return // the image is small enough to be its own thumbnail
+	}
+
+	tw, th := fitSize(cfg.Width, cfg.Height, thumbnailMaxWidth, thumbnailMaxHeight)
+	thumb, mimeType, err := encodeThumbnail(resizeImage(img, tw, th))
+	if err != nil {
+		slog.Debug("failed to encode thumbnail", "error", err)
+		return
+	}
+
+	f.thumbnail = &FileInfo{
+		fileName:     "thumbnail." + mediaExtensions[mimeType],
+		mimeType:     mimeType,
+		contentBytes: thumb,
+		width:        tw,
+		height:       th,
+	}
+}
+
+// encodeThumbnail - encodes the thumbnail to JPEG (PNG if the image has transparency)
+func encodeThumbnail(img *image.RGBA) ([]byte, string, error) {
+	var buf bytes.Buffer
+
+	if !img.Opaque() {
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/png", nil
+	}
+
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/jpeg", nil
+}
+
+// fitSize - size of the image scaled down to fit the bounds (the aspect ratio is kept)
+func fitSize(w, h, maxW, maxH int) (int, int) {
+	if w <= maxW && h <= maxH {
+		return w, h
+	}
+
+	scale := min(float64(maxW)/float64(w), float64(maxH)/float64(h))
+	return max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+}
+
+// resizeImage - scales the image down to the given size. Every pixel is the average of the source area
+func resizeImage(src image.Image, w, h int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0, y1 := bounds.Min.Y+y*sh/h, bounds.Min.Y+max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := bounds.Min.X+x*sw/w, bounds.Min.X+max((x+1)*sw/w, x*sw/w+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
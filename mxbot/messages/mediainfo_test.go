@@ -0,0 +1,236 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("fixture %s: %v", name, err)
+	}
+	return data
+}
+
+// within - the duration is within 10 ms of the expected one
+func within(got, want time.Duration) bool {
+	d := got - want
+	return d > -10*time.Millisecond && d < 10*time.Millisecond
+}
+
+func TestAudioDuration(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		mimeType string
+		want     time.Duration
+	}{
+		{"frames.mp3", "audio/mpeg", 1045 * time.Millisecond}, // 40 frames of 1152 samples at 44.1 kHz
+		{"voice.ogg", "audio/ogg", 2 * time.Second},
+		{"tone.wav", "audio/wav", 1500 * time.Millisecond},
+		{"clip.mp4", "audio/mp4", 2500 * time.Millisecond},
+		{"clip.webm", "audio/webm", 3 * time.Second},
+		{"tone.wav", "audio/flac", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture+" as "+tt.mimeType, func(t *testing.T) {
+			if got := audioDuration(readFixture(t, tt.fixture), tt.mimeType); !within(got, tt.want) {
+				t.Errorf("duration = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsMP3(t *testing.T) {
+	data := readFixture(t, "frames.mp3")
+
+	if !isMP3(data) {
+		t.Error("the file with the ID3 tag isn't recognized")
+	}
+	if !isMP3(data[skipID3(data):]) {
+		t.Error("the frames without the tag aren't recognized")
+	}
+	if isMP3(readFixture(t, "tone.wav")) {
+		t.Error("WAV is recognized as MP3")
+	}
+}
+
+func TestVoiceWaveform(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		mimeType string
+		quiet    bool // the first half is quieter than the second one
+	}{
+		{"tone.wav", "audio/wav", true},  // silence, then the tone
+		{"voice.ogg", "audio/ogg", true}, // small packets, then the large ones
+		{"frames.mp3", "audio/mpeg", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			waveform := voiceWaveform(readFixture(t, tt.fixture), tt.mimeType)
+			if len(waveform) != waveformBars {
+				t.Fatalf("bars = %d, want %d", len(waveform), waveformBars)
+			}
+
+			first, last := waveform[10], waveform[waveformBars-10]
+			for _, v := range waveform {
+				if v < 0 || v > waveformMax {
+					t.Fatalf("value %d is out of 0-%d", v, waveformMax)
+				}
+			}
+			if tt.quiet && first >= last {
+				t.Errorf("waveform %d...%d doesn't follow the loudness", first, last)
+			}
+			if !tt.quiet && first != last {
+				t.Errorf("waveform of the unknown format isn't flat: %d...%d", first, last)
+			}
+		})
+	}
+}
+
+func TestVideoInfo(t *testing.T) {
+	tests := []struct {
+		fixture       string
+		mimeType      string
+		width, height int
+		duration      time.Duration
+	}{
+		{"clip.mp4", "video/mp4", 640, 360, 2500 * time.Millisecond}, // the audio track before the video is skipped
+		{"clip.webm", "video/webm", 320, 240, 3 * time.Second},
+		{"clip.mp4", "video/x-msvideo", 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture+" as "+tt.mimeType, func(t *testing.T) {
+			w, h, d := videoInfo(readFixture(t, tt.fixture), tt.mimeType)
+			if w != tt.width || h != tt.height || !within(d, tt.duration) {
+				t.Errorf("info = %dx%d %v, want %dx%d %v", w, h, d, tt.width, tt.height, tt.duration)
+			}
+		})
+	}
+}
+
+func TestVideoInfoMalformed(t *testing.T) {
+	mp4Box := func(kind string, payload []byte) []byte {
+		b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+		return append(append(b, kind...), payload...)
+	}
+
+	// 64-bit size that overflows the position
+	huge := append(mp4Box("free", nil), binary.BigEndian.AppendUint32(nil, 1)...)
+	huge = append(huge, "moov"...)
+	huge = binary.BigEndian.AppendUint64(huge, 1<<63-4)
+	huge = append(huge, mp4Box("mvhd", make([]byte, 20))...)
+
+	// boxes nested deeper than the headers are
+	tkhd := binary.BigEndian.AppendUint32(make([]byte, 76), 640<<16)
+	tkhd = binary.BigEndian.AppendUint32(tkhd, 360<<16)
+	deep := mp4Box("tkhd", tkhd)
+	for range 1000 {
+		deep = mp4Box("trak", deep)
+	}
+
+	// EBML elements nested the same way (Video in Video...)
+	deepWebm := []byte{0xB0, 0x81, 0x10}
+	for range 1000 {
+		size := len(deepWebm)
+		deepWebm = append([]byte{0xE0, 0x08, 0, 0, 0, 0, 0, 0, 0, 0}, deepWebm...)
+		binary.BigEndian.PutUint64(deepWebm[2:], uint64(size))
+		deepWebm[1] = 0x01 // 8-byte size
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		mimeType string
+	}{
+		{"mp4 64-bit size overflow", huge, "video/mp4"},
+		{"mp4 truncated", mp4Box("moov", mp4Box("mvhd", make([]byte, 20)))[:20], "video/mp4"},
+		{"mp4 deep nesting", deep, "video/mp4"},
+		{"webm deep nesting", deepWebm, "video/webm"},
+		{"webm huge size", []byte{0x18, 0x53, 0x80, 0x67, 0x01, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE, 0x15}, "video/webm"},
+		{"empty", nil, "video/mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, _ := videoInfo(tt.data, tt.mimeType)
+			if w != 0 || h != 0 {
+				t.Errorf("size = %dx%d of the broken file", w, h)
+			}
+		})
+	}
+}
+
+func TestWavInfoMalformed(t *testing.T) {
+	data := readFixture(t, "tone.wav")
+
+	// the size of the data chunk is larger than the file
+	broken := bytes.Clone(data[:200])
+	idx := bytes.Index(broken, []byte("data"))
+	binary.LittleEndian.PutUint32(broken[idx+4:], 0xFFFFFFFF)
+
+	if d, _ := wavInfo(broken); d <= 0 {
+		t.Errorf("duration = %v of the truncated file, want the declared one", d)
+	}
+	if d, _ := wavInfo(data[:30]); d != 0 {
+		t.Errorf("duration = %v without the data chunk", d)
+	}
+}
+
+func TestEncodeBlurhash(t *testing.T) {
+	solid := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range solid.Pix {
+		solid.Pix[i] = 0xFF
+	}
+
+	// flag 'L' (4x3 components), white DC
+	if got, want := encodeBlurhash(solid, 4, 3), "LfTSUA~qfQ~q~qt7fQt7fQfQfQfQ"; got != want {
+		t.Errorf("solid blurhash = %q, want %q", got, want)
+	}
+
+	img, err := png.Decode(bytes.NewReader(readFixture(t, "gradient.png")))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	hash := encodeBlurhash(img, 4, 3)
+	if len(hash) != 6+2*11 {
+		t.Fatalf("blurhash %q has length %d, want 28", hash, len(hash))
+	}
+
+	// the average color of the red to blue gradient is purple
+	dc := decode83(hash[2:6])
+	r, g, b := dc>>16, dc>>8&0xFF, dc&0xFF
+	if r < 100 || b < 100 || g > 10 {
+		t.Errorf("average color = %v, want purple", color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b)})
+	}
+
+	if want := "L.HZ1l|T$9wvo3n~jujtfQfQfQfQ"; hash != want {
+		t.Errorf("blurhash = %q, want %q", hash, want)
+	}
+
+	if encodeBlurhash(img, 0, 3) != "" || encodeBlurhash(image.NewRGBA(image.Rect(0, 0, 0, 0)), 4, 3) != "" {
+		t.Error("blurhash of the invalid input isn't empty")
+	}
+}
+
+func decode83(s string) int {
+	v := 0
+	for _, c := range s {
+		v = v*83 + strings.IndexRune(blurhashChars, c)
+	}
+	return v
+}
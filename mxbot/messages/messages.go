@@ -3,6 +3,7 @@ package messages
 import (
 	"encoding/json"
 	"log/slog"
+	"time"
 
 	"github.com/gomarkdown/markdown"
 	"maunium.net/go/mautrix"
@@ -30,12 +31,67 @@ type Message interface {
 	SetMarkDownSupport(status bool) // set markDown support
 }
 
+// EncryptedMedia - media message that can be sent as the encrypted attachment (E2EE rooms).
+// The file is uploaded encrypted and the message refers to it with the file field instead of url
+type EncryptedMedia interface {
+	SetEncryptedFile(file *event.EncryptedFileInfo) // set key, iv, hashes and URI of the uploaded encrypted file
+}
+
+// ThumbnailMedia - media message with the generated thumbnail. The thumbnail is uploaded before the message
+type ThumbnailMedia interface {
+	ThumbnailUpload() (mautrix.ReqUploadMedia, bool)     // upload request of the thumbnail (false if there is no thumbnail)
+	SetThumbnailURI(contentURI id.ContentURI)            // set URI of the uploaded thumbnail
+	SetEncryptedThumbnail(file *event.EncryptedFileInfo) // set key, iv, hashes and URI of the uploaded encrypted thumbnail
+}
+
 type FileInfo struct {
 	fileName string
 	mimeType string
 
 	contentBytes []byte
 	contentURI   id.ContentURI
+
+	encryptedFile *event.EncryptedFileInfo
+
+	// metadata of the content (see analyzeMedia)
+	width    int
+	height   int
+	duration time.Duration
+	blurhash string
+
+	thumbnail *FileInfo // generated thumbnail of the image
+
+	voice    bool  // voice message (MSC3245)
+	waveform []int // waveform of the voice message (MSC1767), 0-1024
+}
+
+// info - info field of the event
+func (f *FileInfo) info() *event.FileInfo {
+	info := &event.FileInfo{
+		MimeType:     f.mimeType,
+		Size:         len(f.contentBytes),
+		Width:        f.width,
+		Height:       f.height,
+		Duration:     int(f.duration.Milliseconds()),
+		Blurhash:     f.blurhash,
+		AnoaBlurhash: f.blurhash,
+	}
+
+	if thumb := f.thumbnail; thumb != nil && (thumb.encryptedFile != nil || !thumb.contentURI.IsEmpty()) {
+		info.ThumbnailInfo = &event.FileInfo{
+			MimeType: thumb.mimeType,
+			Size:     len(thumb.contentBytes),
+			Width:    thumb.width,
+			Height:   thumb.height,
+		}
+		if thumb.encryptedFile != nil {
+			info.ThumbnailFile = thumb.encryptedFile
+		} else {
+			info.ThumbnailURL = thumb.contentURI.CUString()
+		}
+	}
+
+	return info
 }
 
 var _ Message = (*BaseMessage)(nil)
@@ -64,12 +120,21 @@ func (m *BaseMessage) AsEvent() event.MessageEventContent {
 		Body:    m.text,
 	}
 
-	if m.markDownSupport {
+	// the body of the media message is the caption if it differs from the file name
+	isCaption := m.file == nil || m.text != m.file.fileName
+
+	if m.markDownSupport && isCaption {
 
-		formattedBody := string(markdown.ToHTML([]byte(m.text), nil, nil))
+		formattedBody := SanitizeHTML(string(markdown.ToHTML([]byte(m.text), nil, nil)))
 
-		evt.Format = "org.matrix.custom.html"
+		evt.Format = event.FormatHTML
 		evt.FormattedBody = formattedBody
+
+		// the body is the plain text fallback: clients without HTML must not show the raw markdown
+		switch m.msgType {
+		case event.MsgText, event.MsgNotice, event.MsgEmote:
+			evt.Body = HTMLToPlain(formattedBody)
+		}
 	}
 
 	if m.rel != nil {
@@ -77,9 +142,23 @@ func (m *BaseMessage) AsEvent() event.MessageEventContent {
 	}
 
 	if m.file != nil {
-		evt.URL = m.file.contentURI.CUString()
-		evt.Info = &event.FileInfo{
-			MimeType: m.file.mimeType,
+		if m.file.encryptedFile != nil {
+			evt.File = m.file.encryptedFile
+		} else {
+			evt.URL = m.file.contentURI.CUString()
+		}
+		evt.Info = m.file.info()
+
+		if m.file.fileName != "" {
+			evt.FileName = m.file.fileName
+		}
+
+		if m.file.voice {
+			evt.MSC3245Voice = &event.MSC3245Voice{}
+			evt.MSC1767Audio = &event.MSC1767Audio{
+				Duration: int(m.file.duration.Milliseconds()),
+				Waveform: m.file.waveform,
+			}
 		}
 	}
 
@@ -126,6 +205,33 @@ func (m *BaseMessage) SetContentURI(contentURI id.ContentURI) {
 	m.file.contentURI = contentURI
 }
 
+// SetEncryptedFile - the file is sent encrypted: the event gets the file field instead of url
+func (m *BaseMessage) SetEncryptedFile(file *event.EncryptedFileInfo) {
+	m.file.encryptedFile = file
+}
+
+// ThumbnailUpload - upload request of the generated thumbnail
+func (m *BaseMessage) ThumbnailUpload() (mautrix.ReqUploadMedia, bool) {
+	if m.file == nil || m.file.thumbnail == nil {
+		return mautrix.ReqUploadMedia{}, false
+	}
+
+	return mautrix.ReqUploadMedia{
+		ContentBytes: m.file.thumbnail.contentBytes,
+		ContentType:  m.file.thumbnail.mimeType,
+		FileName:     m.file.thumbnail.fileName,
+	}, true
+}
+
+func (m *BaseMessage) SetThumbnailURI(contentURI id.ContentURI) {
+	m.file.thumbnail.contentURI = contentURI
+}
+
+// SetEncryptedThumbnail - the thumbnail is sent encrypted: the event gets thumbnail_file instead of thumbnail_url
+func (m *BaseMessage) SetEncryptedThumbnail(file *event.EncryptedFileInfo) {
+	m.file.thumbnail.encryptedFile = file
+}
+
 type CustomField struct {
 	Key   string
 	Value any
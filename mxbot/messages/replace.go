@@ -0,0 +1,63 @@
+package messages
+
+import (
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var _ Message = (*ReplaceMessage)(nil)
+
+// ReplaceMessage - edit (m.replace) of the message that was sent before.
+// The wrapped message is the new content of the edited message
+type ReplaceMessage struct {
+	Message
+	target id.EventID
+}
+
+// NewReplace - creates the edit of the target message with the content of msg
+func NewReplace(msg Message, target id.EventID) *ReplaceMessage {
+	return &ReplaceMessage{Message: msg, target: target}
+}
+
+// Target - returns the edited message
+func (m *ReplaceMessage) Target() id.EventID {
+	return m.target
+}
+
+// Unwrap - returns the new content of the edited message
+func (m *ReplaceMessage) Unwrap() Message {
+	return m.Message
+}
+
+// AsJSON - returns the edit event: the fallback with "* " prefix and the new content in m.new_content
+func (m *ReplaceMessage) AsJSON() map[string]any {
+	content := m.Message.AsJSON()
+	if content == nil {
+		return nil
+	}
+
+	// relations of the edited message can't be changed
+	delete(content, "m.relates_to")
+
+	newContent := make(map[string]any, len(content))
+	edit := make(map[string]any, len(content)+2)
+	for k, v := range content {
+		newContent[k] = v
+		edit[k] = v
+	}
+
+	if body, ok := content["body"].(string); ok {
+		edit["body"] = "* " + body
+	}
+	if formatted, ok := content["formatted_body"].(string); ok {
+		edit["formatted_body"] = "* " + formatted
+	}
+
+	edit["m.new_content"] = newContent
+	edit["m.relates_to"] = map[string]any{
+		"rel_type": event.RelReplace,
+		"event_id": m.target,
+	}
+
+	return edit
+}
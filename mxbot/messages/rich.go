@@ -0,0 +1,338 @@
+package messages
+
+import (
+	"encoding/json"
+	"html"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/gomarkdown/markdown"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var _ Message = (*RichMessage)(nil)
+
+// RichMessage - builder of formatted messages.
+// Every part is added both to the HTML (formatted_body) and to the plain text fallback (body).
+// User pills fill m.mentions, so the mentioned users are notified
+//
+//	msg := messages.NewRich().
+//		UserPill(userID, "Alice").Text(", here is the result:").Line().
+//		CodeBlock("go", code).
+//		Spoiler("42", "answer")
+type RichMessage struct {
+	msgType event.MessageType
+
+	html  strings.Builder
+	plain strings.Builder
+
+	mentions event.Mentions
+
+	rel          *event.RelatesTo
+	customFields map[string]any
+}
+
+// NewRich - creates a formatted text message (m.text)
+func NewRich() *RichMessage {
+	return &RichMessage{msgType: event.MsgText}
+}
+
+// NewNotice - creates a formatted notice (m.notice). Notices are meant for automated messages: bots don't answer them
+func NewNotice() *RichMessage {
+	return &RichMessage{msgType: event.MsgNotice}
+}
+
+// NewEmote - creates a formatted emote (m.emote), displayed as an action: "* bot is thinking"
+func NewEmote() *RichMessage {
+	return &RichMessage{msgType: event.MsgEmote}
+}
+
+// Text - adds the plain text (it is escaped in HTML)
+func (m *RichMessage) Text(text string) *RichMessage {
+	m.html.WriteString(escapeText(text))
+	m.plain.WriteString(text)
+	return m
+}
+
+// Line - adds the line break
+func (m *RichMessage) Line() *RichMessage {
+	m.html.WriteString("<br/>")
+	m.plain.WriteString("\n")
+	return m
+}
+
+// Bold - adds the bold text
+func (m *RichMessage) Bold(text string) *RichMessage {
+	return m.wrap("strong", text, text)
+}
+
+// Italic - adds the italic text
+func (m *RichMessage) Italic(text string) *RichMessage {
+	return m.wrap("em", text, text)
+}
+
+// Strike - adds the strikethrough text
+func (m *RichMessage) Strike(text string) *RichMessage {
+	return m.wrap("del", text, text)
+}
+
+// Code - adds the inline code
+func (m *RichMessage) Code(code string) *RichMessage {
+	return m.wrap("code", code, "`"+code+"`")
+}
+
+// Link - adds the link. Only safe schemes (http, https, mailto, ...) are allowed, otherwise the text is added
+func (m *RichMessage) Link(url, text string) *RichMessage {
+	if text == "" {
+		text = url
+	}
+	if !allowedAttrValue("a", "href", url) {
+		return m.Text(text)
+	}
+
+	m.html.WriteString(`<a href="` + html.EscapeString(url) + `">` + escapeText(text) + `</a>`)
+	if text == url {
+		m.plain.WriteString(text)
+	} else {
+		m.plain.WriteString(text + " (" + url + ")")
+	}
+	return m
+}
+
+// CodeBlock - adds the code block. lang is the language of the code for highlighting (optional)
+func (m *RichMessage) CodeBlock(lang, code string) *RichMessage {
+	code = strings.TrimSuffix(code, "\n")
+
+	m.html.WriteString("<pre><code")
+	if lang != "" {
+		m.html.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+	}
+	m.html.WriteString(">" + html.EscapeString(code) + "</code></pre>")
+
+	m.blockPlain("```" + lang + "\n" + code + "\n```")
+	return m
+}
+
+// Quote - adds the block quote
+func (m *RichMessage) Quote(text string) *RichMessage {
+	m.html.WriteString("<blockquote>" + escapeText(text) + "</blockquote>")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = "> " + line
+	}
+	m.blockPlain(strings.Join(lines, "\n"))
+	return m
+}
+
+// Table - adds the table. The header is optional
+func (m *RichMessage) Table(header []string, rows ...[]string) *RichMessage {
+	m.html.WriteString("<table>")
+
+	plain := make([]string, 0, len(rows)+2)
+	if len(header) > 0 {
+		m.html.WriteString("<thead><tr>")
+		for _, cell := range header {
+			m.html.WriteString("<th>" + escapeText(cell) + "</th>")
+		}
+		m.html.WriteString("</tr></thead>")
+
+		plain = append(plain, strings.Join(header, " | "))
+		sep := make([]string, len(header))
+		for i, cell := range header {
+			sep[i] = strings.Repeat("-", max(len([]rune(cell)), 3))
+		}
+		plain = append(plain, strings.Join(sep, " | "))
+	}
+
+	m.html.WriteString("<tbody>")
+	for _, row := range rows {
+		m.html.WriteString("<tr>")
+		for _, cell := range row {
+			m.html.WriteString("<td>" + escapeText(cell) + "</td>")
+		}
+		m.html.WriteString("</tr>")
+
+		plain = append(plain, strings.Join(row, " | "))
+	}
+	m.html.WriteString("</tbody></table>")
+
+	m.blockPlain(strings.Join(plain, "\n"))
+	return m
+}
+
+// UserPill - adds the pill (link) of the user and mentions the user (m.mentions).
+// The display name is used as the text of the pill (user id if empty)
+func (m *RichMessage) UserPill(userID id.UserID, displayName string) *RichMessage {
+	if displayName == "" {
+		displayName = userID.String()
+	}
+
+	m.html.WriteString(`<a href="` + html.EscapeString(userID.URI().MatrixToURL()) + `">` + escapeText(displayName) + `</a>`)
+	m.plain.WriteString(displayName)
+
+	if !slices.Contains(m.mentions.UserIDs, userID) {
+		m.mentions.UserIDs = append(m.mentions.UserIDs, userID)
+	}
+	return m
+}
+
+// RoomPill - adds the pill (link) of the room. ref is the room id (!room:server) or the alias (#alias:server).
+// The ref is used as the text of the pill if text is empty
+func (m *RichMessage) RoomPill(ref string, text string) *RichMessage {
+	if text == "" {
+		text = ref
+	}
+
+	var uri *id.MatrixURI
+	if strings.HasPrefix(ref, "#") {
+		uri = id.RoomAlias(ref).URI()
+	} else {
+		uri = id.RoomID(ref).URI()
+	}
+
+	m.html.WriteString(`<a href="` + html.EscapeString(uri.MatrixToURL()) + `">` + escapeText(text) + `</a>`)
+	m.plain.WriteString(text)
+	return m
+}
+
+// MentionRoom - adds @room and notifies everyone in the room (requires the power level for @room)
+func (m *RichMessage) MentionRoom() *RichMessage {
+	m.mentions.Room = true
+	return m.Text("@room")
+}
+
+// Spoiler - adds the hidden text. The reason is shown instead of the text (optional)
+func (m *RichMessage) Spoiler(text, reason string) *RichMessage {
+	m.html.WriteString(`<span data-mx-spoiler="` + html.EscapeString(reason) + `">` + escapeText(text) + `</span>`)
+	m.plain.WriteString(spoilerPrefix(reason) + text)
+	return m
+}
+
+// HTML - adds the HTML. It is sanitized (see SanitizeHTML), the plain text is generated from it
+func (m *RichMessage) HTML(s string) *RichMessage {
+	sanitized := SanitizeHTML(s)
+	m.html.WriteString(sanitized)
+	m.plain.WriteString(HTMLToPlain(sanitized))
+	return m
+}
+
+// Markdown - adds the markdown rendered to HTML (see HTML)
+func (m *RichMessage) Markdown(md string) *RichMessage {
+	return m.HTML(renderMarkdown(md))
+}
+
+func (m *RichMessage) wrap(tag, text, plain string) *RichMessage {
+	m.html.WriteString("<" + tag + ">" + escapeText(text) + "</" + tag + ">")
+	m.plain.WriteString(plain)
+	return m
+}
+
+// blockPlain - adds the block to the plain text on its own lines
+func (m *RichMessage) blockPlain(block string) {
+	if m.plain.Len() > 0 && !strings.HasSuffix(m.plain.String(), "\n") {
+		m.plain.WriteString("\n")
+	}
+	m.plain.WriteString(block)
+	m.plain.WriteString("\n")
+}
+
+// Body - returns the plain text fallback
+func (m *RichMessage) Body() string {
+	return strings.TrimRight(m.plain.String(), "\n")
+}
+
+// FormattedBody - returns the HTML
+func (m *RichMessage) FormattedBody() string {
+	return m.html.String()
+}
+
+func (m *RichMessage) Type() event.MessageType {
+	return m.msgType
+}
+
+func (m *RichMessage) AsEvent() event.MessageEventContent {
+	evt := event.MessageEventContent{
+		MsgType:       m.msgType,
+		Body:          m.Body(),
+		Format:        event.FormatHTML,
+		FormattedBody: m.FormattedBody(),
+	}
+
+	mentions := m.mentions
+	evt.Mentions = &mentions
+
+	if m.rel != nil {
+		evt.RelatesTo = m.rel
+	}
+
+	return evt
+}
+
+func (m *RichMessage) AsJSON() map[string]any {
+	var result = make(map[string]any)
+
+	d, err := json.Marshal(m.AsEvent())
+	if err != nil {
+		slog.Error("failed to marshal message", "error", err)
+		return nil
+	}
+
+	if err := json.Unmarshal(d, &result); err != nil {
+		slog.Error("failed to unmarshal message", "error", err)
+		return nil
+	}
+
+	for k, v := range m.customFields {
+		result[k] = v
+	}
+
+	return result
+}
+
+// AsReqUpload - rich messages have no media
+func (m *RichMessage) AsReqUpload() mautrix.ReqUploadMedia {
+	return mautrix.ReqUploadMedia{}
+}
+
+// SetContentURI - rich messages have no media
+func (m *RichMessage) SetContentURI(id.ContentURI) {}
+
+func (m *RichMessage) SetRelatesTo(rel *event.RelatesTo) {
+	m.rel = rel
+}
+
+func (m *RichMessage) AddCustomFields(fields ...CustomField) {
+	if m.customFields == nil {
+		m.customFields = make(map[string]any)
+	}
+
+	for _, f := range fields {
+		m.customFields[f.Key] = f.Value
+	}
+}
+
+// SetMarkDownSupport - the rich message is always formatted
+func (m *RichMessage) SetMarkDownSupport(bool) {}
+
+// escapeText - escapes the text for HTML and keeps the line breaks
+func escapeText(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br/>")
+}
+
+// renderMarkdown - renders the markdown to HTML without the wrapping paragraph of a single line
+func renderMarkdown(md string) string {
+	rendered := strings.TrimSpace(string(markdown.ToHTML([]byte(md), nil, nil)))
+
+	inner, ok := strings.CutPrefix(rendered, "<p>")
+	if ok {
+		inner, ok = strings.CutSuffix(inner, "</p>")
+	}
+	if ok && !strings.Contains(inner, "<p>") {
+		return inner
+	}
+	return rendered
+}
@@ -0,0 +1,230 @@
+package messages
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedTags - HTML tags allowed in the formatted body (Matrix spec) with their allowed attributes
+var allowedTags = map[string][]string{
+	"font": {"data-mx-bg-color", "data-mx-color", "color"},
+	"span": {"data-mx-bg-color", "data-mx-color", "data-mx-spoiler"},
+	"a":    {"name", "target", "href"},
+	"img":  {"width", "height", "alt", "title", "src"},
+	"ol":   {"start"},
+	"code": {"class"},
+
+	"del": nil, "h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"blockquote": nil, "p": nil, "ul": nil, "sup": nil, "sub": nil, "li": nil,
+	"b": nil, "i": nil, "u": nil, "strong": nil, "em": nil, "s": nil, "hr": nil, "br": nil,
+	"div": nil, "table": nil, "thead": nil, "tbody": nil, "tr": nil, "th": nil, "td": nil,
+	"caption": nil, "pre": nil, "details": nil, "summary": nil,
+}
+
+// droppedTags - tags that are removed with their content
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"head": true, "title": true, "mx-reply": true,
+}
+
+// allowedLinkSchemes - schemes allowed in a[href]
+var allowedLinkSchemes = map[string]bool{
+	"http": true, "https": true, "ftp": true, "mailto": true, "magnet": true, "matrix": true,
+}
+
+// SanitizeHTML - keeps only the tags and the attributes allowed by the Matrix spec.
+// Disallowed tags are unwrapped (their text is kept), scripts and styles are removed with their content,
+// links are limited to safe schemes and images to mxc:// sources
+func SanitizeHTML(s string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(s), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return html.EscapeString(s)
+	}
+
+	var sb strings.Builder
+	for _, n := range nodes {
+		sanitizeNode(&sb, n)
+	}
+	return sb.String()
+}
+
+func sanitizeNode(sb *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		sb.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		// comments, doctype
+		return
+	}
+
+	tag := strings.ToLower(n.Data)
+	if droppedTags[tag] {
+		return
+	}
+
+	attrs, allowed := allowedTags[tag]
+	if allowed {
+		sb.WriteString("<" + tag)
+		for _, a := range n.Attr {
+			if !containsFold(attrs, a.Key) || !allowedAttrValue(tag, a.Key, a.Val) {
+				continue
+			}
+			sb.WriteString(" " + strings.ToLower(a.Key) + `="` + html.EscapeString(a.Val) + `"`)
+		}
+		if tag == "br" || tag == "hr" || tag == "img" {
+			sb.WriteString("/>")
+			return
+		}
+		sb.WriteString(">")
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sanitizeNode(sb, c)
+	}
+
+	if allowed {
+		sb.WriteString("</" + tag + ">")
+	}
+}
+
+func allowedAttrValue(tag, key, val string) bool {
+	switch {
+	case tag == "a" && strings.EqualFold(key, "href"):
+		u, err := url.Parse(strings.TrimSpace(val))
+		return err == nil && allowedLinkSchemes[strings.ToLower(u.Scheme)]
+	case tag == "img" && strings.EqualFold(key, "src"):
+		return strings.HasPrefix(val, "mxc://")
+	case tag == "code" && strings.EqualFold(key, "class"):
+		return strings.HasPrefix(val, "language-")
+	}
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTMLToPlain - converts the formatted body to the plain text fallback (body):
+// tags are removed, blocks and line breaks become new lines, list items get "- " and table cells " | "
+func HTMLToPlain(s string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(s), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return s
+	}
+
+	var sb strings.Builder
+	for _, n := range nodes {
+		plainNode(&sb, n)
+	}
+
+	// collapse the empty lines left by the nested blocks
+	lines := strings.Split(sb.String(), "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimRight(line, " ")
+		if line == "" && len(out) > 0 && out[len(out)-1] == "" {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+func plainNode(sb *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		// new lines between the block tags are the formatting of the HTML source, not the text
+		if strings.TrimSpace(n.Data) == "" && strings.Contains(n.Data, "\n") && !insidePre(n) {
+			return
+		}
+		sb.WriteString(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	tag := strings.ToLower(n.Data)
+	if droppedTags[tag] {
+		return
+	}
+
+	switch tag {
+	case "br":
+		sb.WriteString("\n")
+		return
+	case "hr":
+		sb.WriteString("\n---\n")
+		return
+	case "img":
+		for _, a := range n.Attr {
+			if a.Key == "alt" {
+				sb.WriteString(a.Val)
+			}
+		}
+		return
+	case "span":
+		for _, a := range n.Attr {
+			if a.Key == "data-mx-spoiler" {
+				sb.WriteString(spoilerPrefix(a.Val))
+			}
+		}
+	case "li":
+		sb.WriteString("\n- ")
+	case "td", "th":
+		for prev := n.PrevSibling; prev != nil; prev = prev.PrevSibling {
+			if prev.Type == html.ElementNode {
+				sb.WriteString(" | ")
+				break
+			}
+		}
+	case "tr", "p", "div", "pre", "blockquote", "ul", "ol", "table", "details",
+		"h1", "h2", "h3", "h4", "h5", "h6":
+		sb.WriteString("\n")
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		plainNode(sb, c)
+	}
+
+	switch tag {
+	case "p", "div", "pre", "blockquote", "ul", "ol", "table", "details",
+		"h1", "h2", "h3", "h4", "h5", "h6":
+		sb.WriteString("\n")
+	}
+}
+
+func insidePre(n *html.Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && p.Data == "pre" {
+			return true
+		}
+	}
+	return false
+}
+
+// spoilerPrefix - plain text marker of the spoiler
+func spoilerPrefix(reason string) string {
+	if reason == "" {
+		return "[Spoiler] "
+	}
+	return "[Spoiler: " + reason + "] "
+}
@@ -0,0 +1,34 @@
+package messages
+
+import (
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// NewVideo - creates a new video message. The MIME type, dimensions and duration are detected from the content (mp4, webm)
+// Video - video bytes
+// Text - message text (optional: take first argument if set). Default: video_YYYY-MM-DD_HH-MM-SS.<ext>
+func NewVideo(video []byte, text ...string) Message {
+	mimeType := detectMediaType(video, kindVideo, "video/mp4")
+	name := defaultFileName(kindVideo, mimeType)
+
+	t := name
+	if len(text) > 0 {
+		t = text[0]
+	}
+
+	file := &FileInfo{
+		fileName:     name,
+		mimeType:     mimeType,
+		contentBytes: video,
+		contentURI:   id.ContentURI{},
+	}
+	analyzeMedia(file, kindVideo)
+
+	return &BaseMessage{
+		text:            t,
+		msgType:         event.MsgVideo,
+		file:            file,
+		markDownSupport: MarkDownSupportDefault,
+	}
+}
@@ -0,0 +1,221 @@
+package messages
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// videoInfo - dimensions and duration of the video (MP4 or WebM). Unknown formats return zeros
+func videoInfo(data []byte, mimeType string) (width, height int, duration time.Duration) {
+	switch mimeType {
+	case "video/mp4", "video/quicktime":
+		return mp4Info(data)
+	case "video/webm", "video/x-matroska":
+		return webmInfo(data)
+	}
+	return 0, 0, 0
+}
+
+// maxContainerDepth - nesting of the boxes (elements) read by the parsers. The headers are a few levels deep,
+// deeper nesting is a broken or a malicious file
+const maxContainerDepth = 8
+
+// mp4Info - reads the movie header (mvhd) and the first track with the dimensions (tkhd)
+func mp4Info(data []byte) (width, height int, duration time.Duration) {
+	var walk func(box []byte, depth int)
+	walk = func(box []byte, depth int) {
+		if depth > maxContainerDepth {
+			return
+		}
+
+		for pos := 0; pos+8 <= len(box); {
+			size := uint64(binary.BigEndian.Uint32(box[pos:]))
+			kind := string(box[pos+4 : pos+8])
+			header := uint64(8)
+
+			switch size {
+			case 0: // the box runs to the end
+				size = uint64(len(box) - pos)
+			case 1: // 64-bit size
+				if pos+16 > len(box) {
+					return
+				}
+				size = binary.BigEndian.Uint64(box[pos+8:])
+				header = 16
+			}
+			if size < header || size > uint64(len(box)-pos) {
+				size = uint64(len(box) - pos) // truncated box: read what we have
+			}
+			payload := box[pos+int(header) : pos+int(size)]
+
+			switch kind {
+			case "moov", "trak":
+				walk(payload, depth+1)
+			case "mvhd":
+				duration = mvhdDuration(payload)
+			case "tkhd":
+				if w, h := tkhdSize(payload); width == 0 && w > 0 && h > 0 {
+					width, height = w, h
+				}
+			}
+
+			pos += int(size)
+		}
+	}
+	walk(data, 0)
+
+	return width, height, duration
+}
+
+// mvhdDuration - duration of the movie header box (version 0 or 1)
+func mvhdDuration(p []byte) time.Duration {
+	var timescale, units uint64
+	switch {
+	case len(p) >= 20 && p[0] == 0:
+		timescale = uint64(binary.BigEndian.Uint32(p[12:]))
+		units = uint64(binary.BigEndian.Uint32(p[16:]))
+	case len(p) >= 32 && p[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(p[20:]))
+		units = binary.BigEndian.Uint64(p[24:])
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(units) / float64(timescale) * float64(time.Second))
+}
+
+// tkhdSize - width and height (16.16 fixed point) of the track header box. Audio tracks have zeros
+func tkhdSize(p []byte) (int, int) {
+	offset := 76
+	if len(p) > 0 && p[0] == 1 {
+		offset = 88
+	}
+	if len(p) < offset+8 {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint32(p[offset:]) >> 16), int(binary.BigEndian.Uint32(p[offset+4:]) >> 16)
+}
+
+// EBML (Matroska/WebM) element IDs
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654AE6B
+	ebmlTrackEntry    = 0xAE
+	ebmlVideo         = 0xE0
+	ebmlPixelWidth    = 0xB0
+	ebmlPixelHeight   = 0xBA
+	ebmlCluster       = 0x1F43B675
+)
+
+// webmInfo - reads the duration (Segment/Info) and the dimensions of the first video track (Segment/Tracks)
+func webmInfo(data []byte) (width, height int, duration time.Duration) {
+	var (
+		timecodeScale uint64 = 1_000_000 // default: 1 ms
+		rawDuration   float64
+	)
+
+	var walk func(buf []byte, depth int) bool
+	walk = func(buf []byte, depth int) bool {
+		if depth > maxContainerDepth {
+			return true
+		}
+
+		for pos := 0; pos < len(buf); {
+			id, idLen := ebmlVint(buf[pos:], false)
+			if idLen == 0 {
+				return true
+			}
+			size, sizeLen := ebmlVint(buf[pos+idLen:], true)
+			if sizeLen == 0 {
+				return true
+			}
+
+			start := pos + idLen + sizeLen
+			end := len(buf) // unknown size: the element runs to the end
+			if size >= 0 && size <= int64(len(buf)-start) {
+				end = start + int(size)
+			}
+			payload := buf[start:end]
+
+			switch id {
+			case ebmlCluster:
+				return false // the media data: all the headers are read
+			case ebmlSegment, ebmlInfo, ebmlTracks, ebmlTrackEntry, ebmlVideo:
+				if !walk(payload, depth+1) {
+					return false
+				}
+			case ebmlTimecodeScale:
+				timecodeScale = ebmlUint(payload)
+			case ebmlDuration:
+				rawDuration = ebmlFloat(payload)
+			case ebmlPixelWidth:
+				if width == 0 {
+					width = int(ebmlUint(payload))
+				}
+			case ebmlPixelHeight:
+				if height == 0 {
+					height = int(ebmlUint(payload))
+				}
+			}
+
+			pos = end
+		}
+		return true
+	}
+	walk(data, 0)
+
+	return width, height, time.Duration(rawDuration * float64(timecodeScale))
+}
+
+// ebmlVint - reads the variable-length integer. The marker bit is kept in the IDs and removed in the sizes.
+// The size with all bits set (unknown size) is returned as -1
+func ebmlVint(b []byte, isSize bool) (int64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+
+	length := 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || length > len(b) {
+		return 0, 0
+	}
+
+	value := int64(b[0])
+	if isSize {
+		value &= int64(0xFF >> length)
+	}
+	allOnes := value == int64(0xFF>>length)
+	for _, c := range b[1:length] {
+		value = value<<8 | int64(c)
+		allOnes = allOnes && c == 0xFF
+	}
+
+	if isSize && allOnes {
+		return -1, length
+	}
+	return value, length
+}
+
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func ebmlFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
@@ -1,6 +1,7 @@
 package mxbot
 
 import (
+	"context"
 	"fmt"
 
 	"maunium.net/go/mautrix/event"
@@ -10,11 +11,13 @@ import (
 	domctx "github.com/tensved/bobrix/mxbot/domain/ctx"
 	domfilters "github.com/tensved/bobrix/mxbot/domain/filters"
 	domhandlers "github.com/tensved/bobrix/mxbot/domain/handlers"
+	domroomconfig "github.com/tensved/bobrix/mxbot/domain/roomconfig"
 	domthreads "github.com/tensved/bobrix/mxbot/domain/threads"
 
 	infracfg "github.com/tensved/bobrix/mxbot/infrastructure/matrix/config"
 	infrabot "github.com/tensved/bobrix/mxbot/infrastructure/matrix/constructor"
 	infrathreads "github.com/tensved/bobrix/mxbot/infrastructure/matrix/threads"
+	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg/migrations"
 )
 
 type Bot = dombot.FullBot
@@ -28,11 +31,51 @@ type Config = infrabot.Config
 type BotCredentials = infracfg.BotCredentials
 type Ctx = domctx.Ctx
 type BotOptions = applbot.BotOptions
+type RoomConfig = domroomconfig.Config
+type StateStore = dombot.StateStore
+type StateStorage = infrabot.StateStorage
+type DeduperBackend = infrabot.DeduperBackend
+type CryptoStorage = infrabot.CryptoStorage
+type QueueBackend = infrabot.QueueBackend
+type QueueOrdering = infrabot.QueueOrdering
+type DeadLetter = dombot.DeadLetter
+type LeaderElector = dombot.LeaderElector
+
+const (
+	StateStorageFile     = infrabot.StateStorageFile
+	StateStoragePostgres = infrabot.StateStoragePostgres
+
+	DeduperPostgres = infrabot.DeduperPostgres
+	DeduperSQLite   = infrabot.DeduperSQLite
+	DeduperMemory   = infrabot.DeduperMemory
+
+	CryptoStorageFile     = infrabot.CryptoStorageFile
+	CryptoStoragePostgres = infrabot.CryptoStoragePostgres
+
+	QueueMemory   = infrabot.QueueMemory
+	QueuePostgres = infrabot.QueuePostgres
+
+	OrderByRoom   = infrabot.OrderByRoom
+	OrderByThread = infrabot.OrderByThread
+	OrderNone     = infrabot.OrderNone
+)
+
+// RoomConfigEventType - room state event type that carries RoomConfig
+var RoomConfigEventType = domroomconfig.StateEventType
 
 var MetadataKeyContext = domctx.MetadataKeyContext
 
+// ErrLeadershipLost - Ping of the replica that has lost the leadership (see Config.ExitOnLeadershipLost)
+var ErrLeadershipLost = dombot.ErrLeadershipLost
+
 const AnswerToCustomField = domctx.AnswerToCustomField
 
+// Retryable - marks the error of the event handler as transient: the event is retried with the backoff
+// (see Config.QueueMaxAttempts). Other errors of the handlers are not retried
+func Retryable(err error) error {
+	return dombot.Retryable(err)
+}
+
 func NewMatrixBot(cfg Config, opts ...applbot.BotOptions) (*applbot.DefaultBot, error) {
 	facade, err := infrabot.NewMatrixBot(cfg)
 	if err != nil {
@@ -48,6 +91,17 @@ func NewMatrixBot(cfg Config, opts ...applbot.BotOptions) (*applbot.DefaultBot,
 	), nil
 }
 
+// Migrate - applies the pending schema migrations of the bobrix tables (dedup, bot state, quotas, audit).
+// Concurrent calls are serialized by the advisory lock. See also Config.Migrate
+func Migrate(ctx context.Context, db migrations.TxBeginner) ([]migrations.Migration, error) {
+	return migrations.Apply(ctx, db)
+}
+
+// MigrationsSQL - the schema migrations as one SQL script (for the external migration tools)
+func MigrationsSQL() (string, error) {
+	return migrations.SQL()
+}
+
 func WithDisplayName(name string) BotOptions {
 	return applbot.WithDisplayName(name)
 }
@@ -5,6 +5,8 @@ import (
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
+
+	domevents "github.com/tensved/bobrix/mxbot/domain/events"
 )
 
 func TextCommand(
@@ -27,6 +29,25 @@ func TextCommand(
 	)
 }
 
+type Reaction = domevents.Reaction
+type Redaction = domevents.Redaction
+type Edit = domevents.Edit
+
+// AsReaction - returns the reaction (key and target event) if the event is m.reaction
+func AsReaction(evt *event.Event) (*Reaction, bool) {
+	return domevents.AsReaction(evt)
+}
+
+// AsRedaction - returns the redaction (redacted event and reason) if the event is m.room.redaction
+func AsRedaction(evt *event.Event) (*Redaction, bool) {
+	return domevents.AsRedaction(evt)
+}
+
+// AsEdit - returns the edit (edited message and new content) if the event is a message with m.replace relation
+func AsEdit(evt *event.Event) (*Edit, bool) {
+	return domevents.AsEdit(evt)
+}
+
 func AsMatrixClient(raw any) (*mautrix.Client, bool) {
 	c, ok := raw.(*mautrix.Client)
 	return c, ok
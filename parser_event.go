@@ -2,14 +2,9 @@ package bobrix
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"encoding/base64"
 	"encoding/json"
-	"fmt"
 	"log/slog"
 	"regexp"
-	"slices"
 
 	"github.com/google/uuid"
 	"github.com/tensved/bobrix/mxbot"
@@ -123,57 +118,27 @@ func DefaultContractParser(bot mxbot.Bot) ContractParser {
 			}
 		}
 
-		return &ServiceRequest{
+		req := &ServiceRequest{
 			ServiceID:   serviceID,
 			ServiceName: groups["service"], // legacy
 			MethodName:  groups["method"],
 			InputParams: inputsData,
 		}
-	}
-}
 
-type AudioMessageParserOpts struct {
-	Downloader  Downloader
-	ServiceID   uuid.UUID
-	ServiceName string // optional/legacy
-	MethodName  string
-	InputName   string
-}
-
-// AudioMessageContractParser - audio message contract parser
-// It is required to specify strictly the name of the service and method, as well as the name for Input,
-// because when sending a voice message there is no possibility to specify parameters by text
-func AudioMessageContractParser(opts *AudioMessageParserOpts) ContractParser {
-	if opts == nil {
-		return nil
-	}
-
-	filters := []mxbot.Filter{
-		mxbot.FilterMessageAudio(),
-	}
-
-	return func(evt *event.Event) *ServiceRequest {
-		for _, filter := range filters {
-			if !filter(evt) {
-				return nil
+		// room settings are applied only to explicit requests (method or inputs are set),
+		// otherwise every mention of the bot would become a request
+		if req.MethodName != "" || len(req.InputParams) > 0 {
+			req.roomDefaults = func(cfg *mxbot.RoomConfig) {
+				if req.ServiceID == "" && req.ServiceName == "" && cfg.DefaultService != "" {
+					req.setService(cfg.DefaultService)
+				}
+				if req.MethodName == "" && cfg.DefaultMethod != "" {
+					req.MethodName = cfg.DefaultMethod
+				}
 			}
 		}
 
-		audioData, err := downloadAudioMessage(opts.Downloader, evt)
-		if err != nil {
-			slog.Error("failed to download audio message", "error", err)
-			return nil
-		}
-
-		inputData := make(map[string]any, 1)
-		inputData[opts.InputName] = audioData
-
-		return &ServiceRequest{
-			ServiceID:   opts.ServiceID.String(),
-			ServiceName: opts.ServiceName, // optional
-			MethodName:  opts.MethodName,
-			InputParams: inputData,
-		}
+		return req
 	}
 }
 
@@ -211,189 +176,39 @@ func AutoRequestParser(opts *AutoParserOpts) ContractParser {
 
 		msg := evt.Content.AsMessage().Body
 
-		inputData := make(map[string]any, 1)
-		inputData[opts.InputName] = msg
-
-		return &ServiceRequest{
+		req := &ServiceRequest{
 			ServiceID:   opts.ServiceID.String(),
 			ServiceName: opts.ServiceName, // optional
 			MethodName:  opts.MethodName,
-			InputParams: inputData,
-		}
-	}
-}
-
-type ImageMessageParserOpts struct {
-	Downloader  mxbot.BotMedia
-	ServiceID   uuid.UUID
-	ServiceName string // optional/legacy
-	MethodName  string
-	InputName   string
-}
-
-// ImageMessageContractParser - image message contract parser
-// It is required to specify strictly the name of the service and method, as well as the name for Input
-// because when sending an image there is no possibility to specify parameters by text
-func ImageMessageContractParser(opts *ImageMessageParserOpts) func(evt *event.Event) *ServiceRequest {
-	if opts == nil {
-		return func(evt *event.Event) *ServiceRequest { return nil }
-	}
-
-	return func(evt *event.Event) *ServiceRequest {
-		if evt.Type != event.EventMessage {
-			return nil
-		}
-		if evt.Content.AsMessage().MsgType != event.MsgImage {
-			return nil
-		}
-
-		imageData, err := downloadImageMessage(opts.Downloader, evt)
-		if err != nil {
-			slog.Error("failed to download image message", "error", err)
-			return nil
 		}
 
-		inputData := make(map[string]any, 1)
-		inputData[opts.InputName] = imageData
+		req.InputParams = map[string]any{opts.InputName: msg}
 
-		return &ServiceRequest{
-			ServiceID:   opts.ServiceID.String(),
-			ServiceName: opts.ServiceName, // optional
-			MethodName:  opts.MethodName,
-			InputParams: inputData,
+		// room settings (io.bobrix.config) override the values of the bot
+		req.roomDefaults = func(cfg *mxbot.RoomConfig) {
+			if cfg.DefaultService != "" {
+				req.setService(cfg.DefaultService)
+			}
+			if cfg.DefaultMethod != "" {
+				req.MethodName = cfg.DefaultMethod
+			}
+			if cfg.DefaultInput != "" {
+				req.InputParams = map[string]any{cfg.DefaultInput: msg}
+			}
 		}
-	}
-}
-
-// downloadAudioMessage - download audio message
-// It returns base64 encoded audio data
-func downloadAudioMessage(bot Downloader, evt *event.Event) (string, error) {
-	allowedMimeTypes := []string{
-		"audio/webm",
-		"audio/ogg",
-		"audio/mpeg",
-	}
-
-	return downloadMediaMessage(bot, evt, allowedMimeTypes)
-}
-
-// downloadImageMessage - download image message
-// It returns base64 encoded image data
-func downloadImageMessage(bot Downloader, evt *event.Event) (string, error) {
-	allowedMimeTypes := []string{
-		"image/jpeg",
-		"image/png",
-		"image/gif",
-	}
-
-	return downloadMediaMessage(bot, evt, allowedMimeTypes)
-}
-
-// decryptMatrixFile decrypts a file encrypted using the Matrix protocol
-func decryptMatrixFile(data []byte, fileInfo map[string]interface{}) ([]byte, error) {
-	// Getting information about the encrypted file
-	iv, ok := fileInfo["iv"].(string)
-	if !ok {
-		return nil, fmt.Errorf("iv not found in file info")
-	}
-
-	key, ok := fileInfo["key"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("key not found in file info")
-	}
-
-	k, ok := key["k"].(string)
-	if !ok {
-		return nil, fmt.Errorf("key.k not found in file info")
-	}
-
-	// Checking the protocol version
-	version, _ := fileInfo["v"].(string)
-	if version != "v2" {
-		return nil, fmt.Errorf("unsupported encryption version: %s", version)
-	}
-
-	// Checking the algorithm
-	alg, _ := key["alg"].(string)
-	if alg != "A256CTR" {
-		return nil, fmt.Errorf("unsupported encryption algorithm: %s", alg)
-	}
-
-	// Decoding base64 with URL-safe format support
-	ivBytes, err := base64.RawURLEncoding.DecodeString(iv)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode iv: %w", err)
-	}
-
-	keyBytes, err := base64.RawURLEncoding.DecodeString(k)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode key: %w", err)
-	}
 
-	// Create an AES cipher
-	block, err := aes.NewCipher(keyBytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return req
 	}
-
-	// Create a CTR mode
-	stream := cipher.NewCTR(block, ivBytes)
-
-	// Расшифровываем данные
-	decrypted := make([]byte, len(data))
-	stream.XORKeyStream(decrypted, data)
-
-	return decrypted, nil
 }
 
-// downloadMediaMessage - download media message
-// It returns base64 encoded media data and checks if the mime type is allowed
-func downloadMediaMessage(bot Downloader, evt *event.Event, allowedMimeTypes []string) (string, error) {
-	ctx := context.Background()
-
-	info := evt.Content.Raw["info"].(map[string]interface{})
-	mimeType := info["mimetype"].(string)
-
-	if !slices.Contains(allowedMimeTypes, mimeType) {
-		return "", fmt.Errorf("%w: %s", ErrInappropriateMimeType, mimeType)
-	}
-
-	var url string
-	var fileInfo map[string]interface{}
-
-	if file, ok := evt.Content.Raw["file"].(map[string]interface{}); ok {
-		// if evt was decrypted
-		url, ok = file["url"].(string)
-		if !ok {
-			return "", fmt.Errorf("%w: url not found in file structure", ErrDownloadFile)
-		}
-		fileInfo = file
-	} else {
-		// if evt wasn't encrypted
-		url, ok = evt.Content.Raw["url"].(string)
-		if !ok {
-			return "", fmt.Errorf("%w: url not found in message content", ErrDownloadFile)
-		}
-	}
-
-	mxcURI, err := id.ContentURIString(url).Parse()
-	if err != nil {
-		return "", fmt.Errorf("%w: %s", ErrParseMXCURI, err)
-	}
-
-	data, err := bot.Download(ctx, mxcURI)
-	if err != nil {
-		return "", fmt.Errorf("%w: %s", ErrDownloadFile, err)
-	}
-
-	// If the file is encrypted, decrypt it
-	if fileInfo != nil {
-		data, err = decryptMatrixFile(data, fileInfo)
-		if err != nil {
-			return "", fmt.Errorf("%w: failed to decrypt file: %v", ErrDownloadFile, err)
-		}
+// setService - sets the service of the request. The value may be a service ID or a service name
+func (r *ServiceRequest) setService(service string) {
+	if _, err := uuid.Parse(service); err == nil {
+		r.ServiceID = service
+		r.ServiceName = ""
+		return
 	}
 
-	encoded := base64.StdEncoding.EncodeToString(data)
-	return encoded, nil
+	r.ServiceID = ""
+	r.ServiceName = service
 }
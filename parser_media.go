@@ -0,0 +1,272 @@
+package bobrix
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"maunium.net/go/mautrix/event"
+)
+
+// audioMimeTypes - default types of the audio messages accepted by AudioMessageContractParser
+var audioMimeTypes = []string{
+	"audio/webm",
+	"audio/ogg",
+	"audio/mpeg",
+}
+
+// imageMimeTypes - default types of the image messages accepted by ImageMessageContractParser
+var imageMimeTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+}
+
+// MediaMessageParserOpts - options of the media message contract parsers (audio, image, video, file, sticker).
+// It is required to specify strictly the name of the service and method, as well as the name for Input,
+// because the attachment has no text to specify parameters
+type MediaMessageParserOpts struct {
+	Downloader       Downloader
+	ServiceID        uuid.UUID
+	ServiceName      string // optional/legacy
+	MethodName       string
+	InputName        string    // input of the attachment
+	CaptionInputName string    // optional. Text input of the caption of the attachment
+	Media            MediaOpts // limits of the attachment. AllowedMimeTypes are the types accepted by the input
+}
+
+// AudioMessageParserOpts - options of AudioMessageContractParser
+type AudioMessageParserOpts = MediaMessageParserOpts
+
+// ImageMessageParserOpts - options of ImageMessageContractParser
+type ImageMessageParserOpts = MediaMessageParserOpts
+
+// MediaMessageContractParser - contract parser of the messages with the attachment of the given type
+// (m.audio, m.image, m.video, m.file). The attachment is bound to InputName, the caption of the attachment
+// is bound to CaptionInputName. The attachment is downloaded (see IngestMedia) only when the request
+// is claimed and allowed: the declared size and type are checked by the parser
+func MediaMessageContractParser(msgType event.MessageType, opts *MediaMessageParserOpts) ContractParser {
+	if opts == nil {
+		return func(evt *event.Event) *ServiceRequest { return nil }
+	}
+
+	return func(evt *event.Event) *ServiceRequest {
+		if evt.Type != event.EventMessage {
+			return nil
+		}
+
+		msg := evt.Content.AsMessage()
+		if msg == nil || msg.MsgType != msgType {
+			return nil
+		}
+
+		return opts.request(evt, msg, mediaCaption(msg))
+	}
+}
+
+// AudioMessageContractParser - audio message contract parser (audio/webm, audio/ogg and audio/mpeg by default)
+func AudioMessageContractParser(opts *AudioMessageParserOpts) ContractParser {
+	return MediaMessageContractParser(event.MsgAudio, withDefaultMimeTypes(opts, audioMimeTypes))
+}
+
+// ImageMessageContractParser - image message contract parser (image/jpeg, image/png and image/gif by default)
+func ImageMessageContractParser(opts *ImageMessageParserOpts) ContractParser {
+	return MediaMessageContractParser(event.MsgImage, withDefaultMimeTypes(opts, imageMimeTypes))
+}
+
+// VideoMessageContractParser - video message contract parser (any video type by default)
+func VideoMessageContractParser(opts *MediaMessageParserOpts) ContractParser {
+	return MediaMessageContractParser(event.MsgVideo, opts)
+}
+
+// FileMessageContractParser - file message contract parser.
+// Set Media.AllowedMimeTypes to accept only the files of the given types
+func FileMessageContractParser(opts *MediaMessageParserOpts) ContractParser {
+	return MediaMessageContractParser(event.MsgFile, opts)
+}
+
+// StickerContractParser - sticker (m.sticker) contract parser.
+// The image of the sticker is bound to InputName, its description to CaptionInputName
+func StickerContractParser(opts *MediaMessageParserOpts) ContractParser {
+	if opts == nil {
+		return func(evt *event.Event) *ServiceRequest { return nil }
+	}
+
+	return func(evt *event.Event) *ServiceRequest {
+		if evt.Type != event.EventSticker {
+			return nil
+		}
+
+		msg := evt.Content.AsMessage()
+		if msg == nil {
+			return nil
+		}
+
+		return opts.request(evt, msg, msg.Body)
+	}
+}
+
+// withDefaultMimeTypes - copy of the options with the default allowed types (if the types are not set)
+func withDefaultMimeTypes(opts *MediaMessageParserOpts, mimeTypes []string) *MediaMessageParserOpts {
+	if opts == nil || len(opts.Media.AllowedMimeTypes) > 0 {
+		return opts
+	}
+
+	withTypes := *opts
+	withTypes.Media.AllowedMimeTypes = mimeTypes
+	return &withTypes
+}
+
+// request - builds the request with the pending attachment (see pendingMedia).
+// It returns nil if the declared size or type of the attachment can't be accepted
+func (opts *MediaMessageParserOpts) request(evt *event.Event, msg *event.MessageEventContent, caption string) *ServiceRequest {
+	if err := checkDeclaredMedia(msg, opts.Media); err != nil {
+		slog.Warn("media message is not accepted", "type", msg.MsgType, "event", evt.ID, "error", err)
+		return nil
+	}
+
+	inputData := make(map[string]any, 2)
+	inputData[opts.InputName] = &pendingMedia{evt: evt, downloader: opts.Downloader, opts: opts.Media}
+	if opts.CaptionInputName != "" && caption != "" {
+		inputData[opts.CaptionInputName] = caption
+	}
+
+	return &ServiceRequest{
+		ServiceID:   opts.ServiceID.String(),
+		ServiceName: opts.ServiceName, // optional
+		MethodName:  opts.MethodName,
+		InputParams: inputData,
+	}
+}
+
+// pendingMedia - attachment of the parsed request. It's downloaded by the claimed request
+// after the room settings and the access are checked (see Bobrix.handleRequest)
+type pendingMedia struct {
+	evt        *event.Event
+	downloader Downloader
+	opts       MediaOpts
+}
+
+// downloadPendingMedia - replaces the pending attachments of the inputs with the downloaded ones.
+// input is the name of the input that failed
+func downloadPendingMedia(ctx context.Context, inputData map[string]any) (input string, err error) {
+	for name, value := range inputData {
+		pending, ok := value.(*pendingMedia)
+		if !ok {
+			continue
+		}
+
+		media, err := IngestMedia(ctx, pending.downloader, pending.evt, pending.opts)
+		if err != nil {
+			delete(inputData, name)
+			return name, err
+		}
+		inputData[name] = media
+	}
+	return "", nil
+}
+
+// checkDeclaredMedia - checks the size and the type declared in the message before the download.
+// The downloaded content is checked again by IngestMedia
+func checkDeclaredMedia(msg *event.MessageEventContent, opts MediaOpts) error {
+	if msg.Info == nil {
+		return nil
+	}
+
+	opts = opts.withDefaults()
+	if int64(msg.Info.Size) > opts.MaxSize {
+		return fmt.Errorf("%w: %d bytes (max %d)", ErrMediaTooLarge, msg.Info.Size, opts.MaxSize)
+	}
+	if msg.Info.MimeType != "" && len(opts.AllowedMimeTypes) > 0 && !slices.Contains(opts.AllowedMimeTypes, msg.Info.MimeType) {
+		return fmt.Errorf("%w: %s", ErrInappropriateMimeType, msg.Info.MimeType)
+	}
+	return nil
+}
+
+// mediaCaption - caption of the attachment. The body is the caption if the message has the file name
+// and the body differs from it, otherwise the body is the file name
+func mediaCaption(msg *event.MessageEventContent) string {
+	if msg.FileName == "" || msg.Body == msg.FileName {
+		return ""
+	}
+	return msg.Body
+}
+
+// LocationParserOpts - options of LocationContractParser
+type LocationParserOpts struct {
+	ServiceID          uuid.UUID
+	ServiceName        string // optional/legacy
+	MethodName         string
+	LatitudeInputName  string // number input of the latitude
+	LongitudeInputName string // number input of the longitude
+	CaptionInputName   string // optional. Text input of the description of the location
+}
+
+// LocationContractParser - location (m.location) contract parser.
+// The geo URI of the location is parsed into the latitude and longitude inputs
+func LocationContractParser(opts *LocationParserOpts) ContractParser {
+	if opts == nil {
+		return func(evt *event.Event) *ServiceRequest { return nil }
+	}
+
+	return func(evt *event.Event) *ServiceRequest {
+		if evt.Type != event.EventMessage {
+			return nil
+		}
+
+		msg := evt.Content.AsMessage()
+		if msg == nil || msg.MsgType != event.MsgLocation {
+			return nil
+		}
+
+		lat, lon, err := ParseGeoURI(msg.GeoURI)
+		if err != nil {
+			slog.Error("failed to parse location message", "event", evt.ID, "error", err)
+			return nil
+		}
+
+		inputData := make(map[string]any, 3)
+		inputData[opts.LatitudeInputName] = lat
+		inputData[opts.LongitudeInputName] = lon
+		if opts.CaptionInputName != "" && msg.Body != "" {
+			inputData[opts.CaptionInputName] = msg.Body
+		}
+
+		return &ServiceRequest{
+			ServiceID:   opts.ServiceID.String(),
+			ServiceName: opts.ServiceName, // optional
+			MethodName:  opts.MethodName,
+			InputParams: inputData,
+		}
+	}
+}
+
+// ParseGeoURI - parses the geo URI (RFC 5870) of the location: geo:<lat>,<lon>[,<alt>][;u=<uncertainty>]
+func ParseGeoURI(uri string) (lat, lon float64, err error) {
+	scheme, rest, ok := strings.Cut(strings.TrimSpace(uri), ":")
+	if !ok || !strings.EqualFold(scheme, "geo") {
+		return 0, 0, fmt.Errorf("%w: %q is not a geo URI", ErrInvalidGeoURI, uri)
+	}
+
+	coords, _, _ := strings.Cut(rest, ";")
+	parts := strings.Split(coords, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, 0, fmt.Errorf("%w: invalid coordinates in %q", ErrInvalidGeoURI, uri)
+	}
+
+	lat, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("%w: invalid latitude in %q", ErrInvalidGeoURI, uri)
+	}
+
+	lon, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("%w: invalid longitude in %q", ErrInvalidGeoURI, uri)
+	}
+
+	return lat, lon, nil
+}
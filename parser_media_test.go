@@ -0,0 +1,235 @@
+package bobrix
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+)
+
+const testMediaURI = id.ContentURIString("mxc://example.org/media")
+
+var pngData = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// pendingInput - the expected value of the attachment input (see pendingMedia)
+const pendingInput = "<pending media>"
+
+func mediaEvent(evtType event.Type, msgType event.MessageType, body, fileName, mimeType string, size int) *event.Event {
+	return &event.Event{
+		ID:     "$media",
+		Type:   evtType,
+		RoomID: testRoomID,
+		Sender: testUserID,
+		Content: event.Content{Parsed: &event.MessageEventContent{
+			MsgType:  msgType,
+			Body:     body,
+			FileName: fileName,
+			URL:      testMediaURI,
+			Info:     &event.FileInfo{MimeType: mimeType, Size: size},
+		}},
+	}
+}
+
+func locationEvent(body, geoURI string) *event.Event {
+	return &event.Event{
+		ID:      "$location",
+		Type:    event.EventMessage,
+		RoomID:  testRoomID,
+		Sender:  testUserID,
+		Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgLocation, Body: body, GeoURI: geoURI}},
+	}
+}
+
+func TestMediaContractParsers(t *testing.T) {
+	opts := func(media MediaOpts) *MediaMessageParserOpts {
+		return &MediaMessageParserOpts{ServiceName: "ada", MethodName: "look", InputName: "media", CaptionInputName: "caption", Media: media}
+	}
+	location := LocationContractParser(&LocationParserOpts{
+		ServiceName:        "ada",
+		MethodName:         "where",
+		LatitudeInputName:  "lat",
+		LongitudeInputName: "lon",
+		CaptionInputName:   "caption",
+	})
+
+	tests := []struct {
+		name   string
+		parser ContractParser
+		evt    *event.Event
+		want   map[string]any // inputs of the request. nil - the event is not a request
+	}{
+		{
+			name:   "image without a caption",
+			parser: ImageMessageContractParser(opts(MediaOpts{})),
+			evt:    mediaEvent(event.EventMessage, event.MsgImage, "cat.png", "", "image/png", 100),
+			want:   map[string]any{"media": pendingInput},
+		},
+		{
+			name:   "image with a caption",
+			parser: ImageMessageContractParser(opts(MediaOpts{})),
+			evt:    mediaEvent(event.EventMessage, event.MsgImage, "what is it?", "cat.png", "image/png", 100),
+			want:   map[string]any{"media": pendingInput, "caption": "what is it?"},
+		},
+		{
+			name:   "message of another type",
+			parser: ImageMessageContractParser(opts(MediaOpts{})),
+			evt:    mediaEvent(event.EventMessage, event.MsgAudio, "voice.ogg", "", "audio/ogg", 100),
+		},
+		{
+			name:   "image type out of the defaults",
+			parser: ImageMessageContractParser(opts(MediaOpts{})),
+			evt:    mediaEvent(event.EventMessage, event.MsgImage, "cat.webp", "", "image/webp", 100),
+		},
+		{
+			name:   "audio of a default type",
+			parser: AudioMessageContractParser(opts(MediaOpts{})),
+			evt:    mediaEvent(event.EventMessage, event.MsgAudio, "voice.ogg", "", "audio/ogg", 100),
+			want:   map[string]any{"media": pendingInput},
+		},
+		{
+			name:   "file of an allowed type",
+			parser: FileMessageContractParser(opts(MediaOpts{AllowedMimeTypes: []string{"application/pdf"}})),
+			evt:    mediaEvent(event.EventMessage, event.MsgFile, "report.pdf", "", "application/pdf", 100),
+			want:   map[string]any{"media": pendingInput},
+		},
+		{
+			name:   "file of another type",
+			parser: FileMessageContractParser(opts(MediaOpts{AllowedMimeTypes: []string{"application/pdf"}})),
+			evt:    mediaEvent(event.EventMessage, event.MsgFile, "notes.txt", "", "text/plain", 100),
+		},
+		{
+			name:   "declared size over the limit",
+			parser: VideoMessageContractParser(opts(MediaOpts{MaxSize: 50})),
+			evt:    mediaEvent(event.EventMessage, event.MsgVideo, "clip.mp4", "", "video/mp4", 100),
+		},
+		{
+			name:   "sticker with its description",
+			parser: StickerContractParser(opts(MediaOpts{})),
+			evt:    mediaEvent(event.EventSticker, "", "smiling cat", "", "image/png", 100),
+			want:   map[string]any{"media": pendingInput, "caption": "smiling cat"},
+		},
+		{
+			name:   "sticker parser ignores images",
+			parser: StickerContractParser(opts(MediaOpts{})),
+			evt:    mediaEvent(event.EventMessage, event.MsgImage, "cat.png", "", "image/png", 100),
+		},
+		{
+			name:   "location",
+			parser: location,
+			evt:    locationEvent("home", "geo:55.75,37.61;u=35"),
+			want:   map[string]any{"lat": 55.75, "lon": 37.61, "caption": "home"},
+		},
+		{
+			name:   "location with an invalid geo URI",
+			parser: location,
+			evt:    locationEvent("home", "geo:255,37.61"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.parser(tt.evt)
+			if (req != nil) != (tt.want != nil) {
+				t.Fatalf("request = %+v, want inputs %v", req, tt.want)
+			}
+			if req == nil {
+				return
+			}
+
+			if len(req.InputParams) != len(tt.want) {
+				t.Errorf("inputs = %v, want %v", req.InputParams, tt.want)
+			}
+			for name, want := range tt.want {
+				got := req.InputParams[name]
+				if want == pendingInput {
+					if _, ok := got.(*pendingMedia); !ok {
+						t.Errorf("input %q = %T, want the pending media", name, got)
+					}
+					continue
+				}
+				if got != want {
+					t.Errorf("input %q = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseGeoURI(t *testing.T) {
+	tests := []struct {
+		uri      string
+		lat, lon float64
+		ok       bool
+	}{
+		{uri: "geo:55.75,37.61", lat: 55.75, lon: 37.61, ok: true},
+		{uri: "GEO:-33.9,18.4,12;u=20", lat: -33.9, lon: 18.4, ok: true},
+		{uri: "geo:55.75"},
+		{uri: "geo:91,0"},
+		{uri: "geo:0,181"},
+		{uri: "https://example.org"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			lat, lon, err := ParseGeoURI(tt.uri)
+			if tt.ok != (err == nil) || (!tt.ok && !errors.Is(err, ErrInvalidGeoURI)) {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if lat != tt.lat || lon != tt.lon {
+				t.Errorf("coordinates = %v,%v, want %v,%v", lat, lon, tt.lat, tt.lon)
+			}
+		})
+	}
+}
+
+func TestDownloadPendingMedia(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte // content of the attachment on the homeserver (nil - not found)
+		err     error
+	}{
+		{name: "downloaded", content: pngData},
+		{name: "content of another type", content: []byte("plain text, not an image"), err: ErrInappropriateMimeType},
+		{name: "download failed", err: ErrDownloadFile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := &testBot{files: map[id.ContentURI][]byte{}}
+			if tt.content != nil {
+				bot.files[testMediaURI.ParseOrIgnore()] = tt.content
+			}
+
+			parser := ImageMessageContractParser(&MediaMessageParserOpts{ServiceName: "ada", MethodName: "look", InputName: "image", Downloader: bot})
+			req := parser(mediaEvent(event.EventMessage, event.MsgImage, "cat.png", "", "image/png", len(tt.content)))
+			if req == nil {
+				t.Fatal("the image is not a request")
+			}
+
+			input, err := downloadPendingMedia(context.Background(), req.InputParams)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+
+			if err != nil {
+				if _, kept := req.InputParams["image"]; input != "image" || kept {
+					t.Errorf("input = %q, kept = %v, want the failed input removed", input, kept)
+				}
+				return
+			}
+
+			media, ok := req.InputParams["image"].(*contracts.Media)
+			if !ok {
+				t.Fatalf("image = %T, want the media", req.InputParams["image"])
+			}
+			defer media.Close()
+			if media.MimeType != "image/png" || media.Size != int64(len(tt.content)) {
+				t.Errorf("media = %s, %d bytes", media.MimeType, media.Size)
+			}
+		})
+	}
+}
@@ -0,0 +1,489 @@
+package bobrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+	applcommands "github.com/tensved/bobrix/mxbot/application/commands"
+	domcommands "github.com/tensved/bobrix/mxbot/domain/commands"
+)
+
+// QuotaScope - whose usage is counted by the quota limit
+type QuotaScope string
+
+const (
+	QuotaScopeUser    QuotaScope = "user"    // per sender
+	QuotaScopeRoom    QuotaScope = "room"    // per room
+	QuotaScopeService QuotaScope = "service" // per service (shared by all users)
+)
+
+// QuotaPeriod - window of the quota limit. Windows are aligned to UTC
+type QuotaPeriod string
+
+const (
+	QuotaPerMinute QuotaPeriod = "minute"
+	QuotaPerHour   QuotaPeriod = "hour"
+	QuotaPerDay    QuotaPeriod = "day"
+)
+
+const defaultQuotaCommand = "quota"
+
+// QuotaLimit - limit of units (calls or method costs, see contracts.Method.Cost) per period.
+// If Service is set, only calls of that service are counted, and the counter is separate for it
+type QuotaLimit struct {
+	Scope   QuotaScope  `json:"scope" yaml:"scope"`
+	Period  QuotaPeriod `json:"period" yaml:"period"`
+	Limit   int64       `json:"limit" yaml:"limit"`
+	Service string      `json:"service,omitempty" yaml:"service,omitempty"` // service name or ID (optional). Required for QuotaScopeService
+}
+
+// QuotaConfig - quota limits of the bot
+type QuotaConfig struct {
+	Limits []QuotaLimit `json:"limits" yaml:"limits"`
+
+	Prefix      string `json:"prefix,omitempty" yaml:"prefix,omitempty"`             // prefix of the quota command. Default: "/"
+	CommandName string `json:"command_name,omitempty" yaml:"command_name,omitempty"` // name of the quota command. Default: "quota"
+}
+
+// Validate - checks the limits of the config
+func (c QuotaConfig) Validate() error {
+	for i, l := range c.Limits {
+		switch l.Scope {
+		case QuotaScopeUser, QuotaScopeRoom:
+		case QuotaScopeService:
+			if l.Service == "" {
+				return fmt.Errorf("quota limit #%d: service is required for scope %q", i, l.Scope)
+			}
+		default:
+			return fmt.Errorf("quota limit #%d: unknown scope %q", i, l.Scope)
+		}
+
+		if l.Period.duration() == 0 {
+			return fmt.Errorf("quota limit #%d: unknown period %q", i, l.Period)
+		}
+
+		if l.Limit <= 0 {
+			return fmt.Errorf("quota limit #%d: limit must be positive", i)
+		}
+	}
+	return nil
+}
+
+func (p QuotaPeriod) duration() time.Duration {
+	switch p {
+	case QuotaPerMinute:
+		return time.Minute
+	case QuotaPerHour:
+		return time.Hour
+	case QuotaPerDay:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// QuotaStore - storage of the quota counters. It must be safe for concurrent use.
+// Counters are identified by the key and the start of the window
+type QuotaStore interface {
+	// Consume adds units to the counter if the result doesn't exceed the limit.
+	// It returns the usage after the call and false if the limit would be exceeded (the counter is not changed)
+	Consume(ctx context.Context, key string, windowStart, windowEnd time.Time, units, limit int64) (used int64, ok bool, err error)
+
+	// Release returns units to the counter (e.g. when another limit of the same call is exceeded)
+	Release(ctx context.Context, key string, windowStart time.Time, units int64) error
+
+	// Usage returns the current value of the counter
+	Usage(ctx context.Context, key string, windowStart time.Time) (int64, error)
+}
+
+// QuotaRequest - a call checked by the QuotaManager
+type QuotaRequest struct {
+	Sender  id.UserID
+	RoomID  id.RoomID
+	Service *BobrixService
+	Method  string
+}
+
+// QuotaExceededError - returned when a quota limit is exceeded
+type QuotaExceededError struct {
+	Limit   QuotaLimit
+	Used    int64
+	ResetAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded: %d/%d per %s (%s), resets at %s",
+		e.Used, e.Limit.Limit, e.Limit.Period, e.Limit.Scope, e.ResetAt.Format(time.RFC3339))
+}
+
+// QuotaUsage - state of the quota limit for the user
+type QuotaUsage struct {
+	Limit     QuotaLimit
+	Used      int64
+	Remaining int64
+	ResetAt   time.Time
+}
+
+// QuotaManager counts the usage of services and enforces the quota limits
+type QuotaManager struct {
+	store  QuotaStore
+	limits []QuotaLimit
+	now    func() time.Time
+}
+
+// NewQuotaManager - QuotaManager constructor
+func NewQuotaManager(store QuotaStore, cfg QuotaConfig) *QuotaManager {
+	return &QuotaManager{
+		store:  store,
+		limits: cfg.Limits,
+		now:    time.Now,
+	}
+}
+
+// WithQuotas - enables quotas of service calls and registers the quota command.
+// Calls over the limit are answered with contracts.ErrCodeTooManyRequests
+func WithQuotas(store QuotaStore, cfg QuotaConfig) BobrixOpts {
+	return func(bx *Bobrix) {
+		if store == nil {
+			bx.logger.Error("quotas are not enabled: store is nil")
+			return
+		}
+		if err := cfg.Validate(); err != nil {
+			bx.logger.Error("quotas are not enabled: invalid config", "error", err)
+			return
+		}
+
+		bx.quotas = NewQuotaManager(store, cfg)
+		bx.registerQuotaCommand(cfg)
+	}
+}
+
+// Consume - consumes units of the call from all limits that apply to it.
+// If any limit is exceeded, nothing is consumed and *QuotaExceededError is returned
+func (qm *QuotaManager) Consume(ctx context.Context, req QuotaRequest, units int64) error {
+	if units <= 0 {
+		units = 1
+	}
+
+	type consumed struct {
+		key   string
+		start time.Time
+	}
+
+	var done []consumed
+	// if the release fails, the units are lost until the end of the window, which is safer than allowing an extra call
+	release := func() {
+		for _, c := range done {
+			_ = qm.store.Release(ctx, c.key, c.start, units)
+		}
+	}
+
+	now := qm.now()
+	for _, limit := range qm.limits {
+		key, ok := limit.key(req)
+		if !ok {
+			continue
+		}
+
+		start, end := limit.window(now)
+
+		if units > limit.Limit {
+			release()
+			return &QuotaExceededError{Limit: limit, Used: 0, ResetAt: end}
+		}
+
+		used, ok, err := qm.store.Consume(ctx, key, start, end, units, limit.Limit)
+		if err != nil {
+			release()
+			return fmt.Errorf("failed to consume quota %s: %w", key, err)
+		}
+		if !ok {
+			release()
+			return &QuotaExceededError{Limit: limit, Used: used, ResetAt: end}
+		}
+
+		done = append(done, consumed{key: key, start: start})
+	}
+
+	return nil
+}
+
+// Usage - returns the state of the limits that apply to the user in the room.
+// Limits of the services are included for all services (or only for the given one)
+func (qm *QuotaManager) Usage(ctx context.Context, sender id.UserID, roomID id.RoomID, services []*BobrixService) ([]QuotaUsage, error) {
+	now := qm.now()
+
+	var out []QuotaUsage
+	for _, limit := range qm.limits {
+		req := QuotaRequest{Sender: sender, RoomID: roomID}
+
+		if limit.Service != "" {
+			idx := -1
+			for i, svc := range services {
+				if limit.matchesService(svc) {
+					idx = i
+					break
+				}
+			}
+			if idx < 0 {
+				continue
+			}
+			req.Service = services[idx]
+		}
+
+		key, ok := limit.key(req)
+		if !ok {
+			continue
+		}
+
+		start, end := limit.window(now)
+		used, err := qm.store.Usage(ctx, key, start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get quota usage %s: %w", key, err)
+		}
+
+		out = append(out, QuotaUsage{
+			Limit:     limit,
+			Used:      used,
+			Remaining: max(limit.Limit-used, 0),
+			ResetAt:   end,
+		})
+	}
+
+	return out, nil
+}
+
+// key - builds the counter key of the request. It returns false if the limit doesn't apply to the request
+func (l QuotaLimit) key(req QuotaRequest) (string, bool) {
+	var sb strings.Builder
+	sb.WriteString(string(l.Scope))
+	sb.WriteString(":")
+	sb.WriteString(string(l.Period))
+
+	switch l.Scope {
+	case QuotaScopeUser:
+		sb.WriteString(":" + req.Sender.String())
+	case QuotaScopeRoom:
+		sb.WriteString(":" + req.RoomID.String())
+	}
+
+	if l.Service != "" {
+		if !l.matchesService(req.Service) {
+			return "", false
+		}
+		sb.WriteString(":" + req.Service.Service.ID.String())
+	}
+
+	return sb.String(), true
+}
+
+func (l QuotaLimit) matchesService(svc *BobrixService) bool {
+	if svc == nil || svc.Service == nil {
+		return false
+	}
+	return l.Service == svc.Service.ID.String() ||
+		normalizeServiceName(l.Service) == normalizeServiceName(svc.Service.Name)
+}
+
+func (l QuotaLimit) window(now time.Time) (start, end time.Time) {
+	d := l.Period.duration()
+	start = now.UTC().Truncate(d)
+	return start, start.Add(d)
+}
+
+// methodCost - quota units of the method call
+func methodCost(svc *BobrixService, method string) int64 {
+	if svc == nil || svc.Service == nil {
+		return 1
+	}
+	if m, ok := svc.Service.Methods[method]; ok && m.Cost > 0 {
+		return m.Cost
+	}
+	return 1
+}
+
+func (bx *Bobrix) registerQuotaCommand(cfg QuotaConfig) {
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = domcommands.DefaultCommandPrefix
+	}
+	name := cfg.CommandName
+	if name == "" {
+		name = defaultQuotaCommand
+	}
+
+	cmd := applcommands.NewCommand(
+		name,
+		func(ctx domcommands.CommandCtx) error {
+			ctx.TryClaim()
+
+			services := bx.Services()
+			if args := ctx.Args(); len(args) > 0 {
+				ref := strings.Join(args, " ")
+				svc, ok := bx.lookupService(ref)
+				if !ok {
+					return ctx.ErrorAnswer(fmt.Sprintf("Service %q not found", ref), contracts.ErrCodeServiceNotFound)
+				}
+				services = []*BobrixService{svc}
+			}
+
+			usage, err := bx.quotas.Usage(ctx.Context(), ctx.Event().Sender, ctx.Event().RoomID, services)
+			if err != nil {
+				bx.logger.Error("failed to get quota usage", "sender", ctx.Event().Sender, "error", err)
+				return ctx.ErrorAnswer("Failed to get quota usage", contracts.ErrCodeInternalServiceError)
+			}
+
+			return ctx.TextAnswer(formatQuotaUsage(usage, services))
+		},
+		domcommands.CommandConfig{
+			Prefix: prefix,
+			Description: map[string]string{
+				"en": "Show remaining quota",
+			},
+			Args: []domcommands.Arg{{
+				Name:        "service",
+				Variadic:    true,
+				Description: map[string]string{"en": "show the quota of the service only"},
+			}},
+		},
+	)
+
+	applcommands.Register(bx.bot, cmd)
+}
+
+func formatQuotaUsage(usage []QuotaUsage, services []*BobrixService) string {
+	if len(usage) == 0 {
+		return "No quota limits apply to you"
+	}
+
+	serviceName := func(ref string) string {
+		for _, svc := range services {
+			if (QuotaLimit{Service: ref}).matchesService(svc) {
+				return svc.Service.Name
+			}
+		}
+		return ref
+	}
+
+	sort.SliceStable(usage, func(i, j int) bool {
+		return usage[i].Limit.Period.duration() < usage[j].Limit.Period.duration()
+	})
+
+	var sb strings.Builder
+	sb.WriteString("Quota:\n\n")
+	for _, u := range usage {
+		target := string(u.Limit.Scope)
+		if u.Limit.Service != "" {
+			target += ", " + serviceName(u.Limit.Service)
+		}
+
+		fmt.Fprintf(&sb, "- %d/%d left per %s (%s), resets in %s\n",
+			u.Remaining, u.Limit.Limit, u.Limit.Period, target, time.Until(u.ResetAt).Round(time.Second))
+	}
+	return sb.String()
+}
+
+// quotaExceededMessage - answer of the bot when the quota is exceeded
+func quotaExceededMessage(err *QuotaExceededError) string {
+	return fmt.Sprintf("Quota exceeded: %d per %s (%s). Resets at %s (in %s)",
+		err.Limit.Limit, err.Limit.Period, err.Limit.Scope,
+		err.ResetAt.UTC().Format("15:04:05 UTC"), time.Until(err.ResetAt).Round(time.Second))
+}
+
+var _ QuotaStore = (*MemoryQuotaStore)(nil)
+
+// MemoryQuotaStore - in-memory quota store. Counters are lost on restart and not shared between replicas
+type MemoryQuotaStore struct {
+	mu        sync.Mutex
+	counters  map[memoryQuotaKey]memoryQuotaCounter
+	lastPrune time.Time
+}
+
+type memoryQuotaKey struct {
+	key   string
+	start time.Time
+}
+
+type memoryQuotaCounter struct {
+	used      int64
+	expiresAt time.Time
+}
+
+// NewMemoryQuotaStore - MemoryQuotaStore constructor
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{
+		counters: make(map[memoryQuotaKey]memoryQuotaCounter),
+	}
+}
+
+func (s *MemoryQuotaStore) Consume(_ context.Context, key string, windowStart, windowEnd time.Time, units, limit int64) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked(time.Now())
+
+	k := memoryQuotaKey{key: key, start: windowStart}
+	c := s.counters[k]
+	if c.used+units > limit {
+		return c.used, false, nil
+	}
+
+	c.used += units
+	c.expiresAt = windowEnd
+	s.counters[k] = c
+
+	return c.used, true, nil
+}
+
+func (s *MemoryQuotaStore) Release(_ context.Context, key string, windowStart time.Time, units int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memoryQuotaKey{key: key, start: windowStart}
+	c, ok := s.counters[k]
+	if !ok {
+		return nil
+	}
+
+	c.used = max(c.used-units, 0)
+	s.counters[k] = c
+	return nil
+}
+
+func (s *MemoryQuotaStore) Usage(_ context.Context, key string, windowStart time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.counters[memoryQuotaKey{key: key, start: windowStart}].used, nil
+}
+
+// pruneLocked - removes expired counters (at most once a minute)
+func (s *MemoryQuotaStore) pruneLocked(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+
+	for k, c := range s.counters {
+		if !c.expiresAt.After(now) {
+			delete(s.counters, k)
+		}
+	}
+}
+
+// asQuotaExceeded - unwraps *QuotaExceededError
+func asQuotaExceeded(err error) (*QuotaExceededError, bool) {
+	var qe *QuotaExceededError
+	if errors.As(err, &qe) {
+		return qe, true
+	}
+	return nil, false
+}
@@ -0,0 +1,122 @@
+package bobrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/tensved/bobrix/mxbot/infrastructure/repository/pg"
+)
+
+var _ QuotaStore = (*PostgresQuotaStore)(nil)
+
+const (
+	quotaPruneInterval = time.Hour
+	quotaPruneTimeout  = time.Minute
+)
+
+// PostgresQuotaStore - quota store in Postgres. Counters survive restarts and are shared between replicas.
+// It uses the table bobrix_quota_counters(key text, window_start timestamptz, used bigint, expires_at timestamptz)
+// with the primary key (key, window_start).
+// The expired counters are deleted in the background at most once an hour (see Prune)
+type PostgresQuotaStore struct {
+	provider  pg.ExecutorProvider
+	lastPrune atomic.Int64 // unix nano
+}
+
+// NewPostgresQuotaStore - PostgresQuotaStore constructor
+func NewPostgresQuotaStore(provider pg.ExecutorProvider) *PostgresQuotaStore {
+	return &PostgresQuotaStore{provider: provider}
+}
+
+func (s *PostgresQuotaStore) Consume(ctx context.Context, key string, windowStart, windowEnd time.Time, units, limit int64) (int64, bool, error) {
+	s.schedulePrune()
+
+	exec := s.provider.Get(ctx)
+
+	q := `
+		INSERT INTO bobrix_quota_counters(key, window_start, used, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key, window_start) DO UPDATE
+		SET used = bobrix_quota_counters.used + EXCLUDED.used
+		WHERE bobrix_quota_counters.used + EXCLUDED.used <= $5
+		RETURNING used
+		`
+
+	var used int64
+	err := exec.QueryRow(ctx, q, key, windowStart, units, windowEnd, limit).Scan(&used)
+	if err == nil {
+		return used, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, err
+	}
+
+	// the limit would be exceeded
+	used, err = s.Usage(ctx, key, windowStart)
+	return used, false, err
+}
+
+func (s *PostgresQuotaStore) Release(ctx context.Context, key string, windowStart time.Time, units int64) error {
+	exec := s.provider.Get(ctx)
+
+	_, err := exec.Exec(ctx,
+		`UPDATE bobrix_quota_counters SET used = GREATEST(used - $3, 0) WHERE key=$1 AND window_start=$2`,
+		key, windowStart, units,
+	)
+	return err
+}
+
+func (s *PostgresQuotaStore) Usage(ctx context.Context, key string, windowStart time.Time) (int64, error) {
+	exec := s.provider.Get(ctx)
+
+	var used int64
+	err := exec.QueryRow(ctx,
+		`SELECT used FROM bobrix_quota_counters WHERE key=$1 AND window_start=$2`,
+		key, windowStart,
+	).Scan(&used)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("quota Usage query failed: %w", err)
+	}
+	return used, nil
+}
+
+// Prune - deletes counters of the expired windows. It returns the number of deleted rows.
+// Consume calls it in the background at most once an hour, a direct call prunes on the own schedule of the caller
+func (s *PostgresQuotaStore) Prune(ctx context.Context) (int64, error) {
+	exec := s.provider.Get(ctx)
+
+	tag, err := exec.Exec(ctx, `DELETE FROM bobrix_quota_counters WHERE expires_at < now()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// schedulePrune - runs Prune in the background if the last run was more than an hour ago
+func (s *PostgresQuotaStore) schedulePrune() {
+	now := time.Now().UnixNano()
+	last := s.lastPrune.Load()
+	if now-last < int64(quotaPruneInterval) || !s.lastPrune.CompareAndSwap(last, now) {
+		return
+	}
+
+	go func() {
+		// the executor of the request (e.g. its transaction) isn't used after the request
+		ctx, cancel := context.WithTimeout(context.Background(), quotaPruneTimeout)
+		defer cancel()
+
+		if _, err := s.Prune(ctx); err != nil {
+			slog.Error("quota: failed to prune counters", "error", err)
+		}
+	}()
+}
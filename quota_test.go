@@ -0,0 +1,187 @@
+package bobrix
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+)
+
+// failingQuotaStore - memory store that fails the counters with the prefix
+type failingQuotaStore struct {
+	*MemoryQuotaStore
+	failPrefix string
+}
+
+func (s *failingQuotaStore) Consume(ctx context.Context, key string, windowStart, windowEnd time.Time, units, limit int64) (int64, bool, error) {
+	if s.failPrefix != "" && strings.HasPrefix(key, s.failPrefix) {
+		return 0, false, errors.New("storage is unavailable")
+	}
+	return s.MemoryQuotaStore.Consume(ctx, key, windowStart, windowEnd, units, limit)
+}
+
+func testService(name string) *BobrixService {
+	return &BobrixService{Service: &contracts.Service{ID: uuid.New(), Name: name}}
+}
+
+func TestQuotaManagerConsume(t *testing.T) {
+	ada, other := testService("ada"), testService("other")
+
+	type call struct {
+		sender  id.UserID
+		service *BobrixService
+		units   int64
+		want    QuotaScope // scope of the exceeded limit. "" - the call is allowed
+		err     bool       // the store fails
+	}
+
+	tests := []struct {
+		name       string
+		limits     []QuotaLimit
+		failPrefix string
+		calls      []call
+		want       map[QuotaScope]int64 // usage of @alice in !room by the scopes of the limits
+	}{
+		{
+			name:   "calls over the user limit",
+			limits: []QuotaLimit{{Scope: QuotaScopeUser, Period: QuotaPerMinute, Limit: 2}},
+			calls: []call{
+				{sender: "@alice", service: ada},
+				{sender: "@alice", service: ada},
+				{sender: "@alice", service: ada, want: QuotaScopeUser},
+				{sender: "@bob", service: ada},
+			},
+			want: map[QuotaScope]int64{QuotaScopeUser: 2},
+		},
+		{
+			name: "the exceeded room limit rolls back the user limit",
+			limits: []QuotaLimit{
+				{Scope: QuotaScopeUser, Period: QuotaPerMinute, Limit: 5},
+				{Scope: QuotaScopeRoom, Period: QuotaPerHour, Limit: 2},
+			},
+			calls: []call{
+				{sender: "@alice", service: ada},
+				{sender: "@bob", service: ada},
+				{sender: "@alice", service: ada, want: QuotaScopeRoom},
+				{sender: "@alice", service: ada, want: QuotaScopeRoom},
+			},
+			want: map[QuotaScope]int64{QuotaScopeUser: 1, QuotaScopeRoom: 2},
+		},
+		{
+			name: "the service limit counts only its service",
+			limits: []QuotaLimit{
+				{Scope: QuotaScopeUser, Period: QuotaPerDay, Limit: 10},
+				{Scope: QuotaScopeService, Period: QuotaPerDay, Limit: 3, Service: "ada"},
+			},
+			calls: []call{
+				{sender: "@alice", service: ada, units: 2},
+				{sender: "@alice", service: other, units: 5},
+				{sender: "@bob", service: ada, units: 2, want: QuotaScopeService},
+				{sender: "@bob", service: ada},
+			},
+			want: map[QuotaScope]int64{QuotaScopeUser: 7, QuotaScopeService: 3},
+		},
+		{
+			name:   "the cost over the limit",
+			limits: []QuotaLimit{{Scope: QuotaScopeUser, Period: QuotaPerMinute, Limit: 2}},
+			calls: []call{
+				{sender: "@alice", service: ada, units: 3, want: QuotaScopeUser},
+				{sender: "@alice", service: ada, units: 2},
+			},
+			want: map[QuotaScope]int64{QuotaScopeUser: 2},
+		},
+		{
+			name: "the failed store rolls back the consumed limits",
+			limits: []QuotaLimit{
+				{Scope: QuotaScopeUser, Period: QuotaPerMinute, Limit: 5},
+				{Scope: QuotaScopeRoom, Period: QuotaPerMinute, Limit: 5},
+			},
+			failPrefix: "room:",
+			calls: []call{
+				{sender: "@alice", service: ada, err: true},
+			},
+			want: map[QuotaScope]int64{QuotaScopeUser: 0},
+		},
+	}
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &failingQuotaStore{MemoryQuotaStore: NewMemoryQuotaStore(), failPrefix: tt.failPrefix}
+			cfg := QuotaConfig{Limits: tt.limits}
+			if err := cfg.Validate(); err != nil {
+				t.Fatalf("config: %v", err)
+			}
+
+			qm := NewQuotaManager(store, cfg)
+			qm.now = func() time.Time { return now }
+
+			for i, c := range tt.calls {
+				units := c.units
+				if units == 0 {
+					units = 1
+				}
+
+				err := qm.Consume(context.Background(), QuotaRequest{Sender: c.sender, RoomID: "!room", Service: c.service}, units)
+
+				qe, exceeded := asQuotaExceeded(err)
+				switch {
+				case c.err:
+					if err == nil || exceeded {
+						t.Errorf("call #%d: err = %v, want the store error", i, err)
+					}
+				case c.want == "":
+					if err != nil {
+						t.Errorf("call #%d: %v", i, err)
+					}
+				case !exceeded || qe.Limit.Scope != c.want:
+					t.Errorf("call #%d: err = %v, want the %s limit exceeded", i, err, c.want)
+				}
+			}
+
+			usage, err := qm.Usage(context.Background(), "@alice", "!room", []*BobrixService{ada})
+			if err != nil {
+				t.Fatalf("usage: %v", err)
+			}
+			got := make(map[QuotaScope]int64, len(usage))
+			for _, u := range usage {
+				got[u.Limit.Scope] = u.Used
+			}
+			for scope, want := range tt.want {
+				if got[scope] != want {
+					t.Errorf("%s usage = %d, want %d", scope, got[scope], want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit QuotaLimit
+		ok    bool
+	}{
+		{"user limit", QuotaLimit{Scope: QuotaScopeUser, Period: QuotaPerDay, Limit: 1}, true},
+		{"service limit without a service", QuotaLimit{Scope: QuotaScopeService, Period: QuotaPerDay, Limit: 1}, false},
+		{"unknown scope", QuotaLimit{Scope: "team", Period: QuotaPerDay, Limit: 1}, false},
+		{"unknown period", QuotaLimit{Scope: QuotaScopeUser, Period: "week", Limit: 1}, false},
+		{"zero limit", QuotaLimit{Scope: QuotaScopeUser, Period: QuotaPerDay}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := QuotaConfig{Limits: []QuotaLimit{tt.limit}}.Validate()
+			if (err == nil) != tt.ok {
+				t.Errorf("Validate = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,540 @@
+package bobrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+	"github.com/tensved/bobrix/mxbot"
+	domcommands "github.com/tensved/bobrix/mxbot/domain/commands"
+	"github.com/tensved/bobrix/mxbot/messages"
+)
+
+const (
+	defaultSlotIdleTimeout = 5 * time.Minute
+	defaultSlotLang        = "en"
+)
+
+var (
+	defaultSlotCancelWords     = []string{"cancel", "/cancel", "stop"}
+	defaultSlotCommandPrefixes = []string{domcommands.DefaultCommandPrefix}
+)
+
+// SlotFillingOpts - options of the slot filling (see WithSlotFilling)
+type SlotFillingOpts struct {
+	IdleTimeout time.Duration // the dialogue is cancelled if the user doesn't answer. Default: 5 minutes
+	CancelWords []string      // messages that cancel the dialogue (case-insensitive). Default: cancel, /cancel, stop
+	Lang        string        // language of the input descriptions if the room has no language set. Default: "en"
+	Store       SlotStore     // storage of the partially filled requests. Default: in-memory
+
+	// CommandPrefixes - messages with these prefixes are commands, not answers (except the cancel words).
+	// Default: "/"
+	CommandPrefixes []string
+}
+
+// SlotSession - partially filled request of the user in the room.
+// Answers are the messages of the thread of the session, or the replies to the prompt outside of the threads
+type SlotSession struct {
+	UserID   id.UserID  `json:"user_id"`
+	RoomID   id.RoomID  `json:"room_id"`
+	ThreadID id.EventID `json:"thread_id,omitempty"` // answers are accepted only in this thread (if set)
+	PromptID id.EventID `json:"prompt_id,omitempty"` // the last question of the bot
+
+	Request ServiceRequest `json:"request"`
+	Missing []string       `json:"missing"` // names of the inputs left to ask, the first one is asked now
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SlotStore - storage of the slot sessions. There is at most one session per user and room.
+// The inputs of the session hold the open attachments (*contracts.Media) until the request is called,
+// so the store must keep the values as they are, in memory of the process (like MemorySlotStore)
+type SlotStore interface {
+	GetSlotSession(ctx context.Context, userID id.UserID, roomID id.RoomID) (*SlotSession, bool, error)
+	PutSlotSession(ctx context.Context, session *SlotSession) error
+	DeleteSlotSession(ctx context.Context, userID id.UserID, roomID id.RoomID) error
+}
+
+// slotFiller - asks the user for the missing required inputs one by one and calls the method when all of them are filled
+type slotFiller struct {
+	opts  SlotFillingOpts
+	store SlotStore
+
+	mu     sync.Mutex
+	timers map[slotKey]*time.Timer
+}
+
+type slotKey struct {
+	user id.UserID
+	room id.RoomID
+}
+
+// WithSlotFilling - enables the dialogue for missing required inputs.
+// Instead of the ErrInputRequired error the bot asks the user for each missing input (using its description),
+// accepts text or media answers and calls the method when every input is filled.
+// The answers are the replies to the question (or the messages of the thread if the request was in a thread),
+// other messages of the user and the commands are handled as usual.
+// The dialogue can be cancelled with one of the cancel words and is cancelled automatically after the idle timeout.
+// Note: hooks of the contract parser (ContractParserOpts) are not called for the resumed request
+func WithSlotFilling(opts ...SlotFillingOpts) BobrixOpts {
+	return func(bx *Bobrix) {
+		var opt SlotFillingOpts
+		if len(opts) > 0 {
+			opt = opts[0]
+		}
+		if opt.IdleTimeout <= 0 {
+			opt.IdleTimeout = defaultSlotIdleTimeout
+		}
+		if len(opt.CancelWords) == 0 {
+			opt.CancelWords = defaultSlotCancelWords
+		}
+		if opt.Lang == "" {
+			opt.Lang = defaultSlotLang
+		}
+		if opt.Store == nil {
+			opt.Store = NewMemorySlotStore()
+		}
+		if len(opt.CommandPrefixes) == 0 {
+			opt.CommandPrefixes = defaultSlotCommandPrefixes
+		}
+
+		bx.slots = &slotFiller{
+			opts:   opt,
+			store:  opt.Store,
+			timers: make(map[slotKey]*time.Timer),
+		}
+
+		// the handler goes before the contract parsers, so the answers are not treated as new requests
+		bx.Use(mxbot.NewMessageHandler(bx.handleSlotAnswer))
+	}
+}
+
+// startSlotFilling - starts the dialogue for the missing inputs of the request.
+// The new request replaces the unfinished one of the user in the room: its media inputs are released.
+// The media inputs of the request are kept by the session only if it returns nil
+func (bx *Bobrix) startSlotFilling(ctx mxbot.Ctx, svc *BobrixService, req *ServiceRequest, missing []contracts.Input) error {
+	evt := ctx.Event()
+
+	session := &SlotSession{
+		UserID: evt.Sender,
+		RoomID: evt.RoomID,
+		Request: ServiceRequest{
+			ServiceID:   svc.Service.ID.String(),
+			ServiceName: svc.Service.Name,
+			MethodName:  req.MethodName,
+			InputParams: make(map[string]any, len(req.InputParams)+len(missing)),
+		},
+		UpdatedAt: time.Now(),
+	}
+	for k, v := range req.InputParams {
+		session.Request.InputParams[k] = v
+	}
+	for _, input := range missing {
+		session.Missing = append(session.Missing, input.Name)
+	}
+	if thread := ctx.Thread(); thread != nil {
+		session.ThreadID = thread.ParentID
+	}
+
+	previous, hasPrevious, err := bx.slots.store.GetSlotSession(ctx.Context(), session.UserID, session.RoomID)
+	if err != nil {
+		return fmt.Errorf("failed to load slot session: %w", err)
+	}
+
+	if err := bx.askSlot(ctx, svc, session); err != nil {
+		return err
+	}
+
+	if hasPrevious {
+		contracts.CloseMedia(previous.Request.InputParams)
+	}
+	return nil
+}
+
+// handleSlotAnswer - accepts the answer of the user to the asked input
+func (bx *Bobrix) handleSlotAnswer(ctx mxbot.Ctx) error {
+	evt := ctx.Event()
+	if evt.Sender.String() == bx.bot.FullName() {
+		return nil
+	}
+
+	msg := evt.Content.AsMessage()
+	if msg == nil {
+		return nil
+	}
+	if _, isEdit := mxbot.AsEdit(evt); isEdit {
+		return nil
+	}
+
+	session, ok, err := bx.slots.store.GetSlotSession(ctx.Context(), evt.Sender, evt.RoomID)
+	if err != nil {
+		bx.logger.Error("failed to load slot session", "user", evt.Sender, "room", evt.RoomID, "error", err)
+		return nil
+	}
+	if !ok || len(session.Missing) == 0 {
+		return nil
+	}
+
+	if time.Since(session.UpdatedAt) > bx.slots.opts.IdleTimeout {
+		bx.slots.discard(ctx.Context(), bx, session)
+		return nil
+	}
+
+	if !session.isAnswer(msg) {
+		return nil
+	}
+
+	text := slotText(msg)
+	cancel := slices.ContainsFunc(bx.slots.opts.CancelWords, func(w string) bool {
+		return strings.EqualFold(text, w)
+	})
+
+	// commands go to their handlers
+	if !cancel && slices.ContainsFunc(bx.slots.opts.CommandPrefixes, func(p string) bool {
+		return strings.HasPrefix(text, p)
+	}) {
+		return nil
+	}
+
+	if ctx.IsHandled() || !ctx.TryClaim() {
+		return nil
+	}
+
+	if cancel {
+		bx.slots.discard(ctx.Context(), bx, session)
+		return ctx.TextAnswer("Request cancelled")
+	}
+
+	svc, method, ok := bx.slotMethod(session)
+	if !ok {
+		bx.slots.discard(ctx.Context(), bx, session)
+		return ctx.ErrorAnswer(
+			fmt.Sprintf("Method %q of service %q is not available anymore", session.Request.MethodName, session.Request.ServiceName),
+			contracts.ErrCodeMethodNotFound,
+		)
+	}
+
+	input, ok := findInput(method, session.Missing[0])
+	if !ok {
+		// the method was changed: skip the unknown input
+		session.Missing = session.Missing[1:]
+	} else {
+		value, problem, err := bx.slotValue(ctx.Context(), evt, msg, input)
+		if err != nil {
+			bx.logger.Error("failed to read slot answer", "input", input.Name, "error", err)
+			return ctx.ErrorAnswer(fmt.Sprintf("Failed to read the answer for %q", input.Name), contracts.ErrCodeInternalServiceError)
+		}
+		if problem != "" {
+			return bx.retrySlot(ctx, session, problem)
+		}
+
+		session.Request.InputParams[input.Name] = value
+		session.Missing = session.Missing[1:]
+	}
+
+	if len(session.Missing) > 0 {
+		if err := bx.askSlot(ctx, svc, session); err != nil {
+			bx.logger.Error("failed to ask slot", "input", session.Missing[0], "error", err)
+			bx.slots.discard(ctx.Context(), bx, session)
+			return ctx.ErrorAnswer("Failed to ask for the missing inputs", contracts.ErrCodeInternalServiceError)
+		}
+		return nil
+	}
+
+	bx.slots.finish(ctx.Context(), bx, session)
+
+	req := session.Request
+	return bx.handleRequest(ctx, &req, ContractParserOpts{})
+}
+
+// askSlot - asks the user for the first missing input of the session and saves the session with the question.
+// The session is saved only if it returns nil
+func (bx *Bobrix) askSlot(ctx mxbot.Ctx, svc *BobrixService, session *SlotSession) error {
+	method, ok := svc.Service.Methods[session.Request.MethodName]
+	if !ok {
+		return fmt.Errorf("method %q not found", session.Request.MethodName)
+	}
+	input, ok := findInput(method, session.Missing[0])
+	if !ok {
+		return fmt.Errorf("input %q of method %q not found", session.Missing[0], session.Request.MethodName)
+	}
+
+	lang := bx.slots.opts.Lang
+	if cfg, _ := bx.bot.RoomConfig(ctx.Context(), ctx.Event().RoomID); cfg != nil && cfg.Language != "" {
+		lang = cfg.Language
+	}
+
+	question := localized(input.Description, lang)
+	if question == "" {
+		question = input.Name
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Please provide **%s**", question)
+	if msgType, isMedia := mediaMessageTypes[input.Type]; isMedia {
+		fmt.Fprintf(&sb, " (send %s)", strings.TrimPrefix(string(msgType), "m."))
+	} else if input.Type != contracts.IOTypeText {
+		fmt.Fprintf(&sb, " (%s)", input.Type)
+	}
+	if session.ThreadID == "" {
+		sb.WriteString(".\n\nReply to this message with the answer, or with")
+	} else {
+		sb.WriteString(".\n\nSend")
+	}
+	fmt.Fprintf(&sb, " `%s` to cancel the request", bx.slots.opts.CancelWords[0])
+
+	return bx.promptSlot(ctx, session, messages.NewText(sb.String()))
+}
+
+// retrySlot - tells the user why the answer doesn't fit. The user answers to this message again
+func (bx *Bobrix) retrySlot(ctx mxbot.Ctx, session *SlotSession, problem string) error {
+	msg := messages.NewText(problem)
+	msg.AddCustomFields(messages.CustomField{Key: "error_code", Value: contracts.ErrCodeBadRequest})
+	return bx.promptSlot(ctx, session, msg)
+}
+
+// promptSlot - sends the question and saves the session waiting for the answer to it
+func (bx *Bobrix) promptSlot(ctx mxbot.Ctx, session *SlotSession, msg messages.Message) error {
+	h, err := ctx.AnswerWithHandle(msg)
+	if err != nil {
+		return err
+	}
+
+	session.PromptID = h.EventID
+	session.UpdatedAt = time.Now()
+	if err := bx.slots.store.PutSlotSession(ctx.Context(), session); err != nil {
+		return fmt.Errorf("failed to save slot session: %w", err)
+	}
+	bx.slots.resetTimer(bx, session)
+	return nil
+}
+
+// slotValue - reads the answer for the input. problem is the message for the user if the answer doesn't fit
+func (bx *Bobrix) slotValue(
+	ctx context.Context,
+	evt *event.Event,
+	msg *event.MessageEventContent,
+	input contracts.Input,
+) (value any, problem string, err error) {
+	if msgType, isMedia := mediaMessageTypes[input.Type]; isMedia {
+		if msg.MsgType != msgType {
+			return nil, fmt.Sprintf("Please send %s for %q", strings.TrimPrefix(string(msgType), "m."), input.Name), nil
+		}
+
+		media, err := IngestMedia(ctx, bx.bot, evt, bx.media)
+		if errors.Is(err, ErrMediaTooLarge) || errors.Is(err, ErrInappropriateMimeType) {
+			return nil, mediaErrorMessage(input.Name, err) + ", please try again", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if !input.AcceptsMimeType(media.MimeType) {
+			_ = media.Close()
+			return nil, mediaErrorMessage(input.Name, ErrInappropriateMimeType) + ", please try again", nil
+		}
+		return media, "", nil
+	}
+
+	if msg.MsgType != event.MsgText && msg.MsgType != event.MsgNotice {
+		return nil, fmt.Sprintf("Please send a text for %q", input.Name), nil
+	}
+
+	text := slotText(msg)
+	switch input.Type {
+	case contracts.IOTypeNumber:
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return nil, fmt.Sprintf("%q is not a number, please try again", text), nil
+		}
+	case contracts.IOTypeBoolean:
+		if _, err := strconv.ParseBool(text); err != nil {
+			return nil, fmt.Sprintf("%q is not yes/no (true/false), please try again", text), nil
+		}
+	case contracts.IOTypeJSON:
+		if !json.Valid([]byte(text)) {
+			return nil, "The answer is not a valid JSON, please try again", nil
+		}
+	}
+
+	return text, "", nil
+}
+
+func (bx *Bobrix) slotMethod(session *SlotSession) (*BobrixService, *contracts.Method, bool) {
+	svc, ok := bx.lookupService(session.Request.ServiceID)
+	if !ok {
+		svc, ok = bx.lookupService(session.Request.ServiceName)
+	}
+	if !ok {
+		return nil, nil, false
+	}
+
+	method, ok := svc.Service.Methods[session.Request.MethodName]
+	return svc, method, ok
+}
+
+// finish - removes the session and its timer
+func (s *slotFiller) finish(ctx context.Context, bx *Bobrix, session *SlotSession) {
+	key := slotKey{user: session.UserID, room: session.RoomID}
+
+	s.mu.Lock()
+	if t, ok := s.timers[key]; ok {
+		t.Stop()
+		delete(s.timers, key)
+	}
+	s.mu.Unlock()
+
+	if err := s.store.DeleteSlotSession(ctx, session.UserID, session.RoomID); err != nil {
+		bx.logger.Error("failed to delete slot session", "user", session.UserID, "room", session.RoomID, "error", err)
+	}
+}
+
+// discard - removes the abandoned session and releases its media inputs
+func (s *slotFiller) discard(ctx context.Context, bx *Bobrix, session *SlotSession) {
+	s.finish(ctx, bx, session)
+	contracts.CloseMedia(session.Request.InputParams)
+}
+
+// resetTimer - (re)starts the idle timer of the session. On timeout the user is notified
+func (s *slotFiller) resetTimer(bx *Bobrix, session *SlotSession) {
+	key := slotKey{user: session.UserID, room: session.RoomID}
+	threadID := session.ThreadID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.timers[key]; ok {
+		t.Stop()
+	}
+
+	s.timers[key] = time.AfterFunc(s.opts.IdleTimeout, func() {
+		ctx := context.Background()
+
+		current, ok, err := s.store.GetSlotSession(ctx, key.user, key.room)
+		if err != nil || !ok || time.Since(current.UpdatedAt) < s.opts.IdleTimeout {
+			return
+		}
+
+		s.discard(ctx, bx, current)
+
+		msg := messages.NewText(fmt.Sprintf("%s, the request is cancelled: no answer for %s", key.user, s.opts.IdleTimeout))
+		if threadID != "" {
+			msg.SetRelatesTo(&event.RelatesTo{Type: event.RelThread, EventID: threadID})
+		}
+		sender, ok := bx.messaging()
+		if !ok {
+			return
+		}
+		if _, err := sender.SendMessage(ctx, key.room, msg); err != nil {
+			bx.logger.Error("failed to send slot timeout message", "room", key.room, "error", err)
+		}
+	})
+}
+
+// isAnswer - the message is in the thread of the session, or replies to the prompt (or starts a thread on it)
+func (session *SlotSession) isAnswer(msg *event.MessageEventContent) bool {
+	if session.ThreadID != "" {
+		return threadRootOf(msg) == session.ThreadID
+	}
+	if session.PromptID == "" {
+		return false
+	}
+	return msg.RelatesTo.GetReplyTo() == session.PromptID || threadRootOf(msg) == session.PromptID
+}
+
+// slotText - text of the answer without the quote of the replied message
+func slotText(msg *event.MessageEventContent) string {
+	if msg.RelatesTo.GetReplyTo() != "" {
+		return strings.TrimSpace(event.TrimReplyFallbackText(msg.Body))
+	}
+	return strings.TrimSpace(msg.Body)
+}
+
+// threadRootOf - returns the root of the thread of the message (empty if the message is not in a thread)
+func threadRootOf(msg *event.MessageEventContent) id.EventID {
+	if rel := msg.GetRelatesTo(); rel.Type == event.RelThread {
+		return rel.EventID
+	}
+	return ""
+}
+
+func findInput(method *contracts.Method, name string) (contracts.Input, bool) {
+	for _, input := range method.Inputs {
+		if input.Name == name {
+			return input, true
+		}
+	}
+	return contracts.Input{}, false
+}
+
+// localized - returns the text in the language (or in English, or in any language)
+func localized(texts map[string]string, lang string) string {
+	if text, ok := texts[lang]; ok {
+		return text
+	}
+	if text, ok := texts[defaultSlotLang]; ok {
+		return text
+	}
+	for _, text := range texts {
+		return text
+	}
+	return ""
+}
+
+var _ SlotStore = (*MemorySlotStore)(nil)
+
+// MemorySlotStore - in-memory slot store
+type MemorySlotStore struct {
+	mu       sync.Mutex
+	sessions map[slotKey]*SlotSession
+}
+
+// NewMemorySlotStore - MemorySlotStore constructor
+func NewMemorySlotStore() *MemorySlotStore {
+	return &MemorySlotStore{
+		sessions: make(map[slotKey]*SlotSession),
+	}
+}
+
+func (s *MemorySlotStore) GetSlotSession(_ context.Context, userID id.UserID, roomID id.RoomID) (*SlotSession, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[slotKey{user: userID, room: roomID}]
+	if !ok {
+		return nil, false, nil
+	}
+
+	// copy, so the caller can modify the session without the lock
+	cp := *session
+	cp.Missing = slices.Clone(session.Missing)
+	cp.Request.InputParams = make(map[string]any, len(session.Request.InputParams))
+	for k, v := range session.Request.InputParams {
+		cp.Request.InputParams[k] = v
+	}
+	return &cp, true, nil
+}
+
+func (s *MemorySlotStore) PutSlotSession(_ context.Context, session *SlotSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[slotKey{user: session.UserID, room: session.RoomID}] = session
+	return nil
+}
+
+func (s *MemorySlotStore) DeleteSlotSession(_ context.Context, userID id.UserID, roomID id.RoomID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, slotKey{user: userID, room: roomID})
+	return nil
+}
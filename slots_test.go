@@ -0,0 +1,159 @@
+package bobrix
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/tensved/bobrix/contracts"
+)
+
+// replyMessage - message of the user that replies to the event
+func replyMessage(eventID id.EventID, body string, to id.EventID) *event.Event {
+	evt := textMessage(eventID, body)
+	evt.Content.AsMessage().RelatesTo = &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: to}}
+	return evt
+}
+
+// startSlotRequest - calls "echo" without the text: the bot asks for it. The request holds the media
+func startSlotRequest(t *testing.T, bx *Bobrix, bot *testBot, eventID id.EventID) *contracts.Media {
+	t.Helper()
+
+	media := contracts.NewMemoryMedia("photo.png", "image/png", []byte("png"), "")
+	req := &ServiceRequest{ServiceName: "ada", MethodName: "echo", InputParams: map[string]any{"photo": media}}
+	if err := bx.handleRequest(bot.newCtx(t, textMessage(eventID, "echo")), req, ContractParserOpts{}); err != nil {
+		t.Fatalf("handleRequest: %v", err)
+	}
+	return media
+}
+
+func mediaClosed(media *contracts.Media) bool {
+	_, err := media.Open()
+	return errors.Is(err, contracts.ErrMediaClosed)
+}
+
+func slotSession(t *testing.T, bx *Bobrix) (*SlotSession, bool) {
+	t.Helper()
+	session, ok, err := bx.slots.store.GetSlotSession(context.Background(), testUserID, testRoomID)
+	if err != nil {
+		t.Fatalf("get session: %v", err)
+	}
+	return session, ok
+}
+
+func TestSlotFilling(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  string
+		reply   bool   // the answer replies to the question
+		want    string // the last message of the bot
+		session bool   // the dialogue goes on
+		closed  bool   // the media of the request is released
+	}{
+		{
+			name:   "the answer calls the method",
+			answer: "hello",
+			reply:  true,
+			want:   "hello",
+			closed: true,
+		},
+		{
+			name:   "cancel word",
+			answer: "Stop",
+			reply:  true,
+			want:   "Request cancelled",
+			closed: true,
+		},
+		{
+			name:    "commands are not answers",
+			answer:  "/help",
+			reply:   true,
+			want:    "Please provide",
+			session: true,
+		},
+		{
+			name:    "messages that don't reply to the question are not answers",
+			answer:  "hello",
+			want:    "Please provide",
+			session: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := &testBot{}
+			bx, _, _ := newTestBobrix(t, bot, WithSlotFilling())
+
+			media := startSlotRequest(t, bx, bot, "$request")
+
+			session, ok := slotSession(t, bx)
+			if !ok || len(session.Missing) != 1 || session.Missing[0] != "text" {
+				t.Fatalf("session = %+v, want the text asked", session)
+			}
+
+			evt := textMessage("$answer", tt.answer)
+			if tt.reply {
+				evt = replyMessage("$answer", tt.answer, bot.messages()[0].ID)
+			}
+			bot.dispatch(t, evt)
+
+			sent := bot.messages()
+			if last := sent[len(sent)-1].Body; !strings.HasPrefix(last, tt.want) {
+				t.Errorf("messages = %q, want the last %q", bodies(sent), tt.want)
+			}
+			if _, ok := slotSession(t, bx); ok != tt.session {
+				t.Errorf("session = %v, want %v", ok, tt.session)
+			}
+			if got := mediaClosed(media); got != tt.closed {
+				t.Errorf("media closed = %v, want %v", got, tt.closed)
+			}
+		})
+	}
+}
+
+func TestSlotFillingReplacedSession(t *testing.T) {
+	bot := &testBot{}
+	bx, _, _ := newTestBobrix(t, bot, WithSlotFilling())
+
+	first := startSlotRequest(t, bx, bot, "$first")
+	second := startSlotRequest(t, bx, bot, "$second")
+
+	if !mediaClosed(first) || mediaClosed(second) {
+		t.Errorf("closed: first = %v, second = %v, want only the replaced one", mediaClosed(first), mediaClosed(second))
+	}
+
+	session, ok := slotSession(t, bx)
+	if !ok || session.Request.InputParams["photo"] != second {
+		t.Errorf("session = %+v, want the second request", session)
+	}
+}
+
+func TestSlotFillingIdleTimeout(t *testing.T) {
+	bot := &testBot{}
+	bx, _, _ := newTestBobrix(t, bot, WithSlotFilling(SlotFillingOpts{IdleTimeout: 20 * time.Millisecond}))
+
+	media := startSlotRequest(t, bx, bot, "$request")
+
+	deadline := time.Now().Add(time.Second)
+	for len(bot.messages()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("messages = %q, want the timeout message", bodies(bot.messages()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if msg := bot.messages()[1].Body; !strings.Contains(msg, "the request is cancelled") {
+		t.Errorf("timeout message = %q", msg)
+	}
+	if _, ok := slotSession(t, bx); ok {
+		t.Error("the session is kept after the timeout")
+	}
+	if !mediaClosed(media) {
+		t.Error("the media is kept after the timeout")
+	}
+}